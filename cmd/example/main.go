@@ -1,70 +1,100 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"lunal-attestation/pkg/attestation" // Use your actual module path
 	"os"
-
-	pb "github.com/google/go-tpm-tools/proto/attest"
-	"google.golang.org/protobuf/encoding/protojson"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "predict" {
+		runPredict(os.Args[2:])
+		return
+	}
+
 	// Define command-line flags
-	inputFile := flag.String("file", "attestation.txt", "Path to the base64-encoded attestation file")
+	inputFile := flag.String("file", "attestation.txt", "Path to the base64-encoded attestation file or FIFO, or - for stdin")
 	verbose := flag.Bool("verbose", false, "Print verbose output")
+	output := flag.String("output", "-", "Where to write the result: - for stdout, a file path to append to, syslog, or syslog://host:port")
+	outputFormat := flag.String("output-format", "text", "Result format: text, or json for one JSON line per verification")
+	expectedPCRs := pcrFlag{}
+	flag.Var(expectedPCRs, "expected-pcr", "Expected PCR value as index=hex, may be repeated")
+	minTCB := flag.String("min-tcb", "", "Minimum TDX TCB status, e.g. UpToDate or SWHardeningNeeded (requires network access)")
+	requireTEE := flag.Bool("require-tee", false, "Require a TEE attestation")
+	var requireSecureBoot boolFlag
+	flag.Var(&requireSecureBoot, "require-secure-boot", "Require secure boot to be enabled, or disabled with -require-secure-boot=false")
 	flag.Parse()
 
-	// Read the base64-encoded attestation file
-	encodedData, err := os.ReadFile(*inputFile)
+	if *outputFormat != "text" && *outputFormat != "json" {
+		log.Fatalf("Invalid -output-format %q", *outputFormat)
+	}
+	out, err := openOutput(*output)
 	if err != nil {
-		log.Fatalf("Failed to read attestation file: %v", err)
+		log.Fatalf("Invalid -output: %v", err)
+	}
+	defer out.Close()
+
+	// Open the base64-encoded attestation input. It is read until EOF, so it may be a pipe.
+	input := os.Stdin
+	if *inputFile != "-" {
+		input, err = os.Open(*inputFile)
+		if err != nil {
+			log.Fatalf("Failed to open attestation file: %v", err)
+		}
+		defer input.Close()
 	}
 
 	// Decode the base64 data
-	attestationBytes, err := base64.StdEncoding.DecodeString(string(encodedData))
+	attestationBytes, err := readAttestation(input)
 	if err != nil {
 		log.Fatalf("Failed to decode base64 data: %v", err)
 	}
 
-	fmt.Printf("Successfully decoded %d bytes of attestation data\n", len(attestationBytes))
+	// Diagnostics go to stderr, so that -output-format json on stdout is only JSON lines.
+	fmt.Fprintf(os.Stderr, "Successfully decoded %d bytes of attestation data\n", len(attestationBytes))
 
 	// Use the same nonce that was used to generate the attestation
 	nonce := []byte("fixed-deterministic-nonce-for-server")
-	fmt.Printf("Using nonce from server: %s\n", string(nonce))
+	fmt.Fprintf(os.Stderr, "Using nonce from server: %s\n", string(nonce))
 
 	// Verify the attestation
 	// Since it's a TDX attestation and we're not using a specific TEE nonce,
 	// we'll pass nil for teeNonce and let the verifier use the main nonce for TEE verification
-	machineState, err := attestation.VerifyAttestation(attestationBytes, "binarypb", nonce, nil)
-	if err != nil {
-		log.Fatalf("Attestation verification failed: %v", err)
+	opts := attestation.DefaultVerifyOptions()
+	opts.Nonce = nonce
+	opts.ExpectedPCRs = expectedPCRs
+	opts.RequireTEE = *requireTEE
+	opts.RequireSecureBoot = requireSecureBoot.value
+	if *minTCB != "" {
+		statuses, err := acceptableTCBStatuses(*minTCB)
+		if err != nil {
+			log.Fatalf("Invalid -min-tcb: %v", err)
+		}
+		opts.Tdx = &attestation.TdxPolicy{RequireTCBStatus: statuses}
 	}
 
-	fmt.Println("✅ Attestation successfully verified!")
-
-	// Print basic information about the machine state
-	if *verbose {
-		printMachineState(machineState)
+	fmt.Fprintln(os.Stderr, "Checks:")
+	for _, check := range opts.EnabledChecks() {
+		fmt.Fprintf(os.Stderr, "  - %s\n", check)
 	}
-}
 
-func printMachineState(machineState *pb.MachineState) {
-	// Option 1: Using the protojson package (recommended)
-	marshaler := protojson.MarshalOptions{
-		Indent:    "  ", // Use 2 spaces for indentation
-		Multiline: true,
+	report, verifyErr := attestation.VerifyAttestationContext(context.Background(), attestationBytes, opts)
+	if err := writeResult(out, *outputFormat, report, verifyErr, *verbose); err != nil {
+		log.Fatalf("Failed to write result: %v", err)
 	}
-
-	jsonBytes, err := marshaler.Marshal(machineState)
-	if err != nil {
-		fmt.Printf("Error marshaling to JSON: %v\n", err)
-		return
+	if verifyErr != nil {
+		out.Close()
+		log.Fatalf("Attestation verification failed: %v", verifyErr)
 	}
+}
 
-	fmt.Println("\n=== Machine State JSON ===")
-	fmt.Println(string(jsonBytes))
+// readAttestation decodes a base64-encoded attestation read from input until EOF, so that input
+// may be a pipe or FIFO.
+func readAttestation(input io.Reader) ([]byte, error) {
+	return io.ReadAll(base64.NewDecoder(base64.StdEncoding, input))
 }
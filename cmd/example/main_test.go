@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"os"
+	"testing"
+
+	"lunal-attestation/pkg/attestation"
+)
+
+// writeChunks writes data to w in small chunks, as an agent writing to a pipe would, and closes it.
+func writeChunks(w *io.PipeWriter, data []byte) {
+	for len(data) > 0 {
+		n := min(len(data), 100)
+		if _, err := w.Write(data[:n]); err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		data = data[n:]
+	}
+	w.Close()
+}
+
+func TestReadAttestationFromPipe(t *testing.T) {
+	encoded, err := os.ReadFile("attestation.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, w := io.Pipe()
+	go writeChunks(w, encoded)
+	got, err := readAttestation(r)
+	if err != nil {
+		t.Fatalf("readAttestation() failed: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("readAttestation() returned %d bytes, want the %d bytes of the fixture", len(got), len(want))
+	}
+
+	opts := attestation.DefaultVerifyOptions()
+	opts.Nonce = []byte("fixed-deterministic-nonce-for-server")
+	r, w = io.Pipe()
+	go writeChunks(w, got)
+	if _, err := attestation.VerifyAttestationFromReader(r, opts); err != nil {
+		t.Errorf("VerifyAttestationFromReader() of a pipe failed: %v", err)
+	}
+}
+
+func TestReadAttestationPipeError(t *testing.T) {
+	r, w := io.Pipe()
+	go func() {
+		w.Write([]byte("AAAA"))
+		w.CloseWithError(io.ErrUnexpectedEOF)
+	}()
+	if _, err := readAttestation(r); err == nil {
+		t.Error("readAttestation() of a failed pipe succeeded, want an error")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"lunal-attestation/pkg/attestation"
+)
+
+// openOutput opens the -output target: - for stdout, syslog for the local syslog daemon,
+// syslog://host:port for a remote one over UDP, or otherwise a file path, appended to.
+func openOutput(target string) (io.WriteCloser, error) {
+	switch {
+	case target == "-":
+		return nopCloser{os.Stdout}, nil
+	case target == "syslog":
+		return openSyslog("")
+	case strings.HasPrefix(target, "syslog://"):
+		return openSyslog(strings.TrimPrefix(target, "syslog://"))
+	default:
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open output: %v", err)
+		}
+		return f, nil
+	}
+}
+
+// nopCloser leaves stdout open when the output is closed.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// jsonResult is the record -output-format json writes for each verification.
+type jsonResult struct {
+	Summary      attestation.Summary `json:"summary"`
+	MachineState json.RawMessage     `json:"machineState,omitempty"`
+}
+
+// writeResult writes the outcome of a verification to out as text or as a single JSON line.
+// With verbose, the redacted machine state of a verified report is included.
+func writeResult(out io.Writer, format string, report *attestation.VerificationReport, verifyErr error, verbose bool) error {
+	var machineState []byte
+	if verbose && verifyErr == nil {
+		var err error
+		machineState, err = attestation.MachineStateJSON(attestation.RedactMachineState(report.MachineState), format == "json")
+		if err != nil {
+			return err
+		}
+	}
+
+	if format == "json" {
+		line, err := json.Marshal(jsonResult{Summary: attestation.Summarize(report, verifyErr), MachineState: machineState})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", line)
+		return err
+	}
+
+	// Each message is a separate write, so that each becomes a syslog record.
+	if verifyErr != nil {
+		_, err := fmt.Fprintf(out, "❌ FAIL: %v\n", verifyErr)
+		return err
+	}
+	messages := []string{"✅ PASS: attestation successfully verified!", attestation.SummaryString(report)}
+	if verbose {
+		if clock := report.QuoteClock; clock != nil {
+			messages = append(messages, fmt.Sprintf("TPM clock: %d ms, reset count %d, restart count %d, safe %v", clock.Clock, clock.ResetCount, clock.RestartCount, clock.Safe))
+		}
+		if cpu := report.CPU; cpu != nil && cpu.FMSPC != "" {
+			messages = append(messages, fmt.Sprintf("CPU: %s, FMSPC %s, microcode SVN %d, early microcode SVN %d", cpu.Vendor, cpu.FMSPC, cpu.MicrocodeSVN, cpu.EarlyMicrocodeSVN))
+		} else if cpu != nil {
+			messages = append(messages, fmt.Sprintf("CPU: %s %s, family %#x model %#x stepping %d, microcode SVN %d", cpu.Vendor, cpu.Product, cpu.Family, cpu.Model, cpu.Stepping, cpu.MicrocodeSVN))
+		}
+		messages = append(messages, "\n=== Machine State JSON (redacted) ===\n"+string(machineState))
+	}
+	for _, message := range messages {
+		if _, err := fmt.Fprintln(out, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
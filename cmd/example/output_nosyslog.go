@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// openSyslog fails, as syslog is not available on this platform.
+func openSyslog(addr string) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("syslog output is not supported on this platform")
+}
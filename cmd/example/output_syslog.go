@@ -0,0 +1,22 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+)
+
+// openSyslog connects to the syslog daemon at addr over UDP, or to the local one if addr is empty.
+func openSyslog(addr string) (io.WriteCloser, error) {
+	network := ""
+	if addr != "" {
+		network = "udp"
+	}
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, "lunal-attestation")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %v", err)
+	}
+	return w, nil
+}
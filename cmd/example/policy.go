@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-tdx-guest/pcs"
+	"lunal-attestation/pkg/attestation"
+)
+
+// tcbStatusOrder lists the TDX TCB statuses from best to worst, for -min-tcb.
+var tcbStatusOrder = []pcs.TcbComponentStatus{
+	pcs.TcbComponentStatusUpToDate,
+	pcs.TcbComponentStatusSwHardeningNeeded,
+	pcs.TcbComponentStatusConfigurationNeeded,
+	pcs.TcbComponentStatusConfigurationAndSWHardeningNeeded,
+	pcs.TcbComponentStatusOutOfDate,
+	pcs.TcbComponentStatusOutOfDateConfigurationNeeded,
+}
+
+// pcrFlag collects repeated -expected-pcr index=digest flags, the digest in hex or base64.
+type pcrFlag map[uint32][]byte
+
+func (f pcrFlag) String() string {
+	var values []string
+	for index, digest := range f {
+		values = append(values, fmt.Sprintf("%d=%x", index, digest))
+	}
+	sort.Strings(values)
+	return strings.Join(values, ",")
+}
+
+func (f pcrFlag) Set(value string) error {
+	index, digest, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected index=hex, got %q", value)
+	}
+	i, err := strconv.ParseUint(index, 10, 32)
+	if err != nil || i > 23 {
+		return fmt.Errorf("invalid PCR index %q", index)
+	}
+	d, err := attestation.ParsePCRValue(digest)
+	if err != nil {
+		return err
+	}
+	f[uint32(i)] = d
+	return nil
+}
+
+// boolFlag is a bool flag that records whether it was set.
+type boolFlag struct {
+	value *bool
+}
+
+func (f *boolFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return strconv.FormatBool(*f.value)
+}
+
+func (f *boolFlag) Set(value string) error {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	f.value = &b
+	return nil
+}
+
+func (f *boolFlag) IsBoolFlag() bool { return true }
+
+// acceptableTCBStatuses returns the TCB statuses at least as good as min.
+func acceptableTCBStatuses(min string) ([]string, error) {
+	for i, status := range tcbStatusOrder {
+		if strings.EqualFold(string(status), min) {
+			statuses := make([]string, 0, i+1)
+			for _, s := range tcbStatusOrder[:i+1] {
+				statuses = append(statuses, string(s))
+			}
+			return statuses, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown TCB status %q", min)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"lunal-attestation/pkg/attestation"
+)
+
+// pcrBanks maps -bank names to PCR bank algorithms.
+var pcrBanks = map[string]tpm2.Algorithm{
+	"sha1":   tpm2.AlgSHA1,
+	"sha256": tpm2.AlgSHA256,
+	"sha384": tpm2.AlgSHA384,
+	"sha512": tpm2.AlgSHA512,
+}
+
+// runPredict implements the predict subcommand, which prints the expected PCRs of a golden boot
+// image.
+func runPredict(args []string) {
+	flags := flag.NewFlagSet("predict", flag.ExitOnError)
+	firmware := flags.String("firmware", "", "Path to the firmware blob measured into PCR 0")
+	kernel := flags.String("kernel", "", "Path to the kernel PE/COFF image measured into PCR 4")
+	initrd := flags.String("initrd", "", "Path to the initrd measured into PCR 9 (optional)")
+	bank := flags.String("bank", "sha256", "PCR bank: sha1, sha256, sha384 or sha512")
+	format := flags.String("format", "json", "Output format: json for a verify config, or flags for -expected-pcr flags")
+	flags.Parse(args)
+
+	hash, ok := pcrBanks[strings.ToLower(*bank)]
+	if !ok {
+		log.Fatalf("Invalid -bank %q", *bank)
+	}
+	if *firmware == "" || *kernel == "" {
+		log.Fatalf("predict requires -firmware and -kernel")
+	}
+	img := &attestation.BootImage{
+		Firmware: readPredictInput(*firmware),
+		Kernel:   readPredictInput(*kernel),
+	}
+	if *initrd != "" {
+		img.Initrd = readPredictInput(*initrd)
+	}
+
+	pcrs, err := attestation.PredictBootImagePCRs(hash, img)
+	if err != nil {
+		log.Fatalf("Failed to predict PCRs: %v", err)
+	}
+
+	switch *format {
+	case "json":
+		// The same encoding as expectedPCRs in a VerifyOptions JSON configuration.
+		values := make(map[uint32]string, len(pcrs))
+		for index, digest := range pcrs {
+			values[index] = fmt.Sprintf("%x", digest)
+		}
+		out, err := json.MarshalIndent(map[string]any{"expectedPCRs": values}, "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to encode PCRs: %v", err)
+		}
+		fmt.Println(string(out))
+	case "flags":
+		indices := make([]uint32, 0, len(pcrs))
+		for index := range pcrs {
+			indices = append(indices, index)
+		}
+		sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+		for _, index := range indices {
+			fmt.Printf("-expected-pcr %d=%x\n", index, pcrs[index])
+		}
+	default:
+		log.Fatalf("Invalid -format %q", *format)
+	}
+}
+
+// readPredictInput reads a boot image component.
+func readPredictInput(path string) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return data
+}
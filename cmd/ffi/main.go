@@ -3,6 +3,7 @@ package main
 // #include <stdlib.h>
 import "C"
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"unsafe"
@@ -53,6 +54,26 @@ func VerifyAttestationFFI(attestationData *C.char, attestationLen C.int,
 	return C.CString(string(jsonBytes)), C.int(len(jsonBytes))
 }
 
+//export VerifyAttestationSummaryFFI
+func VerifyAttestationSummaryFFI(attestationData *C.char, attestationLen C.int,
+	formatStr *C.char,
+	nonce *C.char, nonceLen C.int,
+	teeNonce *C.char, teeNonceLen C.int) (*C.char, C.int) {
+	opts := attestation.DefaultVerifyOptions()
+	opts.Format = C.GoString(formatStr)
+	opts.Nonce = C.GoBytes(unsafe.Pointer(nonce), nonceLen)
+	opts.TeeNonce = C.GoBytes(unsafe.Pointer(teeNonce), teeNonceLen)
+	attestationBytes := C.GoBytes(unsafe.Pointer(attestationData), attestationLen)
+
+	// The summary has the same shape whether or not verification succeeded.
+	report, err := attestation.VerifyAttestationContext(context.Background(), attestationBytes, opts)
+	jsonBytes, _ := json.Marshal(attestation.Summarize(report, err))
+	if err != nil {
+		return C.CString(string(jsonBytes)), C.int(-1)
+	}
+	return C.CString(string(jsonBytes)), C.int(len(jsonBytes))
+}
+
 //export FreeString
 func FreeString(str *C.char) {
 	C.free(unsafe.Pointer(str))
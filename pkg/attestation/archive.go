@@ -0,0 +1,164 @@
+package attestation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"google.golang.org/protobuf/proto"
+)
+
+// archiveMagic starts every encoded AttestationArchive.
+var archiveMagic = []byte("LATA")
+
+// archiveVersion is the version of the AttestationArchive encoding.
+const archiveVersion = 1
+
+// archiveHeaderSize is the size of the fixed AttestationArchive header: magic, version, format,
+// and the lengths of the report and event log.
+const archiveHeaderSize = 4 + 1 + 1 + 4 + 4
+
+// Format codes of the report in an encoded AttestationArchive.
+const (
+	archiveFormatBinarypb  = 0
+	archiveFormatTextproto = 1
+)
+
+// AttestationArchive is an attestation report stored apart from its TCG event log. Its binary
+// encoding, from MarshalBinary, is a 14-byte header followed by the report and then the event log:
+//
+//	offset  size  field
+//	0       4     magic "LATA"
+//	4       1     version, 1
+//	5       1     report format: 0 for binarypb, 1 for textproto
+//	6       4     report length, big endian
+//	10      4     event log length, big endian
+//	14      n     report, without its event log
+//	14+n    m     event log
+type AttestationArchive struct {
+	// Attestation is the attestation report, without its event log
+	Attestation []byte
+	// Format is the format of Attestation, binarypb or textproto
+	Format string
+	// EventLog is the TCG event log of the report
+	EventLog []byte
+}
+
+// SplitAttestation moves the event log out of an attestation report, so that the report and the
+// log can be stored separately. The event log is not covered by the report's signatures, so the
+// report verifies the same once the log is reattached with Join or VerifyOptions.EventLog.
+func SplitAttestation(attestationBytes []byte, format string) (*AttestationArchive, error) {
+	attestation, err := unmarshalAttestation(attestationBytes, format)
+	if err != nil {
+		return nil, err
+	}
+	additions, err := envelopeAdditions(attestationBytes, format)
+	if err != nil {
+		return nil, err
+	}
+	eventLog := attestation.GetEventLog()
+	attestation.EventLog = nil
+	report, err := marshalAttestation(attestation, additions, format)
+	if err != nil {
+		return nil, err
+	}
+	return &AttestationArchive{Attestation: report, Format: format, EventLog: eventLog}, nil
+}
+
+// Join returns the attestation report of the archive with its event log reattached.
+func (a *AttestationArchive) Join() ([]byte, error) {
+	attestation, err := unmarshalAttestation(a.Attestation, a.Format)
+	if err != nil {
+		return nil, err
+	}
+	additions, err := envelopeAdditions(a.Attestation, a.Format)
+	if err != nil {
+		return nil, err
+	}
+	if err := attachEventLog(attestation, a.EventLog); err != nil {
+		return nil, err
+	}
+	return marshalAttestation(attestation, additions, a.Format)
+}
+
+// MarshalBinary encodes the archive as a single byte string.
+func (a *AttestationArchive) MarshalBinary() ([]byte, error) {
+	var format byte
+	switch a.Format {
+	case "binarypb":
+		format = archiveFormatBinarypb
+	case "textproto":
+		format = archiveFormatTextproto
+	default:
+		return nil, fmt.Errorf("format should be either binarypb or textproto")
+	}
+	if uint64(len(a.Attestation)) > 0xffffffff || uint64(len(a.EventLog)) > 0xffffffff {
+		return nil, fmt.Errorf("attestation archive parts must each be smaller than 4 GiB")
+	}
+
+	out := make([]byte, 0, archiveHeaderSize+len(a.Attestation)+len(a.EventLog))
+	out = append(out, archiveMagic...)
+	out = append(out, archiveVersion, format)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(a.Attestation)))
+	out = binary.BigEndian.AppendUint32(out, uint32(len(a.EventLog)))
+	out = append(out, a.Attestation...)
+	out = append(out, a.EventLog...)
+	return out, nil
+}
+
+// UnmarshalBinary decodes an archive encoded by MarshalBinary.
+func (a *AttestationArchive) UnmarshalBinary(data []byte) error {
+	if len(data) < archiveHeaderSize || !bytes.Equal(data[:4], archiveMagic) {
+		return fmt.Errorf("not an attestation archive")
+	}
+	if data[4] != archiveVersion {
+		return fmt.Errorf("unsupported attestation archive version %d", data[4])
+	}
+	var format string
+	switch data[5] {
+	case archiveFormatBinarypb:
+		format = "binarypb"
+	case archiveFormatTextproto:
+		format = "textproto"
+	default:
+		return fmt.Errorf("unknown attestation archive format %d", data[5])
+	}
+	reportLen := uint64(binary.BigEndian.Uint32(data[6:10]))
+	eventLogLen := uint64(binary.BigEndian.Uint32(data[10:14]))
+	body := data[archiveHeaderSize:]
+	if uint64(len(body)) != reportLen+eventLogLen {
+		return fmt.Errorf("attestation archive has %d bytes after its header, expected %d", len(body), reportLen+eventLogLen)
+	}
+
+	a.Format = format
+	a.Attestation = append([]byte(nil), body[:reportLen]...)
+	a.EventLog = append([]byte(nil), body[reportLen:]...)
+	return nil
+}
+
+// attachEventLog sets the event log of an attestation that was stored without one.
+func attachEventLog(attestation *pb.Attestation, eventLog []byte) error {
+	if existing := attestation.GetEventLog(); len(existing) != 0 && !bytes.Equal(existing, eventLog) {
+		return fmt.Errorf("attestation already contains a different event log")
+	}
+	attestation.EventLog = eventLog
+	return nil
+}
+
+// marshalAttestation encodes an attestation report in the given format, in an attestation
+// envelope with the encoded fields of additions if there are any.
+func marshalAttestation(attestation *pb.Attestation, additions []byte, format string) ([]byte, error) {
+	switch format {
+	case "binarypb":
+		out, err := proto.MarshalOptions{Deterministic: true}.Marshal(attestation)
+		if err != nil {
+			return nil, err
+		}
+		return wrapEnvelope(out, additions), nil
+	case "textproto":
+		return marshalOptions.Marshal(attestation)
+	default:
+		return nil, fmt.Errorf("format should be either binarypb or textproto")
+	}
+}
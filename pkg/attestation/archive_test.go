@@ -0,0 +1,179 @@
+package attestation
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+)
+
+// exampleNonce is the nonce of the report of the example command.
+var exampleNonce = []byte("fixed-deterministic-nonce-for-server")
+
+// exampleReport returns the report of the example command, a TDX report with an event log.
+func exampleReport(t *testing.T) []byte {
+	t.Helper()
+	encoded, err := os.ReadFile("../../cmd/example/attestation.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func exampleVerifyOptions() VerifyOptions {
+	opts := DefaultVerifyOptions()
+	opts.Nonce = exampleNonce
+	return opts
+}
+
+func TestAttestationArchiveRoundTrip(t *testing.T) {
+	report := exampleReport(t)
+	original, err := unmarshalAttestation(report, "binarypb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(original.GetEventLog()) == 0 {
+		t.Fatal("example report has no event log")
+	}
+
+	for _, format := range []string{"binarypb", "textproto"} {
+		t.Run(format, func(t *testing.T) {
+			input := report
+			if format == "textproto" {
+				if input, err = marshalAttestation(original, nil, format); err != nil {
+					t.Fatal(err)
+				}
+			}
+			archive, err := SplitAttestation(input, format)
+			if err != nil {
+				t.Fatalf("SplitAttestation() failed: %v", err)
+			}
+			if !bytes.Equal(archive.EventLog, original.GetEventLog()) {
+				t.Errorf("SplitAttestation() event log has %d bytes, want the %d of the report", len(archive.EventLog), len(original.GetEventLog()))
+			}
+			if stripped, err := unmarshalAttestation(archive.Attestation, format); err != nil || len(stripped.GetEventLog()) != 0 {
+				t.Errorf("SplitAttestation() report still carries an event log (%v)", err)
+			}
+
+			// The stored report verifies with the log passed separately.
+			opts := exampleVerifyOptions()
+			opts.EventLog = archive.EventLog
+			if _, err := VerifyAttestationFromReader(bytes.NewReader(archive.Attestation), withFormat(opts, format)); err != nil {
+				t.Errorf("verifying the split report with VerifyOptions.EventLog failed: %v", err)
+			}
+
+			encoded, err := archive.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary() failed: %v", err)
+			}
+			if len(encoded) != archiveHeaderSize+len(archive.Attestation)+len(archive.EventLog) {
+				t.Errorf("MarshalBinary() returned %d bytes, want the header and both parts", len(encoded))
+			}
+			var decoded AttestationArchive
+			if err := decoded.UnmarshalBinary(encoded); err != nil {
+				t.Fatalf("UnmarshalBinary() failed: %v", err)
+			}
+			if decoded.Format != format || !bytes.Equal(decoded.Attestation, archive.Attestation) || !bytes.Equal(decoded.EventLog, archive.EventLog) {
+				t.Errorf("UnmarshalBinary() = %s archive of %d and %d bytes, want the %s archive of %d and %d bytes",
+					decoded.Format, len(decoded.Attestation), len(decoded.EventLog), format, len(archive.Attestation), len(archive.EventLog))
+			}
+
+			joined, err := decoded.Join()
+			if err != nil {
+				t.Fatalf("Join() failed: %v", err)
+			}
+			if _, err := VerifyAttestationFromReader(bytes.NewReader(joined), withFormat(exampleVerifyOptions(), format)); err != nil {
+				t.Errorf("verifying the joined report failed: %v", err)
+			}
+		})
+	}
+}
+
+// withFormat returns opts for reports in format.
+func withFormat(opts VerifyOptions, format string) VerifyOptions {
+	opts.Format = format
+	return opts
+}
+
+func TestAttestationArchiveCorrupted(t *testing.T) {
+	archive, err := SplitAttestation(exampleReport(t), "binarypb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := archive.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := func(offset int, b byte) []byte {
+		data := bytes.Clone(encoded)
+		data[offset] = b
+		return data
+	}
+
+	// resplit moves the boundary between the report and the event log by n bytes, keeping the
+	// lengths consistent with the body.
+	resplit := func(data []byte, n uint32) []byte {
+		data = bytes.Clone(data)
+		binary.BigEndian.PutUint32(data[6:], binary.BigEndian.Uint32(data[6:])-n)
+		binary.BigEndian.PutUint32(data[10:], binary.BigEndian.Uint32(data[10:])+n)
+		return data
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{name: "short header", data: encoded[:archiveHeaderSize-1], wantErr: "not an attestation archive"},
+		{name: "magic", data: corrupt(0, 'X'), wantErr: "not an attestation archive"},
+		{name: "version", data: corrupt(4, 2), wantErr: "unsupported attestation archive version 2"},
+		{name: "format", data: corrupt(5, 7), wantErr: "unknown attestation archive format 7"},
+		{name: "truncated", data: encoded[:len(encoded)-1], wantErr: "bytes after its header"},
+		{name: "trailing data", data: append(bytes.Clone(encoded), 0), wantErr: "bytes after its header"},
+		{name: "misplaced split", data: resplit(encoded, 1), wantErr: ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var decoded AttestationArchive
+			err := decoded.UnmarshalBinary(tc.data)
+			if tc.wantErr == "" {
+				// The lengths still add up, but the parts are cut at the wrong place.
+				if err == nil {
+					_, err = decoded.Join()
+				}
+				if err == nil {
+					t.Fatal("decoding and joining the archive succeeded, want an error")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("UnmarshalBinary() = %v, want an error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+
+	// An archive whose event log was cut short still decodes and joins, but the log no longer
+	// replays to the quoted PCRs.
+	archive.EventLog = archive.EventLog[:len(archive.EventLog)/2]
+	data, err := archive.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded AttestationArchive
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary() failed: %v", err)
+	}
+	joined, err := decoded.Join()
+	if err != nil {
+		t.Fatalf("Join() failed: %v", err)
+	}
+	if _, err := VerifyAttestationContext(t.Context(), joined, exampleVerifyOptions()); err == nil {
+		t.Error("verifying the report with a truncated event log succeeded")
+	}
+}
@@ -0,0 +1,54 @@
+package attestation
+
+import (
+	"context"
+	"runtime"
+)
+
+// VerifyResult is the outcome of a verification started with VerifyAsync
+type VerifyResult struct {
+	// Request is the request that was verified
+	Request VerifyRequest
+	// Report is the verification report, if verification succeeded
+	Report *VerificationReport
+	// Err is the verification error, if verification failed
+	Err error
+}
+
+// asyncWorkers returns the number of concurrent VerifyAsync verifications of a verifier.
+func asyncWorkers(config VerifierConfig) int {
+	if config.AsyncWorkers > 0 {
+		return config.AsyncWorkers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// VerifyAsync verifies req as Verify does on one of the verifier's VerifierConfig.AsyncWorkers
+// workers, and returns a channel that receives its result and is then closed. When every worker
+// is busy, VerifyAsync blocks until one is free or ctx is done, so producers submitting faster
+// than reports verify are slowed down rather than queueing without bound. If ctx is done first,
+// the result holds ctx.Err(). The channel is buffered, so a result that is never received does
+// not hold a worker.
+func (v *Verifier) VerifyAsync(ctx context.Context, req VerifyRequest) <-chan VerifyResult {
+	results := make(chan VerifyResult, 1)
+	if err := ctx.Err(); err != nil {
+		results <- VerifyResult{Request: req, Err: err}
+		close(results)
+		return results
+	}
+	select {
+	case v.workers <- struct{}{}:
+	case <-ctx.Done():
+		results <- VerifyResult{Request: req, Err: ctx.Err()}
+		close(results)
+		return results
+	}
+
+	go func() {
+		defer func() { <-v.workers }()
+		report, err := v.Verify(ctx, req)
+		results <- VerifyResult{Request: req, Report: report, Err: err}
+		close(results)
+	}()
+	return results
+}
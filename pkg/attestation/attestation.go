@@ -2,11 +2,12 @@ package attestation
 
 import (
 	"context"
+	"crypto"
 	"fmt"
 	"io"
-	"strconv"
+	"strings"
+	"time"
 
-	"cloud.google.com/go/compute/metadata"
 	"github.com/google/go-tpm-tools/client"
 	"github.com/google/go-tpm-tools/proto/attest"
 	"github.com/google/go-tpm/legacy/tpm2"
@@ -22,12 +23,21 @@ const (
 	Tdx = "tdx"
 )
 
+// Attestation key type constants
+const (
+	// KeyAK is the key type of the default attestation key, derived from the TPM's owner hierarchy
+	KeyAK = "AK"
+	// KeyGceAK is the key type of the attestation key provisioned by GCE, which has a certificate
+	// issued by Google
+	KeyGceAK = "gceAK"
+)
+
 var attestationKeys = map[string]map[tpm2.Algorithm]func(rw io.ReadWriter) (*client.Key, error){
-	"AK": {
+	KeyAK: {
 		tpm2.AlgRSA: client.AttestationKeyRSA,
 		tpm2.AlgECC: client.AttestationKeyECC,
 	},
-	"gceAK": {
+	KeyGceAK: {
 		tpm2.AlgRSA: client.GceAttestationKeyRSA,
 		tpm2.AlgECC: client.GceAttestationKeyECC,
 	},
@@ -41,107 +51,210 @@ var marshalOptions = prototext.MarshalOptions{
 
 // AttestOptions contains all the options for creating an attestation report
 type AttestOptions struct {
-	// Key specifies the type of attestation key (AK or gceAK)
+	// Key specifies the type of attestation key (KeyAK or KeyGceAK)
 	Key string
 	// KeyAlgo specifies the public key algorithm (RSA or ECC)
 	KeyAlgo tpm2.Algorithm
-	// Nonce is random data used to ensure freshness of the quote
+	// KeyHash specifies the hash algorithm of the AK signing scheme (SHA256, SHA384 or SHA512).
+	// For ECC keys, SHA384 and SHA512 select the P-384 and P-521 curves. Only AK supports hashes
+	// other than SHA256, as gceAK uses the template provisioned by GCE. Defaults to SHA256.
+	KeyHash tpm2.Algorithm
+	// Nonce is random data used to ensure freshness of the quote, at most MaxNonceSize bytes
 	Nonce []byte
 	// TeeTechnology specifies the TEE hardware type (sev-snp, tdx, or empty)
 	TeeTechnology string
-	// TeeNonce attaches extra data to the attestation report of TEE hardware
+	// TeeNonce attaches extra data to the attestation report of TEE hardware, at most MaxNonceSize
+	// bytes
 	TeeNonce []byte
 	// Format specifies the output format (binarypb or textproto)
 	Format string
+	// StrictNonce rejects weak nonces using ValidateNonce
+	StrictNonce bool
+	// InstanceInfoProvider supplies the instance information attached to gceAK attestations.
+	// Defaults to the GCE metadata server.
+	InstanceInfoProvider InstanceInfoProvider
+	// BusyRetries is how many times opening the TPM is retried while another process holds it.
+	// Once retries are exhausted, Attest fails with ErrTPMBusy.
+	BusyRetries int
+	// BusyRetryDelay is the delay before the first retry, doubling after each retry.
+	// Defaults to DefaultBusyRetryDelay.
+	BusyRetryDelay time.Duration
+	// WorkloadClaim is attached to the attestation, bound to Nonce and signed by WorkloadSigner.
+	// It requires the binarypb format.
+	WorkloadClaim *WorkloadClaim
+	// WorkloadSigner is the host key that signs WorkloadClaim
+	WorkloadSigner crypto.Signer
+	// MaxEventLogSize is the maximum size in bytes of the TCG event log. 0 means no limit.
+	MaxEventLogSize int
+	// EventLogOverflow is what Attest does with a larger event log: EventLogError fails, and
+	// EventLogTruncate omits the log, which verifiers report as VerificationReport.EventLogTruncated.
+	// Defaults to EventLogError.
+	EventLogOverflow string
+	// NVIndices are TPM NV indices whose contents are attached to the attestation, certified by the
+	// AK over Nonce where the TPM allows it. The contents of the others are unauthenticated. They
+	// require the binarypb format.
+	NVIndices []uint32
+	// AttachEKCert attaches the certificate of the TPM's EK, for VerifyOptions.TrustedEKRoots. It
+	// requires the binarypb format.
+	AttachEKCert bool
+	// AttachEKPub attaches the public area of the TPM's EK, also on TPMs without an EK certificate
+	// such as most vTPMs. It requires the binarypb format.
+	AttachEKPub bool
+	// CanonicalText encodes textproto reports with CanonicalText, whose layout is stable across
+	// protobuf library versions, instead of the prototext default
+	CanonicalText bool
+	// OmitEventLog leaves the TCG event log out of the attestation, e.g. on platforms without one.
+	// Verifiers then see no boot events; see VerifyOptions.AllowMissingEventLog.
+	OmitEventLog bool
+	// TPM is used instead of opening the TPM device, and is left open. It is meant for a
+	// go-tpm-tools simulator when generating fixtures. Its event log is read only if it implements
+	// client.EventLogGetter; otherwise the attestation has no event log.
+	TPM io.ReadWriter
 }
 
 // DefaultAttestOptions returns the default options for attestation
 func DefaultAttestOptions() AttestOptions {
 	return AttestOptions{
-		Key:           "AK",
-		KeyAlgo:       tpm2.AlgRSA,
-		Nonce:         nil,
-		TeeTechnology: "",
-		TeeNonce:      nil,
-		Format:        "binarypb",
+		Key:                  KeyAK,
+		KeyAlgo:              tpm2.AlgRSA,
+		KeyHash:              tpm2.AlgSHA256,
+		Nonce:                nil,
+		TeeTechnology:        "",
+		TeeNonce:             nil,
+		Format:               "binarypb",
+		StrictNonce:          false,
+		InstanceInfoProvider: nil,
+		BusyRetries:          0,
+		BusyRetryDelay:       DefaultBusyRetryDelay,
+		WorkloadClaim:        nil,
+		WorkloadSigner:       nil,
+		MaxEventLogSize:      0,
+		EventLogOverflow:     EventLogError,
+		NVIndices:            nil,
+		AttachEKCert:         false,
+		AttachEKPub:          false,
+		CanonicalText:        false,
+		OmitEventLog:         false,
+		TPM:                  nil,
 	}
 }
 
 // Attest creates a remote attestation report based on the provided options
 func Attest(opts AttestOptions) ([]byte, error) {
+	return attestWith(opts, nil)
+}
 
-	// Open the TPM device
-	rwc, err := tpm2.OpenTPM()
+// attestWith creates a remote attestation report. If beforeQuote is non-nil, it is called with the
+// TPM right before the quote, once everything else that can fail has succeeded.
+func attestWith(opts AttestOptions, beforeQuote func(rw io.ReadWriter) error) ([]byte, error) {
+	attestOpts, err := buildAttestOpts(opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open TPM: %v", err)
+		return nil, err
 	}
-	defer rwc.Close()
 
-	if !(opts.Format == "binarypb" || opts.Format == "textproto") {
-		return nil, fmt.Errorf("format should be either binarypb or textproto")
+	rwc, err := attestationTPM(opts)
+	if err != nil {
+		return nil, err
 	}
+	defer rwc.Close()
 
-	var attestationKey *client.Key
-	algoToCreateAK, ok := attestationKeys[opts.Key]
-	if !ok {
-		return nil, fmt.Errorf("key should be either AK or gceAK")
-	}
-	createFunc := algoToCreateAK[opts.KeyAlgo]
-	attestationKey, attKeyErr := createFunc(rwc)
-	if attKeyErr != nil {
-		return nil, fmt.Errorf("failed to create attestation key: %v", err)
+	attestationKey, err := createAttestationKey(rwc, opts.Key, opts.KeyAlgo, opts.KeyHash)
+	if err != nil {
+		return nil, err
 	}
 	defer attestationKey.Close()
 
-	attestOpts := client.AttestOpts{}
-	attestOpts.Nonce = opts.Nonce
+	attestOpts.TEEDevice, err = openTEEDevice(opts.TeeTechnology)
+	if err != nil {
+		return nil, err
+	}
 
-	// Add logic to open other hardware devices when required.
-	switch opts.TeeTechnology {
-	case SevSnp:
-		attestOpts.TEEDevice, err = client.CreateSevSnpQuoteProvider()
-		if err != nil {
-			return nil, fmt.Errorf("failed to open %s device: %v", SevSnp, err)
-		}
-		attestOpts.TEENonce = opts.TeeNonce
-	case Tdx:
-		attestOpts.TEEDevice, err = client.CreateTdxQuoteProvider()
+	// An empty, non-nil log stops client.AttestOpts from reading the host's log.
+	eventLog := []byte{}
+	if !opts.OmitEventLog {
+		eventLog, err = readEventLog(opts, rwc)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create %s quote provider: %v", Tdx, err)
+			return nil, fmt.Errorf("failed to retrieve TCG Event Log: %w", err)
 		}
-		attestOpts.TEENonce = opts.TeeNonce
-	case "":
-		if len(opts.TeeNonce) != 0 {
-			return nil, fmt.Errorf("use of TeeNonce requires specifying TEE hardware type with TeeTechnology")
-		}
-	default:
-		return nil, fmt.Errorf("tee-technology should be either empty or should have values %s or %s", SevSnp, Tdx)
 	}
-
-	attestOpts.TCGEventLog, err = client.GetEventLog(rwc)
+	attestOpts.TCGEventLog, err = limitEventLog(eventLog, opts.MaxEventLogSize, opts.EventLogOverflow)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve TCG Event Log: %w", err)
+		return nil, err
 	}
 
+	if beforeQuote != nil {
+		if err := beforeQuote(rwc); err != nil {
+			return nil, err
+		}
+	}
 	attestation, err := attestationKey.Attest(attestOpts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to collect attestation report : %v", err)
 	}
 
-	if opts.Key == "gceAK" {
-		instanceInfo, err := getInstanceInfoFromMetadata()
+	if opts.Key == KeyGceAK {
+		provider := opts.InstanceInfoProvider
+		if provider == nil {
+			provider = &MetadataInstanceInfoProvider{}
+		}
+		instanceInfo, err := provider.InstanceInfo(context.Background())
 		if err != nil {
 			return nil, err
 		}
 		attestation.InstanceInfo = instanceInfo
 	}
 
+	// additions holds the envelope fields of what the Attestation message has no fields for.
+	var additions []byte
+	if len(opts.NVIndices) != 0 {
+		readings, err := readNVIndices(rwc, attestationKey, opts.NVIndices, opts.Nonce)
+		if err != nil {
+			return nil, err
+		}
+		if additions, err = attachNVReadings(additions, readings); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.AttachEKCert || opts.AttachEKPub {
+		ek, err := attachedEndorsementKey(rwc, opts.AttachEKCert)
+		if err != nil {
+			return nil, err
+		}
+		if opts.AttachEKCert {
+			additions = attachEKCertificate(additions, ek.CertDERBytes())
+		}
+		if opts.AttachEKPub {
+			additions, err = attachEKPublic(additions, ek.PublicArea())
+		}
+		ek.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.WorkloadClaim != nil {
+		claim := *opts.WorkloadClaim
+		claim.Nonce = opts.Nonce
+		signed, err := signWorkloadClaim(&claim, opts.WorkloadSigner)
+		if err != nil {
+			return nil, err
+		}
+		if additions, err = attachWorkloadClaim(additions, signed); err != nil {
+			return nil, err
+		}
+	}
+
 	var out []byte
 	if opts.Format == "binarypb" {
-		out, err = proto.Marshal(attestation)
+		// Map fields such as the quoted PCRs are otherwise encoded in random order.
+		out, err = proto.MarshalOptions{Deterministic: true}.Marshal(attestation)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal attestation proto: %v", attestation)
 		}
+		out = wrapEnvelope(out, additions)
+	} else if opts.CanonicalText {
+		out = CanonicalText(attestation)
 	} else {
 		out = []byte(marshalOptions.Format(attestation))
 	}
@@ -149,65 +262,205 @@ func Attest(opts AttestOptions) ([]byte, error) {
 	return out, nil
 }
 
-// GetAttestation creates an attestation report and returns the unmarshaled proto
-func GetAttestation(opts AttestOptions) (*attest.Attestation, error) {
-	attestBytes, err := Attest(opts)
-	if err != nil {
-		return nil, err
+// ValidKeyTypes returns the valid values of AttestOptions.Key.
+func ValidKeyTypes() []string {
+	return []string{KeyAK, KeyGceAK}
+}
+
+// validateKeyType checks that key is one of ValidKeyTypes.
+func validateKeyType(key string) error {
+	if _, ok := attestationKeys[key]; !ok {
+		return fmt.Errorf("invalid key type %q, valid key types are %s", key, strings.Join(ValidKeyTypes(), ", "))
 	}
+	return nil
+}
 
-	var attestation attest.Attestation
-	if opts.Format == "binarypb" {
-		if err := proto.Unmarshal(attestBytes, &attestation); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal attestation proto: %v", err)
+// buildAttestOpts validates opts and assembles the client.AttestOpts passed to go-tpm-tools, without
+// opening any device. The TEE device and event log are added by Attest.
+func buildAttestOpts(opts AttestOptions) (client.AttestOpts, error) {
+	if err := checkNonceSizes(opts.Nonce, opts.TeeNonce); err != nil {
+		return client.AttestOpts{}, err
+	}
+	if opts.StrictNonce {
+		if err := validateNonces(opts.Nonce, opts.TeeNonce); err != nil {
+			return client.AttestOpts{}, err
 		}
-	} else {
-		if err := prototext.Unmarshal(attestBytes, &attestation); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal attestation proto: %v", err)
+	}
+	if err := validateKeyType(opts.Key); err != nil {
+		return client.AttestOpts{}, err
+	}
+
+	if !(opts.Format == "binarypb" || opts.Format == "textproto") {
+		return client.AttestOpts{}, fmt.Errorf("format should be either binarypb or textproto")
+	}
+	if opts.WorkloadClaim != nil {
+		if opts.Format != "binarypb" {
+			return client.AttestOpts{}, fmt.Errorf("workload claims require the binarypb format")
+		}
+		if opts.WorkloadSigner == nil {
+			return client.AttestOpts{}, fmt.Errorf("workload claims require a WorkloadSigner")
 		}
 	}
+	if len(opts.NVIndices) != 0 && opts.Format != "binarypb" {
+		return client.AttestOpts{}, fmt.Errorf("NV indices require the binarypb format")
+	}
+	if opts.AttachEKCert && opts.Format != "binarypb" {
+		return client.AttestOpts{}, fmt.Errorf("EK certificates require the binarypb format")
+	}
+	if opts.AttachEKPub && opts.Format != "binarypb" {
+		return client.AttestOpts{}, fmt.Errorf("EK public keys require the binarypb format")
+	}
 
-	return &attestation, nil
+	attestOpts := client.AttestOpts{Nonce: opts.Nonce}
+	switch opts.TeeTechnology {
+	case SevSnp, Tdx:
+		attestOpts.TEENonce = opts.TeeNonce
+	case "":
+		if len(opts.TeeNonce) != 0 {
+			return client.AttestOpts{}, fmt.Errorf("use of TeeNonce requires specifying TEE hardware type with TeeTechnology")
+		}
+	default:
+		return client.AttestOpts{}, fmt.Errorf("tee-technology should be either empty or should have values %s or %s", SevSnp, Tdx)
+	}
+	return attestOpts, nil
 }
 
-// getInstanceInfoFromMetadata fetches GCE instance information from metadata server
-func getInstanceInfoFromMetadata() (*attest.GCEInstanceInfo, error) {
-	ctx := context.Background()
-	var err error
-	instanceInfo := &attest.GCEInstanceInfo{}
+// openTEEDevice opens the quote provider of the TEE technology, or returns nil without one.
+func openTEEDevice(teeTechnology string) (client.TEEDevice, error) {
+	// Add logic to open other hardware devices when required.
+	switch teeTechnology {
+	case SevSnp:
+		device, err := client.CreateSevSnpQuoteProvider()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s device: %v", SevSnp, err)
+		}
+		return device, nil
+	case Tdx:
+		device, err := client.CreateTdxQuoteProvider()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s quote provider: %v", Tdx, err)
+		}
+		return device, nil
+	}
+	return nil, nil
+}
 
-	instanceInfo.ProjectId, err = metadata.ProjectIDWithContext(ctx)
+// AttestOptsView describes the options Attest passes to go-tpm-tools for a given AttestOptions,
+// for logging the effective configuration
+type AttestOptsView struct {
+	// Nonce is the nonce quoted by the TPM
+	Nonce HexBytes `json:"nonce"`
+	// TEEDevice is the TEE technology whose quote provider Attest opens, if any
+	TEEDevice string `json:"teeDevice,omitempty"`
+	// TEENonce is the nonce bound into the TEE report
+	TEENonce HexBytes `json:"teeNonce,omitempty"`
+	// MaxEventLogSize is the limit applied to the TCG event log read from the TPM, 0 for none
+	MaxEventLogSize int `json:"maxEventLogSize,omitempty"`
+	// EventLogOverflow is what happens to an event log over MaxEventLogSize
+	EventLogOverflow string `json:"eventLogOverflow,omitempty"`
+}
+
+// EffectiveAttestOpts validates opts as Attest does and describes the options it would pass to
+// go-tpm-tools, without opening the TPM or TEE devices.
+func EffectiveAttestOpts(opts AttestOptions) (*AttestOptsView, error) {
+	attestOpts, err := buildAttestOpts(opts)
 	if err != nil {
 		return nil, err
 	}
+	view := &AttestOptsView{
+		Nonce:           attestOpts.Nonce,
+		TEEDevice:       opts.TeeTechnology,
+		TEENonce:        attestOpts.TEENonce,
+		MaxEventLogSize: opts.MaxEventLogSize,
+	}
+	if opts.MaxEventLogSize > 0 {
+		view.EventLogOverflow = opts.EventLogOverflow
+	}
+	return view, nil
+}
 
-	projectNumber, err := metadata.NumericProjectIDWithContext(ctx)
-	if err != nil {
+// createAttestationKey creates the attestation key of the given type, algorithm and signing hash.
+func createAttestationKey(rw io.ReadWriter, key string, keyAlgo tpm2.Algorithm, keyHash tpm2.Algorithm) (*client.Key, error) {
+	if err := validateAttestationKey(key, keyAlgo, keyHash); err != nil {
 		return nil, err
 	}
-	instanceInfo.ProjectNumber, err = strconv.ParseUint(projectNumber, 10, 64)
+
+	var attestationKey *client.Key
+	var err error
+	if keyHash == 0 || keyHash == tpm2.AlgSHA256 {
+		attestationKey, err = attestationKeys[key][keyAlgo](rw)
+	} else {
+		attestationKey, err = client.NewKey(rw, tpm2.HandleOwner, akTemplate(keyAlgo, keyHash))
+	}
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to create attestation key: %v", err)
 	}
+	return attestationKey, nil
+}
 
-	instanceInfo.Zone, err = metadata.ZoneWithContext(ctx)
-	if err != nil {
-		return nil, err
+// validateAttestationKey checks that an attestation key of the given type, algorithm and signing
+// hash can be created.
+func validateAttestationKey(key string, keyAlgo tpm2.Algorithm, keyHash tpm2.Algorithm) error {
+	if err := validateKeyType(key); err != nil {
+		return err
+	}
+	if _, ok := attestationKeys[key][keyAlgo]; !ok {
+		return fmt.Errorf("key-algo should be either RSA or ECC")
 	}
+	switch keyHash {
+	case 0, tpm2.AlgSHA256:
+	case tpm2.AlgSHA384, tpm2.AlgSHA512:
+		if key != KeyAK {
+			return fmt.Errorf("%s only supports SHA256 as key-hash", key)
+		}
+	default:
+		return fmt.Errorf("key-hash should be either SHA256, SHA384 or SHA512")
+	}
+	return nil
+}
 
-	instanceID, err := metadata.InstanceIDWithContext(ctx)
-	if err != nil {
-		return nil, err
+// akTemplate returns the default AK template for keyAlgo, signing with keyHash instead of SHA256.
+func akTemplate(keyAlgo tpm2.Algorithm, keyHash tpm2.Algorithm) tpm2.Public {
+	if keyAlgo == tpm2.AlgRSA {
+		template := client.AKTemplateRSA()
+		template.RSAParameters.Sign.Hash = keyHash
+		return template
 	}
-	instanceInfo.InstanceId, err = strconv.ParseUint(instanceID, 10, 64)
-	if err != nil {
-		return nil, err
+	template := client.AKTemplateECC()
+	template.ECCParameters.Sign.Hash = keyHash
+	template.ECCParameters.CurveID = tpm2.CurveNISTP384
+	size := 48
+	if keyHash == tpm2.AlgSHA512 {
+		template.ECCParameters.CurveID = tpm2.CurveNISTP521
+		size = 66
 	}
+	// The unique field holds zeros of the curve's coordinate size, as in the TCG EK templates,
+	// rather than the P-256 coordinates of the default template.
+	template.ECCParameters.Point = tpm2.ECPoint{XRaw: make([]byte, size), YRaw: make([]byte, size)}
+	return template
+}
 
-	instanceInfo.InstanceName, err = metadata.InstanceNameWithContext(ctx)
+// GetAttestation creates an attestation report and returns the unmarshaled proto
+func GetAttestation(opts AttestOptions) (*attest.Attestation, error) {
+	attestBytes, err := Attest(opts)
 	if err != nil {
 		return nil, err
 	}
 
-	return instanceInfo, err
+	var attestation attest.Attestation
+	if opts.Format == "binarypb" {
+		attestBytes, _, err = unwrapEnvelope(attestBytes)
+		if err != nil {
+			return nil, err
+		}
+		if err := proto.Unmarshal(attestBytes, &attestation); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal attestation proto: %v", err)
+		}
+	} else {
+		if err := prototext.Unmarshal(attestBytes, &attestation); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal attestation proto: %v", err)
+		}
+	}
+
+	return &attestation, nil
 }
@@ -0,0 +1,58 @@
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+func TestAKTemplateECCUniqueSize(t *testing.T) {
+	for _, tc := range []struct {
+		hash tpm2.Algorithm
+		size int
+	}{
+		{hash: tpm2.AlgSHA384, size: 48},
+		{hash: tpm2.AlgSHA512, size: 66},
+	} {
+		point := akTemplate(tpm2.AlgECC, tc.hash).ECCParameters.Point
+		if len(point.XRaw) != tc.size || len(point.YRaw) != tc.size {
+			t.Errorf("akTemplate(ECC, %v) unique field has %d and %d bytes, want %d", tc.hash, len(point.XRaw), len(point.YRaw), tc.size)
+		}
+	}
+}
+
+func TestAttestWithLargerECCKeys(t *testing.T) {
+	for _, tc := range []struct {
+		hash    tpm2.Algorithm
+		bitSize int
+	}{
+		{hash: tpm2.AlgSHA256, bitSize: 256},
+		{hash: tpm2.AlgSHA384, bitSize: 384},
+		{hash: tpm2.AlgSHA512, bitSize: 521},
+	} {
+		t.Run(tc.hash.String(), func(t *testing.T) {
+			nonce := []byte("larger-ecc-key-nonce")
+			opts := DefaultAttestOptions()
+			opts.KeyAlgo = tpm2.AlgECC
+			opts.KeyHash = tc.hash
+			report := attestWithSimulator(t, opts, nonce)
+
+			verified, err := VerifyAttestationContext(t.Context(), report, simulatorVerifyOptions(nonce))
+			if err != nil {
+				t.Fatalf("VerifyAttestationContext() failed: %v", err)
+			}
+			pub, err := tpm2.DecodePublic(verified.Attestation.GetAkPub())
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, err := pub.Key()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ecKey, ok := key.(*ecdsa.PublicKey); !ok || ecKey.Curve.Params().BitSize != tc.bitSize {
+				t.Errorf("AK is %T, want a P-%d key", key, tc.bitSize)
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+// Package attestationtest builds valid attestation reports with a simulated TPM, and deliberately
+// malformed ones derived from them, so that verification policies and their rejection paths can
+// be tested without a TPM or TEE. The simulator requires cgo.
+package attestationtest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/google/go-tpm-tools/client"
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/legacy/tpm2"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"lunal-attestation/pkg/attestation"
+)
+
+// UnknownTEEField is the field number UnknownTEE stores its TEE attestation in. pb.Attestation
+// does not define it, as for a TEE technology added by a newer attester.
+const UnknownTEEField protowire.Number = 100
+
+// evNoAction is the type of TCG events that are not extended into a PCR.
+const evNoAction = 0x3
+
+// Builder attests with a simulated TPM
+type Builder struct {
+	sim *simulator.Simulator
+	// Options are the options of each attestation. Attest sets their Nonce, Format and TPM.
+	Options attestation.AttestOptions
+}
+
+// NewBuilder starts a simulated TPM seeded with seed, so that its AK and PCRs are the same across
+// runs, and returns a Builder attesting with it and DefaultAttestOptions. Call Close when done.
+func NewBuilder(seed int64) (*Builder, error) {
+	sim, err := simulator.GetWithFixedSeedInsecure(seed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start TPM simulator: %v", err)
+	}
+	return &Builder{sim: sim, Options: attestation.DefaultAttestOptions()}, nil
+}
+
+// Close shuts down the simulated TPM of b
+func (b *Builder) Close() error {
+	return b.sim.Close()
+}
+
+// Attestation returns a report quoted over nonce with mutations applied in order. Without
+// mutations, the report verifies with nonce. Additions of Options such as a workload claim are
+// carried in the attestation envelope, not the Attestation.
+func (b *Builder) Attestation(nonce []byte, mutations ...Mutation) (*pb.Attestation, error) {
+	report, err := attestation.GetAttestation(b.options(nonce))
+	if err != nil {
+		return nil, err
+	}
+	if err := Apply(report, mutations...); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Attest returns the binary encoding of Attestation(nonce, mutations...). Without mutations, it is
+// the report of Attest, in an attestation envelope if Options have additions.
+func (b *Builder) Attest(nonce []byte, mutations ...Mutation) ([]byte, error) {
+	if len(mutations) == 0 {
+		return attestation.Attest(b.options(nonce))
+	}
+	report, err := b.Attestation(nonce, mutations...)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(report)
+}
+
+// options returns the options of an attestation over nonce.
+func (b *Builder) options(nonce []byte) attestation.AttestOptions {
+	opts := b.Options
+	opts.Nonce = nonce
+	opts.Format = "binarypb"
+	opts.TPM = b.sim
+	return opts
+}
+
+// Mutation modifies an attestation report, typically so that verification rejects it
+type Mutation func(*pb.Attestation) error
+
+// Apply applies mutations to report in order.
+func Apply(report *pb.Attestation, mutations ...Mutation) error {
+	for _, mutate := range mutations {
+		if err := mutate(report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mutate applies mutations to a binary report, such as a captured fixture, and returns the result.
+func Mutate(data []byte, mutations ...Mutation) ([]byte, error) {
+	report := &pb.Attestation{}
+	if err := proto.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal attestation report: %v", err)
+	}
+	if err := Apply(report, mutations...); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(report)
+}
+
+// CorruptQuoteSignature flips a bit of the signature of every quote.
+func CorruptQuoteSignature(report *pb.Attestation) error {
+	if len(report.GetQuotes()) == 0 {
+		return fmt.Errorf("attestation has no quotes")
+	}
+	for _, quote := range report.GetQuotes() {
+		if err := flipLastBit(quote.GetRawSig()); err != nil {
+			return fmt.Errorf("quote signature: %v", err)
+		}
+	}
+	return nil
+}
+
+// TamperPCR flips a bit of PCR index in every quote that carries it, so that the PCR values no
+// longer match the quoted digest.
+func TamperPCR(index uint32) Mutation {
+	return func(report *pb.Attestation) error {
+		found := false
+		for _, quote := range report.GetQuotes() {
+			if value, ok := quote.GetPcrs().GetPcrs()[index]; ok {
+				found = true
+				if err := flipLastBit(value); err != nil {
+					return fmt.Errorf("PCR %d: %v", index, err)
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("attestation does not quote PCR %d", index)
+		}
+		return nil
+	}
+}
+
+// DropQuotes removes every quote.
+func DropQuotes(report *pb.Attestation) error {
+	report.Quotes = nil
+	return nil
+}
+
+// CorruptEventLog flips a bit of every digest of the first measured event, so that the event log
+// no longer replays to the quoted PCRs. The event log must be in the crypto agile format.
+func CorruptEventLog(report *pb.Attestation) error {
+	offsets, err := firstEventDigests(report.GetEventLog())
+	if err != nil {
+		return fmt.Errorf("event log: %v", err)
+	}
+	for _, offset := range offsets {
+		report.EventLog[offset] ^= 1
+	}
+	return nil
+}
+
+// MismatchedAKPub replaces the AK public area with that of a new RSA key, which did not sign the
+// quotes.
+func MismatchedAKPub(report *pb.Attestation) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	public := client.AKTemplateRSA()
+	public.RSAParameters.ModulusRaw = key.N.Bytes()
+	report.AkPub, err = public.Encode()
+	return err
+}
+
+// UntrustedAKCert attaches a certificate for the AK from a throwaway issuer, which does not chain
+// to the Google roots of a gceAK certificate.
+func UntrustedAKCert(report *pb.Attestation) error {
+	public, err := tpm2.DecodePublic(report.GetAkPub())
+	if err != nil {
+		return fmt.Errorf("failed to decode AK: %v", err)
+	}
+	akPub, err := public.Key()
+	if err != nil {
+		return fmt.Errorf("failed to decode AK: %v", err)
+	}
+	report.AkCert, err = untrustedCertificate(akPub)
+	return err
+}
+
+// MismatchedAKCert attaches a certificate from a throwaway issuer for a key other than the AK.
+func MismatchedAKCert(report *pb.Attestation) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	report.AkCert, err = untrustedCertificate(&key.PublicKey)
+	return err
+}
+
+// UnknownTEE replaces any TEE attestation with opaque bytes in UnknownTEEField, as a report of a
+// TEE technology this package does not know would carry.
+func UnknownTEE(report *pb.Attestation) error {
+	report.TeeAttestation = nil
+	unknown := report.ProtoReflect().GetUnknown()
+	unknown = protowire.AppendTag(unknown, UnknownTEEField, protowire.BytesType)
+	unknown = protowire.AppendBytes(unknown, []byte("unknown TEE attestation"))
+	report.ProtoReflect().SetUnknown(unknown)
+	return nil
+}
+
+// CorruptTEESignature flips a bit of the signature of the TDX quote or SEV-SNP report.
+func CorruptTEESignature(report *pb.Attestation) error {
+	var signature []byte
+	switch {
+	case report.GetTdxAttestation() != nil:
+		signature = report.GetTdxAttestation().GetSignedData().GetSignature()
+	case report.GetSevSnpAttestation() != nil:
+		signature = report.GetSevSnpAttestation().GetReport().GetSignature()
+	default:
+		return fmt.Errorf("attestation does not contain a TEE attestation")
+	}
+	if err := flipLastBit(signature); err != nil {
+		return fmt.Errorf("TEE signature: %v", err)
+	}
+	return nil
+}
+
+// flipLastBit flips the lowest bit of the last byte of b in place.
+func flipLastBit(b []byte) error {
+	if len(b) == 0 {
+		return fmt.Errorf("nothing to corrupt")
+	}
+	b[len(b)-1] ^= 1
+	return nil
+}
+
+// firstEventDigests returns the offsets of the digests of the first event of a crypto agile TCG
+// event log that is not EV_NO_ACTION.
+func firstEventDigests(log []byte) ([]int, error) {
+	le := binary.LittleEndian
+	// The log starts with a TCG_PCR_EVENT holding the Spec ID event, which lists the digest sizes.
+	if len(log) < 32 {
+		return nil, fmt.Errorf("truncated event log")
+	}
+	size := int(le.Uint32(log[28:32]))
+	if len(log) < 32+size || size < 28 || !bytes.HasPrefix(log[32:], []byte("Spec ID Event03")) {
+		return nil, fmt.Errorf("not a crypto agile event log")
+	}
+	specID := log[32 : 32+size]
+	digestSizes := make(map[uint16]int)
+	for i, p := uint32(0), 28; i < le.Uint32(specID[24:28]); i, p = i+1, p+4 {
+		if p+4 > len(specID) {
+			return nil, fmt.Errorf("truncated Spec ID event")
+		}
+		digestSizes[le.Uint16(specID[p:])] = int(le.Uint16(specID[p+2:]))
+	}
+
+	// Each TCG_PCR_EVENT2 is the PCR index, event type, digest count, digests, and event data.
+	for offset := 32 + size; offset+12 <= len(log); {
+		eventType := le.Uint32(log[offset+4:])
+		p := offset + 12
+		var digests []int
+		for i := uint32(0); i < le.Uint32(log[offset+8:]); i++ {
+			if p+2 > len(log) {
+				return nil, fmt.Errorf("truncated event")
+			}
+			digestSize, ok := digestSizes[le.Uint16(log[p:])]
+			if !ok || p+2+digestSize > len(log) {
+				return nil, fmt.Errorf("malformed event digest")
+			}
+			digests = append(digests, p+2)
+			p += 2 + digestSize
+		}
+		if p+4 > len(log) {
+			return nil, fmt.Errorf("truncated event")
+		}
+		if eventType != evNoAction && len(digests) != 0 {
+			return digests, nil
+		}
+		offset = p + 4 + int(le.Uint32(log[p:]))
+	}
+	return nil, fmt.Errorf("no measured events")
+}
+
+// untrustedCertificate returns a DER certificate for pub, issued by a new throwaway key.
+func untrustedCertificate(pub any) ([]byte, error) {
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "attestationtest AK"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %v", err)
+	}
+	return der, nil
+}
@@ -0,0 +1,102 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"google.golang.org/protobuf/proto"
+)
+
+// AuditDigestSize is the size in bytes of audit digests and attestation fingerprints.
+const AuditDigestSize = sha256.Size
+
+// AuditRecord links a verification into a hash chain of verifications, for tamper-evident audit
+// logs. Digest is the SHA-256 digest of the concatenation of Previous, Fingerprint and Timestamp
+// as big-endian 64-bit Unix nanoseconds. Each part has a fixed size, so the encoding is
+// unambiguous. The first record of a chain has an all-zero Previous. The scheme is stable: records
+// verify with any version of this package.
+type AuditRecord struct {
+	// Previous is the Digest of the previous record of the chain
+	Previous HexBytes `json:"previous"`
+	// Fingerprint is the AttestationFingerprint of the verified attestation
+	Fingerprint HexBytes `json:"fingerprint"`
+	// Timestamp is when verification completed
+	Timestamp time.Time `json:"timestamp"`
+	// Digest chains Previous, Fingerprint and Timestamp
+	Digest HexBytes `json:"digest"`
+}
+
+// AttestationFingerprint returns the SHA-256 digest of the deterministic binary encoding of
+// attestation, which identifies a report regardless of the format it was received in.
+func AttestationFingerprint(attestation *pb.Attestation) (HexBytes, error) {
+	encoded, err := proto.MarshalOptions{Deterministic: true}.Marshal(attestation)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(encoded)
+	return digest[:], nil
+}
+
+// AuditDigest returns the digest of an AuditRecord. An empty previous digest starts a chain and is
+// taken as all zeros.
+func AuditDigest(previous []byte, fingerprint []byte, timestamp time.Time) (HexBytes, error) {
+	if len(previous) == 0 {
+		previous = make([]byte, AuditDigestSize)
+	}
+	if len(previous) != AuditDigestSize {
+		return nil, fmt.Errorf("previous audit digest is %d bytes, expected %d", len(previous), AuditDigestSize)
+	}
+	if len(fingerprint) != AuditDigestSize {
+		return nil, fmt.Errorf("attestation fingerprint is %d bytes, expected %d", len(fingerprint), AuditDigestSize)
+	}
+	h := sha256.New()
+	h.Write(previous)
+	h.Write(fingerprint)
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(timestamp.UnixNano())))
+	return h.Sum(nil), nil
+}
+
+// newAuditRecord returns the record of the verification of attestation at timestamp, chained to
+// previous.
+func newAuditRecord(previous []byte, attestation *pb.Attestation, timestamp time.Time) (*AuditRecord, error) {
+	if len(previous) == 0 {
+		previous = make([]byte, AuditDigestSize)
+	}
+	fingerprint, err := AttestationFingerprint(attestation)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := AuditDigest(previous, fingerprint, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditRecord{Previous: previous, Fingerprint: fingerprint, Timestamp: timestamp, Digest: digest}, nil
+}
+
+// VerifyAuditChain checks that records form a hash chain that continues from previous, or starts a
+// new chain if previous is empty: each record's Digest must match its other fields and the
+// Previous of each record must be the Digest of the one before it. Comparing Fingerprint against
+// stored reports is left to the caller.
+func VerifyAuditChain(previous []byte, records []AuditRecord) error {
+	if len(previous) == 0 {
+		previous = make([]byte, AuditDigestSize)
+	}
+	for i, record := range records {
+		if !bytes.Equal(record.Previous, previous) {
+			return fmt.Errorf("audit record %d does not follow the previous record", i)
+		}
+		digest, err := AuditDigest(record.Previous, record.Fingerprint, record.Timestamp)
+		if err != nil {
+			return fmt.Errorf("audit record %d: %v", i, err)
+		}
+		if !bytes.Equal(record.Digest, digest) {
+			return fmt.Errorf("audit record %d has digest %s, expected %s", i, record.Digest, digest)
+		}
+		previous = record.Digest
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package attestation
+
+import (
+	"crypto"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// PE/COFF header offsets used to compute Authenticode digests
+const (
+	peSignatureOffset = 0x3c
+	// coffHeaderSize is the size of the COFF file header following the PE signature
+	coffHeaderSize = 20
+	// optionalHeaderChecksum is the offset of CheckSum in the optional header
+	optionalHeaderChecksum = 64
+	// optionalHeaderSizeOfHeaders is the offset of SizeOfHeaders in the optional header
+	optionalHeaderSizeOfHeaders = 60
+	// securityDirectoryIndex is the index of the certificate table in the data directories
+	securityDirectoryIndex = 4
+	// sectionHeaderSize is the size of each section header
+	sectionHeaderSize = 40
+)
+
+// authenticodeDigest computes the Authenticode digest of a PE/COFF image, as UEFI firmware
+// measures boot applications: the image is hashed without its checksum, certificate table entry
+// and certificate table, with its sections in file order.
+func authenticodeDigest(hash crypto.Hash, image []byte) ([]byte, error) {
+	if !hash.Available() {
+		return nil, fmt.Errorf("hash %v is not available", hash)
+	}
+	if len(image) < peSignatureOffset+4 || image[0] != 'M' || image[1] != 'Z' {
+		return nil, fmt.Errorf("not a PE/COFF image")
+	}
+	peOffset := int(binary.LittleEndian.Uint32(image[peSignatureOffset:]))
+	if peOffset < 0 || peOffset+4+coffHeaderSize > len(image) || string(image[peOffset:peOffset+4]) != "PE\x00\x00" {
+		return nil, fmt.Errorf("not a PE/COFF image")
+	}
+	coff := image[peOffset+4:]
+	numSections := int(binary.LittleEndian.Uint16(coff[2:]))
+	optionalHeaderSize := int(binary.LittleEndian.Uint16(coff[16:]))
+	optionalHeader := peOffset + 4 + coffHeaderSize
+	if optionalHeader+optionalHeaderSize > len(image) || optionalHeaderSize < optionalHeaderChecksum+4 {
+		return nil, fmt.Errorf("truncated PE optional header")
+	}
+
+	var dataDirectories int
+	switch magic := binary.LittleEndian.Uint16(image[optionalHeader:]); magic {
+	case 0x10b:
+		dataDirectories = optionalHeader + 96
+	case 0x20b:
+		dataDirectories = optionalHeader + 112
+	default:
+		return nil, fmt.Errorf("unknown PE optional header magic %#x", magic)
+	}
+	securityDirectory := dataDirectories + securityDirectoryIndex*8
+	if securityDirectory+8 > optionalHeader+optionalHeaderSize {
+		return nil, fmt.Errorf("PE image has no certificate table entry")
+	}
+	checksum := optionalHeader + optionalHeaderChecksum
+	sizeOfHeaders := int(binary.LittleEndian.Uint32(image[optionalHeader+optionalHeaderSizeOfHeaders:]))
+	if sizeOfHeaders < securityDirectory+8 || sizeOfHeaders > len(image) {
+		return nil, fmt.Errorf("invalid PE SizeOfHeaders %d", sizeOfHeaders)
+	}
+	certTableSize := int(binary.LittleEndian.Uint32(image[securityDirectory+4:]))
+
+	h := hash.New()
+	h.Write(image[:checksum])
+	h.Write(image[checksum+4 : securityDirectory])
+	h.Write(image[securityDirectory+8 : sizeOfHeaders])
+
+	type section struct{ offset, size int }
+	sectionHeaders := optionalHeader + optionalHeaderSize
+	if sectionHeaders+numSections*sectionHeaderSize > len(image) {
+		return nil, fmt.Errorf("truncated PE section table")
+	}
+	sections := make([]section, 0, numSections)
+	for i := 0; i < numSections; i++ {
+		header := image[sectionHeaders+i*sectionHeaderSize:]
+		s := section{
+			size:   int(binary.LittleEndian.Uint32(header[16:])),
+			offset: int(binary.LittleEndian.Uint32(header[20:])),
+		}
+		if s.size == 0 {
+			continue
+		}
+		if s.offset < 0 || s.size < 0 || s.offset+s.size > len(image) {
+			return nil, fmt.Errorf("PE section %d lies outside the image", i)
+		}
+		sections = append(sections, s)
+	}
+	sort.Slice(sections, func(i, j int) bool { return sections[i].offset < sections[j].offset })
+
+	hashed := sizeOfHeaders
+	for _, s := range sections {
+		h.Write(image[s.offset : s.offset+s.size])
+		hashed += s.size
+	}
+	// Data after the sections, such as debug information, is hashed up to the certificate table.
+	end := len(image) - certTableSize
+	if end < 0 {
+		return nil, fmt.Errorf("PE certificate table is larger than the image")
+	}
+	if hashed < end {
+		h.Write(image[hashed:end])
+	}
+	return h.Sum(nil), nil
+}
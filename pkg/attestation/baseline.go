@@ -0,0 +1,252 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// DefaultBaselinePCRs are the PCRs recorded in a baseline when VerifierConfig.BaselinePCRs is
+// empty: the firmware, Secure Boot and boot loader PCRs, which do not change while a host runs.
+var DefaultBaselinePCRs = []uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+
+// ErrNoBaseline is returned for a host that has no baseline when the Verifier does not trust
+// first reports.
+var ErrNoBaseline = errors.New("no trusted baseline")
+
+// Baseline holds the measurements of a host trusted on first sight, which later reports from the
+// host must match
+type Baseline struct {
+	// HostID identifies the host, as returned by BaselineHostID
+	HostID string `json:"hostId"`
+	// PCRs are the baseline PCR values, in the bank of Hash
+	PCRs map[uint32]HexBytes `json:"pcrs"`
+	// Hash is the PCR bank of PCRs, e.g. SHA256
+	Hash string `json:"hash"`
+	// TEE holds the baseline TEE measurements: mrtd and rtmr0 to rtmr2 for TDX, measurement for
+	// SEV-SNP
+	TEE map[string]HexBytes `json:"tee,omitempty"`
+	// ResetCount is the TPM reset count of the baseline report, when VerifierConfig.BaselineResetCount
+	// is set. A host whose reset count changed has rebooted or had its TPM reset since.
+	ResetCount *uint32 `json:"resetCount,omitempty"`
+	// TrustedAt is when the baseline was recorded
+	TrustedAt time.Time `json:"trustedAt"`
+	// TrustedReport is the SHA-256 digest of the attestation report the baseline was taken from
+	TrustedReport HexBytes `json:"trustedReport"`
+}
+
+// BaselineDriftError is returned for a report whose measurements differ from its host's baseline
+type BaselineDriftError struct {
+	// HostID identifies the host
+	HostID string
+	// Drift describes each measurement that differs from the baseline
+	Drift []string
+}
+
+func (e *BaselineDriftError) Error() string {
+	return fmt.Sprintf("host %s drifted from its baseline: %s", e.HostID, strings.Join(e.Drift, "; "))
+}
+
+// BaselineStore persists the baselines of a Verifier. Implementations must be safe for concurrent
+// use.
+type BaselineStore interface {
+	// LoadBaseline returns the baseline of hostID, or nil if it has none
+	LoadBaseline(ctx context.Context, hostID string) (*Baseline, error)
+	// StoreBaseline records baseline as the baseline of its host, replacing any previous one
+	StoreBaseline(ctx context.Context, baseline *Baseline) error
+}
+
+// MemoryBaselineStore is a BaselineStore that keeps baselines in memory
+type MemoryBaselineStore struct {
+	mu        sync.Mutex
+	baselines map[string]*Baseline
+}
+
+// NewMemoryBaselineStore creates an empty MemoryBaselineStore
+func NewMemoryBaselineStore() *MemoryBaselineStore {
+	return &MemoryBaselineStore{baselines: make(map[string]*Baseline)}
+}
+
+// LoadBaseline returns the baseline of hostID, or nil if it has none.
+func (s *MemoryBaselineStore) LoadBaseline(ctx context.Context, hostID string) (*Baseline, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.baselines[hostID], nil
+}
+
+// StoreBaseline records baseline as the baseline of its host.
+func (s *MemoryBaselineStore) StoreBaseline(ctx context.Context, baseline *Baseline) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.baselines[baseline.HostID] = baseline
+	return nil
+}
+
+// BaselineHostID identifies the host of an attestation report by the SHA-256 digest of its AK,
+// which the TPM derives from its seed and so is stable across reboots.
+func BaselineHostID(report *VerificationReport) string {
+	digest := sha256.Sum256(report.Attestation.GetAkPub())
+	return hex.EncodeToString(digest[:])
+}
+
+// NewBaseline takes a baseline of the given PCRs and the TEE measurements of a verified report.
+func NewBaseline(report *VerificationReport, pcrs []uint32) (*Baseline, error) {
+	hash := report.MachineState.GetHash()
+	var quoted map[uint32][]byte
+	for _, quote := range report.Attestation.GetQuotes() {
+		if quote.GetPcrs().GetHash() == hash {
+			quoted = quote.GetPcrs().GetPcrs()
+			break
+		}
+	}
+	if quoted == nil {
+		return nil, fmt.Errorf("attestation does not quote the %v PCR bank", hash)
+	}
+
+	baseline := &Baseline{
+		HostID:    BaselineHostID(report),
+		PCRs:      make(map[uint32]HexBytes, len(pcrs)),
+		Hash:      hash.String(),
+		TrustedAt: time.Now(),
+	}
+	for _, index := range pcrs {
+		value, ok := quoted[index]
+		if !ok {
+			return nil, fmt.Errorf("attestation does not quote PCR %d", index)
+		}
+		baseline.PCRs[index] = value
+	}
+
+	baseline.TEE = teeMeasurements(report.MachineState)
+	return baseline, nil
+}
+
+// teeMeasurements returns the stable TEE measurements of a verified machine state: mrtd and rtmr0
+// to rtmr2 for TDX, measurement for SEV-SNP, and nil without a TEE attestation.
+func teeMeasurements(ms *pb.MachineState) map[string]HexBytes {
+	if tdx := ms.GetTdxAttestation(); tdx != nil {
+		body := tdx.GetTdQuoteBody()
+		measurements := map[string]HexBytes{"mrtd": body.GetMrTd()}
+		// RTMR3 is extended by the running workload.
+		for i, rtmr := range body.GetRtmrs() {
+			if i < 3 {
+				measurements[fmt.Sprintf("rtmr%d", i)] = rtmr
+			}
+		}
+		return measurements
+	} else if snp := ms.GetSevSnpAttestation(); snp != nil {
+		return map[string]HexBytes{"measurement": snp.GetReport().GetMeasurement()}
+	}
+	return nil
+}
+
+// baselineDrift lists the measurements of current that differ from baseline.
+func baselineDrift(baseline *Baseline, current *Baseline) []string {
+	var drift []string
+	if baseline.Hash != current.Hash {
+		return []string{fmt.Sprintf("PCR bank is %s, baseline is %s", current.Hash, baseline.Hash)}
+	}
+	for index, want := range baseline.PCRs {
+		if got, ok := current.PCRs[index]; !ok || !bytes.Equal(got, want) {
+			drift = append(drift, fmt.Sprintf("PCR %d is %s, baseline is %s", index, got, want))
+		}
+	}
+	if baseline.ResetCount != nil && current.ResetCount != nil && *baseline.ResetCount != *current.ResetCount {
+		drift = append(drift, fmt.Sprintf("TPM reset count is %d, baseline is %d", *current.ResetCount, *baseline.ResetCount))
+	}
+	for name, want := range baseline.TEE {
+		if got, ok := current.TEE[name]; !ok || !bytes.Equal(got, want) {
+			drift = append(drift, fmt.Sprintf("%s is %s, baseline is %s", name, got, want))
+		}
+	}
+	sort.Strings(drift)
+	return drift
+}
+
+// baselinePCRs returns the PCRs the verifier records in baselines.
+func (v *Verifier) baselinePCRs() []uint32 {
+	if len(v.config.BaselinePCRs) == 0 {
+		return DefaultBaselinePCRs
+	}
+	return v.config.BaselinePCRs
+}
+
+// newBaseline takes the baseline the verifier records for a verified report.
+func (v *Verifier) newBaseline(report *VerificationReport) (*Baseline, error) {
+	baseline, err := NewBaseline(report, v.baselinePCRs())
+	if err != nil {
+		return nil, err
+	}
+	if v.config.BaselineResetCount {
+		if report.QuoteClock == nil {
+			return nil, fmt.Errorf("report does not carry the TPM reset count")
+		}
+		resetCount := report.QuoteClock.ResetCount
+		baseline.ResetCount = &resetCount
+	}
+	return baseline, nil
+}
+
+// checkBaseline compares a verified report against the baseline of its host. A host without a
+// baseline fails with ErrNoBaseline, unless the verifier trusts first reports, in which case
+// the report becomes its baseline.
+func (v *Verifier) checkBaseline(ctx context.Context, report *VerificationReport) error {
+	current, err := v.newBaseline(report)
+	if err != nil {
+		return err
+	}
+	baseline, err := v.config.Baselines.LoadBaseline(ctx, current.HostID)
+	if err != nil {
+		return fmt.Errorf("failed to load baseline of host %s: %v", current.HostID, err)
+	}
+
+	if baseline == nil {
+		if !v.config.TrustFirstBaseline {
+			return fmt.Errorf("host %s: %w", current.HostID, ErrNoBaseline)
+		}
+		if current.TrustedReport, err = AttestationFingerprint(report.Attestation); err != nil {
+			return err
+		}
+		if err := v.config.Baselines.StoreBaseline(ctx, current); err != nil {
+			return fmt.Errorf("failed to store baseline of host %s: %v", current.HostID, err)
+		}
+		report.Baseline = current
+		report.BaselineEstablished = true
+		return nil
+	}
+
+	if drift := baselineDrift(baseline, current); len(drift) != 0 {
+		return &BaselineDriftError{HostID: current.HostID, Drift: drift}
+	}
+	report.Baseline = baseline
+	return nil
+}
+
+// TrustBaseline records the measurements of a verified report as the baseline of its host,
+// replacing any previous baseline. It is the explicit first-trust step for a Verifier that does
+// not set TrustFirstBaseline, and re-pins a host after an intended change.
+func (v *Verifier) TrustBaseline(ctx context.Context, report *VerificationReport) (*Baseline, error) {
+	if v.config.Baselines == nil {
+		return nil, fmt.Errorf("verifier does not have a BaselineStore")
+	}
+	baseline, err := v.newBaseline(report)
+	if err != nil {
+		return nil, err
+	}
+	if baseline.TrustedReport, err = AttestationFingerprint(report.Attestation); err != nil {
+		return nil, err
+	}
+	if err := v.config.Baselines.StoreBaseline(ctx, baseline); err != nil {
+		return nil, fmt.Errorf("failed to store baseline of host %s: %v", baseline.HostID, err)
+	}
+	return baseline, nil
+}
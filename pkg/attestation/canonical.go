@@ -0,0 +1,196 @@
+package attestation
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// canonicalIndent is the indentation of each nesting level of canonical text.
+const canonicalIndent = "  "
+
+// CanonicalizeAttestation re-encodes an attestation report in the canonical form of its format, so
+// that stored reports diff cleanly: the deterministic binary encoding for binarypb, keeping the
+// attestation envelope of a report with one, and for textproto the output of CanonicalText.
+// Unknown fields are dropped from textproto reports, as they are by verification.
+func CanonicalizeAttestation(data []byte, format string) ([]byte, error) {
+	attestation, err := unmarshalAttestation(data, format)
+	if err != nil {
+		return nil, err
+	}
+	if format == "textproto" {
+		return CanonicalText(attestation), nil
+	}
+	additions, err := envelopeAdditions(data, format)
+	if err != nil {
+		return nil, err
+	}
+	return marshalAttestation(attestation, additions, format)
+}
+
+// CanonicalText encodes m as textproto in a stable layout that does not depend on the protobuf
+// library version: one field per line, indented by two spaces per level, in field number order,
+// map entries ordered by key, and every byte of bytes fields escaped as \xHH. The output parses
+// as ordinary textproto. Unknown fields are omitted.
+func CanonicalText(m proto.Message) []byte {
+	var buf bytes.Buffer
+	writeCanonicalMessage(&buf, m.ProtoReflect(), 0)
+	return buf.Bytes()
+}
+
+// writeCanonicalMessage writes the populated fields of m at the given nesting depth.
+func writeCanonicalMessage(buf *bytes.Buffer, m protoreflect.Message, depth int) {
+	fields := m.Descriptor().Fields()
+	ordered := make([]protoreflect.FieldDescriptor, 0, fields.Len())
+	for i := 0; i < fields.Len(); i++ {
+		if m.Has(fields.Get(i)) {
+			ordered = append(ordered, fields.Get(i))
+		}
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].Number() < ordered[j].Number() })
+
+	for _, fd := range ordered {
+		value := m.Get(fd)
+		switch {
+		case fd.IsMap():
+			writeCanonicalMap(buf, fd, value.Map(), depth)
+		case fd.IsList():
+			list := value.List()
+			for i := 0; i < list.Len(); i++ {
+				writeCanonicalField(buf, string(fd.Name()), fd, list.Get(i), depth)
+			}
+		default:
+			writeCanonicalField(buf, string(fd.Name()), fd, value, depth)
+		}
+	}
+}
+
+// writeCanonicalMap writes the entries of a map field ordered by key.
+func writeCanonicalMap(buf *bytes.Buffer, fd protoreflect.FieldDescriptor, entries protoreflect.Map, depth int) {
+	keys := make([]protoreflect.MapKey, 0, entries.Len())
+	entries.Range(func(key protoreflect.MapKey, _ protoreflect.Value) bool {
+		keys = append(keys, key)
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool { return mapKeyLess(keys[i], keys[j]) })
+
+	indent := strings.Repeat(canonicalIndent, depth)
+	for _, key := range keys {
+		buf.WriteString(indent + string(fd.Name()) + " {\n")
+		writeCanonicalField(buf, "key", fd.MapKey(), key.Value(), depth+1)
+		writeCanonicalField(buf, "value", fd.MapValue(), entries.Get(key), depth+1)
+		buf.WriteString(indent + "}\n")
+	}
+}
+
+// mapKeyLess orders map keys of the same kind.
+func mapKeyLess(a, b protoreflect.MapKey) bool {
+	switch a.Interface().(type) {
+	case bool:
+		return !a.Bool() && b.Bool()
+	case int32, int64:
+		return a.Int() < b.Int()
+	case uint32, uint64:
+		return a.Uint() < b.Uint()
+	default:
+		return a.String() < b.String()
+	}
+}
+
+// writeCanonicalField writes a single field value, or list element, named name.
+func writeCanonicalField(buf *bytes.Buffer, name string, fd protoreflect.FieldDescriptor, value protoreflect.Value, depth int) {
+	indent := strings.Repeat(canonicalIndent, depth)
+	if fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+		buf.WriteString(indent + name + " {\n")
+		writeCanonicalMessage(buf, value.Message(), depth+1)
+		buf.WriteString(indent + "}\n")
+		return
+	}
+	buf.WriteString(indent + name + ": " + canonicalScalar(fd, value) + "\n")
+}
+
+// canonicalScalar formats a scalar field value as textproto.
+func canonicalScalar(fd protoreflect.FieldDescriptor, value protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return strconv.FormatBool(value.Bool())
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(value.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return strconv.FormatInt(int64(value.Enum()), 10)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return strconv.FormatInt(value.Int(), 10)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return strconv.FormatUint(value.Uint(), 10)
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return canonicalFloat(value.Float(), fd.Kind())
+	case protoreflect.StringKind:
+		return quoteCanonicalString(value.String())
+	case protoreflect.BytesKind:
+		return quoteCanonicalBytes(value.Bytes())
+	default:
+		return fmt.Sprint(value.Interface())
+	}
+}
+
+// canonicalFloat formats a float or double in its shortest exact form.
+func canonicalFloat(f float64, kind protoreflect.Kind) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	bits := 64
+	if kind == protoreflect.FloatKind {
+		bits = 32
+	}
+	return strconv.FormatFloat(f, 'g', -1, bits)
+}
+
+// quoteCanonicalString quotes s, escaping quotes, backslashes and control characters, and keeping
+// other valid UTF-8 as is.
+func quoteCanonicalString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == utf8.RuneError && size == 1, r < 0x20, r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, s[i])
+		default:
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// quoteCanonicalBytes quotes b with every byte escaped as \xHH.
+func quoteCanonicalBytes(b []byte) string {
+	var s strings.Builder
+	s.Grow(2 + 4*len(b))
+	s.WriteByte('"')
+	for _, c := range b {
+		fmt.Fprintf(&s, `\x%02x`, c)
+	}
+	s.WriteByte('"')
+	return s.String()
+}
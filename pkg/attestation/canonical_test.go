@@ -0,0 +1,72 @@
+package attestation
+
+import (
+	"bytes"
+	"testing"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"github.com/google/go-tpm-tools/proto/tpm"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCanonicalText(t *testing.T) {
+	pcrs := &tpm.PCRs{Hash: tpm.HashAlgo_SHA256, Pcrs: map[uint32][]byte{7: {0xff}, 0: {0x01, 0x02}}}
+	want := `hash: SHA256
+pcrs {
+  key: 0
+  value: "\x01\x02"
+}
+pcrs {
+  key: 7
+  value: "\xff"
+}
+`
+	if got := string(CanonicalText(pcrs)); got != want {
+		t.Errorf("CanonicalText() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCanonicalizeAttestationRoundTrip(t *testing.T) {
+	report := exampleReport(t)
+	original, err := unmarshalAttestation(report, "binarypb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := marshalAttestation(original, nil, "textproto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for format, input := range map[string][]byte{"binarypb": report, "textproto": text} {
+		t.Run(format, func(t *testing.T) {
+			canonical, err := CanonicalizeAttestation(input, format)
+			if err != nil {
+				t.Fatalf("CanonicalizeAttestation() failed: %v", err)
+			}
+			decoded, err := unmarshalAttestation(canonical, format)
+			if err != nil {
+				t.Fatalf("canonical report does not parse: %v", err)
+			}
+			if !proto.Equal(decoded, original) {
+				t.Error("canonical report differs from the original")
+			}
+			// Canonicalizing is idempotent, byte for byte.
+			again, err := CanonicalizeAttestation(canonical, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, canonical) {
+				t.Errorf("canonicalizing the canonical report changed it from %d to %d bytes", len(canonical), len(again))
+			}
+			if _, err := VerifyAttestationFromReader(bytes.NewReader(canonical), withFormat(exampleVerifyOptions(), format)); err != nil {
+				t.Errorf("verifying the canonical report failed: %v", err)
+			}
+		})
+	}
+
+	// Fresh messages with the same content encode to the same bytes.
+	clone := proto.Clone(original).(*pb.Attestation)
+	if !bytes.Equal(CanonicalText(clone), CanonicalText(original)) {
+		t.Error("CanonicalText() of equal messages differs")
+	}
+}
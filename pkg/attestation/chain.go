@@ -0,0 +1,64 @@
+package attestation
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// ErrBrokenChain is returned when a report of a delegation chain does not endorse the key of the
+// next one.
+var ErrBrokenChain = errors.New("delegation chain is broken")
+
+// ChainLink is a verified report in a delegation chain, such as that of a confidential VM followed
+// by that of an enclave it hosts
+type ChainLink struct {
+	// Report is the verified report of the link
+	Report *VerificationReport
+	// Key is the key the link attests with, which the previous link must endorse. Defaults to the
+	// AK of Report.
+	Key crypto.PublicKey
+}
+
+// key returns the key of the link.
+func (l ChainLink) key() (crypto.PublicKey, error) {
+	if l.Key != nil {
+		return l.Key, nil
+	}
+	pub, err := tpm2.DecodePublic(l.Report.Attestation.GetAkPub())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode AK public area: %v", err)
+	}
+	return pub.Key()
+}
+
+// VerifyChain checks that reports form a delegation chain back to the hardware of the first: each
+// report's TEE report data must begin with PublicKeyReportData of the key of the next link, as a
+// parent binds the key of a child workload it vouches for. Each report must already have been
+// verified on its own, with its own nonce.
+func VerifyChain(reports ...ChainLink) error {
+	if len(reports) < 2 {
+		return fmt.Errorf("a delegation chain needs at least two reports, got %d", len(reports))
+	}
+	for i, link := range reports {
+		if link.Report == nil {
+			return fmt.Errorf("link %d has no report", i)
+		}
+	}
+	for i := 1; i < len(reports); i++ {
+		key, err := reports[i].key()
+		if err != nil {
+			return fmt.Errorf("link %d: %v", i, err)
+		}
+		keyHash, err := PublicKeyReportData(key)
+		if err != nil {
+			return fmt.Errorf("link %d: %v", i, err)
+		}
+		if err := checkReportDataHash(reports[i-1].Report.Attestation, keyHash); err != nil {
+			return fmt.Errorf("%w: link %d does not endorse the key of link %d: %v", ErrBrokenChain, i-1, i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package attestation
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// DefaultChallengeTTL is how long a challenge can be answered when no TTL is configured.
+const DefaultChallengeTTL = 5 * time.Minute
+
+// challengeNonceSize is the size of challenge nonces, twice MinNonceSize.
+const challengeNonceSize = 32
+
+// ErrUnknownChallenge is returned for a challenge that was not issued by the Verifier, or that was
+// already answered.
+var ErrUnknownChallenge = errors.New("unknown or already used challenge")
+
+// ErrChallengeExpired is returned for a challenge answered after its expiry.
+var ErrChallengeExpired = errors.New("challenge expired")
+
+// Challenge is a one-time nonce issued by a Verifier for an attester to attest over
+type Challenge struct {
+	// Nonce is the nonce the attester must pass as AttestOptions.Nonce
+	Nonce []byte
+	// Expires is when the challenge can no longer be answered
+	Expires time.Time
+}
+
+// challengeEntry is an outstanding challenge.
+type challengeEntry struct {
+	expires time.Time
+	// ekCert is the raw EK certificate an EK challenge was made for
+	ekCert []byte
+	// akName is the encoded TPM name of the AK an EK challenge was made for
+	akName []byte
+}
+
+// challengeStore tracks the outstanding challenges of a Verifier.
+type challengeStore struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]challengeEntry
+}
+
+func newChallengeStore(ttl time.Duration) *challengeStore {
+	if ttl <= 0 {
+		ttl = DefaultChallengeTTL
+	}
+	return &challengeStore{ttl: ttl, entries: make(map[string]challengeEntry)}
+}
+
+// issue records and returns a new challenge, bound to ekCert and akName if they are not nil,
+// dropping expired ones.
+func (s *challengeStore) issue(ekCert, akName []byte) (*Challenge, error) {
+	nonce := make([]byte, challengeNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge nonce: %v", err)
+	}
+	now := time.Now()
+	challenge := &Challenge{Nonce: nonce, Expires: now.Add(s.ttl)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, entry := range s.entries {
+		if now.After(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+	s.entries[string(nonce)] = challengeEntry{expires: challenge.Expires, ekCert: ekCert, akName: akName}
+	return challenge, nil
+}
+
+// consume removes nonce from the outstanding challenges, failing if it is unknown or expired, and
+// returns its entry.
+func (s *challengeStore) consume(nonce []byte) (*challengeEntry, error) {
+	s.mu.Lock()
+	entry, ok := s.entries[string(nonce)]
+	delete(s.entries, string(nonce))
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, ErrUnknownChallenge
+	}
+	if time.Now().After(entry.expires) {
+		return nil, ErrChallengeExpired
+	}
+	return &entry, nil
+}
+
+// NewChallenge issues a fresh random nonce for an attester to attest over. It can be answered
+// once with VerifyResponse until it expires after VerifierConfig.ChallengeTTL.
+func (v *Verifier) NewChallenge() (*Challenge, error) {
+	return v.challenges.issue(nil, nil)
+}
+
+// VerifyResponse verifies an attestation report made in response to challenge with the verifier's
+// policy. The challenge is used up whether or not verification succeeds.
+func (v *Verifier) VerifyResponse(challenge *Challenge, attestationBytes []byte, format string) (*pb.MachineState, error) {
+	if challenge == nil {
+		return nil, fmt.Errorf("no challenge to verify the response to")
+	}
+	if _, err := v.challenges.consume(challenge.Nonce); err != nil {
+		return nil, err
+	}
+	report, err := v.Verify(context.Background(), VerifyRequest{
+		Attestation: attestationBytes,
+		Format:      format,
+		Nonce:       challenge.Nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report.MachineState, nil
+}
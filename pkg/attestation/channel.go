@@ -0,0 +1,99 @@
+package attestation
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ChannelBindingLabel is the TLS exporter label (RFC 5705, RFC 8446) of ChannelBindingFromTLS.
+const ChannelBindingLabel = "EXPORTER-lunal-attestation-channel-binding"
+
+// channelBindingSize is the size of the keying material exported by ChannelBindingFromTLS.
+const channelBindingSize = 32
+
+// Roles of the endpoints of an attested channel. Each endpoint attests over the channel binding
+// and its own role, so that the report of one endpoint cannot be reflected back as the other's.
+const (
+	ChannelClient = "client"
+	ChannelServer = "server"
+)
+
+// ErrChannelBindingMismatch is returned when a report was not attested over the expected channel
+// binding and role.
+var ErrChannelBindingMismatch = errors.New("attestation is not bound to the channel")
+
+// ChannelBindingFromTLS exports a channel binding from an established TLS connection, using
+// ChannelBindingLabel. Both endpoints of the connection derive the same value, which no other
+// connection shares. The connection must use TLS 1.3 or the extended master secret of TLS 1.2.
+func ChannelBindingFromTLS(state tls.ConnectionState) ([]byte, error) {
+	binding, err := state.ExportKeyingMaterial(ChannelBindingLabel, nil, channelBindingSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export TLS channel binding: %v", err)
+	}
+	return binding, nil
+}
+
+// ChannelBindingFromKeys returns a channel binding for a key exchange outside TLS: the SHA-256
+// digest of the ephemeral public keys of the client and the server, each prefixed with its length.
+func ChannelBindingFromKeys(clientKey []byte, serverKey []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(ChannelBindingLabel))
+	for _, key := range [][]byte{clientKey, serverKey} {
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(key))))
+		h.Write(key)
+	}
+	return h.Sum(nil)
+}
+
+// ChannelNonce returns the nonce an endpoint of role ChannelClient or ChannelServer attests with
+// to bind its report to binding, and that the peer verifies the report with.
+func ChannelNonce(binding []byte, role string) ([]byte, error) {
+	if role != ChannelClient && role != ChannelServer {
+		return nil, fmt.Errorf("unknown channel role %q", role)
+	}
+	if len(binding) == 0 {
+		return nil, fmt.Errorf("channel binding is empty")
+	}
+	h := sha256.New()
+	h.Write([]byte(ChannelBindingLabel))
+	h.Write([]byte{0})
+	h.Write([]byte(role))
+	h.Write([]byte{0})
+	h.Write(binding)
+	return h.Sum(nil), nil
+}
+
+// VerifyChannelBinding checks that the quotes of a verified report were made over the
+// ChannelNonce of binding and role, so that the report was produced for this connection by the
+// endpoint of that role. Verifying the report with ChannelNonce as its nonce makes the same check;
+// VerifyChannelBinding is for reports verified without it, e.g. by a Verifier.
+func VerifyChannelBinding(report *VerificationReport, binding []byte, role string) error {
+	expected, err := ChannelNonce(binding, role)
+	if err != nil {
+		return err
+	}
+	if report == nil {
+		return fmt.Errorf("no verification report")
+	}
+	nonce, err := quotedNonce(report.Attestation)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(nonce, expected) != 1 {
+		return fmt.Errorf("%w: %s report nonce %x, expected %x", ErrChannelBindingMismatch, role, nonce, expected)
+	}
+	return nil
+}
+
+// VerifyHandshakeReports checks that the verified reports of both endpoints of a key exchange
+// were produced for the handshake with binding, client and server in their own roles.
+func VerifyHandshakeReports(client *VerificationReport, server *VerificationReport, binding []byte) error {
+	if err := VerifyChannelBinding(client, binding, ChannelClient); err != nil {
+		return err
+	}
+	return VerifyChannelBinding(server, binding, ChannelServer)
+}
@@ -0,0 +1,245 @@
+package attestation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EnabledChecks describes the checks verification with o performs, in the order they run, e.g. to
+// log the effective policy or find out why a report passed. Checks that only apply when the report
+// carries the data they check, such as a TEE attestation, say so.
+func (o VerifyOptions) EnabledChecks() []string {
+	var checks []string
+	add := func(format string, args ...any) {
+		checks = append(checks, fmt.Sprintf(format, args...))
+	}
+
+	if o.MaxVerifyDuration > 0 {
+		add("verification completes within %v", o.MaxVerifyDuration)
+	}
+	if o.MaxAttestationSize > 0 {
+		add("attestation report at most %d bytes", o.MaxAttestationSize)
+	}
+	if o.MaxEventLogEntries > 0 {
+		add("event log at most %d entries", o.MaxEventLogEntries)
+	}
+	if o.MaxCollateralSize > 0 {
+		add("TEE collateral documents at most %d bytes", o.MaxCollateralSize)
+	}
+	if o.StrictNonce {
+		add("nonce strength")
+	}
+	if o.RejectUnknownFields {
+		add("no fields unknown to this verifier")
+	}
+	if o.RequireTEE {
+		add("TEE attestation required")
+	}
+	if len(o.ExpectedAKName) != 0 {
+		add("AK name %x", o.ExpectedAKName)
+	}
+	if !o.VerifyGceAKCert && !o.AllowAKCertMismatch {
+		add("AK certificate certifies the AK, if present")
+	}
+	switch {
+	case o.RejectSHA1:
+		add("SHA-1 PCR banks rejected")
+	case o.AllowSHA1:
+		add("SHA-1 PCR bank allowed when no stronger bank is quoted")
+	}
+	if len(o.TrustedEKRoots) != 0 {
+		add("EK certificate chains to %d trusted roots", len(o.TrustedEKRoots))
+	}
+	if o.VerifyGceAKCert {
+		add("TPM quote signed by an AK with a gceAK certificate")
+	} else {
+		add("TPM quote signed by the attestation key (trust on first use)")
+	}
+	if len(o.Nonce) != 0 {
+		add("TPM quote nonce")
+	} else {
+		add("TPM quote nonce is empty")
+	}
+	if o.TolerateEventLogParseErrors {
+		add("event log replay, dropped on failure")
+	} else {
+		add("event log replay, if present")
+	}
+	if o.AllowMissingEventLog {
+		add("event log checks skipped without an event log")
+	}
+	if o.RequireInstanceInfo {
+		add("GCE instance info")
+	}
+	if len(o.AllowedInstances) != 0 {
+		add("GCE instance in %d allowed instances", len(o.AllowedInstances))
+	}
+	if len(o.ExpectedReportDataHash) != 0 {
+		add("TEE report data begins with %x", []byte(o.ExpectedReportDataHash))
+	}
+	if o.RequireBoundEvidence {
+		add("TEE report data commits to the quoted nonce")
+	}
+	reportData := " and report data"
+	if len(o.TeeNonce) == 0 && len(o.ExpectedReportDataHash) == 0 && o.AllowUnboundTeeReportData {
+		reportData = ", report data unchecked"
+	}
+	if o.RequireTEE {
+		add("TEE attestation signature%s", reportData)
+	} else {
+		add("TEE attestation signature%s, if present", reportData)
+	}
+	if o.CheckRevocations {
+		add("TEE certificates not revoked")
+	}
+	checks = append(checks, o.Tdx.enabledChecks()...)
+	checks = append(checks, o.SevSnp.enabledChecks()...)
+
+	if o.DeniedMeasurements != nil {
+		add("no denied measurements")
+	}
+	if len(o.ExpectedPCRs) != 0 {
+		add("expected PCRs %s", pcrIndices(o.ExpectedPCRs))
+	}
+	if len(o.CurrentPCRs) != 0 {
+		add("current PCRs %s", pcrIndices(o.CurrentPCRs))
+	}
+	if o.ReferenceValues != nil {
+		add("reference values")
+	}
+	if len(o.RIMs) != 0 {
+		add("%d RIMs", len(o.RIMs))
+	}
+	if o.IntegrityBaseline != nil {
+		add("integrity baseline")
+	}
+	if o.Components != nil && len(o.Components.Components) != 0 {
+		components := make([]string, 0, len(o.Components.Components))
+		for component := range o.Components.Components {
+			components = append(components, component)
+		}
+		sort.Strings(components)
+		add("components %s", strings.Join(components, ", "))
+	}
+	if o.RTMRComponents != nil && len(o.RTMRComponents.Components) != 0 {
+		components := make([]string, 0, len(o.RTMRComponents.Components))
+		for component := range o.RTMRComponents.Components {
+			components = append(components, component)
+		}
+		sort.Strings(components)
+		add("TDX RTMR components %s", strings.Join(components, ", "))
+	}
+	if o.TransparencyLog != nil {
+		add("measurements logged in a transparency log by %d trusted publishers", len(o.TransparencyLog.PublisherKeys))
+	}
+	if len(o.AllowedBootEntries) != 0 {
+		add("%d allowed boot entries", len(o.AllowedBootEntries))
+	}
+	if len(o.ExpectedEventSequence) != 0 {
+		add("event sequence of %d events", len(o.ExpectedEventSequence))
+	}
+	if o.RequireSecureBoot != nil {
+		if *o.RequireSecureBoot {
+			add("secure boot enabled")
+		} else {
+			add("secure boot disabled")
+		}
+	}
+	if p := o.ShieldedVM; p != nil && (p.VTPM || p.SecureBoot || p.IntegrityMonitoring || p.ConfidentialComputing) {
+		var settings []string
+		for _, s := range []struct {
+			name    string
+			enabled bool
+		}{{"vTPM", p.VTPM}, {"secure boot", p.SecureBoot}, {"integrity monitoring", p.IntegrityMonitoring}, {"confidential computing", p.ConfidentialComputing}} {
+			if s.enabled {
+				settings = append(settings, s.name)
+			}
+		}
+		add("shielded VM with %s", strings.Join(settings, ", "))
+	}
+	if len(o.ExpectedNVIndices) != 0 {
+		add("%d NV indices", len(o.ExpectedNVIndices))
+	}
+	if o.WorkloadClaimKey != nil {
+		add("workload claim")
+	}
+	return checks
+}
+
+// enabledChecks describes the checks p adds to TDX verification.
+func (p *TdxPolicy) enabledChecks() []string {
+	if p == nil {
+		return nil
+	}
+	var checks []string
+	if len(p.ExpectedFMSPCs) != 0 {
+		checks = append(checks, "TDX FMSPC in "+strings.Join(p.ExpectedFMSPCs, ", "))
+	}
+	if len(p.RequireTCBStatus) != 0 {
+		checks = append(checks, "TDX TCB status in "+strings.Join(p.RequireTCBStatus, ", "))
+	}
+	if p.RequireFreshCollateral {
+		checks = append(checks, "fresh TDX collateral")
+	}
+	if p.RequireConsistentCollateral {
+		checks = append(checks, "TDX collateral issued after the PCK certificate and TCB date")
+	}
+	if len(p.MinTEETCBSVN) != 0 {
+		checks = append(checks, "minimum TDX TEE_TCB_SVN")
+	}
+	if len(p.ExpectedMRSEAMs) != 0 {
+		checks = append(checks, fmt.Sprintf("TDX module MRSEAM, %d allowed", len(p.ExpectedMRSEAMs)))
+	}
+	if p.VerifySEAMIdentity {
+		checks = append(checks, "TDX module identity")
+	}
+	if p.PCKRetrieval == PCKFromPPID {
+		checks = append(checks, "TDX PCK certificate fetched by encrypted PPID from "+p.PCKServiceURL)
+	}
+	return checks
+}
+
+// enabledChecks describes the checks p adds to SEV-SNP verification.
+func (p *SevSnpPolicy) enabledChecks() []string {
+	if p == nil {
+		return nil
+	}
+	var checks []string
+	if len(p.ExpectedReportID) != 0 {
+		checks = append(checks, "SEV-SNP REPORT_ID")
+	}
+	if len(p.ExpectedReportIDMA) != 0 {
+		checks = append(checks, "SEV-SNP REPORT_ID_MA")
+	}
+	if len(p.TrustedIDKeyHashes) != 0 || len(p.TrustedAuthorKeyHashes) != 0 || p.RequireAuthorKey {
+		checks = append(checks, "SEV-SNP ID block signed by a trusted key")
+	}
+	if p.RequireEmbeddedCerts {
+		checks = append(checks, "SEV-SNP certificates embedded in the attestation")
+	}
+	if p.RequireSMTDisabled {
+		checks = append(checks, "SEV-SNP host SMT disabled")
+	}
+	if p.RequireTSMEEnabled {
+		checks = append(checks, "SEV-SNP host TSME enabled")
+	}
+	return checks
+}
+
+// pcrIndices lists the indices of pcrs in increasing order.
+func pcrIndices(pcrs map[uint32][]byte) string {
+	indices := make([]uint32, 0, len(pcrs))
+	for index := range pcrs {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	var b strings.Builder
+	for i, index := range indices {
+		if i != 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprint(&b, index)
+	}
+	return b.String()
+}
@@ -0,0 +1,419 @@
+package attestation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultCollateralTTL is how long fetched TEE collateral is cached when no TTL is configured.
+const DefaultCollateralTTL = time.Hour
+
+// DefaultCollateralFetchTimeout bounds each fetch of TEE collateral, including its wait for the rate
+// limiter, when no timeout is configured.
+const DefaultCollateralFetchTimeout = time.Minute
+
+// collateralSource supplies TEE collateral documents by URL.
+type collateralSource interface {
+	get(ctx context.Context, url string) (map[string][]string, []byte, error)
+}
+
+// CollateralDocument is a TEE collateral document as fetched from AMD KDS or Intel PCS.
+// Distributed caches can store its JSON encoding.
+type CollateralDocument struct {
+	// Header holds the response headers, which carry the issuer chains of Intel collateral
+	Header map[string][]string `json:"header,omitempty"`
+	// Body is the response body
+	Body []byte `json:"body"`
+	// FetchedAt is when the fetch of the document started. Documents fetched before an
+	// invalidation that covers them are ignored.
+	FetchedAt time.Time `json:"fetchedAt,omitempty"`
+}
+
+// CollateralDescriptor selects TEE collateral documents to invalidate, by URL. A document matches
+// if its URL equals URL or starts with URLPrefix.
+type CollateralDescriptor struct {
+	// URL is the exact URL of a document, such as the CRL of an AMD product
+	URL string `json:"url,omitempty"`
+	// URLPrefix selects all documents under a URL, such as
+	// "https://kdsintf.amd.com/vcek/v1/Milan/" for the certificates of one AMD product. Empty
+	// selects none.
+	URLPrefix string `json:"urlPrefix,omitempty"`
+}
+
+// matches reports whether d selects the document at url.
+func (d CollateralDescriptor) matches(url string) bool {
+	return url == d.URL || (d.URLPrefix != "" && strings.HasPrefix(url, d.URLPrefix))
+}
+
+// CollateralCache stores the TEE collateral documents fetched by a Verifier, keyed by URL.
+// Implementations must be safe for concurrent use, and must return the header and body of each
+// document exactly as they were set, since collateral signatures cover the body and the issuer
+// chain is carried in the header. Documents are verified on every use, so a shared cache does not
+// need to be trusted with their integrity, only with their availability.
+type CollateralCache interface {
+	// Get returns the document cached for url, or nil if there is none or it has expired
+	Get(ctx context.Context, url string) (*CollateralDocument, error)
+	// Set caches document for url for ttl
+	Set(ctx context.Context, url string, document *CollateralDocument, ttl time.Duration) error
+}
+
+// MemoryCollateralCache is a CollateralCache that keeps documents in memory until they expire
+type MemoryCollateralCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCollateralEntry
+}
+
+type memoryCollateralEntry struct {
+	document *CollateralDocument
+	expires  time.Time
+}
+
+// NewMemoryCollateralCache creates an empty MemoryCollateralCache
+func NewMemoryCollateralCache() *MemoryCollateralCache {
+	return &MemoryCollateralCache{entries: make(map[string]memoryCollateralEntry)}
+}
+
+// Get returns the unexpired document cached for url, or nil. An expired document is removed.
+func (c *MemoryCollateralCache) Get(ctx context.Context, url string) (*CollateralDocument, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[url]
+	if !ok {
+		return nil, nil
+	}
+	if !time.Now().Before(entry.expires) {
+		delete(c.entries, url)
+		return nil, nil
+	}
+	return entry.document, nil
+}
+
+// Set caches document for url until ttl has passed, and removes the documents that have expired.
+func (c *MemoryCollateralCache) Set(ctx context.Context, url string, document *CollateralDocument, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[url] = memoryCollateralEntry{document: document, expires: now.Add(ttl)}
+	return nil
+}
+
+// collateralCache fetches TEE collateral (certificates, CRLs, TCB info) over HTTPS and caches the
+// responses by URL in a CollateralCache so that verifications sharing it only fetch each document
+// once per TTL. Concurrent misses for the same URL share a single fetch, which is canceled when
+// all of them have given up. Cache errors are treated as misses, so an unavailable cache only costs
+// fetches.
+type collateralCache struct {
+	client  *http.Client
+	ttl     time.Duration
+	limiter *rateLimiter
+	store   CollateralCache
+	flight  singleflight.Group
+	// maxDocumentSize bounds the fetched documents, 0 for no bound
+	maxDocumentSize int64
+	// fetchTimeout bounds each shared fetch
+	fetchTimeout time.Duration
+
+	flightMu sync.Mutex
+	// fetches are the shared fetches in progress, by URL
+	fetches map[string]*collateralFetch
+
+	mu sync.Mutex
+	// invalidatedAt is when the whole cache was last invalidated
+	invalidatedAt time.Time
+	// invalidations are the descriptors invalidated since, kept until the documents they cover
+	// would have expired
+	invalidations []collateralInvalidation
+}
+
+type collateralInvalidation struct {
+	descriptor CollateralDescriptor
+	at         time.Time
+}
+
+// collateralFetch is a shared fetch in progress and the callers waiting for it.
+type collateralFetch struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// newCollateralCache creates a collateral cache over store, which defaults to a
+// MemoryCollateralCache. Fetches wait for limiter, unless it is nil.
+func newCollateralCache(client *http.Client, ttl time.Duration, limiter *rateLimiter, store CollateralCache) *collateralCache {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if ttl <= 0 {
+		ttl = DefaultCollateralTTL
+	}
+	if store == nil {
+		store = NewMemoryCollateralCache()
+	}
+	return &collateralCache{
+		client:       client,
+		ttl:          ttl,
+		limiter:      limiter,
+		store:        store,
+		fetchTimeout: DefaultCollateralFetchTimeout,
+		fetches:      make(map[string]*collateralFetch),
+	}
+}
+
+// get returns the response headers and body for url, fetching it if it is not cached.
+func (c *collateralCache) get(ctx context.Context, url string) (map[string][]string, []byte, error) {
+	if document := c.cached(ctx, url); document != nil {
+		return document.Header, document.Body, nil
+	}
+
+	fetch, results := c.join(ctx, url)
+	select {
+	case result := <-results:
+		c.leave(url, fetch)
+		if result.Err != nil {
+			return nil, nil, result.Err
+		}
+		document := result.Val.(*CollateralDocument)
+		return document.Header, document.Body, nil
+	case <-ctx.Done():
+		c.leave(url, fetch)
+		return nil, nil, ctx.Err()
+	}
+}
+
+// join waits for the shared fetch of url, starting it if there is none. The fetch outlives callers
+// that give up, so that it still serves the others, but not all of them, and it is bounded by the
+// fetch timeout.
+func (c *collateralCache) join(ctx context.Context, url string) (*collateralFetch, <-chan singleflight.Result) {
+	c.flightMu.Lock()
+	defer c.flightMu.Unlock()
+	fetch := c.fetches[url]
+	if fetch == nil {
+		fetch = &collateralFetch{}
+		fetch.ctx, fetch.cancel = context.WithTimeout(context.WithoutCancel(ctx), c.fetchTimeout)
+		c.fetches[url] = fetch
+	}
+	fetch.waiters++
+	fetchCtx := fetch.ctx
+	return fetch, c.flight.DoChan(url, func() (any, error) {
+		defer c.finish(url, fetch)
+		if document := c.cached(fetchCtx, url); document != nil {
+			return document, nil
+		}
+		fetchedAt := time.Now()
+		header, body, err := c.fetch(fetchCtx, url)
+		if err != nil {
+			return nil, err
+		}
+		document := &CollateralDocument{Header: header, Body: body, FetchedAt: fetchedAt}
+		_ = c.store.Set(fetchCtx, url, document, c.ttl)
+		return document, nil
+	})
+}
+
+// finish forgets fetch of url once it completed, so that later callers start a new one.
+func (c *collateralCache) finish(url string, fetch *collateralFetch) {
+	c.flightMu.Lock()
+	defer c.flightMu.Unlock()
+	if c.fetches[url] == fetch {
+		delete(c.fetches, url)
+	}
+}
+
+// leave stops waiting for fetch of url. The last caller to leave cancels the fetch if it is still in
+// progress, and makes later callers start a new one.
+func (c *collateralCache) leave(url string, fetch *collateralFetch) {
+	c.flightMu.Lock()
+	defer c.flightMu.Unlock()
+	fetch.waiters--
+	if fetch.waiters > 0 {
+		return
+	}
+	fetch.cancel()
+	if c.fetches[url] == fetch {
+		delete(c.fetches, url)
+		c.flight.Forget(url)
+	}
+}
+
+// cached returns the document cached for url, or nil on a miss, a cache error or a document that
+// was invalidated.
+func (c *collateralCache) cached(ctx context.Context, url string) *CollateralDocument {
+	document, err := c.store.Get(ctx, url)
+	if err != nil || document == nil || c.invalidated(url, document.FetchedAt) {
+		return nil
+	}
+	return document
+}
+
+// invalidate makes the documents d selects, or all documents if d is nil, misses until they are
+// fetched again.
+func (c *collateralCache) invalidate(d *CollateralDescriptor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if d == nil {
+		c.invalidatedAt = now
+		c.invalidations = nil
+		return
+	}
+	// Documents cached before an invalidation have expired a TTL after it.
+	kept := c.invalidations[:0]
+	for _, inv := range c.invalidations {
+		if now.Sub(inv.at) < c.ttl {
+			kept = append(kept, inv)
+		}
+	}
+	c.invalidations = append(kept, collateralInvalidation{descriptor: *d, at: now})
+}
+
+// invalidated reports whether the document at url fetched at fetchedAt has been invalidated since.
+func (c *collateralCache) invalidated(url string, fetchedAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.invalidatedAt.IsZero() && !fetchedAt.After(c.invalidatedAt) {
+		return true
+	}
+	for _, inv := range c.invalidations {
+		if !fetchedAt.After(inv.at) && inv.descriptor.matches(url) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetch fetches url once the limiter allows it.
+func (c *collateralCache) fetch(ctx context.Context, url string) (map[string][]string, []byte, error) {
+	if c.limiter != nil {
+		if err := c.limiter.wait(ctx); err != nil {
+			return nil, nil, fmt.Errorf("waiting to fetch %s: %w", url, err)
+		}
+	}
+	return fetchCollateral(ctx, c.client, url, c.maxDocumentSize)
+}
+
+// httpCollateral fetches TEE collateral over HTTPS within the context of each verification, without
+// caching or sharing fetches, unlike the default getters of go-sev-guest and go-tdx-guest which
+// take no context.
+type httpCollateral struct {
+	client *http.Client
+	// maxDocumentSize bounds the fetched documents, 0 for no bound
+	maxDocumentSize int64
+}
+
+func (h *httpCollateral) get(ctx context.Context, url string) (map[string][]string, []byte, error) {
+	return fetchCollateral(ctx, h.client, url, h.maxDocumentSize)
+}
+
+// fetchCollateral fetches the collateral document at url with client, failing for documents over
+// maxDocumentSize bytes unless it is 0.
+func fetchCollateral(ctx context.Context, client *http.Client, url string, maxDocumentSize int64) (map[string][]string, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("failed to retrieve %s, status code received %d", url, resp.StatusCode)
+	}
+	reader := io.Reader(resp.Body)
+	if maxDocumentSize > 0 {
+		reader = io.LimitReader(resp.Body, maxDocumentSize+1)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if maxDocumentSize > 0 && int64(len(body)) > maxDocumentSize {
+		return nil, nil, &LimitError{Input: "collateral document " + url, Limit: "MaxCollateralSize", Max: maxDocumentSize, Size: int64(len(body))}
+	}
+	return resp.Header, body, nil
+}
+
+// rateLimiter is a token bucket that spaces out collateral fetches to rate per second, allowing
+// bursts of up to burst fetches.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter of rate fetches per second, or nil if rate is not positive.
+// burst defaults to 1.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes a token, waiting until one is available or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	// Taking the token before waiting queues concurrent callers behind each other.
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// sevSnpCollateralGetter adapts a collateralSource to go-sev-guest's trust.HTTPSGetter.
+type sevSnpCollateralGetter struct {
+	ctx    context.Context
+	source collateralSource
+}
+
+// Get returns the body of url.
+func (g *sevSnpCollateralGetter) Get(url string) ([]byte, error) {
+	_, body, err := g.source.get(g.ctx, url)
+	return body, err
+}
+
+// tdxCollateralGetter adapts a collateralSource to go-tdx-guest's trust.HTTPSGetter.
+type tdxCollateralGetter struct {
+	ctx    context.Context
+	source collateralSource
+}
+
+// Get returns the headers and body of url.
+func (g *tdxCollateralGetter) Get(url string) (map[string][]string, []byte, error) {
+	return g.source.get(g.ctx, url)
+}
@@ -0,0 +1,133 @@
+package attestation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// collateralServer serves a fixed document and counts the requests it received.
+func collateralServer(t *testing.T, delay time.Duration) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte("collateral"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestCollateralCacheAbandonedRateLimitWait(t *testing.T) {
+	server, requests := collateralServer(t, 0)
+	limiter := newRateLimiter(1, 1)
+	cache := newCollateralCache(server.Client(), 0, limiter, nil)
+	// Take the only token, so that the next fetch waits about a second for one.
+	if err := limiter.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := cache.get(ctx, server.URL); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("get() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if n := requests.Load(); n != 0 {
+		t.Errorf("server received %d requests after the caller gave up, want 0", n)
+	}
+	// The abandoned wait returned its token, so a fetch is due about a second after the first one.
+	limiter.mu.Lock()
+	tokens := limiter.tokens + time.Since(limiter.last).Seconds()*limiter.rate
+	limiter.mu.Unlock()
+	if tokens < 0.9 {
+		t.Errorf("limiter has %.2f tokens, want the abandoned token returned", tokens)
+	}
+}
+
+func TestCollateralCacheFetchTimeout(t *testing.T) {
+	server, _ := collateralServer(t, time.Minute)
+	cache := newCollateralCache(server.Client(), 0, nil, nil)
+	cache.fetchTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if _, _, err := cache.get(context.Background(), server.URL); err == nil {
+		t.Fatal("get() of a stalled document succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("get() of a stalled document took %v, want the fetch timeout", elapsed)
+	}
+}
+
+func TestCollateralCacheSharesConcurrentMisses(t *testing.T) {
+	server, requests := collateralServer(t, 100*time.Millisecond)
+	cache := newCollateralCache(server.Client(), 0, nil, nil)
+
+	const callers = 20
+	errs := make(chan error, callers)
+	for range callers {
+		go func() {
+			_, body, err := cache.get(context.Background(), server.URL)
+			if err == nil && string(body) != "collateral" {
+				err = fmt.Errorf("body = %q, want %q", body, "collateral")
+			}
+			errs <- err
+		}()
+	}
+	for range callers {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d concurrent misses made %d fetches, want 1", callers, n)
+	}
+}
+
+func TestMemoryCollateralCacheEvictsExpired(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCollateralCache()
+	document := &CollateralDocument{Body: []byte("collateral")}
+	cache.Set(ctx, "https://example.com/expired", document, time.Millisecond)
+	cache.Set(ctx, "https://example.com/read", document, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+
+	if got, _ := cache.Get(ctx, "https://example.com/read"); got != nil {
+		t.Errorf("Get() of an expired document = %v, want nil", got)
+	}
+	cache.Set(ctx, "https://example.com/fresh", document, time.Hour)
+	if n := len(cache.entries); n != 1 {
+		t.Errorf("cache holds %d entries, want only the unexpired one", n)
+	}
+}
+
+func TestCollateralCacheRetriesAfterStalledFetch(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte("collateral"))
+	}))
+	defer server.Close()
+	cache := newCollateralCache(server.Client(), 0, nil, nil)
+	cache.fetchTimeout = 100 * time.Millisecond
+
+	if _, _, err := cache.get(context.Background(), server.URL); err == nil {
+		t.Fatal("get() of a stalled document succeeded")
+	}
+	if _, body, err := cache.get(context.Background(), server.URL); err != nil || string(body) != "collateral" {
+		t.Errorf("get() after a stalled fetch = %q, %v, want a new fetch", body, err)
+	}
+}
@@ -0,0 +1,135 @@
+package attestation
+
+import (
+	"fmt"
+	"strings"
+
+	sabi "github.com/google/go-sev-guest/abi"
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// Names of the built-in compliance profiles
+const (
+	// ProfileProdConfidential is for production confidential VMs
+	ProfileProdConfidential = "prod-confidential"
+	// ProfileDevConfidential is for development confidential VMs, which may be debuggable
+	ProfileDevConfidential = "dev-confidential"
+	// ProfileShieldedVM is for VMs with Secure Boot and measured boot, with or without a TEE
+	ProfileShieldedVM = "shielded-vm"
+)
+
+// BuiltinProfiles are the built-in compliance profiles, by name.
+var BuiltinProfiles = map[string]ComplianceProfile{
+	ProfileProdConfidential: {
+		Name:                ProfileProdConfidential,
+		RequireTEE:          true,
+		RequireSecureBoot:   true,
+		RejectDebug:         true,
+		RequireEventLog:     true,
+		AcceptedTCBStatuses: []string{"UpToDate", "SWHardeningNeeded"},
+	},
+	ProfileDevConfidential: {
+		Name:       ProfileDevConfidential,
+		RequireTEE: true,
+	},
+	ProfileShieldedVM: {
+		Name:              ProfileShieldedVM,
+		RequireSecureBoot: true,
+		RequireEventLog:   true,
+	},
+}
+
+// ComplianceProfile is a named set of requirements on a verified host, evaluated against its
+// VerificationReport with EvaluateProfile
+type ComplianceProfile struct {
+	// Name identifies the profile
+	Name string `json:"name"`
+	// RequireTEE requires a verified SEV-SNP or TDX attestation
+	RequireTEE bool `json:"requireTEE,omitempty"`
+	// Technologies restricts the TEE technology (sev-snp or tdx) when set
+	Technologies []string `json:"technologies,omitempty"`
+	// RequireSecureBoot requires Secure Boot to be enabled, as recorded in the event log
+	RequireSecureBoot bool `json:"requireSecureBoot,omitempty"`
+	// RejectDebug rejects TEE guests that the host can debug: SEV-SNP guests whose policy allows
+	// debugging and TDX guests with the DEBUG TD attribute
+	RejectDebug bool `json:"rejectDebug,omitempty"`
+	// RequireEventLog requires the report to carry its event log
+	RequireEventLog bool `json:"requireEventLog,omitempty"`
+	// RequireGceAKEndorsement requires the AK to be endorsed by a gceAK certificate, as checked with
+	// VerifyOptions.VerifyGceAKCert
+	RequireGceAKEndorsement bool `json:"requireGceAKEndorsement,omitempty"`
+	// AcceptedTCBStatuses lists the accepted TDX TCB statuses. The status is only resolved when
+	// VerifyOptions.Tdx requires one. Not checked for other technologies.
+	AcceptedTCBStatuses []string `json:"acceptedTCBStatuses,omitempty"`
+}
+
+// EvaluateProfile reports whether a verified host meets profile, and lists the requirements it
+// does not meet. The requirements are checked against what report records, so checks such as the
+// TDX TCB status must also be enabled in the VerifyOptions that produced it.
+func EvaluateProfile(report *VerificationReport, profile ComplianceProfile) (bool, []string) {
+	var unmet []string
+	ms := report.MachineState
+
+	if profile.RequireTEE && report.Technology == "" {
+		unmet = append(unmet, "requires a TEE attestation")
+	}
+	if len(profile.Technologies) != 0 && report.Technology != "" && !containsString(profile.Technologies, report.Technology) {
+		unmet = append(unmet, fmt.Sprintf("TEE technology %s is not one of %s", report.Technology, strings.Join(profile.Technologies, ", ")))
+	}
+	if profile.RequireSecureBoot && !ms.GetSecureBoot().GetEnabled() {
+		unmet = append(unmet, "requires Secure Boot to be enabled")
+	}
+	if profile.RejectDebug {
+		if debug, err := teeDebuggable(ms); err != nil {
+			unmet = append(unmet, fmt.Sprintf("cannot determine whether the TEE guest is debuggable: %v", err))
+		} else if debug {
+			unmet = append(unmet, "TEE guest is debuggable")
+		}
+	}
+	if profile.RequireEventLog && (report.EventLogTruncated || len(report.Attestation.GetEventLog()) == 0) {
+		unmet = append(unmet, "requires the event log")
+	}
+	if profile.RequireGceAKEndorsement && report.GceAKEndorsement == nil {
+		unmet = append(unmet, "requires a gceAK certificate endorsing the AK")
+	}
+	if len(profile.AcceptedTCBStatuses) != 0 && report.Technology == Tdx {
+		switch {
+		case report.Tdx == nil || report.Tdx.TCBStatus == "":
+			unmet = append(unmet, "TDX TCB status was not resolved")
+		case !containsString(profile.AcceptedTCBStatuses, report.Tdx.TCBStatus):
+			unmet = append(unmet, fmt.Sprintf("TDX TCB status %s is not one of %s", report.Tdx.TCBStatus, strings.Join(profile.AcceptedTCBStatuses, ", ")))
+		}
+	}
+	return len(unmet) == 0, unmet
+}
+
+// teeDebuggable reports whether the TEE guest of a verified machine state can be debugged by the
+// host. Machine states without a TEE attestation are not debuggable.
+func teeDebuggable(ms *pb.MachineState) (bool, error) {
+	if snp := ms.GetSevSnpAttestation(); snp != nil {
+		policy, err := sabi.ParseSnpPolicy(snp.GetReport().GetPolicy())
+		if err != nil {
+			return false, err
+		}
+		return policy.Debug, nil
+	}
+	if tdx := ms.GetTdxAttestation(); tdx != nil {
+		attributes := tdx.GetTdQuoteBody().GetTdAttributes()
+		if len(attributes) == 0 {
+			return false, fmt.Errorf("TD quote has no TD attributes")
+		}
+		// DEBUG is bit 0 of TDATTRIBUTES.
+		return attributes[0]&1 != 0, nil
+	}
+	return false, nil
+}
+
+// containsString reports whether values contains s.
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,278 @@
+package attestation
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// Names of the boot components of DefaultPCRLayout
+const (
+	// ComponentFirmware is the platform firmware code
+	ComponentFirmware = "firmware"
+	// ComponentFirmwareConfig is the platform firmware configuration
+	ComponentFirmwareConfig = "firmwareConfig"
+	// ComponentOptionROMs are the option ROMs and UEFI drivers loaded from devices
+	ComponentOptionROMs = "optionROMs"
+	// ComponentBootloader is the boot manager and boot loader code
+	ComponentBootloader = "bootloader"
+	// ComponentSecureBootPolicy is the Secure Boot state and variables
+	ComponentSecureBootPolicy = "secureBootPolicy"
+	// ComponentKernel is the kernel command line and the files the boot loader loaded
+	ComponentKernel = "kernel"
+	// ComponentRuntime is what the guest measures at runtime, such as its workload. Only in
+	// DefaultRTMRLayout.
+	ComponentRuntime = "runtime"
+)
+
+// PCRLayout maps boot component names to the PCRs a platform measures them into
+type PCRLayout map[string][]uint32
+
+// DefaultPCRLayout is the layout of the TCG PC Client Platform Firmware Profile, with GRUB
+// measuring the kernel command line into PCR 8 and the files it loads into PCR 9.
+var DefaultPCRLayout = PCRLayout{
+	ComponentFirmware:         {0},
+	ComponentFirmwareConfig:   {1},
+	ComponentOptionROMs:       {2},
+	ComponentBootloader:       {4},
+	ComponentSecureBootPolicy: {7},
+	ComponentKernel:           {8, 9},
+}
+
+// ComponentPolicy expresses PCR expectations by boot component rather than by PCR index, so that
+// one policy applies to platforms with different PCR layouts by only changing Layout.
+type ComponentPolicy struct {
+	// Layout maps the components of the policy to PCRs on the verified platform. Defaults to
+	// DefaultPCRLayout.
+	Layout PCRLayout `json:"layout,omitempty"`
+	// Components maps component names to their acceptable measurements. Each measurement lists
+	// the digest of every PCR of the component, in Layout order.
+	Components map[string][][]HexBytes `json:"components"`
+}
+
+// Allow adds an acceptable measurement of component, the digest of each of its PCRs in layout
+// order.
+func (p *ComponentPolicy) Allow(component string, digests ...[]byte) {
+	if p.Components == nil {
+		p.Components = make(map[string][][]HexBytes)
+	}
+	measurement := make([]HexBytes, len(digests))
+	for i, digest := range digests {
+		measurement[i] = digest
+	}
+	p.Components[component] = append(p.Components[component], measurement)
+}
+
+// layout returns the PCRs of component.
+func (p *ComponentPolicy) layout(component string) ([]uint32, bool) {
+	layout := p.Layout
+	if layout == nil {
+		layout = DefaultPCRLayout
+	}
+	pcrs, ok := layout[component]
+	return pcrs, ok && len(pcrs) != 0
+}
+
+// RTMRLayout maps boot component names to the TDX RTMR a guest stack measures them into
+type RTMRLayout map[string]uint32
+
+// DefaultRTMRLayout is the layout of TDVF (OVMF for TDX) guests: the firmware configuration and
+// Secure Boot variables in RTMR 0, the boot loader in RTMR 1, the kernel, initrd and command line
+// it loads in RTMR 2, and runtime measurements in RTMR 3. The firmware code itself is MRTD.
+var DefaultRTMRLayout = RTMRLayout{
+	ComponentFirmwareConfig: 0,
+	ComponentBootloader:     1,
+	ComponentKernel:         2,
+	ComponentRuntime:        3,
+}
+
+// RTMRComponentPolicy expresses TDX RTMR expectations by boot component rather than by RTMR index,
+// so that one policy applies to guest stacks that measure components into different RTMRs by only
+// changing Layout.
+type RTMRComponentPolicy struct {
+	// Layout maps the components of the policy to RTMRs on the verified guest. Defaults to
+	// DefaultRTMRLayout.
+	Layout RTMRLayout `json:"layout,omitempty"`
+	// Components maps component names to the acceptable values of their RTMR
+	Components map[string][]HexBytes `json:"components"`
+}
+
+// Allow adds an acceptable value of the RTMR of component.
+func (p *RTMRComponentPolicy) Allow(component string, digest []byte) {
+	if p.Components == nil {
+		p.Components = make(map[string][]HexBytes)
+	}
+	p.Components[component] = append(p.Components[component], digest)
+}
+
+// layout returns the RTMR of component.
+func (p *RTMRComponentPolicy) layout(component string) (uint32, bool) {
+	layout := p.Layout
+	if layout == nil {
+		layout = DefaultRTMRLayout
+	}
+	rtmr, ok := layout[component]
+	return rtmr, ok
+}
+
+// ComponentResult is the outcome of checking one component of a ComponentPolicy or
+// RTMRComponentPolicy
+type ComponentResult struct {
+	// Component is the component name
+	Component string `json:"component"`
+	// PCRs are the PCRs the layout maps the component to, for a ComponentPolicy
+	PCRs []uint32 `json:"pcrs,omitempty"`
+	// RTMR is the RTMR the layout maps the component to, for an RTMRComponentPolicy
+	RTMR *uint32 `json:"rtmr,omitempty"`
+	// Passed reports that the PCRs held one of the acceptable measurements
+	Passed bool `json:"passed"`
+	// Reason describes why the component failed
+	Reason string `json:"reason,omitempty"`
+}
+
+// ComponentPolicyError is returned for a report with at least one component that does not
+// satisfy the ComponentPolicy or RTMRComponentPolicy
+type ComponentPolicyError struct {
+	// Results holds the result of every component, including those that passed
+	Results []ComponentResult
+}
+
+func (e *ComponentPolicyError) Error() string {
+	var failed []string
+	for _, result := range e.Results {
+		if !result.Passed {
+			failed = append(failed, fmt.Sprintf("%s: %s", result.Component, result.Reason))
+		}
+	}
+	return strings.Join(failed, ", ")
+}
+
+// checkComponentPolicy resolves the components of policy to PCRs and checks the verified PCRs of
+// an attestation against them. The results are returned, ordered by component name, whether or
+// not a component failed.
+func checkComponentPolicy(policy *ComponentPolicy, attestation *pb.Attestation, ms *pb.MachineState) ([]ComponentResult, error) {
+	pcrs, err := verifiedPCRs(attestation, ms)
+	if err != nil {
+		return nil, err
+	}
+	components := make([]string, 0, len(policy.Components))
+	for component := range policy.Components {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+
+	var results []ComponentResult
+	failed := false
+	for _, component := range components {
+		result := checkComponent(policy, component, pcrs)
+		if !result.Passed {
+			failed = true
+		}
+		results = append(results, result)
+	}
+	if failed {
+		return results, &ComponentPolicyError{Results: results}
+	}
+	return results, nil
+}
+
+// checkComponent checks the PCRs of one component against its acceptable measurements.
+func checkComponent(policy *ComponentPolicy, component string, pcrs map[uint32][]byte) ComponentResult {
+	indices, ok := policy.layout(component)
+	result := ComponentResult{Component: component, PCRs: indices}
+	if !ok {
+		result.Reason = "component is not in the PCR layout"
+		return result
+	}
+	values := make([][]byte, len(indices))
+	for i, index := range indices {
+		value, ok := pcrs[index]
+		if !ok {
+			result.Reason = fmt.Sprintf("PCR %d is not quoted", index)
+			return result
+		}
+		values[i] = value
+	}
+	for _, measurement := range policy.Components[component] {
+		if len(measurement) != len(values) {
+			result.Reason = fmt.Sprintf("acceptable measurement has %d digests, the layout has %d PCRs", len(measurement), len(values))
+			return result
+		}
+		if measurementMatches(measurement, values) {
+			result.Passed = true
+			return result
+		}
+	}
+	var got []string
+	for i, index := range indices {
+		got = append(got, fmt.Sprintf("PCR %d is %x", index, values[i]))
+	}
+	result.Reason = strings.Join(got, ", ") + ", which is not an acceptable measurement"
+	return result
+}
+
+// measurementMatches reports whether every digest of measurement equals the PCR value at its
+// position.
+func measurementMatches(measurement []HexBytes, values [][]byte) bool {
+	for i, digest := range measurement {
+		if !bytes.Equal(digest, values[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkRTMRComponentPolicy resolves the components of policy to RTMRs and checks the verified TDX
+// quote of ms against them. The results are returned, ordered by component name, whether or not a
+// component failed.
+func checkRTMRComponentPolicy(policy *RTMRComponentPolicy, ms *pb.MachineState) ([]ComponentResult, error) {
+	body := ms.GetTdxAttestation().GetTdQuoteBody()
+	if body == nil {
+		return nil, fmt.Errorf("RTMR components require a TDX attestation")
+	}
+	components := make([]string, 0, len(policy.Components))
+	for component := range policy.Components {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+
+	var results []ComponentResult
+	failed := false
+	for _, component := range components {
+		result := checkRTMRComponent(policy, component, body.GetRtmrs())
+		if !result.Passed {
+			failed = true
+		}
+		results = append(results, result)
+	}
+	if failed {
+		return results, &ComponentPolicyError{Results: results}
+	}
+	return results, nil
+}
+
+// checkRTMRComponent checks the RTMR of one component against its acceptable values.
+func checkRTMRComponent(policy *RTMRComponentPolicy, component string, rtmrs [][]byte) ComponentResult {
+	result := ComponentResult{Component: component}
+	index, ok := policy.layout(component)
+	if !ok {
+		result.Reason = "component is not in the RTMR layout"
+		return result
+	}
+	result.RTMR = &index
+	if int(index) >= len(rtmrs) {
+		result.Reason = fmt.Sprintf("RTMR %d is not in the quote", index)
+		return result
+	}
+	for _, digest := range policy.Components[component] {
+		if bytes.Equal(digest, rtmrs[index]) {
+			result.Passed = true
+			return result
+		}
+	}
+	result.Reason = fmt.Sprintf("RTMR %d is %x, which is not an acceptable measurement", index, rtmrs[index])
+	return result
+}
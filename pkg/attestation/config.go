@@ -0,0 +1,395 @@
+package attestation
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// verifyOptionsFields has the fields of VerifyOptions without its JSON methods.
+type verifyOptionsFields VerifyOptions
+
+// verifyOptionsJSON is the JSON form of VerifyOptions. Certificates and keys are encoded as DER,
+// and expected PCRs as hex.
+type verifyOptionsJSON struct {
+	verifyOptionsFields
+	ExpectedPCRs         map[uint32]string `json:"expectedPCRs,omitempty"`
+	GceRootCerts         [][]byte          `json:"gceRootCerts,omitempty"`
+	GceIntermediateCerts [][]byte          `json:"gceIntermediateCerts,omitempty"`
+	TrustedEKRoots       [][]byte          `json:"trustedEKRoots,omitempty"`
+	WorkloadClaimKey     []byte            `json:"workloadClaimKey,omitempty"`
+}
+
+// MarshalJSON encodes o as JSON, with certificates and keys encoded as base64 DER
+func (o VerifyOptions) MarshalJSON() ([]byte, error) {
+	j := verifyOptionsJSON{
+		verifyOptionsFields:  verifyOptionsFields(o),
+		GceRootCerts:         encodeCertificates(o.GceRootCerts),
+		GceIntermediateCerts: encodeCertificates(o.GceIntermediateCerts),
+		TrustedEKRoots:       encodeCertificates(o.TrustedEKRoots),
+	}
+	if len(o.ExpectedPCRs) != 0 {
+		j.ExpectedPCRs = make(map[uint32]string, len(o.ExpectedPCRs))
+		for index, digest := range o.ExpectedPCRs {
+			j.ExpectedPCRs[index] = hex.EncodeToString(digest)
+		}
+	}
+	if o.WorkloadClaimKey != nil {
+		var err error
+		if j.WorkloadClaimKey, err = x509.MarshalPKIXPublicKey(o.WorkloadClaimKey); err != nil {
+			return nil, fmt.Errorf("invalid workloadClaimKey: %v", err)
+		}
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes VerifyOptions encoded by MarshalJSON. Expected PCRs may be written in any
+// form accepted by ParsePCRValue.
+func (o *VerifyOptions) UnmarshalJSON(data []byte) error {
+	var j verifyOptionsJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*o = VerifyOptions(j.verifyOptionsFields)
+
+	var err error
+	if len(j.ExpectedPCRs) != 0 {
+		o.ExpectedPCRs = make(map[uint32][]byte, len(j.ExpectedPCRs))
+		for index, value := range j.ExpectedPCRs {
+			if o.ExpectedPCRs[index], err = ParsePCRValue(value); err != nil {
+				return fmt.Errorf("invalid expectedPCRs: %v", err)
+			}
+		}
+	}
+	if o.GceRootCerts, err = decodeCertificates(j.GceRootCerts); err != nil {
+		return fmt.Errorf("invalid gceRootCerts: %v", err)
+	}
+	if o.GceIntermediateCerts, err = decodeCertificates(j.GceIntermediateCerts); err != nil {
+		return fmt.Errorf("invalid gceIntermediateCerts: %v", err)
+	}
+	if o.TrustedEKRoots, err = decodeCertificates(j.TrustedEKRoots); err != nil {
+		return fmt.Errorf("invalid trustedEKRoots: %v", err)
+	}
+	if len(j.WorkloadClaimKey) != 0 {
+		if o.WorkloadClaimKey, err = x509.ParsePKIXPublicKey(j.WorkloadClaimKey); err != nil {
+			return fmt.Errorf("invalid workloadClaimKey: %v", err)
+		}
+	}
+	return nil
+}
+
+// transparencyLogPolicyJSON is the JSON form of TransparencyLogPolicy, with publisher keys encoded
+// as PKIX DER. The log is not serialized.
+type transparencyLogPolicyJSON struct {
+	PublisherKeys [][]byte `json:"publisherKeys,omitempty"`
+	PCRs          []uint32 `json:"pcrs,omitempty"`
+}
+
+// MarshalJSON encodes the publisher keys and PCRs of p as JSON. The log is not encoded.
+func (p TransparencyLogPolicy) MarshalJSON() ([]byte, error) {
+	j := transparencyLogPolicyJSON{PCRs: p.PCRs}
+	for _, key := range p.PublisherKeys {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid publisherKeys: %v", err)
+		}
+		j.PublisherKeys = append(j.PublisherKeys, der)
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes a TransparencyLogPolicy encoded by MarshalJSON. Log is left nil.
+func (p *TransparencyLogPolicy) UnmarshalJSON(data []byte) error {
+	var j transparencyLogPolicyJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*p = TransparencyLogPolicy{PCRs: j.PCRs}
+	for _, der := range j.PublisherKeys {
+		key, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return fmt.Errorf("invalid publisherKeys: %v", err)
+		}
+		p.PublisherKeys = append(p.PublisherKeys, key)
+	}
+	return nil
+}
+
+// verifierConfigJSON is the JSON form of VerifierConfig. The HTTP client, collateral cache and
+// baseline store are not serialized.
+type verifierConfigJSON struct {
+	Options                VerifyOptions `json:"options"`
+	CollateralTTL          string        `json:"collateralTTL,omitempty"`
+	CollateralRateLimit    float64       `json:"collateralRateLimit,omitempty"`
+	CollateralBurst        int           `json:"collateralBurst,omitempty"`
+	CollateralFetchTimeout string        `json:"collateralFetchTimeout,omitempty"`
+	TrustConfigs           []TrustConfig `json:"trustConfigs,omitempty"`
+	ChallengeTTL           string        `json:"challengeTTL,omitempty"`
+	TrustFirstBaseline     bool          `json:"trustFirstBaseline,omitempty"`
+	BaselinePCRs           []uint32      `json:"baselinePCRs,omitempty"`
+	BaselineResetCount     bool          `json:"baselineResetCount,omitempty"`
+	AsyncWorkers           int           `json:"asyncWorkers,omitempty"`
+}
+
+// MarshalJSON encodes the policy of c as JSON, so that the configuration that verified a report
+// can be stored alongside it. HTTPClient, CollateralCache and Baselines are not encoded.
+func (c VerifierConfig) MarshalJSON() ([]byte, error) {
+	j := verifierConfigJSON{
+		Options:             c.Options,
+		CollateralRateLimit: c.CollateralRateLimit,
+		CollateralBurst:     c.CollateralBurst,
+		TrustConfigs:        c.TrustConfigs,
+		TrustFirstBaseline:  c.TrustFirstBaseline,
+		BaselinePCRs:        c.BaselinePCRs,
+		BaselineResetCount:  c.BaselineResetCount,
+		AsyncWorkers:        c.AsyncWorkers,
+	}
+	if c.CollateralTTL != 0 {
+		j.CollateralTTL = c.CollateralTTL.String()
+	}
+	if c.CollateralFetchTimeout != 0 {
+		j.CollateralFetchTimeout = c.CollateralFetchTimeout.String()
+	}
+	if c.ChallengeTTL != 0 {
+		j.ChallengeTTL = c.ChallengeTTL.String()
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes a VerifierConfig encoded by MarshalJSON. HTTPClient, CollateralCache and
+// Baselines are left nil.
+func (c *VerifierConfig) UnmarshalJSON(data []byte) error {
+	var j verifierConfigJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*c = VerifierConfig{
+		Options:             j.Options,
+		CollateralRateLimit: j.CollateralRateLimit,
+		CollateralBurst:     j.CollateralBurst,
+		TrustConfigs:        j.TrustConfigs,
+		TrustFirstBaseline:  j.TrustFirstBaseline,
+		BaselinePCRs:        j.BaselinePCRs,
+		BaselineResetCount:  j.BaselineResetCount,
+		AsyncWorkers:        j.AsyncWorkers,
+	}
+	if j.CollateralTTL != "" {
+		ttl, err := time.ParseDuration(j.CollateralTTL)
+		if err != nil {
+			return fmt.Errorf("invalid collateralTTL: %v", err)
+		}
+		c.CollateralTTL = ttl
+	}
+	if j.CollateralFetchTimeout != "" {
+		timeout, err := time.ParseDuration(j.CollateralFetchTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid collateralFetchTimeout: %v", err)
+		}
+		c.CollateralFetchTimeout = timeout
+	}
+	if j.ChallengeTTL != "" {
+		ttl, err := time.ParseDuration(j.ChallengeTTL)
+		if err != nil {
+			return fmt.Errorf("invalid challengeTTL: %v", err)
+		}
+		c.ChallengeTTL = ttl
+	}
+	return nil
+}
+
+// attestOptionsJSON is the JSON form of AttestOptions. Algorithms are encoded by name, and the
+// busy retry delay as a duration string. The instance info provider, workload claim and signer,
+// and TPM are not serialized.
+type attestOptionsJSON struct {
+	Key              string   `json:"key,omitempty"`
+	KeyAlgo          string   `json:"keyAlgo,omitempty"`
+	KeyHash          string   `json:"keyHash,omitempty"`
+	Nonce            []byte   `json:"nonce,omitempty"`
+	TeeTechnology    string   `json:"teeTechnology,omitempty"`
+	TeeNonce         []byte   `json:"teeNonce,omitempty"`
+	Format           string   `json:"format,omitempty"`
+	StrictNonce      bool     `json:"strictNonce,omitempty"`
+	BusyRetries      int      `json:"busyRetries,omitempty"`
+	BusyRetryDelay   string   `json:"busyRetryDelay,omitempty"`
+	MaxEventLogSize  int      `json:"maxEventLogSize,omitempty"`
+	EventLogOverflow string   `json:"eventLogOverflow,omitempty"`
+	NVIndices        []uint32 `json:"nvIndices,omitempty"`
+	AttachEKCert     bool     `json:"attachEKCert,omitempty"`
+	AttachEKPub      bool     `json:"attachEKPub,omitempty"`
+	CanonicalText    bool     `json:"canonicalText,omitempty"`
+	OmitEventLog     bool     `json:"omitEventLog,omitempty"`
+}
+
+// Names of the key and hash algorithms of AttestOptions in its JSON form
+var (
+	keyAlgoNames = map[string]tpm2.Algorithm{"RSA": tpm2.AlgRSA, "ECC": tpm2.AlgECC}
+	keyHashNames = map[string]tpm2.Algorithm{"SHA256": tpm2.AlgSHA256, "SHA384": tpm2.AlgSHA384, "SHA512": tpm2.AlgSHA512}
+)
+
+// MarshalJSON encodes o as JSON, with algorithms by name (e.g. "RSA", "SHA256"). The instance info
+// provider, workload claim and signer, and TPM are not encoded.
+func (o AttestOptions) MarshalJSON() ([]byte, error) {
+	j := attestOptionsJSON{
+		Key:              o.Key,
+		Nonce:            o.Nonce,
+		TeeTechnology:    o.TeeTechnology,
+		TeeNonce:         o.TeeNonce,
+		Format:           o.Format,
+		StrictNonce:      o.StrictNonce,
+		BusyRetries:      o.BusyRetries,
+		MaxEventLogSize:  o.MaxEventLogSize,
+		EventLogOverflow: o.EventLogOverflow,
+		NVIndices:        o.NVIndices,
+		AttachEKCert:     o.AttachEKCert,
+		AttachEKPub:      o.AttachEKPub,
+		CanonicalText:    o.CanonicalText,
+		OmitEventLog:     o.OmitEventLog,
+	}
+	var err error
+	if o.KeyAlgo != 0 {
+		if j.KeyAlgo, err = algorithmName(keyAlgoNames, o.KeyAlgo); err != nil {
+			return nil, fmt.Errorf("invalid keyAlgo: %v", err)
+		}
+	}
+	if o.KeyHash != 0 {
+		if j.KeyHash, err = algorithmName(keyHashNames, o.KeyHash); err != nil {
+			return nil, fmt.Errorf("invalid keyHash: %v", err)
+		}
+	}
+	if o.BusyRetryDelay != 0 {
+		j.BusyRetryDelay = o.BusyRetryDelay.String()
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes AttestOptions encoded by MarshalJSON. Algorithm names are case-insensitive.
+// The instance info provider, workload claim and signer, and TPM are left nil.
+func (o *AttestOptions) UnmarshalJSON(data []byte) error {
+	var j attestOptionsJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*o = AttestOptions{
+		Key:              j.Key,
+		Nonce:            j.Nonce,
+		TeeTechnology:    j.TeeTechnology,
+		TeeNonce:         j.TeeNonce,
+		Format:           j.Format,
+		StrictNonce:      j.StrictNonce,
+		BusyRetries:      j.BusyRetries,
+		MaxEventLogSize:  j.MaxEventLogSize,
+		EventLogOverflow: j.EventLogOverflow,
+		NVIndices:        j.NVIndices,
+		AttachEKCert:     j.AttachEKCert,
+		AttachEKPub:      j.AttachEKPub,
+		CanonicalText:    j.CanonicalText,
+		OmitEventLog:     j.OmitEventLog,
+	}
+	var err error
+	if j.KeyAlgo != "" {
+		if o.KeyAlgo, err = parseAlgorithmName(keyAlgoNames, j.KeyAlgo); err != nil {
+			return fmt.Errorf("invalid keyAlgo: %v", err)
+		}
+	}
+	if j.KeyHash != "" {
+		if o.KeyHash, err = parseAlgorithmName(keyHashNames, j.KeyHash); err != nil {
+			return fmt.Errorf("invalid keyHash: %v", err)
+		}
+	}
+	if j.BusyRetryDelay != "" {
+		if o.BusyRetryDelay, err = time.ParseDuration(j.BusyRetryDelay); err != nil {
+			return fmt.Errorf("invalid busyRetryDelay: %v", err)
+		}
+	}
+	return nil
+}
+
+// AttestOptionsFromConfig builds AttestOptions from a JSON configuration in the form encoded by
+// AttestOptions.MarshalJSON, e.g. {"keyAlgo": "ECC", "keyHash": "SHA384", "teeTechnology": "tdx"}.
+// The key type, key algorithm, format, event log overflow policy and busy retry delay default to
+// their DefaultAttestOptions values, and the result is validated as Attest would validate it.
+func AttestOptionsFromConfig(data []byte) (AttestOptions, error) {
+	var opts AttestOptions
+	if err := json.Unmarshal(data, &opts); err != nil {
+		return AttestOptions{}, fmt.Errorf("invalid attestation config: %w", err)
+	}
+	defaults := DefaultAttestOptions()
+	if opts.Key == "" {
+		opts.Key = defaults.Key
+	}
+	if opts.KeyAlgo == 0 {
+		opts.KeyAlgo = defaults.KeyAlgo
+	}
+	if opts.KeyHash == 0 {
+		opts.KeyHash = defaults.KeyHash
+	}
+	if opts.Format == "" {
+		opts.Format = defaults.Format
+	}
+	if opts.EventLogOverflow == "" {
+		opts.EventLogOverflow = defaults.EventLogOverflow
+	}
+	if opts.BusyRetryDelay == 0 {
+		opts.BusyRetryDelay = defaults.BusyRetryDelay
+	}
+
+	if err := validateAttestationKey(opts.Key, opts.KeyAlgo, opts.KeyHash); err != nil {
+		return AttestOptions{}, fmt.Errorf("invalid attestation config: %w", err)
+	}
+	if opts.EventLogOverflow != EventLogError && opts.EventLogOverflow != EventLogTruncate {
+		return AttestOptions{}, fmt.Errorf("invalid attestation config: eventLogOverflow should be either %s or %s", EventLogError, EventLogTruncate)
+	}
+	if _, err := buildAttestOpts(opts); err != nil {
+		return AttestOptions{}, fmt.Errorf("invalid attestation config: %w", err)
+	}
+	return opts, nil
+}
+
+// algorithmName returns the name of alg in names.
+func algorithmName(names map[string]tpm2.Algorithm, alg tpm2.Algorithm) (string, error) {
+	for name, a := range names {
+		if a == alg {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported algorithm %v", alg)
+}
+
+// parseAlgorithmName returns the algorithm named name in names, ignoring case.
+func parseAlgorithmName(names map[string]tpm2.Algorithm, name string) (tpm2.Algorithm, error) {
+	for n, alg := range names {
+		if strings.EqualFold(n, name) {
+			return alg, nil
+		}
+	}
+	valid := make([]string, 0, len(names))
+	for n := range names {
+		valid = append(valid, n)
+	}
+	sort.Strings(valid)
+	return 0, fmt.Errorf("unknown algorithm %q, valid algorithms are %s", name, strings.Join(valid, ", "))
+}
+
+func encodeCertificates(certs []*x509.Certificate) [][]byte {
+	if len(certs) == 0 {
+		return nil
+	}
+	ders := make([][]byte, len(certs))
+	for i, cert := range certs {
+		ders[i] = cert.Raw
+	}
+	return ders
+}
+
+func decodeCertificates(ders [][]byte) ([]*x509.Certificate, error) {
+	if len(ders) == 0 {
+		return nil, nil
+	}
+	return parseCertificates(ders...)
+}
@@ -0,0 +1,270 @@
+package attestation
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// testCertificate returns a self-signed certificate for key.
+func testCertificate(t *testing.T, key *ecdsa.PrivateKey) *x509.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test root"},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(1<<32, 0),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// testPolicy returns verify options with every policy field set.
+func testPolicy(t *testing.T) VerifyOptions {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := testCertificate(t, key)
+	digest := make([]byte, 32)
+	digest[0] = 1
+	secureBoot := true
+	return VerifyOptions{
+		Format:                      "textproto",
+		Nonce:                       []byte("nonce"),
+		TeeNonce:                    []byte("tee nonce"),
+		AllowUnboundTeeReportData:   true,
+		ExpectedPCRs:                map[uint32][]byte{0: digest},
+		CurrentPCRs:                 map[uint32][]byte{7: digest},
+		ReferenceValues:             &ReferenceValues{PCRs: map[uint32][][]byte{4: {digest}}, MRTD: [][]byte{digest}},
+		DeniedMeasurements:          &ReferenceValues{SevSnpMeasurement: [][]byte{digest}},
+		ExpectedReportDataHash:      digest,
+		RequireBoundEvidence:        true,
+		RequireTEE:                  true,
+		StrictNonce:                 true,
+		AllowedBootEntries:          [][]byte{digest},
+		ExpectedEventSequence:       []ExpectedEvent{{PCR: 4, Digest: digest}},
+		RequireSecureBoot:           &secureBoot,
+		ShieldedVM:                  &ShieldedVMPolicy{VTPM: true, SecureBoot: true},
+		ExpectedAKName:              digest,
+		VerifyGceAKCert:             true,
+		AllowAKCertMismatch:         true,
+		RequireInstanceInfo:         true,
+		AllowedInstances:            []InstanceIdentity{{ProjectID: "project", InstanceName: "instance"}},
+		GceRootCerts:                []*x509.Certificate{cert},
+		GceIntermediateCerts:        []*x509.Certificate{cert},
+		TrustedEKRoots:              []*x509.Certificate{cert},
+		Tdx:                         &TdxPolicy{RequireTCBStatus: []string{"UpToDate"}, MaxCollateralAge: time.Hour},
+		SevSnp:                      &SevSnpPolicy{RequireSMTDisabled: true},
+		AllowSHA1:                   true,
+		RejectSHA1:                  true,
+		ExpectedNVIndices:           map[uint32][]byte{0x1c10000: digest},
+		RIMs:                        []*RIM{{ID: "rim", ReferenceValues: &ReferenceValues{PCRs: map[uint32][][]byte{0: {digest}}}}},
+		IntegrityBaseline:           &IntegrityBaseline{EarlyBoot: map[uint32]HexBytes{0: digest}},
+		Components:                  &ComponentPolicy{Components: map[string][][]HexBytes{ComponentKernel: {{digest}}}},
+		RTMRComponents:              &RTMRComponentPolicy{Components: map[string][]HexBytes{ComponentKernel: {digest}}},
+		TransparencyLog:             &TransparencyLogPolicy{PublisherKeys: []crypto.PublicKey{&key.PublicKey}, PCRs: []uint32{0, 4}},
+		CheckRevocations:            true,
+		VerificationTime:            time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		WorkloadClaimKey:            &key.PublicKey,
+		TolerateEventLogParseErrors: true,
+		AllowMissingEventLog:        true,
+		RejectUnknownFields:         true,
+		PreviousAuditDigest:         digest,
+		CollectAllErrors:            true,
+		MaxAttestationSize:          1 << 20,
+		MaxEventLogEntries:          100,
+		MaxCollateralSize:           1 << 10,
+		MaxVerifyDuration:           time.Minute,
+	}
+}
+
+// checkAllFieldsSet fails for the fields of v that are zero, other than those in except, so that
+// new fields cannot be left out of the test or its round trip.
+func checkAllFieldsSet(t *testing.T, v any, except ...string) {
+	t.Helper()
+	value := reflect.ValueOf(v)
+	for i := range value.NumField() {
+		name := value.Type().Field(i).Name
+		if value.Field(i).IsZero() && !contains(except, name) {
+			t.Errorf("%s.%s is not set", value.Type().Name(), name)
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestVerifierConfigRoundTrip(t *testing.T) {
+	policy := testPolicy(t)
+	config := VerifierConfig{
+		Options:                policy,
+		CollateralTTL:          time.Hour,
+		CollateralRateLimit:    2.5,
+		CollateralBurst:        3,
+		CollateralFetchTimeout: 10 * time.Second,
+		TrustConfigs:           []TrustConfig{{Name: "tdx", Technologies: []string{Tdx}, Options: policy}},
+		ChallengeTTL:           time.Minute,
+		TrustFirstBaseline:     true,
+		BaselinePCRs:           []uint32{0, 4, 7},
+		BaselineResetCount:     true,
+		AsyncWorkers:           4,
+	}
+	// EventLog is supplied by each request, and the other fields are not encoded.
+	checkAllFieldsSet(t, config.Options, "EventLog")
+	checkAllFieldsSet(t, config, "HTTPClient", "CollateralCache", "Baselines")
+
+	encoded, err := json.Marshal(NewVerifier(config).Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded VerifierConfig
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, config) {
+		reencoded, _ := json.Marshal(decoded)
+		t.Errorf("decoded config differs from the original:\n got %s\nwant %s", reencoded, encoded)
+	}
+}
+
+func TestVerifyOptionsJSONDefaults(t *testing.T) {
+	var opts VerifyOptions
+	if err := json.Unmarshal([]byte(`{"nonce":"bm9uY2U="}`), &opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.AllowUnboundTeeReportData {
+		t.Error("options decoded without allowUnboundTeeReportData leave TEE report data unbound")
+	}
+}
+
+func TestAttestOptionsRoundTrip(t *testing.T) {
+	opts := AttestOptions{
+		Key:              KeyAK,
+		KeyAlgo:          tpm2.AlgECC,
+		KeyHash:          tpm2.AlgSHA384,
+		Nonce:            []byte("attest-options-nonce"),
+		TeeTechnology:    Tdx,
+		TeeNonce:         []byte("attest-options-tee-nonce"),
+		Format:           "binarypb",
+		StrictNonce:      true,
+		BusyRetries:      3,
+		BusyRetryDelay:   250 * time.Millisecond,
+		MaxEventLogSize:  1 << 16,
+		EventLogOverflow: EventLogTruncate,
+		NVIndices:        []uint32{0x1c10000, 0x1500000},
+		AttachEKCert:     true,
+		AttachEKPub:      true,
+		CanonicalText:    true,
+		OmitEventLog:     true,
+	}
+	// The provider, workload claim and signer, and TPM are not encoded.
+	checkAllFieldsSet(t, opts, "InstanceInfoProvider", "WorkloadClaim", "WorkloadSigner", "TPM")
+
+	encoded, err := json.Marshal(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded AttestOptions
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, opts) {
+		reencoded, _ := json.Marshal(decoded)
+		t.Errorf("decoded options differ from the original:\n got %s\nwant %s", reencoded, encoded)
+	}
+	// Encoding is stable, so stored configs do not churn.
+	reencoded, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reencoded) != string(encoded) {
+		t.Errorf("re-encoded options differ:\n got %s\nwant %s", reencoded, encoded)
+	}
+
+	fromConfig, err := AttestOptionsFromConfig(encoded)
+	if err != nil {
+		t.Fatalf("AttestOptionsFromConfig() failed: %v", err)
+	}
+	if !reflect.DeepEqual(fromConfig, opts) {
+		t.Errorf("AttestOptionsFromConfig() = %+v, want %+v", fromConfig, opts)
+	}
+}
+
+func TestAttestOptionsFromConfig(t *testing.T) {
+	defaults := DefaultAttestOptions()
+	tests := []struct {
+		name    string
+		config  string
+		want    func(*AttestOptions)
+		wantErr string
+	}{
+		{name: "empty", config: `{}`, want: func(*AttestOptions) {}},
+		{
+			name:   "algorithm names ignore case",
+			config: `{"keyAlgo": "ecc", "keyHash": "sha512", "teeTechnology": "sev-snp"}`,
+			want: func(o *AttestOptions) {
+				o.KeyAlgo, o.KeyHash, o.TeeTechnology = tpm2.AlgECC, tpm2.AlgSHA512, SevSnp
+			},
+		},
+		{
+			name:   "durations",
+			config: `{"busyRetries": 2, "busyRetryDelay": "1s"}`,
+			want:   func(o *AttestOptions) { o.BusyRetries, o.BusyRetryDelay = 2, time.Second },
+		},
+		{name: "unknown key algorithm", config: `{"keyAlgo": "DSA"}`, wantErr: `invalid keyAlgo: unknown algorithm "DSA", valid algorithms are ECC, RSA`},
+		{name: "unknown key hash", config: `{"keyHash": "SHA1"}`, wantErr: "valid algorithms are SHA256, SHA384, SHA512"},
+		{name: "key hash of the GCE AK", config: `{"key": "gceAK", "keyHash": "SHA384"}`, wantErr: "gceAK only supports SHA256"},
+		{name: "unknown key type", config: `{"key": "EK"}`, wantErr: "invalid attestation config"},
+		{name: "overflow policy", config: `{"eventLogOverflow": "drop"}`, wantErr: "eventLogOverflow should be either error or truncate"},
+		{name: "retry delay", config: `{"busyRetryDelay": "soon"}`, wantErr: "invalid busyRetryDelay"},
+		{name: "format", config: `{"format": "json"}`, wantErr: "format should be either binarypb or textproto"},
+		{name: "NV indices in textproto", config: `{"format": "textproto", "nvIndices": [1]}`, wantErr: "NV indices require the binarypb format"},
+		{name: "TEE nonce without technology", config: `{"teeNonce": "bm9uY2U="}`, wantErr: "requires specifying TEE hardware type"},
+		{name: "malformed", config: `{"keyAlgo": 1}`, wantErr: "invalid attestation config"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := AttestOptionsFromConfig([]byte(tc.config))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("AttestOptionsFromConfig(%s) = %v, want an error containing %q", tc.config, err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AttestOptionsFromConfig(%s) failed: %v", tc.config, err)
+			}
+			want := defaults
+			tc.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("AttestOptionsFromConfig(%s) = %+v, want %+v", tc.config, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package attestation
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+	sv "github.com/google/go-sev-guest/verify"
+	"github.com/google/go-sev-guest/verify/trust"
+	"github.com/google/go-tdx-guest/proto/tdx"
+	tv "github.com/google/go-tdx-guest/verify"
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"github.com/google/go-tpm-tools/server"
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// VerifyInternalConsistency checks that an attestation report is consistent with itself, without
+// trust anchors, expected values or network access: the quotes are signed by the embedded AK over
+// a common nonce and match the event log, an embedded AK certificate certifies the AK, and the TEE
+// attestation is signed by a key that chains to the root certificate it carries. A report that
+// passes may still fail VerifyAttestation, but a report that fails is corrupted or forged.
+func VerifyInternalConsistency(attestationBytes []byte, format string) error {
+	attestation, err := unmarshalAttestation(attestationBytes, format)
+	if err != nil {
+		return err
+	}
+
+	pub, err := tpm2.DecodePublic(attestation.GetAkPub())
+	if err != nil {
+		return err
+	}
+	if err := validateAKPublic(pub); err != nil {
+		return err
+	}
+	if err := checkQuoteSchemes(attestation, pub); err != nil {
+		return fmt.Errorf("verifying TPM attestation: %w", err)
+	}
+	akPub, err := pub.Key()
+	if err != nil {
+		return err
+	}
+	if len(attestation.GetAkCert()) != 0 {
+		if _, err := checkGceAKCert(attestation, akPub); err != nil {
+			return err
+		}
+	}
+
+	if _, err := VerifyQuoteSignature(attestation, akPub); err != nil {
+		return fmt.Errorf("verifying TPM attestation: %w", err)
+	}
+
+	nonce, err := quotedNonce(attestation)
+	if err != nil {
+		return err
+	}
+	verifyOpts := server.VerifyOpts{Nonce: nonce, TrustedAKs: []crypto.PublicKey{akPub}, AllowSHA1: true}
+	tpmAttestation := attestation
+	if isPSSAK(pub) {
+		if tpmAttestation, verifyOpts, err = resignPSSQuotes(attestation, verifyOpts); err != nil {
+			return fmt.Errorf("verifying TPM attestation: %w", err)
+		}
+	}
+	if _, err := server.VerifyAttestation(tpmAttestation, verifyOpts); err != nil {
+		return fmt.Errorf("verifying TPM attestation: %w", err)
+	}
+
+	switch tee := attestation.GetTeeAttestation().(type) {
+	case *pb.Attestation_TdxAttestation:
+		err = checkTdxConsistency(tee.TdxAttestation)
+	case *pb.Attestation_SevSnpAttestation:
+		err = checkSevSnpConsistency(tee.SevSnpAttestation)
+	}
+	if err != nil {
+		return fmt.Errorf("verifying TEE attestation: %w", err)
+	}
+	return nil
+}
+
+// quotedNonce returns the nonce signed by the first quote of attestation.
+func quotedNonce(attestation *pb.Attestation) ([]byte, error) {
+	quotes := attestation.GetQuotes()
+	if len(quotes) == 0 {
+		return nil, fmt.Errorf("attestation does not contain any quotes")
+	}
+	data, err := tpm2.DecodeAttestationData(quotes[0].GetQuote())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode quote: %v", err)
+	}
+	return data.ExtraData, nil
+}
+
+// checkTdxConsistency verifies quote against the root of its own PCK certificate chain.
+func checkTdxConsistency(quote *tdx.QuoteV4) error {
+	chain, err := pckCertificateChain(quote)
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(chain[len(chain)-1])
+	opts := tv.DefaultOptions()
+	opts.TrustedRoots = roots
+	return tv.TdxQuote(quote, opts)
+}
+
+// checkSevSnpConsistency verifies attestation against the ASK and ARK in its own certificate
+// chain. Missing certificates are not fetched.
+func checkSevSnpConsistency(attestation *spb.Attestation) error {
+	chain := attestation.GetCertificateChain()
+	if len(chain.GetAskCert()) == 0 || len(chain.GetArkCert()) == 0 {
+		return fmt.Errorf("attestation does not contain the ASK and ARK certificates")
+	}
+	certs := &trust.ProductCerts{}
+	if err := certs.Decode(chain.GetAskCert(), chain.GetArkCert()); err != nil {
+		return fmt.Errorf("failed to parse ASK and ARK certificates: %v", err)
+	}
+	// Trusted roots are taken as given, so check that the ARK signs itself and the ASK.
+	if err := certs.Ark.CheckSignatureFrom(certs.Ark); err != nil {
+		return fmt.Errorf("ARK certificate is not self-signed: %v", err)
+	}
+	if err := certs.Ask.CheckSignatureFrom(certs.Ark); err != nil {
+		return fmt.Errorf("ASK certificate is not signed by the ARK: %v", err)
+	}
+
+	roots := make(map[string][]*trust.AMDRootCerts)
+	for productLine := range trust.DefaultRootCerts {
+		root := trust.AMDRootCertsProduct(productLine)
+		root.ProductCerts = certs
+		roots[productLine] = []*trust.AMDRootCerts{root}
+	}
+	return sv.SnpAttestation(attestation, &sv.Options{DisableCertFetching: true, TrustedRoots: roots})
+}
@@ -0,0 +1,71 @@
+package attestation
+
+import (
+	"strings"
+
+	sabi "github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+	"github.com/google/go-tdx-guest/pcs"
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// CPUInfo describes the CPU of an attested host as its TEE attestation reports it, to correlate
+// hosts with CPU errata and microcode advisories
+type CPUInfo struct {
+	// Vendor is the CPU vendor, AMD or Intel
+	Vendor string
+	// Product is the AMD product line, e.g. Milan or Genoa. Not set for Intel.
+	Product string
+	// Family, Model and Stepping are the CPUID family, model and stepping, which SEV-SNP reports
+	// carry from version 3. Zero for older reports and for Intel, whose quotes do not carry them.
+	Family   uint8
+	Model    uint8
+	Stepping uint8
+	// FMSPC is the family, model, stepping and platform code of the PCK certificate, as a hex
+	// string. Only set for Intel.
+	FMSPC string
+	// MicrocodeSVN is the security version of the microcode: the microcode SPL of the reported TCB
+	// for AMD, and the SGX late microcode update SVN of the PCK certificate for Intel
+	MicrocodeSVN uint8
+	// EarlyMicrocodeSVN is the early microcode update SVN of the PCK certificate. Only set for
+	// Intel.
+	EarlyMicrocodeSVN uint8
+}
+
+// cpuInfo returns the CPU information of the verified TEE attestation of attestation, or nil if it
+// has none.
+func cpuInfo(attestation *pb.Attestation) *CPUInfo {
+	if snp := attestation.GetSevSnpAttestation(); snp != nil {
+		report := snp.GetReport()
+		info := &CPUInfo{
+			Vendor:       "AMD",
+			MicrocodeSVN: kds.DecomposeTCBVersion(kds.TCBVersion(report.GetReportedTcb())).UcodeSpl,
+		}
+		if fms := report.GetCpuid1EaxFms(); fms != 0 {
+			info.Family, info.Model, info.Stepping = sabi.FmsFromCpuid1Eax(fms)
+			info.Product = kds.ProductLineFromFms(fms)
+		} else if snp.GetProduct() != nil {
+			info.Product = kds.ProductLine(snp.GetProduct())
+		}
+		return info
+	}
+	if quote := attestation.GetTdxAttestation(); quote != nil {
+		info := &CPUInfo{Vendor: "Intel"}
+		chain, err := pckCertificateChain(quote)
+		if err != nil || len(chain) == 0 {
+			return info
+		}
+		exts, err := pcs.PckCertificateExtensions(chain[0])
+		if err != nil {
+			return info
+		}
+		info.FMSPC = strings.ToLower(exts.FMSPC)
+		// Components 0 and 1 of the SGX TCB are the early and late microcode updates.
+		if components := exts.TCB.CPUSvnComponents; len(components) >= 2 {
+			info.EarlyMicrocodeSVN = components[0]
+			info.MicrocodeSVN = components[1]
+		}
+		return info
+	}
+	return nil
+}
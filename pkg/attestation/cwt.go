@@ -0,0 +1,193 @@
+package attestation
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CWT claim keys of ToCWT. The standard keys are those of RFC 8392 and the Entity Attestation
+// Token (RFC 9711); the negative keys are private-use claims of this package. The mapping is
+// stable: claims may be added, but existing keys keep their meaning.
+const (
+	// CWTClaimIssuedAt (iat) is when verification completed, in seconds since the Unix epoch
+	CWTClaimIssuedAt = 6
+	// CWTClaimNonce (eat_nonce) is the nonce signed by the TPM quotes
+	CWTClaimNonce = 10
+	// CWTClaimHardwareModel (hwmodel) identifies the host CPU, e.g. "AMD Genoa" or
+	// "Intel 00806f050000" with the FMSPC of the PCK certificate
+	CWTClaimHardwareModel = 259
+	// CWTClaimProfile (eat_profile) is CWTProfile
+	CWTClaimProfile = 265
+	// CWTClaimMeasurements (measurements) holds a single application/cbor measurement: a map from
+	// measurement names, e.g. "PCR 0", "MRTD", "RTMR 0" or "MEASUREMENT", to digests
+	CWTClaimMeasurements = 273
+	// CWTClaimTechnology is the verified TEE technology (sev-snp or tdx), omitted without a TEE
+	CWTClaimTechnology = -65537
+	// CWTClaimTCBStatus is the resolved TDX TCB status, when the policy required one
+	CWTClaimTCBStatus = -65538
+)
+
+// CWTProfile is the eat_profile of the tokens of ToCWT, which identifies the claim mapping above.
+const CWTProfile = "tag:lunal.dev,2025:attestation-go/cwt/v1"
+
+// cwtContentFormatCBOR is the CoAP content format of application/cbor, which the measurements
+// claim uses for its single measurement.
+const cwtContentFormatCBOR = 60
+
+// ToCWT encodes the verified claims of report as a CBOR Web Token, signed with signer as a tagged
+// COSE_Sign1 structure, for relying parties that consume RATS attestation results rather than
+// machine states. ECDSA keys sign with ES256, ES384 or ES512 by curve, RSA keys with PS256 and
+// Ed25519 keys with EdDSA.
+func ToCWT(report *VerificationReport, signer crypto.Signer) ([]byte, error) {
+	if report == nil || report.MachineState == nil {
+		return nil, fmt.Errorf("no verified report to encode")
+	}
+	encMode, err := cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		return nil, err
+	}
+	claims, err := cwtClaims(encMode, report)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := encMode.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode CWT claims: %v", err)
+	}
+	return signCOSE(encMode, signer, payload)
+}
+
+// cwtClaims maps report to the claims of ToCWT.
+func cwtClaims(encMode cbor.EncMode, report *VerificationReport) (map[int]any, error) {
+	claims := map[int]any{CWTClaimProfile: CWTProfile}
+	if !report.VerifiedAt.IsZero() {
+		claims[CWTClaimIssuedAt] = report.VerifiedAt.Unix()
+	}
+	// The quotes verified, so the nonce can be decoded.
+	if nonce, err := quotedNonce(report.Attestation); err == nil {
+		claims[CWTClaimNonce] = nonce
+	}
+	if cpu := report.CPU; cpu != nil {
+		model := cpu.Vendor
+		if cpu.Product != "" {
+			model += " " + cpu.Product
+		} else if cpu.FMSPC != "" {
+			model += " " + cpu.FMSPC
+		}
+		claims[CWTClaimHardwareModel] = []byte(model)
+	}
+	if report.Technology != "" {
+		claims[CWTClaimTechnology] = report.Technology
+	}
+	if report.Tdx != nil && report.Tdx.TCBStatus != "" {
+		claims[CWTClaimTCBStatus] = report.Tdx.TCBStatus
+	}
+
+	measurements, err := cwtMeasurements(report)
+	if err != nil {
+		return nil, err
+	}
+	if len(measurements) != 0 {
+		encoded, err := encMode.Marshal(measurements)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode measurements: %v", err)
+		}
+		claims[CWTClaimMeasurements] = []any{[]any{cwtContentFormatCBOR, encoded}}
+	}
+	return claims, nil
+}
+
+// cwtMeasurements names the verified PCRs and TEE measurements of report.
+func cwtMeasurements(report *VerificationReport) (map[string][]byte, error) {
+	measurements := make(map[string][]byte)
+	pcrs, err := verifiedPCRs(report.Attestation, report.MachineState)
+	if err != nil {
+		return nil, err
+	}
+	for index, digest := range pcrs {
+		measurements[fmt.Sprintf("PCR %d", index)] = digest
+	}
+	if body := report.MachineState.GetTdxAttestation().GetTdQuoteBody(); body != nil {
+		measurements["MRTD"] = body.GetMrTd()
+		for i, rtmr := range body.GetRtmrs() {
+			measurements[fmt.Sprintf("RTMR %d", i)] = rtmr
+		}
+	}
+	if snp := report.MachineState.GetSevSnpAttestation().GetReport(); snp != nil {
+		measurements["MEASUREMENT"] = snp.GetMeasurement()
+	}
+	return measurements, nil
+}
+
+// signCOSE signs payload with signer as a COSE_Sign1 structure tagged 18, with the algorithm in
+// the protected header.
+func signCOSE(encMode cbor.EncMode, signer crypto.Signer, payload []byte) ([]byte, error) {
+	var alg int64
+	var hash crypto.Hash
+	var opts crypto.SignerOpts
+	switch key := signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		switch key.Curve.Params().BitSize {
+		case 256:
+			alg, hash = coseAlgES256, crypto.SHA256
+		case 384:
+			alg, hash = coseAlgES384, crypto.SHA384
+		case 521:
+			alg, hash = coseAlgES512, crypto.SHA512
+		default:
+			return nil, fmt.Errorf("unsupported ECDSA curve %s", key.Curve.Params().Name)
+		}
+		opts = hash
+	case *rsa.PublicKey:
+		alg, hash = coseAlgPS256, crypto.SHA256
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	case ed25519.PublicKey:
+		alg, opts = coseAlgEdDSA, crypto.Hash(0)
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", key)
+	}
+
+	protected, err := encMode.Marshal(map[int]int64{coseHeaderAlg: alg})
+	if err != nil {
+		return nil, err
+	}
+	toBeSigned, err := encMode.Marshal([]any{"Signature1", protected, []byte{}, payload})
+	if err != nil {
+		return nil, err
+	}
+	message := toBeSigned
+	if hash != 0 {
+		h := hash.New()
+		h.Write(toBeSigned)
+		message = h.Sum(nil)
+	}
+	signature, err := signer.Sign(rand.Reader, message, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign CWT: %v", err)
+	}
+	if key, ok := signer.Public().(*ecdsa.PublicKey); ok {
+		// COSE encodes ECDSA signatures as r and s, each the size of the curve order, rather than
+		// the ASN.1 that crypto.Signer returns.
+		var parsed struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(signature, &parsed); err != nil {
+			return nil, fmt.Errorf("malformed ECDSA signature: %v", err)
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		parsed.R.FillBytes(signature[:size])
+		parsed.S.FillBytes(signature[size:])
+	}
+
+	return encMode.Marshal(cbor.Tag{
+		Number:  corimSignedTag,
+		Content: []any{protected, map[int]any{}, payload, signature},
+	})
+}
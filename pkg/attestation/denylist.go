@@ -0,0 +1,53 @@
+package attestation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// ErrDeniedMeasurement is returned when a measurement of an attestation matches
+// VerifyOptions.DeniedMeasurements.
+var ErrDeniedMeasurement = errors.New("measurement is denied")
+
+// checkDeniedMeasurements fails with ErrDeniedMeasurement, naming every match, if a measurement of
+// a verified attestation is one of the digests denied for it. Measurements the attestation does
+// not carry, such as TDX registers of a SEV-SNP attestation, cannot match.
+func checkDeniedMeasurements(denied *ReferenceValues, attestation *pb.Attestation, ms *pb.MachineState) error {
+	var matches []string
+	if len(denied.PCRs) != 0 {
+		pcrs, err := verifiedPCRs(attestation, ms)
+		if err != nil {
+			return err
+		}
+		for _, index := range sortedIndices(denied.PCRs) {
+			if got, ok := pcrs[index]; ok && containsDigest(denied.PCRs[index], got) {
+				matches = append(matches, fmt.Sprintf("PCR %d is %x", index, got))
+			}
+		}
+	}
+
+	if body := ms.GetTdxAttestation().GetTdQuoteBody(); body != nil {
+		if containsDigest(denied.MRTD, body.GetMrTd()) {
+			matches = append(matches, fmt.Sprintf("MRTD is %x", body.GetMrTd()))
+		}
+		for _, index := range sortedIndices(denied.RTMRs) {
+			if int(index) < len(body.GetRtmrs()) && containsDigest(denied.RTMRs[index], body.GetRtmrs()[index]) {
+				matches = append(matches, fmt.Sprintf("RTMR %d is %x", index, body.GetRtmrs()[index]))
+			}
+		}
+	}
+
+	if report := ms.GetSevSnpAttestation().GetReport(); report != nil {
+		if containsDigest(denied.SevSnpMeasurement, report.GetMeasurement()) {
+			matches = append(matches, fmt.Sprintf("MEASUREMENT is %x", report.GetMeasurement()))
+		}
+	}
+
+	if len(matches) != 0 {
+		return fmt.Errorf("%w: %s", ErrDeniedMeasurement, strings.Join(matches, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package attestation
+
+import (
+	"fmt"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Field numbers of the TEE attestation oneof of pb.Attestation.
+var (
+	sevSnpAttestationField = attestationFieldNumber("sev_snp_attestation")
+	tdxAttestationField    = attestationFieldNumber("tdx_attestation")
+)
+
+func attestationFieldNumber(name protoreflect.Name) protowire.Number {
+	return (&pb.Attestation{}).ProtoReflect().Descriptor().Fields().ByName(name).Number()
+}
+
+// HasTEEAttestation reports whether an attestation report carries a TEE attestation, and the
+// TEE technology (sev-snp or tdx) if it does. Binary reports are only scanned for their top-level
+// fields rather than fully unmarshaled, and nothing is verified.
+func HasTEEAttestation(data []byte, format string) (bool, string, error) {
+	switch format {
+	case "binarypb":
+		attestation, _, err := unwrapEnvelope(data)
+		if err != nil {
+			return false, "", fmt.Errorf("fail to parse attestation report: %v", err)
+		}
+		technology, err := scanTEETechnology(attestation)
+		if err != nil {
+			return false, "", fmt.Errorf("fail to parse attestation report: %v", err)
+		}
+		return technology != "", technology, nil
+	case "textproto":
+		attestation := &pb.Attestation{}
+		if err := unmarshalOptions.Unmarshal(data, attestation); err != nil {
+			return false, "", fmt.Errorf("fail to unmarshal attestation report: %v", err)
+		}
+		technology := teeTechnology(attestation)
+		return technology != "", technology, nil
+	default:
+		return false, "", fmt.Errorf("format should be either binarypb or textproto")
+	}
+}
+
+// scanTEETechnology walks the top-level fields of a binary attestation. As with any oneof, the
+// last TEE attestation field present wins.
+func scanTEETechnology(data []byte) (string, error) {
+	technology := ""
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return "", protowire.ParseError(n)
+		}
+		data = data[n:]
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			return "", protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if typ != protowire.BytesType {
+			continue
+		}
+		switch num {
+		case sevSnpAttestationField:
+			technology = SevSnp
+		case tdxAttestationField:
+			technology = Tdx
+		}
+	}
+	return technology, nil
+}
+
+// checkAttestationLayout checks the wire types of the top-level fields of a binary attestation.
+// Attesters built on go-tpm-tools releases since v0.3 share the layout of the version this package
+// is built with and only differ in which fields they populate, which verification handles. An
+// incompatible attester is caught here, as protobuf would otherwise silently drop a known field
+// with an unexpected wire type, such as a TEE attestation. Unknown fields are left to unmarshaling.
+func checkAttestationLayout(data []byte) error {
+	fields := (&pb.Attestation{}).ProtoReflect().Descriptor().Fields()
+	var oneof protoreflect.FieldDescriptor
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		field := fields.ByNumber(num)
+		if field == nil {
+			continue
+		}
+		if !wireTypeMatches(field, typ) {
+			return fmt.Errorf("attestation field %s (%d) has wire type %d: the report was produced by an incompatible attester", field.Name(), num, typ)
+		}
+		if field.ContainingOneof() != nil {
+			if oneof != nil && oneof.Number() != num {
+				return fmt.Errorf("attestation has both %s and %s: the report was produced by an incompatible attester", oneof.Name(), field.Name())
+			}
+			oneof = field
+		}
+	}
+	return nil
+}
+
+// wireTypeMatches reports whether typ is a valid encoding of field.
+func wireTypeMatches(field protoreflect.FieldDescriptor, typ protowire.Type) bool {
+	var want protowire.Type
+	switch field.Kind() {
+	case protoreflect.MessageKind, protoreflect.BytesKind, protoreflect.StringKind:
+		return typ == protowire.BytesType
+	case protoreflect.GroupKind:
+		return typ == protowire.StartGroupType
+	case protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind, protoreflect.FloatKind:
+		want = protowire.Fixed32Type
+	case protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind, protoreflect.DoubleKind:
+		want = protowire.Fixed64Type
+	default:
+		want = protowire.VarintType
+	}
+	// Repeated scalars may be packed.
+	return typ == want || (field.IsList() && typ == protowire.BytesType)
+}
@@ -0,0 +1,93 @@
+package attestation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// olderAttesterFixtures are reports of attesters built on older go-tpm-tools releases, keyed by
+// release. Each was produced on the simulated TPM with seed 1 by the release's client package:
+// the AK of AttestationKeyRSA and quotes of the SHA-1 and SHA-256 banks over the nonce
+// "go-tpm-tools-<release>-nonce". The simulator has no event log to attach. testdata/README.md
+// describes how to regenerate them with the generators under testdata/gen.
+var olderAttesterFixtures = []string{"v0.3.11", "v0.4.1"}
+
+func readOlderAttesterFixture(t *testing.T, release string) ([]byte, []byte) {
+	t.Helper()
+	report, err := os.ReadFile(filepath.Join("testdata", "go-tpm-tools-"+release+".binarypb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report, []byte("go-tpm-tools-" + release + "-nonce")
+}
+
+func TestCheckAttestationLayout(t *testing.T) {
+	akPubField := attestationFieldNumber("ak_pub")
+	teeAttestation := func(num protowire.Number) []byte {
+		return protowire.AppendBytes(protowire.AppendTag(nil, num, protowire.BytesType), nil)
+	}
+	current := attestWithSimulator(t, DefaultAttestOptions(), []byte("attestation-layout-nonce"))
+
+	type testCase struct {
+		name    string
+		data    []byte
+		wantErr string
+	}
+	tests := []testCase{
+		{name: "current", data: current},
+		{name: "empty", data: nil},
+		{name: "unknown field", data: protowire.AppendVarint(protowire.AppendTag(current, 99, protowire.VarintType), 1)},
+		{name: "single TEE attestation", data: append(teeAttestation(sevSnpAttestationField), teeAttestation(sevSnpAttestationField)...)},
+		{
+			name:    "ak_pub as varint",
+			data:    protowire.AppendVarint(protowire.AppendTag(nil, akPubField, protowire.VarintType), 1),
+			wantErr: "attestation field ak_pub (1) has wire type 0",
+		},
+		{
+			name:    "both TEE attestations",
+			data:    append(teeAttestation(sevSnpAttestationField), teeAttestation(tdxAttestationField)...),
+			wantErr: "attestation has both sev_snp_attestation and tdx_attestation",
+		},
+		{name: "truncated", data: current[:len(current)-1], wantErr: "unexpected EOF"},
+	}
+	for _, release := range olderAttesterFixtures {
+		report, _ := readOlderAttesterFixture(t, release)
+		tests = append(tests, testCase{name: "go-tpm-tools " + release, data: report})
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkAttestationLayout(tc.data)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkAttestationLayout() failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("checkAttestationLayout() = %v, want an error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyOlderAttesters(t *testing.T) {
+	for _, release := range olderAttesterFixtures {
+		t.Run(release, func(t *testing.T) {
+			report, nonce := readOlderAttesterFixture(t, release)
+			verified, err := VerifyAttestationContext(t.Context(), report, simulatorVerifyOptions(nonce))
+			if err != nil {
+				t.Fatalf("VerifyAttestationContext() failed: %v", err)
+			}
+			if len(verified.UnknownFields) != 0 {
+				t.Errorf("UnknownFields = %v, want none", verified.UnknownFields)
+			}
+			if _, err := VerifyAttestationContext(t.Context(), report, simulatorVerifyOptions([]byte("another nonce"))); err == nil {
+				t.Error("VerifyAttestationContext() with another nonce succeeded")
+			}
+		})
+	}
+}
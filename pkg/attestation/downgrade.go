@@ -0,0 +1,150 @@
+package attestation
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/google/go-sev-guest/kds"
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+	"github.com/google/go-tdx-guest/proto/tdx"
+)
+
+// DetectDowngrade compares two verified reports of the same host, prev taken before cur, and lists
+// the components of cur that regressed:
+//   - TDX TEE_TCB_SVN components, and SEV-SNP TCB security patch levels, firmware version and guest
+//     SVN that went down
+//   - TPM firmware versions and reset counters that went down, and a TPM clock that went back
+//     without a reset or restart
+//   - TEE measurements that changed. Measurements are digests and have no order, so a change is
+//     reported unless the security version covering it rose with it: SEAMSVN for MRSEAM and the
+//     guest SVN for the SEV-SNP measurement. The TD measurements mrtd and rtmr0 to rtmr2 have no
+//     security version and are reported whenever they change.
+//
+// The reports must have the same AK, as BaselineHostID checks, and the same TEE technology, and
+// carry the QuoteClock that verification reports.
+func DetectDowngrade(prev, cur *VerificationReport) ([]string, error) {
+	if prev == nil || cur == nil {
+		return nil, fmt.Errorf("both reports are required")
+	}
+	if prevHost, curHost := BaselineHostID(prev), BaselineHostID(cur); prevHost != curHost {
+		return nil, fmt.Errorf("reports are from different hosts: %s and %s", prevHost, curHost)
+	}
+	if prev.Technology != cur.Technology {
+		return nil, fmt.Errorf("TEE technology changed from %q to %q", prev.Technology, cur.Technology)
+	}
+	if prev.QuoteClock == nil || cur.QuoteClock == nil {
+		return nil, fmt.Errorf("reports do not both carry the TPM clock of their quote")
+	}
+
+	var regressed []string
+	if p, c := prev.MachineState.GetTdxAttestation(), cur.MachineState.GetTdxAttestation(); p != nil && c != nil {
+		regressed = append(regressed, tdxDowngrade(p, c)...)
+	}
+	if p, c := prev.MachineState.GetSevSnpAttestation().GetReport(), cur.MachineState.GetSevSnpAttestation().GetReport(); p != nil && c != nil {
+		regressed = append(regressed, sevSnpDowngrade(p, c)...)
+	}
+	regressed = append(regressed, quoteClockDowngrade(prev.QuoteClock, cur.QuoteClock)...)
+	sort.Strings(regressed)
+	return regressed, nil
+}
+
+// tdxDowngrade lists the TDX security versions and measurements of cur that regressed from prev.
+func tdxDowngrade(prev, cur *tdx.QuoteV4) []string {
+	var regressed []string
+	prevBody, curBody := prev.GetTdQuoteBody(), cur.GetTdQuoteBody()
+	prevSvn, curSvn := prevBody.GetTeeTcbSvn(), curBody.GetTeeTcbSvn()
+	for i := 0; i < len(prevSvn) && i < len(curSvn); i++ {
+		if curSvn[i] < prevSvn[i] {
+			regressed = append(regressed, fmt.Sprintf("TDX TEE_TCB_SVN component %d is %d, previously %d", i, curSvn[i], prevSvn[i]))
+		}
+	}
+	seamSvnRose := len(prevSvn) > 0 && len(curSvn) > 0 && curSvn[0] > prevSvn[0]
+	if !seamSvnRose && !bytes.Equal(prevBody.GetMrSeam(), curBody.GetMrSeam()) {
+		regressed = append(regressed, "MRSEAM changed without a TDX module SVN increase")
+	}
+	regressed = append(regressed, measurementChanges(prevBody.GetMrTd(), curBody.GetMrTd(), "mrtd")...)
+	prevRtmrs, curRtmrs := prevBody.GetRtmrs(), curBody.GetRtmrs()
+	// RTMR3 is extended by the running workload.
+	for i := 0; i < 3 && i < len(prevRtmrs) && i < len(curRtmrs); i++ {
+		regressed = append(regressed, measurementChanges(prevRtmrs[i], curRtmrs[i], fmt.Sprintf("rtmr%d", i))...)
+	}
+	return regressed
+}
+
+// sevSnpDowngrade lists the SEV-SNP security versions and measurement of cur that regressed from
+// prev.
+func sevSnpDowngrade(prev, cur *spb.Report) []string {
+	var regressed []string
+	tcbs := []struct {
+		name      string
+		prev, cur uint64
+	}{
+		{"current", prev.GetCurrentTcb(), cur.GetCurrentTcb()},
+		{"reported", prev.GetReportedTcb(), cur.GetReportedTcb()},
+		{"committed", prev.GetCommittedTcb(), cur.GetCommittedTcb()},
+		{"launch", prev.GetLaunchTcb(), cur.GetLaunchTcb()},
+	}
+	for _, tcb := range tcbs {
+		p, c := kds.DecomposeTCBVersion(kds.TCBVersion(tcb.prev)), kds.DecomposeTCBVersion(kds.TCBVersion(tcb.cur))
+		parts := []struct {
+			name      string
+			prev, cur uint8
+		}{
+			{"bootloader", p.BlSpl, c.BlSpl},
+			{"TEE", p.TeeSpl, c.TeeSpl},
+			{"SNP", p.SnpSpl, c.SnpSpl},
+			{"microcode", p.UcodeSpl, c.UcodeSpl},
+		}
+		for _, part := range parts {
+			if part.cur < part.prev {
+				regressed = append(regressed, fmt.Sprintf("SEV-SNP %s TCB %s SPL is %d, previously %d", tcb.name, part.name, part.cur, part.prev))
+			}
+		}
+	}
+
+	prevFirmware := [3]uint32{prev.GetCommittedMajor(), prev.GetCommittedMinor(), prev.GetCommittedBuild()}
+	curFirmware := [3]uint32{cur.GetCommittedMajor(), cur.GetCommittedMinor(), cur.GetCommittedBuild()}
+	for i := range curFirmware {
+		if curFirmware[i] != prevFirmware[i] {
+			if curFirmware[i] < prevFirmware[i] {
+				regressed = append(regressed, fmt.Sprintf("SEV-SNP committed firmware is %d.%d.%d, previously %d.%d.%d",
+					curFirmware[0], curFirmware[1], curFirmware[2], prevFirmware[0], prevFirmware[1], prevFirmware[2]))
+			}
+			break
+		}
+	}
+
+	if cur.GetGuestSvn() < prev.GetGuestSvn() {
+		regressed = append(regressed, fmt.Sprintf("SEV-SNP guest SVN is %d, previously %d", cur.GetGuestSvn(), prev.GetGuestSvn()))
+	}
+	if cur.GetGuestSvn() <= prev.GetGuestSvn() && !bytes.Equal(prev.GetMeasurement(), cur.GetMeasurement()) {
+		regressed = append(regressed, "measurement changed without a guest SVN increase")
+	}
+	return regressed
+}
+
+// quoteClockDowngrade lists the TPM counters of cur that went back from prev.
+func quoteClockDowngrade(prev, cur *QuoteClockInfo) []string {
+	var regressed []string
+	if cur.FirmwareVersion < prev.FirmwareVersion {
+		regressed = append(regressed, fmt.Sprintf("TPM firmware version is %#x, previously %#x", cur.FirmwareVersion, prev.FirmwareVersion))
+	}
+	switch {
+	case cur.ResetCount < prev.ResetCount:
+		regressed = append(regressed, fmt.Sprintf("TPM reset count is %d, previously %d", cur.ResetCount, prev.ResetCount))
+	case cur.ResetCount == prev.ResetCount && cur.RestartCount < prev.RestartCount:
+		regressed = append(regressed, fmt.Sprintf("TPM restart count is %d, previously %d", cur.RestartCount, prev.RestartCount))
+	case cur.ResetCount == prev.ResetCount && cur.RestartCount == prev.RestartCount && cur.Clock < prev.Clock:
+		regressed = append(regressed, fmt.Sprintf("TPM clock is %d ms, previously %d ms", cur.Clock, prev.Clock))
+	}
+	return regressed
+}
+
+// measurementChanges reports name if the measurement changed from prev to cur.
+func measurementChanges(prev, cur []byte, name string) []string {
+	if bytes.Equal(prev, cur) {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s changed from %x to %x", name, prev, cur)}
+}
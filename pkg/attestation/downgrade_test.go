@@ -0,0 +1,36 @@
+package attestation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectDowngradeQuoteClock(t *testing.T) {
+	sim := newTestSimulator(t)
+	defer sim.Close()
+	opts := DefaultAttestOptions()
+	opts.TPM = sim
+	verify := func(nonce []byte) *VerificationReport {
+		t.Helper()
+		report, err := VerifyAttestationContext(t.Context(), attestWithSimulator(t, opts, nonce), simulatorVerifyOptions(nonce))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.QuoteClock == nil {
+			t.Fatal("QuoteClock is nil, want the clock of the verified quote")
+		}
+		return report
+	}
+	prev := verify([]byte("downgrade-previous-nonce"))
+	cur := verify([]byte("downgrade-current-nonce"))
+
+	if regressed, err := DetectDowngrade(prev, cur); err != nil || len(regressed) != 0 {
+		t.Errorf("DetectDowngrade() = %v, %v, want no regressions", regressed, err)
+	}
+
+	// Without the clock of one report, the TPM is not compared rather than silently passing.
+	cur.QuoteClock = nil
+	if _, err := DetectDowngrade(prev, cur); err == nil || !strings.Contains(err.Error(), "TPM clock") {
+		t.Errorf("DetectDowngrade() without a quote clock = %v, want an error", err)
+	}
+}
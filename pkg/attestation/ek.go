@@ -0,0 +1,400 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/go-tpm-tools/client"
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/legacy/tpm2/credactivation"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// EKCertificateField is the field number that carries the DER EK certificate of the TPM in the
+// attestation envelope of a binary report.
+const EKCertificateField protowire.Number = 4
+
+// EKPublicField is the field number that carries the encoded TPMT_PUBLIC of the TPM's EK in the
+// attestation envelope of a binary report.
+const EKPublicField protowire.Number = 5
+
+// Object identifiers of the TPM attributes in the subject alternative name of EK certificates
+var (
+	oidTPMManufacturer = asn1.ObjectIdentifier{2, 23, 133, 2, 1}
+	oidTPMModel        = asn1.ObjectIdentifier{2, 23, 133, 2, 2}
+	oidTPMVersion      = asn1.ObjectIdentifier{2, 23, 133, 2, 3}
+	oidSubjectAltName  = asn1.ObjectIdentifier{2, 5, 29, 17}
+)
+
+// ekCredentialBlockSize is the symmetric block size of credentials made for the default EKs,
+// which use AES-128.
+const ekCredentialBlockSize = 16
+
+// EKChallenge is a credential that only the TPM holding both the challenged EK and AK can
+// activate, with ActivateEKCredential
+type EKChallenge struct {
+	// CredentialBlob is the TPM2B_ID_OBJECT produced by MakeCredential
+	CredentialBlob []byte
+	// EncryptedSecret is the TPM2B_ENCRYPTED_SECRET produced by MakeCredential
+	EncryptedSecret []byte
+	// Expires is when the challenge can no longer be answered
+	Expires time.Time
+}
+
+// EKIdentity identifies the TPM of an attestation by its EK. The EK does not sign the quotes, so
+// unless Certified is set and a credential activation bound the AK to it, it is only the EK the
+// attester claims.
+type EKIdentity struct {
+	// PublicKey is the EK public key, from the attached EK public area or else the EK certificate
+	PublicKey crypto.PublicKey
+	// PublicKeyDigest is the SHA-256 digest of the DER SubjectPublicKeyInfo of PublicKey
+	PublicKeyDigest HexBytes
+	// Certified reports that the EK certificate chained to VerifyOptions.TrustedEKRoots
+	Certified bool
+	// Manufacturer is the TPM manufacturer from a certified EK certificate, e.g. "id:4E544300"
+	Manufacturer string
+	// Model is the TPM model from a certified EK certificate
+	Model string
+	// Version is the TPM firmware version from a certified EK certificate
+	Version string
+}
+
+// endorsementKey returns the EK of the TPM that has a certificate, trying RSA before ECC.
+func endorsementKey(rw io.ReadWriter) (*client.Key, error) {
+	for _, create := range []func(io.ReadWriter) (*client.Key, error){client.EndorsementKeyRSA, client.EndorsementKeyECC} {
+		ek, err := create(rw)
+		if err != nil {
+			continue
+		}
+		if ek.Cert() != nil {
+			return ek, nil
+		}
+		ek.Close()
+	}
+	return nil, fmt.Errorf("TPM does not have an EK with a certificate")
+}
+
+// attachedEndorsementKey returns the EK to attach to an attestation: the EK with a certificate,
+// which certRequired requires, or else the RSA EK.
+func attachedEndorsementKey(rw io.ReadWriter, certRequired bool) (*client.Key, error) {
+	ek, err := endorsementKey(rw)
+	if err == nil || certRequired {
+		return ek, err
+	}
+	ek, err = client.EndorsementKeyRSA(rw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create EK: %v", err)
+	}
+	return ek, nil
+}
+
+// attachEKPublic appends the encoded public area of the EK as the EKPublicField envelope field to
+// additions.
+func attachEKPublic(additions []byte, public tpm2.Public) ([]byte, error) {
+	encoded, err := public.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode EK public area: %v", err)
+	}
+	additions = protowire.AppendTag(additions, EKPublicField, protowire.BytesType)
+	return protowire.AppendBytes(additions, encoded), nil
+}
+
+// GetEKPublic returns the EK public key attached to a binary attestation report, or nil if it has
+// none. The key is not verified.
+func GetEKPublic(attestationBytes []byte) (crypto.PublicKey, error) {
+	value, ok, err := envelopeField(attestationBytes, EKPublicField, protowire.BytesType)
+	if err != nil || !ok {
+		return nil, err
+	}
+	encoded, _ := protowire.ConsumeBytes(value)
+	public, err := tpm2.DecodePublic(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode EK public area: %v", err)
+	}
+	key, err := public.Key()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode EK public key: %v", err)
+	}
+	return key, nil
+}
+
+// ekIdentity returns the EK identity of a binary attestation report, or nil if it carries neither
+// an EK public area nor an EK certificate. certified is the EK certificate verified against the
+// trusted EK roots, if any. An attached public area must match the key of the EK certificate.
+func ekIdentity(attestationBytes []byte, certified *x509.Certificate) (*EKIdentity, error) {
+	key, err := GetEKPublic(attestationBytes)
+	if err != nil {
+		return nil, err
+	}
+	cert := certified
+	if cert == nil {
+		if cert, err = GetEKCertificate(attestationBytes); err != nil {
+			return nil, err
+		}
+	}
+	if cert != nil {
+		if key == nil {
+			key = cert.PublicKey
+		} else if !publicKeysEqual(key, cert.PublicKey) {
+			return nil, fmt.Errorf("EK public area does not match the key of the EK certificate")
+		}
+	}
+	if key == nil {
+		return nil, nil
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode EK public key: %v", err)
+	}
+	digest := sha256.Sum256(der)
+	identity := &EKIdentity{PublicKey: key, PublicKeyDigest: digest[:], Certified: certified != nil}
+	if certified != nil {
+		identity.Manufacturer, identity.Model, identity.Version = tpmAttributes(certified)
+	}
+	return identity, nil
+}
+
+// publicKeysEqual reports whether two public keys are the same.
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// tpmAttributes returns the TPM manufacturer, model and version from the directory name in the
+// subject alternative name of an EK certificate, leaving those it does not hold empty.
+func tpmAttributes(cert *x509.Certificate) (manufacturer, model, version string) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var names []asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &names); err != nil {
+			return
+		}
+		for _, name := range names {
+			// directoryName [4]
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 4 {
+				continue
+			}
+			var rdns pkix.RDNSequence
+			if _, err := asn1.Unmarshal(name.Bytes, &rdns); err != nil {
+				continue
+			}
+			for _, rdn := range rdns {
+				for _, attribute := range rdn {
+					value, _ := attribute.Value.(string)
+					switch {
+					case attribute.Type.Equal(oidTPMManufacturer):
+						manufacturer = value
+					case attribute.Type.Equal(oidTPMModel):
+						model = value
+					case attribute.Type.Equal(oidTPMVersion):
+						version = value
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// attachEKCertificate appends der as the EKCertificateField envelope field to additions.
+func attachEKCertificate(additions []byte, der []byte) []byte {
+	additions = protowire.AppendTag(additions, EKCertificateField, protowire.BytesType)
+	return protowire.AppendBytes(additions, der)
+}
+
+// GetEKCertificate returns the EK certificate attached to a binary attestation report, or nil if it
+// has none. The certificate is not verified.
+func GetEKCertificate(attestationBytes []byte) (*x509.Certificate, error) {
+	value, ok, err := envelopeField(attestationBytes, EKCertificateField, protowire.BytesType)
+	if err != nil || !ok {
+		return nil, err
+	}
+	der, _ := protowire.ConsumeBytes(value)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse EK certificate: %v", err)
+	}
+	return cert, nil
+}
+
+// checkEKCert checks that the EK certificate of the binary attestation report attestationBytes
+// chains to roots, using the intermediate certificates of attestation, unmarshaled from it.
+func checkEKCert(attestationBytes []byte, attestation *pb.Attestation, roots []*x509.Certificate) (*x509.Certificate, error) {
+	cert, err := GetEKCertificate(attestationBytes)
+	if err != nil {
+		return nil, err
+	}
+	if cert == nil {
+		return nil, fmt.Errorf("attestation does not contain an EK certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, root := range roots {
+		opts.Roots.AddCert(root)
+	}
+	for _, der := range attestation.GetIntermediateCerts() {
+		intermediate, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse intermediate certificate: %v", err)
+		}
+		opts.Intermediates.AddCert(intermediate)
+	}
+	// EK certificates mark their TPM subject alternative name critical, which crypto/x509 does not
+	// handle. It only identifies the TPM model.
+	cert.UnhandledCriticalExtensions = nil
+	if _, err := cert.Verify(opts); err != nil {
+		return nil, fmt.Errorf("EK certificate does not chain to a trusted root: %v", err)
+	}
+	return cert, nil
+}
+
+// NewEKChallenge makes a credential for the EK and AK of an attestation report, whose EK
+// certificate must chain to the TrustedEKRoots of the verifier's Options. The attester recovers
+// the credential secret with ActivateEKCredential and attests with it as the nonce, which
+// VerifyEKResponse then checks. The report itself is not verified.
+func (v *Verifier) NewEKChallenge(attestationBytes []byte, format string) (*EKChallenge, error) {
+	if len(v.config.Options.TrustedEKRoots) == 0 {
+		return nil, fmt.Errorf("verifier does not have TrustedEKRoots")
+	}
+	attestation, err := unmarshalAttestation(attestationBytes, format)
+	if err != nil {
+		return nil, err
+	}
+	ekCert, err := checkEKCert(attestationBytes, attestation, v.config.Options.TrustedEKRoots)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := tpm2.DecodePublic(attestation.GetAkPub())
+	if err != nil {
+		return nil, err
+	}
+	name, err := pub.Name()
+	if err != nil {
+		return nil, err
+	}
+	akName, err := name.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := v.challenges.issue(ekCert.Raw, akName)
+	if err != nil {
+		return nil, err
+	}
+	credentialBlob, encryptedSecret, err := credactivation.Generate(name.Digest, ekCert.PublicKey, ekCredentialBlockSize, challenge.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make EK credential: %v", err)
+	}
+	return &EKChallenge{CredentialBlob: credentialBlob, EncryptedSecret: encryptedSecret, Expires: challenge.Expires}, nil
+}
+
+// VerifyEKResponse verifies an attestation report quoted over the secret of an EKChallenge with
+// the verifier's policy, and checks that it carries the challenged EK certificate and is quoted by
+// the challenged AK. This proves the AK is resident in the TPM of that EK. The challenge is used up
+// whether or not verification succeeds.
+func (v *Verifier) VerifyEKResponse(attestationBytes []byte, format string) (*VerificationReport, error) {
+	attestation, err := unmarshalAttestation(attestationBytes, format)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := quotedNonce(attestation)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := v.challenges.consume(nonce)
+	if err != nil {
+		return nil, err
+	}
+	// Anyone can quote the recovered secret, so only the AK the credential was made for answers.
+	pub, err := tpm2.DecodePublic(attestation.GetAkPub())
+	if err != nil {
+		return nil, err
+	}
+	name, err := pub.Name()
+	if err != nil {
+		return nil, err
+	}
+	akName, err := name.Encode()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(akName, entry.akName) {
+		return nil, fmt.Errorf("attestation is not quoted by the AK of the challenge")
+	}
+
+	report, err := v.Verify(context.Background(), VerifyRequest{Attestation: attestationBytes, Format: format, Nonce: nonce})
+	if err != nil {
+		return nil, err
+	}
+	cert, err := GetEKCertificate(attestationBytes)
+	if err != nil {
+		return nil, err
+	}
+	if cert == nil || !bytes.Equal(cert.Raw, entry.ekCert) {
+		return nil, fmt.Errorf("attestation does not carry the EK certificate of the challenge")
+	}
+	report.EKCertificate = cert
+	return report, nil
+}
+
+// ActivateEKCredential recovers the secret of challenge with the EK and the AK selected by
+// opts.Key, opts.KeyAlgo and opts.KeyHash, to be passed as the Nonce of the next attestation.
+func ActivateEKCredential(opts AttestOptions, challenge *EKChallenge) ([]byte, error) {
+	rwc, err := attestationTPM(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer rwc.Close()
+	return activateEKCredential(rwc, opts, challenge)
+}
+
+// activateEKCredential recovers the secret of challenge on an open TPM.
+func activateEKCredential(rwc io.ReadWriter, opts AttestOptions, challenge *EKChallenge) ([]byte, error) {
+	attestationKey, err := createAttestationKey(rwc, opts.Key, opts.KeyAlgo, opts.KeyHash)
+	if err != nil {
+		return nil, err
+	}
+	defer attestationKey.Close()
+	ek, err := endorsementKey(rwc)
+	if err != nil {
+		return nil, err
+	}
+	defer ek.Close()
+
+	session, err := client.NewEKSession(rwc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start EK session: %v", err)
+	}
+	defer session.Close()
+	ekAuth, err := session.Auth()
+	if err != nil {
+		return nil, fmt.Errorf("failed to authorize EK: %v", err)
+	}
+	akAuth := tpm2.AuthCommand{Session: tpm2.HandlePasswordSession, Attributes: tpm2.AttrContinueSession}
+
+	// MakeCredential output includes the TPM2B size prefixes, which ActivateCredential adds itself.
+	if len(challenge.CredentialBlob) < 2 || len(challenge.EncryptedSecret) < 2 {
+		return nil, fmt.Errorf("malformed EK challenge")
+	}
+	secret, err := tpm2.ActivateCredentialUsingAuth(rwc, []tpm2.AuthCommand{akAuth, ekAuth}, attestationKey.Handle(), ek.Handle(), challenge.CredentialBlob[2:], challenge.EncryptedSecret[2:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to activate EK credential: %v", err)
+	}
+	return secret, nil
+}
@@ -0,0 +1,145 @@
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-tpm-tools/client"
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// provisionEKCertificate issues a certificate for the RSA EK of the simulated TPM sim, and writes it
+// to the NV index of RSA EK certificates, as TPM manufacturers do. It returns the issuing root.
+func provisionEKCertificate(t *testing.T, sim io.ReadWriter) *x509.Certificate {
+	t.Helper()
+	ek, err := client.EndorsementKeyRSA(sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ekPub := ek.PublicKey()
+	ek.Close()
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test EK root"},
+		NotBefore:             time.Unix(0, 0),
+		NotAfter:              time.Unix(1<<32, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := x509.ParseCertificate(rootDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ekTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(1<<32, 0),
+		KeyUsage:     x509.KeyUsageKeyEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, ekTemplate, root, ekPub, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := tpmutil.Handle(client.EKCertNVIndexRSA)
+	if err := tpm2.NVDefineSpace(sim, tpm2.HandleOwner, index, "", "", nil, tpm2.AttrOwnerRead|tpm2.AttrOwnerWrite, uint16(len(der))); err != nil {
+		t.Fatal(err)
+	}
+	if err := tpm2.NVWrite(sim, tpm2.HandleOwner, index, "", der, 0); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestEKPublicEnvelope(t *testing.T) {
+	nonce := []byte("ek-public-envelope-nonce")
+	opts := DefaultAttestOptions()
+	opts.AttachEKPub = true
+	report := attestWithSimulator(t, opts, nonce)
+
+	key, err := GetEKPublic(report)
+	if err != nil || key == nil {
+		t.Fatalf("GetEKPublic() = %v, %v, want the EK public key", key, err)
+	}
+	verified, err := VerifyAttestationContext(t.Context(), report, simulatorVerifyOptions(nonce))
+	if err != nil {
+		t.Fatalf("VerifyAttestationContext() failed: %v", err)
+	}
+	if id := verified.EKIdentity; id == nil || id.Certified || !publicKeysEqual(id.PublicKey, key) {
+		t.Errorf("EKIdentity = %+v, want the uncertified attached EK", id)
+	}
+
+	// A certificate for another key contradicts the attached public area.
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, additions, err := unwrapEnvelope(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatched := wrapEnvelope(plain, attachEKCertificate(additions, testCertificate(t, other).Raw))
+	if _, err := VerifyAttestationContext(t.Context(), mismatched, simulatorVerifyOptions(nonce)); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("VerifyAttestationContext() with a mismatched EK certificate = %v, want a mismatch error", err)
+	}
+}
+
+func TestEKChallenge(t *testing.T) {
+	sim := newTestSimulator(t)
+	defer sim.Close()
+	root := provisionEKCertificate(t, sim)
+	opts := DefaultAttestOptions()
+	opts.AttachEKCert = true
+	opts.TPM = sim
+	verifyOpts := simulatorVerifyOptions(nil)
+	verifyOpts.TrustedEKRoots = []*x509.Certificate{root}
+	verifier := NewVerifier(VerifierConfig{Options: verifyOpts})
+
+	// respond answers a challenge for the RSA AK, attesting over its secret with the AK of akOpts.
+	respond := func(akOpts AttestOptions) []byte {
+		t.Helper()
+		challenge, err := verifier.NewEKChallenge(attestWithSimulator(t, opts, []byte("ek-challenge-request-nonce")), "binarypb")
+		if err != nil {
+			t.Fatalf("NewEKChallenge() failed: %v", err)
+		}
+		secret, err := activateEKCredential(sim, opts, challenge)
+		if err != nil {
+			t.Fatalf("activateEKCredential() failed: %v", err)
+		}
+		return attestWithSimulator(t, akOpts, secret)
+	}
+
+	report, err := verifier.VerifyEKResponse(respond(opts), "binarypb")
+	if err != nil {
+		t.Fatalf("VerifyEKResponse() failed: %v", err)
+	}
+	if report.EKCertificate == nil {
+		t.Error("EKCertificate is nil, want the challenged EK certificate")
+	}
+
+	// The secret is no proof for another AK that quotes it, even one of the same TPM.
+	eccOpts := opts
+	eccOpts.KeyAlgo = tpm2.AlgECC
+	if _, err := verifier.VerifyEKResponse(respond(eccOpts), "binarypb"); err == nil || !strings.Contains(err.Error(), "not quoted by the AK of the challenge") {
+		t.Errorf("VerifyEKResponse() quoted by another AK = %v, want an AK mismatch error", err)
+	}
+}
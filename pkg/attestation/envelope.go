@@ -0,0 +1,148 @@
+package attestation
+
+import (
+	"bytes"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// An attestation envelope is the binary report of Attest when it carries additions of this
+// package, which the go-tpm-tools Attestation message has no fields for. It is envelopeMagic
+// followed by the protobuf encoding of this message, which this package owns:
+//
+//	message AttestationEnvelope {
+//	  // Binary encoding of the go-tpm-tools Attestation
+//	  bytes attestation = 1;
+//	  // SignedWorkloadClaim, encoded as JSON
+//	  bytes workload_claim = 2;
+//	  // NVReadings, each encoded as JSON
+//	  repeated bytes nv_readings = 3;
+//	  // DER certificate of the TPM's EK
+//	  bytes ek_certificate = 4;
+//	  // Encoded TPMT_PUBLIC of the TPM's EK
+//	  bytes ek_public = 5;
+//	}
+//
+// Binary reports without additions remain plain Attestation messages. A protobuf encoding cannot
+// begin with a zero byte, the tag of field 0, so the magic tells envelopes apart from them.
+var envelopeMagic = []byte("\x00lunal-attestation\x01")
+
+// envelopeAttestationField is the field of an attestation envelope that holds the Attestation.
+const envelopeAttestationField protowire.Number = 1
+
+// envelopeFields are the wire types of the fields of an attestation envelope that carry additions.
+var envelopeFields = map[protowire.Number]protowire.Type{
+	WorkloadClaimField: protowire.BytesType,
+	NVReadingField:     protowire.BytesType,
+	EKCertificateField: protowire.BytesType,
+	EKPublicField:      protowire.BytesType,
+}
+
+// wrapEnvelope returns the attestation envelope of a binary attestation and the encoded fields of
+// its additions, or the attestation itself if it has none.
+func wrapEnvelope(attestation []byte, additions []byte) []byte {
+	if len(additions) == 0 {
+		return attestation
+	}
+	out := make([]byte, 0, len(envelopeMagic)+protowire.SizeTag(envelopeAttestationField)+protowire.SizeBytes(len(attestation))+len(additions))
+	out = append(out, envelopeMagic...)
+	out = protowire.AppendTag(out, envelopeAttestationField, protowire.BytesType)
+	out = protowire.AppendBytes(out, attestation)
+	return append(out, additions...)
+}
+
+// unwrapEnvelope returns the binary attestation of a binary report and the encoded fields of its
+// additions. A report that is not an attestation envelope is itself the attestation.
+func unwrapEnvelope(data []byte) ([]byte, []byte, error) {
+	if !bytes.HasPrefix(data, envelopeMagic) {
+		return data, nil, nil
+	}
+	var attestation, additions []byte
+	found := false
+	for rest := data[len(envelopeMagic):]; len(rest) > 0; {
+		num, typ, n := protowire.ConsumeTag(rest)
+		if n < 0 {
+			return nil, nil, fmt.Errorf("malformed attestation envelope: %v", protowire.ParseError(n))
+		}
+		m := protowire.ConsumeFieldValue(num, typ, rest[n:])
+		if m < 0 {
+			return nil, nil, fmt.Errorf("malformed attestation envelope: %v", protowire.ParseError(m))
+		}
+		field := rest[:n+m]
+		rest = rest[n+m:]
+
+		if num != envelopeAttestationField {
+			additions = append(additions, field...)
+			continue
+		}
+		if typ != protowire.BytesType {
+			return nil, nil, fmt.Errorf("malformed attestation envelope: attestation has wire type %d", typ)
+		}
+		attestation, _ = protowire.ConsumeBytes(field[n:])
+		found = true
+	}
+	if !found {
+		return nil, nil, fmt.Errorf("attestation envelope does not contain an attestation")
+	}
+	return attestation, additions, nil
+}
+
+// envelopeField returns the encoded value of the last field num of type typ of the attestation
+// envelope data.
+func envelopeField(data []byte, num protowire.Number, typ protowire.Type) ([]byte, bool, error) {
+	values, err := envelopeFieldValues(data, num, typ)
+	if err != nil || len(values) == 0 {
+		return nil, false, err
+	}
+	return values[len(values)-1], true, nil
+}
+
+// envelopeFieldValues returns the encoded values of the fields num of type typ of the attestation
+// envelope data, none if data is not an envelope.
+func envelopeFieldValues(data []byte, num protowire.Number, typ protowire.Type) ([][]byte, error) {
+	_, additions, err := unwrapEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	var values [][]byte
+	for len(additions) > 0 {
+		n, t, l := protowire.ConsumeTag(additions)
+		additions = additions[l:]
+		l = protowire.ConsumeFieldValue(n, t, additions)
+		if n == num && t == typ {
+			values = append(values, additions[:l])
+		}
+		additions = additions[l:]
+	}
+	return values, nil
+}
+
+// unknownEnvelopeFields lists the fields of the attestation envelope data that this package does
+// not define, or that have another wire type, e.g. "envelope.9".
+func unknownEnvelopeFields(data []byte) ([]string, error) {
+	_, additions, err := unwrapEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for len(additions) > 0 {
+		num, typ, n := protowire.ConsumeTag(additions)
+		additions = additions[n:]
+		additions = additions[protowire.ConsumeFieldValue(num, typ, additions):]
+		if known, ok := envelopeFields[num]; !ok || known != typ {
+			paths = append(paths, fmt.Sprintf("envelope.%d", num))
+		}
+	}
+	return paths, nil
+}
+
+// envelopeAdditions returns the encoded fields of the additions of a report in format, which only
+// binary reports have.
+func envelopeAdditions(data []byte, format string) ([]byte, error) {
+	if format != "binarypb" {
+		return nil, nil
+	}
+	_, additions, err := unwrapEnvelope(data)
+	return additions, err
+}
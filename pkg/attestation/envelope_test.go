@@ -0,0 +1,167 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// newTestSimulator starts a simulated TPM with a fixed seed. Only one runs at a time, so close it
+// when done.
+func newTestSimulator(t *testing.T) *simulator.Simulator {
+	t.Helper()
+	sim, err := simulator.GetWithFixedSeedInsecure(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sim
+}
+
+// attestWithSimulator attests with opts, quoting over nonce, on opts.TPM or else on a new
+// simulated TPM.
+func attestWithSimulator(t *testing.T, opts AttestOptions, nonce []byte) []byte {
+	t.Helper()
+	if opts.TPM == nil {
+		sim := newTestSimulator(t)
+		defer sim.Close()
+		opts.TPM = sim
+	}
+	opts.Nonce = nonce
+	report, err := Attest(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// simulatorVerifyOptions returns the options that verify reports of attestWithSimulator over nonce.
+func simulatorVerifyOptions(nonce []byte) VerifyOptions {
+	opts := DefaultVerifyOptions()
+	opts.Nonce = nonce
+	opts.AllowMissingEventLog = true
+	return opts
+}
+
+func TestWorkloadClaimEnvelope(t *testing.T) {
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := []byte("workload-claim-envelope-nonce")
+	opts := DefaultAttestOptions()
+	opts.WorkloadClaim = &WorkloadClaim{ImageDigest: "sha256:0123", ConfigHash: "abcd"}
+	opts.WorkloadSigner = signer
+	report := attestWithSimulator(t, opts, nonce)
+	if !bytes.HasPrefix(report, envelopeMagic) {
+		t.Fatal("report with a workload claim is not an attestation envelope")
+	}
+
+	verifyOpts := simulatorVerifyOptions(nonce)
+	verifyOpts.WorkloadClaimKey = &signer.PublicKey
+	verified, err := VerifyAttestationContext(t.Context(), report, verifyOpts)
+	if err != nil {
+		t.Fatalf("VerifyAttestationContext() failed: %v", err)
+	}
+	if got := verified.WorkloadClaim; got == nil || got.ImageDigest != "sha256:0123" || !bytes.Equal(got.Nonce, nonce) {
+		t.Errorf("WorkloadClaim = %+v, want the attested claim", got)
+	}
+	if len(verified.UnknownFields) != 0 {
+		t.Errorf("UnknownFields = %v, want none", verified.UnknownFields)
+	}
+
+	// Re-encoding the report keeps the claim.
+	canonical, err := CanonicalizeAttestation(report, "binarypb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := SplitAttestation(canonical, "binarypb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined, err := archive.Join()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed, err := GetWorkloadClaim(joined); err != nil || signed == nil {
+		t.Errorf("GetWorkloadClaim() of the re-encoded report = %v, %v, want the claim", signed, err)
+	}
+}
+
+func TestPlainAttestationIsNotWrapped(t *testing.T) {
+	nonce := []byte("plain-attestation-nonce")
+	report := attestWithSimulator(t, DefaultAttestOptions(), nonce)
+	attestation, additions, err := unwrapEnvelope(report)
+	if err != nil || !bytes.Equal(attestation, report) || additions != nil {
+		t.Fatalf("unwrapEnvelope() of a report without additions = %d bytes, %x, %v, want the report itself", len(attestation), additions, err)
+	}
+	if signed, err := GetWorkloadClaim(report); err != nil || signed != nil {
+		t.Errorf("GetWorkloadClaim() = %v, %v, want no claim", signed, err)
+	}
+}
+
+func TestUnwrapEnvelope(t *testing.T) {
+	attestation := []byte{0x0a, 0x01, 0x01}
+	claim := protowire.AppendBytes(protowire.AppendTag(nil, WorkloadClaimField, protowire.BytesType), []byte("{}"))
+	unknown := protowire.AppendVarint(protowire.AppendTag(nil, 9, protowire.VarintType), 1)
+
+	tests := []struct {
+		name      string
+		data      []byte
+		additions []byte
+		unknown   []string
+		wantErr   bool
+	}{
+		{name: "plain attestation", data: attestation},
+		{name: "envelope", data: wrapEnvelope(attestation, claim), additions: claim},
+		{name: "unknown field", data: wrapEnvelope(attestation, unknown), additions: unknown, unknown: []string{"envelope.9"}},
+		{name: "no attestation", data: append(bytes.Clone(envelopeMagic), claim...), wantErr: true},
+		{name: "truncated", data: wrapEnvelope(attestation, claim)[:len(envelopeMagic)+3], wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, additions, err := unwrapEnvelope(tc.data)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("unwrapEnvelope() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unwrapEnvelope() failed: %v", err)
+			}
+			if !bytes.Equal(got, attestation) || !bytes.Equal(additions, tc.additions) {
+				t.Errorf("unwrapEnvelope() = %x, %x, want %x, %x", got, additions, attestation, tc.additions)
+			}
+			paths, err := unknownEnvelopeFields(tc.data)
+			if err != nil || len(paths) != len(tc.unknown) || (len(paths) != 0 && paths[0] != tc.unknown[0]) {
+				t.Errorf("unknownEnvelopeFields() = %v, %v, want %v", paths, err, tc.unknown)
+			}
+		})
+	}
+}
+
+func TestRejectUnknownEnvelopeFields(t *testing.T) {
+	nonce := []byte("unknown-envelope-field-nonce")
+	plain := attestWithSimulator(t, DefaultAttestOptions(), nonce)
+	unknown := protowire.AppendBytes(protowire.AppendTag(nil, 99, protowire.BytesType), []byte("newer addition"))
+	report := wrapEnvelope(plain, unknown)
+
+	opts := simulatorVerifyOptions(nonce)
+	verified, err := VerifyAttestationContext(t.Context(), report, opts)
+	if err != nil {
+		t.Fatalf("VerifyAttestationContext() failed: %v", err)
+	}
+	if len(verified.UnknownFields) != 1 || verified.UnknownFields[0] != "envelope.99" {
+		t.Errorf("UnknownFields = %v, want [envelope.99]", verified.UnknownFields)
+	}
+	opts.RejectUnknownFields = true
+	if _, err := VerifyAttestationContext(t.Context(), report, opts); !errors.Is(err, ErrUnknownFields) {
+		t.Errorf("VerifyAttestationContext() with RejectUnknownFields = %v, want ErrUnknownFields", err)
+	}
+}
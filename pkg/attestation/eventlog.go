@@ -0,0 +1,227 @@
+package attestation
+
+import (
+	"encoding/binary"
+	"fmt"
+	"iter"
+	"strings"
+	"unicode/utf16"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// Event log overflow policies for AttestOptions.EventLogOverflow
+const (
+	// EventLogError fails the attestation when the event log is too large
+	EventLogError = "error"
+	// EventLogTruncate omits the event log, which verifiers detect from the quoted PCRs
+	EventLogTruncate = "truncate"
+)
+
+// TCG event types used when inspecting the event log.
+const (
+	evPostCode                   = 0x1
+	evIPL                        = 0xd
+	evSCRTMContents              = 0x7
+	evSCRTMVersion               = 0x8
+	evEFIBootServicesApplication = 0x80000003
+	evEFIBootServicesDriver      = 0x80000004
+	evEFIRuntimeServicesDriver   = 0x80000005
+	evEFIPlatformFirmwareBlob    = 0x80000008
+	evEFIPlatformFirmwareBlob2   = 0x8000000a
+)
+
+// bootEntryPCR is the PCR that EFI boot applications are measured into.
+const bootEntryPCR = 4
+
+// BootEntry is an EFI boot application measured into PCR 4 during boot
+type BootEntry struct {
+	// Index is the position of the entry's event in the event log
+	Index int
+	// Digest is the measured digest of the boot application
+	Digest []byte
+	// Path is the file path recorded in the event. It is not measured, so it is only a hint.
+	Path string
+}
+
+// BootEvent is an event of the event log of a verified machine state
+type BootEvent struct {
+	// PCR is the PCR the event was measured into
+	PCR uint32
+	// Type is the TCG event type. It is not measured, so it is only a hint.
+	Type uint32
+	// Digest is the digest extended into PCR, from the verified PCR bank
+	Digest []byte
+	// Data is the event data
+	Data []byte
+	// DigestVerified reports that Digest is the digest of Data, so that Data can be trusted
+	DigestVerified bool
+}
+
+// BootEntryError is returned when the event log contains a boot entry that is not allowed
+type BootEntryError struct {
+	Entry BootEntry
+}
+
+func (e *BootEntryError) Error() string {
+	return fmt.Sprintf("boot entry at event %d (path %q, digest %x) is not in the allowed boot entries", e.Entry.Index, e.Entry.Path, e.Entry.Digest)
+}
+
+// limitEventLog applies the size limit max (none if 0) to log according to policy, and returns the
+// log to attest.
+func limitEventLog(log []byte, max int, policy string) ([]byte, error) {
+	if max <= 0 || len(log) <= max {
+		return log, nil
+	}
+	switch policy {
+	case "", EventLogError:
+		return nil, fmt.Errorf("TCG event log is %d bytes, exceeding the maximum of %d", len(log), max)
+	case EventLogTruncate:
+		// A partial log cannot be replayed against the PCRs, so the whole log is dropped. The log
+		// is empty rather than nil, which client.AttestOpts would replace with the host's log.
+		return []byte{}, nil
+	default:
+		return nil, fmt.Errorf("event-log-overflow should be either %s or %s", EventLogError, EventLogTruncate)
+	}
+}
+
+// eventLogOmitted reports whether a verified attestation has no event log although its quoted
+// boot PCRs, 0 to 7, hold measurements, so that the attester omitted the log it had. This is
+// derived from the quote rather than recorded by the attester, which could leave a record out.
+func eventLogOmitted(attestation *pb.Attestation, ms *pb.MachineState) bool {
+	if len(attestation.GetEventLog()) != 0 {
+		return false
+	}
+	pcrs, err := verifiedPCRs(attestation, ms)
+	if err != nil {
+		return false
+	}
+	for index, value := range pcrs {
+		if index < 8 && strings.Trim(string(value), "\x00") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// EventLogEntries iterates over the events of a verified machine state in log order, with their
+// index in the log. Events are converted as the iteration reaches them, so a scan that stops early
+// does not convert the rest of the log.
+func EventLogEntries(ms *pb.MachineState) iter.Seq2[int, BootEvent] {
+	return func(yield func(int, BootEvent) bool) {
+		for i, event := range ms.GetRawEvents() {
+			bootEvent := BootEvent{
+				PCR:            event.GetPcrIndex(),
+				Type:           event.GetUntrustedType(),
+				Digest:         event.GetDigest(),
+				Data:           event.GetData(),
+				DigestVerified: event.GetDigestVerified(),
+			}
+			if !yield(i, bootEvent) {
+				return
+			}
+		}
+	}
+}
+
+// EventsByType returns the events of a verified machine state with the TCG event type eventType,
+// e.g. 0x80000003 for EV_EFI_BOOT_SERVICES_APPLICATION, in log order.
+func EventsByType(ms *pb.MachineState, eventType uint32) []BootEvent {
+	var events []BootEvent
+	for _, event := range EventLogEntries(ms) {
+		if event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// BootEntries returns the EFI boot applications recorded in a verified machine state, in load order.
+func BootEntries(ms *pb.MachineState) []BootEntry {
+	var entries []BootEntry
+	for i, event := range EventLogEntries(ms) {
+		if event.PCR != bootEntryPCR || event.Type != evEFIBootServicesApplication {
+			continue
+		}
+		entries = append(entries, BootEntry{
+			Index:  i,
+			Digest: event.Digest,
+			Path:   imageLoadEventPath(event.Data),
+		})
+	}
+	return entries
+}
+
+// checkBootEntries fails on the first boot entry whose digest is not in allowed.
+func checkBootEntries(ms *pb.MachineState, allowed [][]byte) error {
+	for _, entry := range BootEntries(ms) {
+		if !containsDigest(allowed, entry.Digest) {
+			return &BootEntryError{Entry: entry}
+		}
+	}
+	return nil
+}
+
+// imageLoadEventPath extracts the file path from the device path of a UEFI_IMAGE_LOAD_EVENT.
+// It returns an empty string if the event data cannot be parsed.
+func imageLoadEventPath(data []byte) string {
+	// ImageLocationInMemory, ImageLengthInMemory and ImageLinkTimeAddress precede the
+	// LengthOfDevicePath field, each 8 bytes long.
+	const devicePathOffset = 32
+	if len(data) < devicePathOffset {
+		return ""
+	}
+	length := binary.LittleEndian.Uint64(data[24:devicePathOffset])
+	if length > uint64(len(data)-devicePathOffset) {
+		return ""
+	}
+	devicePath := data[devicePathOffset : devicePathOffset+int(length)]
+
+	var parts []string
+	for len(devicePath) >= 4 {
+		nodeType, subType := devicePath[0], devicePath[1]
+		nodeLength := int(binary.LittleEndian.Uint16(devicePath[2:4]))
+		if nodeLength < 4 || nodeLength > len(devicePath) {
+			break
+		}
+		// Media device path, file path subtype.
+		if nodeType == 0x04 && subType == 0x04 {
+			parts = append(parts, decodeUTF16(devicePath[4:nodeLength]))
+		}
+		// End of entire device path.
+		if nodeType == 0x7f && subType == 0xff {
+			break
+		}
+		devicePath = devicePath[nodeLength:]
+	}
+	return strings.Join(parts, "")
+}
+
+// decodeUTF16 decodes a NUL-terminated little-endian UTF-16 string.
+func decodeUTF16(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+// checkSecureBoot fails unless the Secure Boot state recorded in ms is enabled as required.
+func checkSecureBoot(ms *pb.MachineState, enabled bool) error {
+	actual := ms.GetSecureBoot().GetEnabled()
+	if actual != enabled {
+		return fmt.Errorf("secure boot is %s, but the policy requires it to be %s", secureBootState(actual), secureBootState(enabled))
+	}
+	return nil
+}
+
+func secureBootState(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
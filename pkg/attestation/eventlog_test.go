@@ -0,0 +1,125 @@
+package attestation
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"github.com/google/go-tpm-tools/proto/tpm"
+)
+
+func TestEventLogOmitted(t *testing.T) {
+	zero := make([]byte, 32)
+	measured := bytes.Repeat([]byte{0xab}, 32)
+	attestation := func(eventLog []byte, pcrs map[uint32][]byte) *pb.Attestation {
+		return &pb.Attestation{
+			EventLog: eventLog,
+			Quotes:   []*tpm.Quote{{Pcrs: &tpm.PCRs{Hash: tpm.HashAlgo_SHA256, Pcrs: pcrs}}},
+		}
+	}
+	ms := &pb.MachineState{Hash: tpm.HashAlgo_SHA256}
+
+	tests := []struct {
+		name        string
+		attestation *pb.Attestation
+		ms          *pb.MachineState
+		want        bool
+	}{
+		{name: "log present", attestation: attestation([]byte{1}, map[uint32][]byte{0: measured}), ms: ms},
+		{name: "no measurements", attestation: attestation(nil, map[uint32][]byte{0: zero, 7: zero}), ms: ms},
+		{name: "boot PCR measured", attestation: attestation(nil, map[uint32][]byte{0: zero, 4: measured}), ms: ms, want: true},
+		{name: "only later PCRs measured", attestation: attestation(nil, map[uint32][]byte{0: zero, 16: measured}), ms: ms},
+		{name: "no quote over the verified bank", attestation: attestation(nil, map[uint32][]byte{0: measured}), ms: &pb.MachineState{Hash: tpm.HashAlgo_SHA1}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := eventLogOmitted(tc.attestation, tc.ms); got != tc.want {
+				t.Errorf("eventLogOmitted() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLimitEventLog(t *testing.T) {
+	log := bytes.Repeat([]byte{1}, 100)
+	if got, err := limitEventLog(log, 100, EventLogError); err != nil || !bytes.Equal(got, log) {
+		t.Errorf("limitEventLog() within the limit = %d bytes, %v, want the log", len(got), err)
+	}
+	if _, err := limitEventLog(log, 99, EventLogError); err == nil {
+		t.Error("limitEventLog() over the limit with EventLogError succeeded, want an error")
+	}
+	// The omitted log is empty rather than nil, so that the host's log is not read instead.
+	if got, err := limitEventLog(log, 99, EventLogTruncate); err != nil || got == nil || len(got) != 0 {
+		t.Errorf("limitEventLog() over the limit with EventLogTruncate = %v, %v, want an empty log", got, err)
+	}
+}
+
+func TestAttestWithoutEventLog(t *testing.T) {
+	secureBoot := true
+	// withBootPolicy returns opts with every check that needs an event log.
+	withBootPolicy := func(opts VerifyOptions) VerifyOptions {
+		opts.AllowedBootEntries = [][]byte{make([]byte, 32)}
+		opts.ExpectedEventSequence = []ExpectedEvent{{PCR: 4, Digest: make([]byte, 32)}}
+		opts.RequireSecureBoot = &secureBoot
+		return opts
+	}
+	skipped := []string{"boot entries", "event sequence", "secure boot"}
+
+	nonce := []byte("log-less-round-trip-nonce")
+	attestOpts := DefaultAttestOptions()
+	attestOpts.OmitEventLog = true
+	simulated := attestWithSimulator(t, attestOpts, nonce)
+
+	// The example TDX report stands in for a TEE-only platform without an event log.
+	tdx, err := unmarshalAttestation(exampleReport(t), "binarypb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tdx.EventLog = nil
+	teeOnly, err := marshalAttestation(tdx, nil, "binarypb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		report []byte
+		opts   VerifyOptions
+	}{
+		{name: "OmitEventLog", report: simulated, opts: simulatorVerifyOptions(nonce)},
+		{name: "TEE only", report: teeOnly, opts: exampleVerifyOptions()},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if attestation, err := unmarshalAttestation(tc.report, "binarypb"); err != nil || len(attestation.GetEventLog()) != 0 {
+				t.Fatalf("report carries an event log (%v)", err)
+			}
+
+			// Without the policy checks that need it, the missing log is no failure.
+			verified, err := VerifyAttestationContext(t.Context(), tc.report, tc.opts)
+			if err != nil {
+				t.Fatalf("VerifyAttestationContext() failed: %v", err)
+			}
+			if !verified.EventLogMissing || len(verified.SkippedChecks) != 0 {
+				t.Errorf("EventLogMissing, SkippedChecks = %v, %v, want true and none", verified.EventLogMissing, verified.SkippedChecks)
+			}
+
+			opts := withBootPolicy(tc.opts)
+			opts.AllowMissingEventLog = false
+			if _, err := VerifyAttestationContext(t.Context(), tc.report, opts); err == nil || !strings.Contains(err.Error(), "attestation has no event log") {
+				t.Errorf("VerifyAttestationContext() with boot checks = %v, want a missing event log error", err)
+			}
+
+			opts.AllowMissingEventLog = true
+			verified, err = VerifyAttestationContext(t.Context(), tc.report, opts)
+			if err != nil {
+				t.Fatalf("VerifyAttestationContext() with AllowMissingEventLog failed: %v", err)
+			}
+			if !verified.EventLogMissing || !reflect.DeepEqual(verified.SkippedChecks, skipped) {
+				t.Errorf("EventLogMissing, SkippedChecks = %v, %v, want true and %v", verified.EventLogMissing, verified.SkippedChecks, skipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package attestation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	sv "github.com/google/go-sev-guest/verify"
+	"github.com/google/go-tdx-guest/pcs"
+	"github.com/google/go-tdx-guest/proto/tdx"
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// EvidenceBundle is an attestation report packaged with the TEE collateral needed to verify it,
+// so that it can be verified offline long after the collateral endpoints have changed.
+type EvidenceBundle struct {
+	// Attestation is the attestation report
+	Attestation []byte `json:"attestation"`
+	// Format is the format of Attestation, binarypb or textproto
+	Format string `json:"format"`
+	// Collateral holds the TEE collateral documents as they were fetched
+	Collateral []BundledDocument `json:"collateral,omitempty"`
+	// CapturedAt is when the collateral was fetched. VerifyEvidenceBundle verifies as of this time.
+	CapturedAt time.Time `json:"capturedAt"`
+}
+
+// BundledDocument is a collateral document captured in an EvidenceBundle
+type BundledDocument struct {
+	// URL is where the document was fetched from
+	URL string `json:"url"`
+	// Header holds the response headers, which carry the issuer chains of Intel collateral
+	Header map[string][]string `json:"header,omitempty"`
+	// Body is the response body
+	Body []byte `json:"body"`
+}
+
+// ExportEvidenceBundle fetches the TEE collateral of an attestation report and packages it with
+// the report. For TDX the bundle holds the TCB info and QE identity, and for SEV-SNP any
+// certificates missing from the report. The report itself is not verified.
+func ExportEvidenceBundle(attestationBytes []byte, format string) (*EvidenceBundle, error) {
+	attestation, err := unmarshalAttestation(attestationBytes, format)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	recorder := &recordingCollateral{source: newCollateralCache(nil, 0, nil, nil)}
+	capturedAt := time.Now()
+
+	switch tee := attestation.GetTeeAttestation().(type) {
+	case *pb.Attestation_TdxAttestation:
+		if err := fetchTdxCollateral(tee.TdxAttestation, &tdxCollateralGetter{ctx: ctx, source: recorder}); err != nil {
+			return nil, err
+		}
+	case *pb.Attestation_SevSnpAttestation:
+		if err := sv.SnpAttestation(tee.SevSnpAttestation, sevSnpVerifyOptions(ctx, recorder, capturedAt)); err != nil {
+			return nil, fmt.Errorf("failed to fetch SEV-SNP collateral: %v", err)
+		}
+	}
+
+	return &EvidenceBundle{
+		Attestation: attestationBytes,
+		Format:      format,
+		Collateral:  recorder.documents,
+		CapturedAt:  capturedAt,
+	}, nil
+}
+
+// fetchTdxCollateral fetches the TCB info and QE identity for quote through getter.
+func fetchTdxCollateral(quote *tdx.QuoteV4, getter *tdxCollateralGetter) error {
+	chain, err := pckCertificateChain(quote)
+	if err != nil {
+		return err
+	}
+	exts, err := pcs.PckCertificateExtensions(chain[0])
+	if err != nil {
+		return fmt.Errorf("could not get PCK certificate extensions: %v", err)
+	}
+	root := chain[len(chain)-1]
+	fmspc := strings.ToLower(exts.FMSPC)
+	if _, err := fetchTcbInfo(pcs.TcbInfoURL(fmspc), fmspc, root, getter); err != nil {
+		return err
+	}
+	if _, err := fetchQeIdentity(pcs.QeIdentityURL(), root, getter); err != nil {
+		return err
+	}
+	return nil
+}
+
+// VerifyEvidenceBundle verifies the attestation report in bundle according to opts without network
+// access. TEE collateral is only taken from the bundle, and certificates and collateral are checked
+// as of bundle.CapturedAt unless opts.VerificationTime is set. opts.Format is ignored in favor of
+// bundle.Format.
+func VerifyEvidenceBundle(bundle *EvidenceBundle, opts VerifyOptions) (*VerificationReport, error) {
+	opts.Format = bundle.Format
+	if opts.VerificationTime.IsZero() {
+		opts.VerificationTime = bundle.CapturedAt
+	}
+	return verifyAttestation(context.Background(), bundle.Attestation, opts, bundledCollateral(bundle.Collateral))
+}
+
+// recordingCollateral fetches collateral through source and records every document it returns.
+type recordingCollateral struct {
+	source collateralSource
+
+	mu        sync.Mutex
+	documents []BundledDocument
+}
+
+func (r *recordingCollateral) get(ctx context.Context, url string) (map[string][]string, []byte, error) {
+	header, body, err := r.source.get(ctx, url)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.mu.Lock()
+	r.documents = append(r.documents, BundledDocument{URL: url, Header: header, Body: body})
+	r.mu.Unlock()
+	return header, body, nil
+}
+
+// bundledCollateral serves the documents of an EvidenceBundle and fails for any other URL.
+type bundledCollateral []BundledDocument
+
+func (b bundledCollateral) get(_ context.Context, url string) (map[string][]string, []byte, error) {
+	for _, doc := range b {
+		if doc.URL == url {
+			return doc.Header, doc.Body, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%s is not in the evidence bundle", url)
+}
@@ -0,0 +1,37 @@
+package attestation
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// MaxExtendDataSize is the largest data ExtendAndAttest can extend, the TPM2_PCR_Event limit.
+// Larger data should be hashed first.
+const MaxExtendDataSize = 1024
+
+// ExtendAndAttest extends data into PCR index and then attests as Attest does, so that the quote
+// proves the extended value. Every active PCR bank is extended with the digest of data in its own
+// hash algorithm, as MeasuredComponent{PCR: index, Data: data} predicts with PredictPCRs.
+//
+// The extend is irreversible until the next reboot: every later quote of the PCR includes it. It is
+// done on the TPM used for the quote, after the options are validated and the AK, TEE device and
+// event log are ready, so that a failure before it leaves the PCR untouched. A quote that fails
+// after it still leaves the PCR extended. Use a PCR that boot does not measure into, such as 15 or
+// 23, as extends absent from the event log otherwise break its replay.
+func ExtendAndAttest(index uint32, data []byte, opts AttestOptions) ([]byte, error) {
+	if index > 23 {
+		return nil, fmt.Errorf("invalid PCR index %d", index)
+	}
+	if len(data) > MaxExtendDataSize {
+		return nil, fmt.Errorf("extend data is %d bytes, exceeding the maximum of %d", len(data), MaxExtendDataSize)
+	}
+	return attestWith(opts, func(rw io.ReadWriter) error {
+		if err := tpm2.PCREvent(rw, tpmutil.Handle(index), data); err != nil {
+			return fmt.Errorf("failed to extend PCR %d: %v", index, err)
+		}
+		return nil
+	})
+}
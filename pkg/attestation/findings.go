@@ -0,0 +1,204 @@
+package attestation
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/google/go-tpm-tools/proto/tpm"
+)
+
+// Finding levels, matching the SARIF result levels
+const (
+	// LevelError marks a failed verification check
+	LevelError = "error"
+	// LevelWarning marks a weakness of a verified attestation
+	LevelWarning = "warning"
+	// LevelNote marks information about a verified attestation
+	LevelNote = "note"
+)
+
+// Finding is a single observation about the verification of an attestation report
+type Finding struct {
+	// RuleID identifies the kind of finding, e.g. tpm-quote or tdx-tcb-status
+	RuleID string `json:"ruleId"`
+	// Level is LevelError, LevelWarning or LevelNote
+	Level string `json:"level"`
+	// Message describes the finding
+	Message string `json:"message"`
+}
+
+// checkRules maps the error messages of failed verification checks to finding rule IDs.
+var checkRules = []struct {
+	prefix string
+	ruleID string
+}{
+	{"fail to unmarshal attestation report", "attestation-format"},
+	{"verifying attestation schema", "attestation-schema"},
+	{"verifying detached signature", "detached-signature"},
+	{"candidate nonces", "nonce"},
+	{"verifying AK name", "ak-name"},
+	{"verifying gceAK certificate", "gce-ak-certificate"},
+	{"verifying AK certificate", "ak-certificate"},
+	{"verifying EK certificate", "ek-certificate"},
+	{"verifying EK identity", "ek-identity"},
+	{"verifying TPM attestation", "tpm-quote"},
+	{"verifying instance info", "instance-info"},
+	{"verifying allowed instances", "allowed-instances"},
+	{"TEE_TCB_SVN", "tdx-tee-tcb-svn"},
+	{"SEV-SNP PLATFORM_INFO", "sev-snp-platform-info"},
+	{"verifying report data", "tee-report-data"},
+	{"verifying bound evidence", "bound-evidence"},
+	{"verifying TEE attestation", "tee-attestation"},
+	{"verifying denied measurements", "denied-measurements"},
+	{"verifying expected PCRs", "expected-pcrs"},
+	{"verifying current PCRs", "stale-quote"},
+	{"verifying reference values", "reference-values"},
+	{"verifying RIMs", "rim"},
+	{"verifying integrity baseline", "integrity-baseline"},
+	{"verifying components", "components"},
+	{"verifying RTMR components", "rtmr-components"},
+	{"verifying transparency log", "transparency-log"},
+	{"verifying boot entries", "boot-entries"},
+	{"verifying event sequence", "event-sequence"},
+	{"verifying secure boot", "secure-boot"},
+	{"verifying shielded VM", "shielded-vm"},
+	{"verifying workload claim", "workload-claim"},
+}
+
+// Findings lists the findings of a verification: an error finding for each failure joined in err
+// if verification failed, as with VerifyOptions.CollectAllErrors, and otherwise warnings and notes about report, such as a missing TEE attestation or an outdated
+// TDX TCB.
+func Findings(report *VerificationReport, err error) []Finding {
+	if err != nil {
+		failures := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			failures = joined.Unwrap()
+		}
+		findings := make([]Finding, 0, len(failures))
+		for _, failure := range failures {
+			findings = append(findings, Finding{RuleID: errorRuleID(failure), Level: LevelError, Message: failure.Error()})
+		}
+		return findings
+	}
+	if report == nil {
+		return nil
+	}
+
+	var findings []Finding
+	add := func(ruleID string, level string, message string) {
+		findings = append(findings, Finding{RuleID: ruleID, Level: level, Message: message})
+	}
+	if report.Technology == "" {
+		add("tee-attestation", LevelWarning, "attestation does not contain a TEE attestation")
+	}
+	if report.Tdx != nil && report.Tdx.TCBStatus != "" && report.Tdx.TCBStatus != "UpToDate" {
+		add("tdx-tcb-status", LevelWarning, "TDX TCB status is "+report.Tdx.TCBStatus)
+	}
+	if report.MachineState.GetHash() == tpm.HashAlgo_SHA1 {
+		add("pcr-bank", LevelWarning, "machine state was verified against the SHA-1 PCR bank")
+	}
+	if report.EventLogTruncated {
+		add("event-log", LevelWarning, "attester omitted its event log, so boot events are not verified")
+	} else if report.EventLogError != "" {
+		add("event-log", LevelWarning, "event log was dropped, so boot events are not verified: "+report.EventLogError)
+	} else if report.EventLogMissing {
+		add("event-log", LevelWarning, "attestation has no event log, so boot events are not verified")
+	} else if !report.MachineState.GetSecureBoot().GetEnabled() {
+		add("secure-boot", LevelWarning, "secure boot is disabled")
+	}
+	if len(report.SkippedChecks) != 0 {
+		add("event-log", LevelWarning, "checks skipped without an event log: "+strings.Join(report.SkippedChecks, ", "))
+	}
+	if len(report.UnknownFields) != 0 {
+		add("attestation-schema", LevelWarning, "attestation has fields unknown to this verifier, which were not verified: "+strings.Join(report.UnknownFields, ", "))
+	}
+	if report.BaselineEstablished {
+		add("baseline", LevelNote, "host "+report.Baseline.HostID+" was trusted on first use; its measurements are now its baseline")
+	}
+	if report.GceAKEndorsement == nil {
+		add("gce-ak-certificate", LevelNote, "attestation key is trusted on first use, not by certificate")
+	}
+	return findings
+}
+
+// errorRuleID returns the rule ID of a failed verification.
+func errorRuleID(err error) string {
+	var bootEntryErr *BootEntryError
+	var driftErr *BaselineDriftError
+	var limitErr *LimitError
+	switch {
+	case errors.Is(err, ErrStaleCollateral):
+		return "tdx-collateral-freshness"
+	case errors.Is(err, ErrInconsistentCollateral):
+		return "tdx-collateral-consistency"
+	case errors.Is(err, ErrWeakNonce), errors.Is(err, ErrNonceTooLong), errors.Is(err, ErrChannelBindingMismatch):
+		return "nonce"
+	case errors.As(err, &bootEntryErr):
+		return "boot-entries"
+	case errors.As(err, &driftErr):
+		return "baseline-drift"
+	case errors.Is(err, ErrNoBaseline):
+		return "baseline"
+	case errors.Is(err, ErrVerificationBudgetExceeded):
+		return "verification-budget"
+	case errors.Is(err, ErrBrokenChain):
+		return "delegation-chain"
+	case errors.As(err, &limitErr):
+		return "resource-limit"
+	}
+	message := err.Error()
+	for _, rule := range checkRules {
+		if strings.Contains(message, rule.prefix) {
+			return rule.ruleID
+		}
+	}
+	return "verification"
+}
+
+// sarifLog is the subset of SARIF 2.1.0 used by FindingsSARIF.
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name string `json:"name"`
+}
+
+type sarifResult struct {
+	RuleID  string       `json:"ruleId"`
+	Level   string       `json:"level"`
+	Message sarifMessage `json:"message"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+// FindingsSARIF encodes findings as a SARIF 2.1.0 log, for security tooling that ingests scan
+// results.
+func FindingsSARIF(findings []Finding) ([]byte, error) {
+	results := make([]sarifResult, 0, len(findings))
+	for _, f := range findings {
+		results = append(results, sarifResult{RuleID: f.RuleID, Level: f.Level, Message: sarifMessage{Text: f.Message}})
+	}
+	return json.MarshalIndent(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs: []sarifRun{{
+			Tool:    sarifTool{Driver: sarifDriver{Name: "lunal-attestation"}},
+			Results: results,
+		}},
+	}, "", "  ")
+}
@@ -0,0 +1,104 @@
+package attestation
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// DefaultMaxFrameSize bounds the payload of frames when FrameOptions.MaxFrameSize is not set.
+const DefaultMaxFrameSize = 16 << 20
+
+// frameLengthSize is the size of the length prefix of a frame.
+const frameLengthSize = 4
+
+// ErrFrameTooLarge is returned when a frame is longer than FrameOptions.MaxFrameSize.
+var ErrFrameTooLarge = errors.New("frame exceeds the maximum size")
+
+// FrameOptions configures the framing of attestation streams, in which each attestation report is
+// preceded by its length as a 4-byte unsigned integer.
+type FrameOptions struct {
+	// ByteOrder is the byte order of the length prefix. Defaults to binary.BigEndian (network
+	// order). Clients writing the length as a native integer, as Rust and C clients often do, need
+	// binary.NativeEndian or the order of their platform.
+	ByteOrder binary.ByteOrder
+	// MaxFrameSize bounds the payload of frames, so that a corrupt or hostile length prefix is
+	// rejected before it is read. 0 means DefaultMaxFrameSize.
+	MaxFrameSize int
+}
+
+// DefaultFrameOptions returns the framing of network-order length prefixes and payloads of up to
+// DefaultMaxFrameSize.
+func DefaultFrameOptions() FrameOptions {
+	return FrameOptions{
+		ByteOrder:    binary.BigEndian,
+		MaxFrameSize: DefaultMaxFrameSize,
+	}
+}
+
+// byteOrder returns the byte order of length prefixes.
+func (o FrameOptions) byteOrder() binary.ByteOrder {
+	if o.ByteOrder == nil {
+		return binary.BigEndian
+	}
+	return o.ByteOrder
+}
+
+// maxFrameSize returns the bound on frame payloads, which the length prefix caps.
+func (o FrameOptions) maxFrameSize() uint64 {
+	if o.MaxFrameSize <= 0 {
+		return DefaultMaxFrameSize
+	}
+	return min(uint64(o.MaxFrameSize), math.MaxUint32)
+}
+
+// WriteFrame writes payload to w as a frame.
+func WriteFrame(w io.Writer, payload []byte, opts FrameOptions) error {
+	if uint64(len(payload)) > opts.maxFrameSize() {
+		return fmt.Errorf("%w: %d bytes, at most %d", ErrFrameTooLarge, len(payload), opts.maxFrameSize())
+	}
+	frame := make([]byte, frameLengthSize, frameLengthSize+len(payload))
+	opts.byteOrder().PutUint32(frame, uint32(len(payload)))
+	if _, err := w.Write(append(frame, payload...)); err != nil {
+		return fmt.Errorf("failed to write frame: %v", err)
+	}
+	return nil
+}
+
+// ReadFrame reads the payload of the next frame from r. It returns io.EOF if r ends before the
+// frame begins, and io.ErrUnexpectedEOF if it ends within it.
+func ReadFrame(r io.Reader, opts FrameOptions) ([]byte, error) {
+	var prefix [frameLengthSize]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	length := uint64(opts.byteOrder().Uint32(prefix[:]))
+	if length > opts.maxFrameSize() {
+		return nil, fmt.Errorf("%w: length prefix is %d bytes, at most %d", ErrFrameTooLarge, length, opts.maxFrameSize())
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
+
+// VerifyAttestationFrame reads the next attestation report from a framed stream and verifies it.
+// It returns io.EOF once r ends between frames.
+func VerifyAttestationFrame(r io.Reader, frameOpts FrameOptions, opts VerifyOptions) (*pb.MachineState, error) {
+	attestationBytes, err := ReadFrame(r, frameOpts)
+	if err == io.EOF {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attestation frame: %w", err)
+	}
+	return VerifyAttestationWithOptions(attestationBytes, opts)
+}
@@ -0,0 +1,100 @@
+package attestation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	payloads := [][]byte{[]byte("first"), {}, bytes.Repeat([]byte{0xab}, 300)}
+	tests := []struct {
+		name   string
+		order  binary.ByteOrder
+		prefix []byte
+	}{
+		{name: "default", order: nil, prefix: []byte{0, 0, 0, 5}},
+		{name: "big endian", order: binary.BigEndian, prefix: []byte{0, 0, 0, 5}},
+		{name: "little endian", order: binary.LittleEndian, prefix: []byte{5, 0, 0, 0}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := FrameOptions{ByteOrder: tc.order}
+			var stream bytes.Buffer
+			for _, payload := range payloads {
+				if err := WriteFrame(&stream, payload, opts); err != nil {
+					t.Fatalf("WriteFrame() failed: %v", err)
+				}
+			}
+			if !bytes.HasPrefix(stream.Bytes(), append(bytes.Clone(tc.prefix), payloads[0]...)) {
+				t.Errorf("stream begins with %x, want the length prefix %x", stream.Bytes()[:frameLengthSize], tc.prefix)
+			}
+
+			for _, want := range payloads {
+				got, err := ReadFrame(&stream, opts)
+				if err != nil {
+					t.Fatalf("ReadFrame() failed: %v", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("ReadFrame() = %x, want %x", got, want)
+				}
+			}
+			if _, err := ReadFrame(&stream, opts); err != io.EOF {
+				t.Errorf("ReadFrame() at the end of the stream = %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestFrameByteOrderMismatch(t *testing.T) {
+	var stream bytes.Buffer
+	if err := WriteFrame(&stream, []byte("native"), FrameOptions{ByteOrder: binary.LittleEndian}); err != nil {
+		t.Fatal(err)
+	}
+	// Read in network order, the prefix of 6 is 6<<24, over the default bound.
+	if _, err := ReadFrame(&stream, DefaultFrameOptions()); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("ReadFrame() of a little-endian prefix in network order = %v, want ErrFrameTooLarge", err)
+	}
+}
+
+func TestFrameLimits(t *testing.T) {
+	opts := FrameOptions{MaxFrameSize: 8}
+	if err := WriteFrame(io.Discard, make([]byte, 9), opts); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("WriteFrame() over MaxFrameSize = %v, want ErrFrameTooLarge", err)
+	}
+	lengthPrefix := func(order binary.ByteOrder, length uint32) []byte {
+		prefix := make([]byte, frameLengthSize)
+		order.PutUint32(prefix, length)
+		return prefix
+	}
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		if _, err := ReadFrame(bytes.NewReader(append(lengthPrefix(order, 9), make([]byte, 9)...)), FrameOptions{ByteOrder: order, MaxFrameSize: 8}); !errors.Is(err, ErrFrameTooLarge) {
+			t.Errorf("ReadFrame() over MaxFrameSize in %v = %v, want ErrFrameTooLarge", order, err)
+		}
+		truncated := append(lengthPrefix(order, 4), 1, 2)
+		if _, err := ReadFrame(bytes.NewReader(truncated), FrameOptions{ByteOrder: order}); err != io.ErrUnexpectedEOF {
+			t.Errorf("ReadFrame() of a truncated frame in %v = %v, want io.ErrUnexpectedEOF", order, err)
+		}
+	}
+}
+
+func TestVerifyAttestationFrame(t *testing.T) {
+	report := exampleReport(t)
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			opts := FrameOptions{ByteOrder: order}
+			var stream bytes.Buffer
+			if err := WriteFrame(&stream, report, opts); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := VerifyAttestationFrame(&stream, opts, exampleVerifyOptions()); err != nil {
+				t.Errorf("VerifyAttestationFrame() failed: %v", err)
+			}
+			if _, err := VerifyAttestationFrame(&stream, opts, exampleVerifyOptions()); err != io.EOF {
+				t.Errorf("VerifyAttestationFrame() at the end of the stream = %v, want io.EOF", err)
+			}
+		})
+	}
+}
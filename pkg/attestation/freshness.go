@@ -0,0 +1,83 @@
+package attestation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// ErrStaleQuote is returned when the quoted PCRs differ from the current PCR values of the host,
+// as for a quote replayed from an earlier state.
+var ErrStaleQuote = errors.New("stale TPM quote")
+
+// QuoteClockInfo holds the clock and reset counters of the TPM when it signed a quote, from the
+// TPMS_ATTEST structure. Comparing them across quotes of a host reveals reboots, TPM resets and
+// quotes older than one already seen. The TPM obfuscates the counters and firmware version with a
+// value specific to the AK, so they only compare across quotes by the same AK.
+type QuoteClockInfo struct {
+	// Clock is the time in milliseconds the TPM has been powered since it was last cleared
+	Clock uint64 `json:"clock"`
+	// ResetCount is the number of TPM resets, e.g. reboots, since the TPM was last cleared
+	ResetCount uint32 `json:"resetCount"`
+	// RestartCount is the number of TPM restarts or resumes since the last reset
+	RestartCount uint32 `json:"restartCount"`
+	// Safe reports that Clock has not been reported lower than an earlier value
+	Safe bool `json:"safe"`
+	// FirmwareVersion is the TPM firmware version
+	FirmwareVersion uint64 `json:"firmwareVersion"`
+}
+
+// quoteClockInfo returns the clock info of the quote over the verified PCR bank.
+func quoteClockInfo(attestation *pb.Attestation, ms *pb.MachineState) (*QuoteClockInfo, error) {
+	for _, quote := range attestation.GetQuotes() {
+		if quote.GetPcrs().GetHash() != ms.GetHash() {
+			continue
+		}
+		data, err := tpm2.DecodeAttestationData(quote.GetQuote())
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode quote: %v", err)
+		}
+		return &QuoteClockInfo{
+			Clock:           data.ClockInfo.Clock,
+			ResetCount:      data.ClockInfo.ResetCount,
+			RestartCount:    data.ClockInfo.RestartCount,
+			Safe:            data.ClockInfo.Safe != 0,
+			FirmwareVersion: data.FirmwareVersion,
+		}, nil
+	}
+	return nil, fmt.Errorf("no quote over the verified PCR bank (%v)", tpm2.Algorithm(ms.GetHash()))
+}
+
+// checkCurrentPCRs compares the verified quoted PCRs with current values read from the host,
+// listing every PCR that differs.
+func checkCurrentPCRs(current map[uint32][]byte, attestation *pb.Attestation, ms *pb.MachineState) error {
+	pcrs, err := verifiedPCRs(attestation, ms)
+	if err != nil {
+		return err
+	}
+	indices := make([]uint32, 0, len(current))
+	for index := range current {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+
+	var stale []string
+	for _, index := range indices {
+		got, ok := pcrs[index]
+		if !ok {
+			return fmt.Errorf("attestation does not quote PCR %d", index)
+		}
+		if !bytes.Equal(got, current[index]) {
+			stale = append(stale, fmt.Sprintf("PCR %d is quoted as %x, currently %x", index, got, current[index]))
+		}
+	}
+	if len(stale) != 0 {
+		return fmt.Errorf("%w: %s", ErrStaleQuote, strings.Join(stale, "; "))
+	}
+	return nil
+}
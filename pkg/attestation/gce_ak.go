@@ -0,0 +1,103 @@
+package attestation
+
+import (
+	"crypto"
+	"crypto/x509"
+	_ "embed"
+	"fmt"
+	"time"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// Google's EK/AK CA certificates (DER encoded), as published at
+// https://pki.goog/cloud_integrity/tpm_ek_root_1.crt and
+// https://privateca-content-62d71773-0000-21da-852e-f4f5e80d7778.storage.googleapis.com/032bf9d39db4fa06aade/ca.crt
+var (
+	//go:embed ca-certs/tpm_ek_root_1.cer
+	gceEKRootCA []byte
+	//go:embed ca-certs/tpm_ek_intermediate_2.crt
+	gceEKIntermediateCA2 []byte
+	//go:embed ca-certs/tpm_ek_intermediate_3.crt
+	gceEKIntermediateCA3 []byte
+	//go:embed ca-certs/gcp_ek_ak_ca_root.crt
+	gcpCASEKRootCA []byte
+	//go:embed ca-certs/gcp_ek_ak_ca_intermediate_v3.crt
+	gcpCASEKIntermediateCA3 []byte
+)
+
+// Bundled Google CA certificates used to validate gceAK certificates
+var (
+	GceAKRootCerts         []*x509.Certificate
+	GceAKIntermediateCerts []*x509.Certificate
+)
+
+func init() {
+	var err error
+	GceAKRootCerts, err = parseCertificates(gceEKRootCA, gcpCASEKRootCA)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse bundled GCE root certificates: %v", err))
+	}
+	GceAKIntermediateCerts, err = parseCertificates(gceEKIntermediateCA2, gceEKIntermediateCA3, gcpCASEKIntermediateCA3)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse bundled GCE intermediate certificates: %v", err))
+	}
+}
+
+// GceAKEndorsement describes the Google-issued certificate that endorsed the AK of a verified
+// attestation
+type GceAKEndorsement struct {
+	// Subject is the subject of the AK certificate
+	Subject string
+	// Issuer is the issuer of the AK certificate
+	Issuer string
+	// SerialNumber is the serial number of the AK certificate, in hexadecimal
+	SerialNumber string
+	// NotBefore and NotAfter bound the validity period of the AK certificate
+	NotBefore time.Time
+	NotAfter  time.Time
+	// InstanceInfo is the GCE instance named by the certificate. It is nil for certificates that
+	// do not carry production instance information.
+	InstanceInfo *pb.GCEInstanceInfo
+}
+
+// checkGceAKCert parses the AK certificate of attestation and checks that it certifies akPub.
+// Validation of the certificate chain is left to server.VerifyAttestation.
+func checkGceAKCert(attestation *pb.Attestation, akPub crypto.PublicKey) (*x509.Certificate, error) {
+	if len(attestation.GetAkCert()) == 0 {
+		return nil, fmt.Errorf("attestation does not contain an AK certificate")
+	}
+	cert, err := x509.ParseCertificate(attestation.GetAkCert())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse AK certificate: %v", err)
+	}
+	certPub, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !certPub.Equal(akPub) {
+		return nil, fmt.Errorf("AK certificate does not certify the AK of the attestation")
+	}
+	return cert, nil
+}
+
+// newGceAKEndorsement records the details of a validated AK certificate.
+func newGceAKEndorsement(cert *x509.Certificate, ms *pb.MachineState) *GceAKEndorsement {
+	return &GceAKEndorsement{
+		Subject:      cert.Subject.String(),
+		Issuer:       cert.Issuer.String(),
+		SerialNumber: cert.SerialNumber.Text(16),
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		InstanceInfo: ms.GetPlatform().GetInstanceInfo(),
+	}
+}
+
+func parseCertificates(ders ...[]byte) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0, len(ders))
+	for _, der := range ders {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
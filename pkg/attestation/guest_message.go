@@ -0,0 +1,41 @@
+package attestation
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+)
+
+// ErrVmplMismatch is returned when a SEV-SNP report was not requested from the expected VMPL.
+var ErrVmplMismatch = errors.New("SEV-SNP report was not requested from the expected VMPL")
+
+// VerifySevSnpGuestMessage verifies a message signed by a SEV-SNP guest, against the verified
+// report of that guest. The VMPCKs that authenticate guest requests to the AMD secure processor
+// are shared only between the guest and the processor, so a relying party cannot check them.
+// Instead the guest binds a signing key to its VMPCK context: software at the given VMPL, which
+// only that level can request reports with through its VMPCK, requests a report whose report
+// data begins with PublicKeyReportData(key), and then signs its messages with the key.
+//
+// VerifySevSnpGuestMessage checks that the report was requested from vmpl, that its report data
+// binds key, and that signature is a valid signature of message by key (RSA PKCS #1 v1.5 or ECDSA
+// over its SHA-256 digest, or Ed25519 over message itself).
+func VerifySevSnpGuestMessage(report *VerificationReport, vmpl uint32, key crypto.PublicKey, message, signature []byte) error {
+	snp := report.Attestation.GetSevSnpAttestation()
+	if snp == nil {
+		return fmt.Errorf("attestation does not contain a SEV-SNP attestation")
+	}
+	if got := snp.GetReport().GetVmpl(); got != vmpl {
+		return fmt.Errorf("%w: report is from VMPL %d, expected %d", ErrVmplMismatch, got, vmpl)
+	}
+	keyHash, err := PublicKeyReportData(key)
+	if err != nil {
+		return err
+	}
+	if err := checkReportDataHash(report.Attestation, keyHash); err != nil {
+		return err
+	}
+	if err := verifySignature(key, message, signature); err != nil {
+		return fmt.Errorf("invalid guest message signature: %v", err)
+	}
+	return nil
+}
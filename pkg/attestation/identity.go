@@ -0,0 +1,100 @@
+package attestation
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/google/go-tdx-guest/pcs"
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// IdentityMismatchError is returned by SameIdentity for a report that came from a different
+// machine than the first report
+type IdentityMismatchError struct {
+	// Field is the identity field that differed: AK, TEE, CHIP_ID or PPID
+	Field string
+	// Index is the position of the report that differed
+	Index int
+}
+
+func (e *IdentityMismatchError) Error() string {
+	return fmt.Sprintf("report %d has a different %s than report 0", e.Index, e.Field)
+}
+
+// identityField is a stable identity field of a report.
+type identityField struct {
+	name  string
+	value []byte
+}
+
+// SameIdentity reports whether attestation reports, each in binarypb or textproto format, come
+// from the same machine: the same AK and, for TEE reports, the same SEV-SNP CHIP_ID or the same
+// TDX platform PPID. When they do not, the error is an *IdentityMismatchError naming the field
+// that differed. The reports are not verified, so each should be verified first.
+func SameIdentity(reports ...[]byte) (bool, error) {
+	if len(reports) == 0 {
+		return false, fmt.Errorf("no attestation reports to compare")
+	}
+	var first []identityField
+	for i, data := range reports {
+		attestation, err := unmarshalAnyAttestation(data)
+		if err != nil {
+			return false, fmt.Errorf("report %d: %w", i, err)
+		}
+		identity, err := reportIdentity(attestation)
+		if err != nil {
+			return false, fmt.Errorf("report %d: %w", i, err)
+		}
+		if i == 0 {
+			first = identity
+			continue
+		}
+		// Reports with the same TEE technology have the same fields.
+		for j, field := range identity {
+			if !bytes.Equal(field.value, first[j].value) {
+				return false, &IdentityMismatchError{Field: field.name, Index: i}
+			}
+		}
+	}
+	return true, nil
+}
+
+// unmarshalAnyAttestation parses a report in textproto format, or in binarypb format if it is not
+// valid textproto.
+func unmarshalAnyAttestation(data []byte) (*pb.Attestation, error) {
+	if attestation, err := unmarshalAttestation(data, "textproto"); err == nil {
+		return attestation, nil
+	}
+	return unmarshalAttestation(data, "binarypb")
+}
+
+// reportIdentity returns the identity fields of attestation, in the order they are compared.
+func reportIdentity(attestation *pb.Attestation) ([]identityField, error) {
+	if len(attestation.GetAkPub()) == 0 {
+		return nil, fmt.Errorf("attestation does not contain an AK")
+	}
+	identity := []identityField{
+		{name: "AK", value: attestation.GetAkPub()},
+		{name: "TEE", value: []byte(teeTechnology(attestation))},
+	}
+
+	switch tee := attestation.GetTeeAttestation().(type) {
+	case *pb.Attestation_SevSnpAttestation:
+		chipID := tee.SevSnpAttestation.GetReport().GetChipId()
+		if len(chipID) == 0 {
+			return nil, fmt.Errorf("SEV-SNP report does not contain a CHIP_ID")
+		}
+		identity = append(identity, identityField{name: "CHIP_ID", value: chipID})
+	case *pb.Attestation_TdxAttestation:
+		chain, err := pckCertificateChain(tee.TdxAttestation)
+		if err != nil {
+			return nil, err
+		}
+		exts, err := pcs.PckCertificateExtensions(chain[0])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse PCK certificate extensions: %v", err)
+		}
+		identity = append(identity, identityField{name: "PPID", value: []byte(exts.PPID)})
+	}
+	return identity, nil
+}
@@ -0,0 +1,115 @@
+package attestation
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/google/go-tpm-tools/proto/attest"
+)
+
+// InstanceInfoProvider supplies the GCE instance information attached to gceAK attestations
+type InstanceInfoProvider interface {
+	InstanceInfo(ctx context.Context) (*attest.GCEInstanceInfo, error)
+}
+
+// MetadataInstanceInfoProvider reads instance information from the GCE metadata server
+type MetadataInstanceInfoProvider struct {
+	// Client is the metadata client to query. Defaults to a client for the local metadata server.
+	Client *metadata.Client
+}
+
+// InstanceInfo fetches the project, zone and instance of the running VM.
+//
+// Values are read directly rather than through the metadata package's cached helpers, so that
+// clients pointed at different servers never share results.
+func (p *MetadataInstanceInfoProvider) InstanceInfo(ctx context.Context) (*attest.GCEInstanceInfo, error) {
+	c := p.Client
+	if c == nil {
+		c = metadata.NewClient(nil)
+	}
+	var err error
+	instanceInfo := &attest.GCEInstanceInfo{}
+
+	instanceInfo.ProjectId, err = getMetadata(ctx, c, "project/project-id")
+	if err != nil {
+		return nil, err
+	}
+
+	projectNumber, err := getMetadata(ctx, c, "project/numeric-project-id")
+	if err != nil {
+		return nil, err
+	}
+	instanceInfo.ProjectNumber, err = strconv.ParseUint(projectNumber, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid project number %q: %v", projectNumber, err)
+	}
+
+	// The zone is returned as projects/<number>/zones/<zone>.
+	zone, err := getMetadata(ctx, c, "instance/zone")
+	if err != nil {
+		return nil, err
+	}
+	instanceInfo.Zone = zone[strings.LastIndex(zone, "/")+1:]
+
+	instanceID, err := getMetadata(ctx, c, "instance/id")
+	if err != nil {
+		return nil, err
+	}
+	instanceInfo.InstanceId, err = strconv.ParseUint(instanceID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid instance ID %q: %v", instanceID, err)
+	}
+
+	instanceInfo.InstanceName, err = getMetadata(ctx, c, "instance/name")
+	if err != nil {
+		return nil, err
+	}
+
+	return instanceInfo, nil
+}
+
+func getMetadata(ctx context.Context, c *metadata.Client, suffix string) (string, error) {
+	value, err := c.GetWithContext(ctx, suffix)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from metadata server: %w", suffix, err)
+	}
+	return strings.TrimSpace(value), nil
+}
+
+// checkInstanceInfo fails unless the verified machine state identifies a GCE instance. Only the
+// gceAK certificate attests instance information, so it is never present without verifyGceAKCert.
+func checkInstanceInfo(ms *attest.MachineState, verifyGceAKCert bool) error {
+	if ms.GetPlatform().GetInstanceInfo() != nil {
+		return nil
+	}
+	if !verifyGceAKCert {
+		return fmt.Errorf("instance information is only attested by the gceAK certificate, which is not verified without VerifyGceAKCert")
+	}
+	return fmt.Errorf("gceAK certificate does not contain instance information")
+}
+
+// InstanceIdentity identifies a GCE instance by its project and name
+type InstanceIdentity struct {
+	// ProjectID is the ID of the project of the instance
+	ProjectID string `json:"projectId"`
+	// InstanceName is the name of the instance
+	InstanceName string `json:"instanceName"`
+}
+
+// checkAllowedInstances checks that the gceAK certificate of a verified machine state names one of
+// the allowed instances, and returns it.
+func checkAllowedInstances(ms *attest.MachineState, allowed []InstanceIdentity, verifyGceAKCert bool) (*InstanceIdentity, error) {
+	if err := checkInstanceInfo(ms, verifyGceAKCert); err != nil {
+		return nil, err
+	}
+	info := ms.GetPlatform().GetInstanceInfo()
+	for i := range allowed {
+		if allowed[i].ProjectID == info.GetProjectId() && allowed[i].InstanceName == info.GetInstanceName() {
+			return &allowed[i], nil
+		}
+	}
+	return nil, fmt.Errorf("instance %s in project %s is not one of the %d allowed instances", info.GetInstanceName(), info.GetProjectId(), len(allowed))
+}
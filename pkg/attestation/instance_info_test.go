@@ -0,0 +1,107 @@
+package attestation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-tpm-tools/client"
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+	"google.golang.org/protobuf/proto"
+
+	"lunal-attestation/pkg/attestation/metadatatest"
+)
+
+// testInstanceInfo is the instance information served by the fake metadata servers of the tests.
+func testInstanceInfo() *pb.GCEInstanceInfo {
+	return &pb.GCEInstanceInfo{
+		Zone:          "us-central1-a",
+		ProjectId:     "test-project",
+		ProjectNumber: 123456789,
+		InstanceName:  "test-instance",
+		InstanceId:    987654321,
+	}
+}
+
+func TestMetadataInstanceInfoProvider(t *testing.T) {
+	info := testInstanceInfo()
+	srv := metadatatest.NewServer(info)
+	defer srv.Close()
+
+	// The zone is served as projects/<number>/zones/<zone>.
+	provider := &MetadataInstanceInfoProvider{Client: srv.Client()}
+	got, err := provider.InstanceInfo(t.Context())
+	if err != nil {
+		t.Fatalf("InstanceInfo() failed: %v", err)
+	}
+	if !proto.Equal(got, info) {
+		t.Errorf("InstanceInfo() = %v, want %v", got, info)
+	}
+
+	// Values are not cached between calls.
+	moved := testInstanceInfo()
+	moved.Zone = "europe-west4-b"
+	srv.SetInstanceInfo(moved)
+	if got, err := provider.InstanceInfo(t.Context()); err != nil || !proto.Equal(got, moved) {
+		t.Errorf("InstanceInfo() after a move = %v, %v, want %v", got, err, moved)
+	}
+
+	// Without a client, the provider queries the server of GCE_METADATA_HOST.
+	t.Setenv("GCE_METADATA_HOST", srv.Host())
+	if got, err := (&MetadataInstanceInfoProvider{}).InstanceInfo(t.Context()); err != nil || !proto.Equal(got, moved) {
+		t.Errorf("InstanceInfo() of GCE_METADATA_HOST = %v, %v, want %v", got, err, moved)
+	}
+
+	down := metadatatest.NewServer(info)
+	client := down.Client()
+	down.Close()
+	if _, err := (&MetadataInstanceInfoProvider{Client: client}).InstanceInfo(t.Context()); err == nil || !strings.Contains(err.Error(), "failed to read project/project-id") {
+		t.Errorf("InstanceInfo() of a stopped server = %v, want a read error", err)
+	}
+}
+
+func TestAttestGceAKInstanceInfo(t *testing.T) {
+	sim := newTestSimulator(t)
+	defer sim.Close()
+	// Provision the GCE AK template, as GCE does. Without a certificate, nothing attests the
+	// instance information.
+	template, err := client.AKTemplateRSA().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	attributes := tpm2.AttrOwnerRead | tpm2.AttrOwnerWrite
+	if err := tpm2.NVDefineSpace(sim, tpm2.HandleOwner, tpmutil.Handle(client.GceAKTemplateNVIndexRSA), "", "", nil, attributes, uint16(len(template))); err != nil {
+		t.Fatal(err)
+	}
+	if err := tpm2.NVWrite(sim, tpm2.HandleOwner, tpmutil.Handle(client.GceAKTemplateNVIndexRSA), "", template, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	info := testInstanceInfo()
+	srv := metadatatest.NewServer(info)
+	defer srv.Close()
+	nonce := []byte("gce-ak-instance-info-nonce")
+	opts := DefaultAttestOptions()
+	opts.Key = KeyGceAK
+	opts.InstanceInfoProvider = &MetadataInstanceInfoProvider{Client: srv.Client()}
+	opts.TPM = sim
+	report := attestWithSimulator(t, opts, nonce)
+
+	attestation, err := unmarshalAttestation(report, "binarypb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(attestation.GetInstanceInfo(), info) {
+		t.Errorf("attached instance information = %v, want %v", attestation.GetInstanceInfo(), info)
+	}
+
+	verifyOpts := simulatorVerifyOptions(nonce)
+	if _, err := VerifyAttestationContext(t.Context(), report, verifyOpts); err != nil {
+		t.Fatalf("VerifyAttestationContext() failed: %v", err)
+	}
+	verifyOpts.RequireInstanceInfo = true
+	if _, err := VerifyAttestationContext(t.Context(), report, verifyOpts); err == nil || !strings.Contains(err.Error(), "only attested by the gceAK certificate") {
+		t.Errorf("VerifyAttestationContext() with RequireInstanceInfo = %v, want an error about the unverified certificate", err)
+	}
+}
@@ -0,0 +1,154 @@
+package attestation
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// Integrity monitoring categories, as reported by GCE Shielded VM integrity monitoring
+const (
+	// IntegrityEarlyBoot covers the firmware, up to the hand-off to the boot loader
+	IntegrityEarlyBoot = "earlyBoot"
+	// IntegrityLateBoot covers the boot loader, up to the hand-off to the kernel
+	IntegrityLateBoot = "lateBoot"
+	// IntegrityMLE covers the measured launch environment of a dynamic root of trust
+	IntegrityMLE = "mle"
+)
+
+// IntegrityPCRs are the PCRs NewIntegrityBaseline records for each integrity monitoring category.
+var IntegrityPCRs = map[string][]uint32{
+	IntegrityEarlyBoot: {0},
+	IntegrityLateBoot:  {4, 7, 8, 9},
+	IntegrityMLE:       {17, 18},
+}
+
+// integrityCategories lists the integrity monitoring categories in the order they are checked.
+var integrityCategories = []string{IntegrityEarlyBoot, IntegrityLateBoot, IntegrityMLE}
+
+// IntegrityBaseline is a GCE-style integrity monitoring baseline: the PCR values expected in the
+// verified PCR bank, grouped by boot category. A category without values is not checked.
+type IntegrityBaseline struct {
+	// EarlyBoot holds the expected early boot PCRs
+	EarlyBoot map[uint32]HexBytes `json:"earlyBoot,omitempty"`
+	// LateBoot holds the expected late boot PCRs
+	LateBoot map[uint32]HexBytes `json:"lateBoot,omitempty"`
+	// MLE holds the expected measured launch environment PCRs
+	MLE map[uint32]HexBytes `json:"mle,omitempty"`
+}
+
+// category returns the expected PCRs of an integrity monitoring category.
+func (b *IntegrityBaseline) category(name string) *map[uint32]HexBytes {
+	switch name {
+	case IntegrityEarlyBoot:
+		return &b.EarlyBoot
+	case IntegrityLateBoot:
+		return &b.LateBoot
+	default:
+		return &b.MLE
+	}
+}
+
+// IntegrityResult is the outcome of checking one category of an IntegrityBaseline
+type IntegrityResult struct {
+	// Category is IntegrityEarlyBoot, IntegrityLateBoot or IntegrityMLE
+	Category string `json:"category"`
+	// Passed reports that every PCR of the category matched the baseline
+	Passed bool `json:"passed"`
+	// Drift describes each PCR that differs from the baseline
+	Drift []string `json:"drift,omitempty"`
+}
+
+// IntegrityDriftError is returned for a report whose PCRs differ from the IntegrityBaseline in at
+// least one category
+type IntegrityDriftError struct {
+	// Results holds the result of every checked category, including those that passed
+	Results []IntegrityResult
+}
+
+func (e *IntegrityDriftError) Error() string {
+	var drifted []string
+	for _, result := range e.Results {
+		if !result.Passed {
+			drifted = append(drifted, fmt.Sprintf("%s drifted: %s", result.Category, strings.Join(result.Drift, "; ")))
+		}
+	}
+	return strings.Join(drifted, ", ")
+}
+
+// Drifted returns the categories that differ from the baseline.
+func (e *IntegrityDriftError) Drifted() []string {
+	var drifted []string
+	for _, result := range e.Results {
+		if !result.Passed {
+			drifted = append(drifted, result.Category)
+		}
+	}
+	return drifted
+}
+
+// NewIntegrityBaseline takes an integrity baseline of the IntegrityPCRs of a verified report, as
+// GCE does when it learns a baseline from the latest boot.
+func NewIntegrityBaseline(report *VerificationReport) (*IntegrityBaseline, error) {
+	pcrs, err := verifiedPCRs(report.Attestation, report.MachineState)
+	if err != nil {
+		return nil, err
+	}
+	baseline := &IntegrityBaseline{}
+	for _, name := range integrityCategories {
+		values := make(map[uint32]HexBytes, len(IntegrityPCRs[name]))
+		for _, index := range IntegrityPCRs[name] {
+			value, ok := pcrs[index]
+			if !ok {
+				return nil, fmt.Errorf("attestation does not quote PCR %d", index)
+			}
+			values[index] = value
+		}
+		*baseline.category(name) = values
+	}
+	return baseline, nil
+}
+
+// checkIntegrityBaseline checks the verified PCRs of an attestation against each category of
+// baseline. The results are returned whether or not a category drifted.
+func checkIntegrityBaseline(baseline *IntegrityBaseline, attestation *pb.Attestation, ms *pb.MachineState) ([]IntegrityResult, error) {
+	pcrs, err := verifiedPCRs(attestation, ms)
+	if err != nil {
+		return nil, err
+	}
+	var results []IntegrityResult
+	drifted := false
+	for _, name := range integrityCategories {
+		expected := *baseline.category(name)
+		if len(expected) == 0 {
+			continue
+		}
+		indices := make([]uint32, 0, len(expected))
+		for index := range expected {
+			indices = append(indices, index)
+		}
+		sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+
+		result := IntegrityResult{Category: name, Passed: true}
+		for _, index := range indices {
+			got, ok := pcrs[index]
+			if !ok {
+				result.Drift = append(result.Drift, fmt.Sprintf("PCR %d is not quoted", index))
+			} else if !bytes.Equal(got, expected[index]) {
+				result.Drift = append(result.Drift, fmt.Sprintf("PCR %d is %x, baseline is %s", index, got, expected[index]))
+			}
+		}
+		if len(result.Drift) != 0 {
+			result.Passed = false
+			drifted = true
+		}
+		results = append(results, result)
+	}
+	if drifted {
+		return results, &IntegrityDriftError{Results: results}
+	}
+	return results, nil
+}
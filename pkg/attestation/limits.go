@@ -0,0 +1,199 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Resource limits of DefaultVerifyOptions. They leave ample room for real reports while bounding
+// the memory and CPU that a crafted report can make verification spend.
+const (
+	// DefaultMaxAttestationSize bounds attestation reports, attached event log included. Reports
+	// with a TEE attestation and event log are tens of kilobytes, and the event logs of hosts with
+	// many boot components a few hundred.
+	DefaultMaxAttestationSize = 16 << 20
+	// DefaultMaxEventLogEntries bounds the events of the TCG event log, typically a few hundred
+	DefaultMaxEventLogEntries = 10000
+	// DefaultMaxCollateralSize bounds each TEE collateral document. Certificates, CRLs, TCB info
+	// and QE identities are a few kilobytes.
+	DefaultMaxCollateralSize = 4 << 20
+)
+
+// LimitError is returned when an input of a verification exceeds a resource limit of
+// VerifyOptions
+type LimitError struct {
+	// Input describes the input, e.g. "attestation report"
+	Input string
+	// Limit is the name of the exceeded option, e.g. MaxAttestationSize
+	Limit string
+	// Max is the value of the option
+	Max int64
+	// Size is the size of the input, or the size at which reading it stopped
+	Size int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s of %d exceeds %s of %d", e.Input, e.Size, e.Limit, e.Max)
+}
+
+// checkAttestationSize enforces opts.MaxAttestationSize on a report and its detached event log.
+func checkAttestationSize(attestationBytes []byte, opts VerifyOptions) error {
+	size := int64(len(attestationBytes) + len(opts.EventLog))
+	if opts.MaxAttestationSize > 0 && size > opts.MaxAttestationSize {
+		return &LimitError{Input: "attestation report", Limit: "MaxAttestationSize", Max: opts.MaxAttestationSize, Size: size}
+	}
+	return nil
+}
+
+// checkEventLogEntries enforces max on the number of events of a TCG event log.
+func checkEventLogEntries(eventLog []byte, max int) error {
+	if max <= 0 {
+		return nil
+	}
+	if n := countEventLogEntries(eventLog, max); n > max {
+		return &LimitError{Input: "event log", Limit: "MaxEventLogEntries", Max: int64(max), Size: int64(n)}
+	}
+	return nil
+}
+
+// specIDSignature begins the Spec ID event, the first event of a crypto-agile event log.
+var specIDSignature = []byte("Spec ID Event03\x00")
+
+// countEventLogEntries counts the events of a TCG event log, in the SHA-1 or crypto-agile format,
+// stopping after max+1. Counting stops at the first malformed event, which replaying the log
+// rejects.
+func countEventLogEntries(eventLog []byte, max int) int {
+	// The first event has the SHA-1 format: PCR index, event type, SHA-1 digest, event size.
+	const sha1HeaderSize = 4 + 4 + 20 + 4
+	if len(eventLog) < sha1HeaderSize {
+		return 0
+	}
+	size := int64(binary.LittleEndian.Uint32(eventLog[sha1HeaderSize-4:]))
+	if size > int64(len(eventLog)-sha1HeaderSize) {
+		return 0
+	}
+	specID := eventLog[sha1HeaderSize : sha1HeaderSize+int(size)]
+	rest := eventLog[sha1HeaderSize+int(size):]
+	count := 1
+
+	if !bytes.HasPrefix(specID, specIDSignature) {
+		for count <= max && len(rest) >= sha1HeaderSize {
+			size := int64(binary.LittleEndian.Uint32(rest[sha1HeaderSize-4:]))
+			if size > int64(len(rest)-sha1HeaderSize) {
+				break
+			}
+			rest = rest[sha1HeaderSize+int(size):]
+			count++
+		}
+		return count
+	}
+
+	// The Spec ID event lists the digest size of each algorithm after the platform class, spec
+	// version and uintn size.
+	if len(specID) < len(specIDSignature)+4+4+4 {
+		return count
+	}
+	algorithms := specID[len(specIDSignature)+4+4:]
+	numAlgorithms := int(binary.LittleEndian.Uint32(algorithms))
+	algorithms = algorithms[4:]
+	if numAlgorithms > len(algorithms)/4 {
+		return count
+	}
+	digestSizes := make(map[uint16]int, numAlgorithms)
+	for i := 0; i < numAlgorithms; i++ {
+		digestSizes[binary.LittleEndian.Uint16(algorithms[4*i:])] = int(binary.LittleEndian.Uint16(algorithms[4*i+2:]))
+	}
+
+	// Later events: PCR index, event type, digest count, then each algorithm and digest, then the
+	// event size and data.
+	for count <= max && len(rest) >= 12 {
+		digests := binary.LittleEndian.Uint32(rest[8:])
+		offset := 12
+		for i := uint32(0); i < digests; i++ {
+			if len(rest) < offset+2 {
+				return count
+			}
+			digestSize, ok := digestSizes[binary.LittleEndian.Uint16(rest[offset:])]
+			if !ok {
+				return count
+			}
+			offset += 2 + digestSize
+		}
+		if len(rest) < offset+4 {
+			break
+		}
+		size := int64(binary.LittleEndian.Uint32(rest[offset:]))
+		if size > int64(len(rest)-offset-4) {
+			break
+		}
+		rest = rest[offset+4+int(size):]
+		count++
+	}
+	return count
+}
+
+// limitedCollateral fails for collateral documents over max bytes, and remembers the first such
+// failure, which TEE verification libraries do not wrap. Without a source, it bounds the default
+// collateral getters of go-sev-guest and go-tdx-guest, keeping their retries and timeouts.
+type limitedCollateral struct {
+	source collateralSource
+	max    int64
+
+	mu       sync.Mutex
+	exceeded *LimitError
+}
+
+func (l *limitedCollateral) get(ctx context.Context, url string) (map[string][]string, []byte, error) {
+	header, body, err := l.source.get(ctx, url)
+	return l.check(url, header, body, err)
+}
+
+// check fails for a document of url over the limit, whether it was read in full or its source
+// failed with a LimitError.
+func (l *limitedCollateral) check(url string, header map[string][]string, body []byte, err error) (map[string][]string, []byte, error) {
+	var limitErr *LimitError
+	if err == nil && int64(len(body)) > l.max {
+		limitErr = &LimitError{Input: "collateral document " + url, Limit: "MaxCollateralSize", Max: l.max, Size: int64(len(body))}
+		err = limitErr
+	} else if !errors.As(err, &limitErr) {
+		return header, body, err
+	}
+	l.mu.Lock()
+	if l.exceeded == nil {
+		l.exceeded = limitErr
+	}
+	l.mu.Unlock()
+	return nil, nil, err
+}
+
+// fetchTruncated fetches url as the default getters of the TEE libraries do, but reads at most
+// l.max+1 bytes of the document. A longer document is returned truncated rather than failing, so
+// that the retrying getters wrapping it do not fetch it again, and check then rejects it.
+func (l *limitedCollateral) fetchTruncated(url string) (map[string][]string, []byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("failed to retrieve %s, status code received %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, l.max+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Header, body, nil
+}
+
+// exceededLimit returns the first document over the limit, if any.
+func (l *limitedCollateral) exceededLimit() *LimitError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.exceeded
+}
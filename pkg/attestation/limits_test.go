@@ -0,0 +1,53 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDefaultCollateralGettersLimitDocuments(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write(bytes.Repeat([]byte{'c'}, 1024))
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		name string
+		get  func(limited *limitedCollateral) error
+	}{
+		{"tdx", func(limited *limitedCollateral) error {
+			_, _, err := tdxGetter(context.Background(), limited).Get(server.URL)
+			return err
+		}},
+		{"sev-snp", func(limited *limitedCollateral) error {
+			_, err := sevSnpGetter(context.Background(), limited).Get(server.URL)
+			return err
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			requests.Store(0)
+			if err := test.get(&limitedCollateral{max: 1024}); err != nil {
+				t.Errorf("Get() of a document at the limit failed: %v", err)
+			}
+
+			limited := &limitedCollateral{max: 100}
+			var limitErr *LimitError
+			if err := test.get(limited); !errors.As(err, &limitErr) {
+				t.Fatalf("Get() of a document over the limit = %v, want a *LimitError", err)
+			}
+			if limited.exceededLimit() == nil {
+				t.Error("exceededLimit() = nil after a document over the limit")
+			}
+			if n := requests.Load(); n != 2 {
+				t.Errorf("server received %d requests, want 2 without retries", n)
+			}
+		})
+	}
+}
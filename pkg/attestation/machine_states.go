@@ -0,0 +1,56 @@
+package attestation
+
+import (
+	"context"
+
+	"github.com/google/go-sev-guest/proto/sevsnp"
+	"github.com/google/go-tdx-guest/proto/tdx"
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"google.golang.org/protobuf/proto"
+)
+
+// MachineStates is a verified machine state split by the root of trust vouching for each part
+type MachineStates struct {
+	// TPMState is what the TPM quotes attest: the platform, PCRs, replayed boot events and
+	// secure boot state. Its TeeAttestation is not set.
+	TPMState *pb.MachineState
+	// TEEState is the TEE evidence, verified against the TEE vendor's roots, or nil if the
+	// attestation has none
+	TEEState *TEEState
+}
+
+// TEEState is the verified evidence of a TEE. Exactly one of Tdx and SevSnp is set.
+type TEEState struct {
+	// Technology is the TEE technology (sev-snp or tdx)
+	Technology string
+	// Tdx is the TDX quote, signed by the quoting enclave under Intel's roots
+	Tdx *tdx.QuoteV4
+	// SevSnp is the SEV-SNP report and certificates, signed under AMD's roots
+	SevSnp *sevsnp.Attestation
+}
+
+// SplitMachineState returns the machine state of report split into its TPM and TEE parts, which
+// report.MachineState combines. Both parts are copies. They are bound to each other only by the
+// nonce that the TPM quotes and the TEE report data carry, as VerifyAttestation checked.
+func SplitMachineState(report *VerificationReport) *MachineStates {
+	tpmState := proto.Clone(report.MachineState).(*pb.MachineState)
+	tpmState.TeeAttestation = nil
+	states := &MachineStates{TPMState: tpmState}
+	switch tee := report.MachineState.GetTeeAttestation().(type) {
+	case *pb.MachineState_TdxAttestation:
+		states.TEEState = &TEEState{Technology: Tdx, Tdx: proto.Clone(tee.TdxAttestation).(*tdx.QuoteV4)}
+	case *pb.MachineState_SevSnpAttestation:
+		states.TEEState = &TEEState{Technology: SevSnp, SevSnp: proto.Clone(tee.SevSnpAttestation).(*sevsnp.Attestation)}
+	}
+	return states
+}
+
+// VerifyAttestationSplit verifies a remote attestation report according to opts, like
+// VerifyAttestationWithOptions, and returns its machine state split by root of trust.
+func VerifyAttestationSplit(attestationBytes []byte, opts VerifyOptions) (*MachineStates, error) {
+	report, err := VerifyAttestationContext(context.Background(), attestationBytes, opts)
+	if err != nil {
+		return nil, err
+	}
+	return SplitMachineState(report), nil
+}
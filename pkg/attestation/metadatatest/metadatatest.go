@@ -0,0 +1,93 @@
+// Package metadatatest provides an in-memory fake of the GCE metadata server, so that code
+// attaching instance information to gceAK attestations can be tested off GCE.
+package metadatatest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/google/go-tpm-tools/proto/attest"
+)
+
+const metadataPrefix = "/computeMetadata/v1/"
+
+// Server is a fake metadata server serving the instance information of a single VM
+type Server struct {
+	srv *httptest.Server
+
+	mu   sync.Mutex
+	info *attest.GCEInstanceInfo
+}
+
+// NewServer starts a fake metadata server reporting info. Call Close when done.
+func NewServer(info *attest.GCEInstanceInfo) *Server {
+	s := &Server{info: info}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// SetInstanceInfo changes the instance information served by s
+func (s *Server) SetInstanceInfo(info *attest.GCEInstanceInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.info = info
+}
+
+// Host returns the host:port of s, suitable for the GCE_METADATA_HOST environment variable
+func (s *Server) Host() string {
+	return s.srv.Listener.Addr().String()
+}
+
+// Client returns a metadata client whose requests are all sent to s, regardless of
+// GCE_METADATA_HOST.
+func (s *Server) Client() *metadata.Client {
+	target, _ := url.Parse(s.srv.URL)
+	transport := s.srv.Client().Transport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, target.Host)
+	}
+	return metadata.NewClient(&http.Client{Transport: transport})
+}
+
+// Close shuts down s
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Metadata-Flavor") != "Google" {
+		http.Error(w, "missing Metadata-Flavor header", http.StatusForbidden)
+		return
+	}
+
+	s.mu.Lock()
+	info := s.info
+	s.mu.Unlock()
+
+	var value string
+	switch r.URL.Path {
+	case metadataPrefix + "project/project-id":
+		value = info.GetProjectId()
+	case metadataPrefix + "project/numeric-project-id":
+		value = strconv.FormatUint(info.GetProjectNumber(), 10)
+	case metadataPrefix + "instance/zone":
+		value = "projects/" + strconv.FormatUint(info.GetProjectNumber(), 10) + "/zones/" + info.GetZone()
+	case metadataPrefix + "instance/id":
+		value = strconv.FormatUint(info.GetInstanceId(), 10)
+	case metadataPrefix + "instance/name":
+		value = info.GetInstanceName()
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Metadata-Flavor", "Google")
+	w.Header().Set("Content-Type", "application/text")
+	w.Write([]byte(value))
+}
@@ -0,0 +1,340 @@
+package attestation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultMutualAttestationTimeout bounds a mutual attestation handshake when
+// MutualAttestation.Timeout is not set. Attesting and verifying collateral take a few seconds.
+const DefaultMutualAttestationTimeout = 30 * time.Second
+
+// DefaultMaxMutualHandshakes bounds the concurrent handshakes of a Listener when
+// MutualAttestation.MaxHandshakes is not set.
+const DefaultMaxMutualHandshakes = 64
+
+// mutualChallengeSize is the size of the challenge each endpoint issues.
+const mutualChallengeSize = 32
+
+// mutualAccepted is the payload of the frame a client sends once it accepted the server's report.
+var mutualAccepted = []byte{1}
+
+// MutualAttestation attests both endpoints of TLS connections to each other before they carry
+// application traffic. Each endpoint issues a random challenge, the peer attests over it and the
+// TLS channel binding in its own role, and each verifies the peer's report. A report can thus not
+// be replayed from another connection or reflected back to the endpoint that made it.
+type MutualAttestation struct {
+	// Attest configures the attestation of this endpoint. Its Nonce is set for each handshake.
+	Attest AttestOptions
+	// Verify is the policy the peer's report must satisfy. Its Nonce is set for each handshake, and
+	// the peer's TEE report data is bound to it unless AllowUnboundTeeReportData is set.
+	Verify VerifyOptions
+	// Timeout bounds each handshake. 0 means DefaultMutualAttestationTimeout.
+	Timeout time.Duration
+	// MaxHandshakes bounds the handshakes a Listener runs concurrently. Further connections wait in
+	// the backlog of the wrapped listener. 0 means DefaultMaxMutualHandshakes.
+	MaxHandshakes int
+}
+
+// AttestedConn is a TLS connection whose peer passed mutual attestation
+type AttestedConn struct {
+	*tls.Conn
+	// Report is the verified report of the peer
+	Report *VerificationReport
+}
+
+// ClientHandshake performs mutual attestation as the client of conn, completing its TLS handshake
+// first, and returns the verified report of the server.
+func (m *MutualAttestation) ClientHandshake(ctx context.Context, conn *tls.Conn) (*VerificationReport, error) {
+	return m.handshake(ctx, conn, ChannelClient)
+}
+
+// ServerHandshake performs mutual attestation as the server of conn, completing its TLS handshake
+// first, and returns the verified report of the client. The server only attests to clients whose
+// report it verified.
+func (m *MutualAttestation) ServerHandshake(ctx context.Context, conn *tls.Conn) (*VerificationReport, error) {
+	return m.handshake(ctx, conn, ChannelServer)
+}
+
+// handshake runs the exchange of role over conn. Messages are frames in the order below, so that
+// neither endpoint writes while the other does:
+//
+//	client -> server: client challenge
+//	server -> client: server challenge
+//	client -> server: client report over the server challenge
+//	server -> client: server report over the client challenge, once the client report verified
+//	client -> server: acceptance, once the server report verified
+func (m *MutualAttestation) handshake(ctx context.Context, conn *tls.Conn, role string) (*VerificationReport, error) {
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = DefaultMutualAttestationTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("TLS handshake failed: %v", err)
+	}
+	binding, err := ChannelBindingFromTLS(conn.ConnectionState())
+	if err != nil {
+		return nil, err
+	}
+	challenge := make([]byte, mutualChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge: %v", err)
+	}
+
+	frames := DefaultFrameOptions()
+	frames.MaxFrameSize = int(min(m.Verify.MaxAttestationSize, DefaultMaxFrameSize))
+	if frames.MaxFrameSize <= 0 {
+		frames.MaxFrameSize = DefaultMaxFrameSize
+	}
+	send := func(payload []byte) error { return WriteFrame(conn, payload, frames) }
+	receive := func(what string) ([]byte, error) {
+		payload, err := ReadFrame(conn, frames)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s of the peer: %w", what, err)
+		}
+		return payload, nil
+	}
+	receiveChallenge := func() ([]byte, error) {
+		peerChallenge, err := receive("challenge")
+		if err == nil && len(peerChallenge) != mutualChallengeSize {
+			err = fmt.Errorf("peer challenge has %d bytes, expected %d", len(peerChallenge), mutualChallengeSize)
+		}
+		return peerChallenge, err
+	}
+	peerRole := ChannelServer
+	if role == ChannelServer {
+		peerRole = ChannelClient
+	}
+
+	var peerChallenge []byte
+	if role == ChannelClient {
+		if err := send(challenge); err != nil {
+			return nil, err
+		}
+		if peerChallenge, err = receiveChallenge(); err != nil {
+			return nil, err
+		}
+		if err := m.sendReport(send, binding, peerChallenge, role); err != nil {
+			return nil, err
+		}
+		report, err := m.verifyPeer(ctx, receive, binding, challenge, peerRole)
+		if err != nil {
+			return nil, err
+		}
+		if err := send(mutualAccepted); err != nil {
+			return nil, err
+		}
+		return report, nil
+	}
+
+	if peerChallenge, err = receiveChallenge(); err != nil {
+		return nil, err
+	}
+	if err := send(challenge); err != nil {
+		return nil, err
+	}
+	report, err := m.verifyPeer(ctx, receive, binding, challenge, peerRole)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.sendReport(send, binding, peerChallenge, role); err != nil {
+		return nil, err
+	}
+	if _, err := receive("acceptance"); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// sendReport attests over the peer's challenge in role and sends the report.
+func (m *MutualAttestation) sendReport(send func([]byte) error, binding, peerChallenge []byte, role string) error {
+	opts := m.Attest
+	var err error
+	if opts.Nonce, err = ChannelNonce(mutualBinding(binding, peerChallenge), role); err != nil {
+		return err
+	}
+	report, err := Attest(opts)
+	if err != nil {
+		return fmt.Errorf("failed to attest: %w", err)
+	}
+	return send(report)
+}
+
+// verifyPeer receives the report of the peer in peerRole and verifies it over challenge.
+func (m *MutualAttestation) verifyPeer(ctx context.Context, receive func(string) ([]byte, error), binding, challenge []byte, peerRole string) (*VerificationReport, error) {
+	peerReport, err := receive("report")
+	if err != nil {
+		return nil, err
+	}
+	opts := m.Verify
+	if opts.Nonce, err = ChannelNonce(mutualBinding(binding, challenge), peerRole); err != nil {
+		return nil, err
+	}
+	report, err := VerifyAttestationContext(ctx, peerReport, opts)
+	if err != nil {
+		return nil, fmt.Errorf("verifying %s report: %w", peerRole, err)
+	}
+	return report, nil
+}
+
+// mutualBinding binds a TLS channel binding to the challenge an endpoint issued, each prefixed
+// with its length.
+func mutualBinding(binding, challenge []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(ChannelBindingLabel))
+	for _, value := range [][]byte{binding, challenge} {
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(value))))
+		h.Write(value)
+	}
+	return h.Sum(nil)
+}
+
+// Dialer returns a dial function that connects over TCP, completes a TLS handshake with config and
+// performs mutual attestation as the client, returning an *AttestedConn. Its signature is that of
+// grpc.WithContextDialer; the gRPC client then uses insecure transport credentials, as the
+// connection is already TLS. An empty config.ServerName is taken from the address.
+func (m *MutualAttestation) Dialer(config *tls.Config) func(context.Context, string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		config := config.Clone()
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if config.ServerName == "" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			config.ServerName = host
+		}
+		var dialer net.Dialer
+		raw, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		conn := tls.Client(raw, config)
+		report, err := m.ClientHandshake(ctx, conn)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("mutual attestation with %s failed: %w", addr, err)
+		}
+		return &AttestedConn{Conn: conn, Report: report}, nil
+	}
+}
+
+// Listener wraps ln so that Accept returns *AttestedConn connections whose clients completed a TLS
+// handshake with config and passed mutual attestation, as with grpc.Server.Serve and insecure
+// transport credentials. Up to MaxHandshakes handshakes run concurrently, and connections that
+// fail them are closed.
+func (m *MutualAttestation) Listener(ln net.Listener, config *tls.Config) net.Listener {
+	maxHandshakes := m.MaxHandshakes
+	if maxHandshakes <= 0 {
+		maxHandshakes = DefaultMaxMutualHandshakes
+	}
+	return &attestedListener{
+		Listener:   ln,
+		attest:     m,
+		config:     config,
+		conns:      make(chan *AttestedConn),
+		done:       make(chan struct{}),
+		handshakes: make(chan struct{}, maxHandshakes),
+	}
+}
+
+// attestedListener is the listener of MutualAttestation.Listener.
+type attestedListener struct {
+	net.Listener
+	attest *MutualAttestation
+	config *tls.Config
+	conns  chan *AttestedConn
+	done   chan struct{}
+	// handshakes holds a token for each handshake in progress
+	handshakes chan struct{}
+
+	start     sync.Once
+	closeOnce sync.Once
+	mu        sync.Mutex
+	err       error
+}
+
+// Accept returns the next connection that passed mutual attestation.
+func (l *attestedListener) Accept() (net.Conn, error) {
+	l.start.Do(func() { go l.acceptLoop() })
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.err != nil {
+			return nil, l.err
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener. Connections still in their handshake are closed as they complete it.
+func (l *attestedListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// acceptLoop accepts connections and attests them until the listener fails or is closed.
+func (l *attestedListener) acceptLoop() {
+	for {
+		select {
+		case l.handshakes <- struct{}{}:
+		case <-l.done:
+			return
+		}
+		raw, err := l.Listener.Accept()
+		if err != nil {
+			l.mu.Lock()
+			if l.err == nil && !errors.Is(err, net.ErrClosed) {
+				l.err = err
+			}
+			l.mu.Unlock()
+			l.closeOnce.Do(func() { close(l.done) })
+			return
+		}
+		go func() {
+			defer func() { <-l.handshakes }()
+			conn := tls.Server(raw, l.config)
+			finished := make(chan struct{})
+			go func() {
+				// Closing the listener aborts handshakes in progress.
+				select {
+				case <-l.done:
+					conn.Close()
+				case <-finished:
+				}
+			}()
+			report, err := l.attest.ServerHandshake(context.Background(), conn)
+			close(finished)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			select {
+			case l.conns <- &AttestedConn{Conn: conn, Report: report}:
+			case <-l.done:
+				conn.Close()
+			}
+		}()
+	}
+}
@@ -0,0 +1,226 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// NitroRootFingerprint is the SHA-256 fingerprint of the AWS Nitro Enclaves root certificate
+// (AWS_NitroEnclaves_Root-G1), as published by AWS. Attestation documents must chain to it unless
+// NitroVerifyOptions.TrustedRoots is set.
+const NitroRootFingerprint = "641a0321a3e244efe456463195d606317ed7cdcc3c1756e09893f3c68f79bb5b"
+
+// nitroDigest is the only digest algorithm of Nitro attestation documents.
+const nitroDigest = "SHA384"
+
+// NitroVerifyOptions holds the requirements of an AWS Nitro Enclaves attestation document
+type NitroVerifyOptions struct {
+	// ExpectedPCRs maps PCR indices to their expected SHA-384 values. PCRs not listed are not
+	// checked.
+	ExpectedPCRs map[uint32]HexBytes `json:"expectedPCRs,omitempty"`
+	// ExpectedUserData is the user data the enclave must have attested, if set
+	ExpectedUserData HexBytes `json:"expectedUserData,omitempty"`
+	// TrustedRoots are the accepted roots of the document's certificate bundle. Defaults to the
+	// AWS Nitro Enclaves root, identified by NitroRootFingerprint. Not encoded as JSON.
+	TrustedRoots []*x509.Certificate `json:"-"`
+	// VerificationTime is the time at which certificates are checked. Defaults to the current time.
+	VerificationTime time.Time `json:"verificationTime,omitempty"`
+}
+
+// DefaultNitroVerifyOptions returns NitroVerifyOptions that trust the AWS Nitro Enclaves root
+// and check no PCRs.
+func DefaultNitroVerifyOptions() NitroVerifyOptions {
+	return NitroVerifyOptions{
+		ExpectedPCRs:     nil,
+		ExpectedUserData: nil,
+		TrustedRoots:     nil,
+		VerificationTime: time.Time{},
+	}
+}
+
+// NitroMachineState is the verified content of an AWS Nitro Enclaves attestation document
+type NitroMachineState struct {
+	// ModuleID identifies the Nitro Secure Module that issued the document
+	ModuleID string
+	// Timestamp is when the document was issued
+	Timestamp time.Time
+	// PCRs maps PCR indices to their SHA-384 values
+	PCRs map[uint32]HexBytes
+	// Certificate is the certificate of the Nitro Secure Module that signed the document
+	Certificate *x509.Certificate
+	// PublicKey is the public key the enclave included in the document, if any
+	PublicKey HexBytes
+	// UserData is the user data the enclave included in the document, if any
+	UserData HexBytes
+	// Nonce is the nonce of the document
+	Nonce HexBytes
+}
+
+// nitroDocument is the payload of a Nitro attestation document.
+type nitroDocument struct {
+	ModuleID    string            `cbor:"module_id"`
+	Digest      string            `cbor:"digest"`
+	Timestamp   uint64            `cbor:"timestamp"`
+	PCRs        map[uint32][]byte `cbor:"pcrs"`
+	Certificate []byte            `cbor:"certificate"`
+	CABundle    [][]byte          `cbor:"cabundle"`
+	PublicKey   []byte            `cbor:"public_key"`
+	UserData    []byte            `cbor:"user_data"`
+	Nonce       []byte            `cbor:"nonce"`
+}
+
+// VerifyNitroAttestation verifies an AWS Nitro Enclaves attestation document: its COSE_Sign1
+// signature by the certificate of the Nitro Secure Module, the chain of that certificate through
+// the document's CA bundle to the AWS Nitro root, that it holds nonce, and the expected PCRs and
+// user data of opts.
+func VerifyNitroAttestation(doc []byte, nonce []byte, opts NitroVerifyOptions) (*NitroMachineState, error) {
+	if len(nonce) == 0 {
+		return nil, fmt.Errorf("a nonce is required to verify a Nitro attestation document")
+	}
+	protected, payload, signature, err := parseNitroSign1(doc)
+	if err != nil {
+		return nil, err
+	}
+	var document nitroDocument
+	if err := cbor.Unmarshal(payload, &document); err != nil {
+		return nil, fmt.Errorf("failed to decode Nitro attestation document: %v", err)
+	}
+	if document.Digest != nitroDigest {
+		return nil, fmt.Errorf("Nitro attestation document has digest %q, expected %s", document.Digest, nitroDigest)
+	}
+
+	now := opts.VerificationTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+	cert, err := verifyNitroCertificates(&document, opts.TrustedRoots, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var header map[any]any
+	if err := cbor.Unmarshal(protected, &header); err != nil {
+		return nil, fmt.Errorf("failed to decode Nitro attestation document protected header: %v", err)
+	}
+	alg, ok := header[uint64(coseHeaderAlg)].(int64)
+	if !ok {
+		return nil, fmt.Errorf("Nitro attestation document protected header does not contain a signature algorithm")
+	}
+	toBeSigned, err := cbor.Marshal([]any{"Signature1", protected, []byte{}, payload})
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyCOSESignature(cert.PublicKey, alg, toBeSigned, signature); err != nil {
+		return nil, fmt.Errorf("Nitro attestation document signature: %w", err)
+	}
+
+	if !bytes.Equal(document.Nonce, nonce) {
+		return nil, fmt.Errorf("Nitro attestation document nonce %x does not match the expected nonce", document.Nonce)
+	}
+	if opts.ExpectedUserData != nil && !bytes.Equal(document.UserData, opts.ExpectedUserData) {
+		return nil, fmt.Errorf("Nitro attestation document user data %x does not match the expected %s", document.UserData, opts.ExpectedUserData)
+	}
+
+	state := &NitroMachineState{
+		ModuleID:    document.ModuleID,
+		Timestamp:   time.UnixMilli(int64(document.Timestamp)).UTC(),
+		PCRs:        make(map[uint32]HexBytes, len(document.PCRs)),
+		Certificate: cert,
+		PublicKey:   document.PublicKey,
+		UserData:    document.UserData,
+		Nonce:       document.Nonce,
+	}
+	for index, value := range document.PCRs {
+		state.PCRs[index] = value
+	}
+	indices := make([]uint32, 0, len(opts.ExpectedPCRs))
+	for index := range opts.ExpectedPCRs {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	for _, index := range indices {
+		expected := opts.ExpectedPCRs[index]
+		got, ok := state.PCRs[index]
+		if !ok {
+			return nil, fmt.Errorf("Nitro attestation document has no PCR %d", index)
+		}
+		if !bytes.Equal(got, expected) {
+			return nil, fmt.Errorf("PCR %d is %s, expected %s", index, got, expected)
+		}
+	}
+	return state, nil
+}
+
+// parseNitroSign1 splits a COSE_Sign1 structure, tagged or not, into its protected header, payload
+// and signature.
+func parseNitroSign1(doc []byte) ([]byte, []byte, []byte, error) {
+	var sign1 []cbor.RawMessage
+	if err := cbor.Unmarshal(doc, &sign1); err != nil {
+		var tagged cbor.RawTag
+		if tagErr := cbor.Unmarshal(doc, &tagged); tagErr != nil || tagged.Number != corimSignedTag {
+			return nil, nil, nil, fmt.Errorf("Nitro attestation document is not a COSE_Sign1 structure: %v", err)
+		}
+		if err := cbor.Unmarshal(tagged.Content, &sign1); err != nil {
+			return nil, nil, nil, fmt.Errorf("Nitro attestation document is not a COSE_Sign1 structure: %v", err)
+		}
+	}
+	if len(sign1) != 4 {
+		return nil, nil, nil, fmt.Errorf("Nitro attestation document is not a COSE_Sign1 structure")
+	}
+	var protected, payload, signature []byte
+	if cbor.Unmarshal(sign1[0], &protected) != nil || cbor.Unmarshal(sign1[2], &payload) != nil || cbor.Unmarshal(sign1[3], &signature) != nil || payload == nil {
+		return nil, nil, nil, fmt.Errorf("Nitro attestation document is not a COSE_Sign1 structure with an attached payload")
+	}
+	return protected, payload, signature, nil
+}
+
+// verifyNitroCertificates verifies the certificate of a document through its CA bundle, which
+// lists the root first, and returns it. Without roots, the root of the bundle must be the AWS
+// Nitro Enclaves root.
+func verifyNitroCertificates(document *nitroDocument, roots []*x509.Certificate, now time.Time) (*x509.Certificate, error) {
+	cert, err := x509.ParseCertificate(document.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Nitro attestation document certificate: %v", err)
+	}
+	if len(document.CABundle) == 0 {
+		return nil, fmt.Errorf("Nitro attestation document has an empty CA bundle")
+	}
+	bundle := make([]*x509.Certificate, len(document.CABundle))
+	for i, der := range document.CABundle {
+		if bundle[i], err = x509.ParseCertificate(der); err != nil {
+			return nil, fmt.Errorf("failed to parse Nitro attestation document CA bundle: %v", err)
+		}
+	}
+	if len(roots) == 0 {
+		fingerprint := sha256.Sum256(bundle[0].Raw)
+		if hex.EncodeToString(fingerprint[:]) != NitroRootFingerprint {
+			return nil, fmt.Errorf("Nitro attestation document CA bundle does not start with the AWS Nitro Enclaves root")
+		}
+		roots = bundle[:1]
+	}
+
+	rootPool := x509.NewCertPool()
+	for _, root := range roots {
+		rootPool.AddCert(root)
+	}
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range bundle[1:] {
+		intermediates.AddCert(intermediate)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         rootPool,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to verify Nitro attestation document certificate: %v", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,79 @@
+package attestation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// MinNonceSize is the minimum nonce length, in bytes, accepted by ValidateNonce. 16 bytes gives
+// 128 bits of freshness when the nonce is drawn from a cryptographically secure source.
+const MinNonceSize = 16
+
+// MaxNonceSize is the maximum nonce and TEE nonce length, in bytes, accepted by Attest and
+// verification. It is the size of the SEV-SNP and TDX report data, which a nonce fills when no TEE
+// nonce is given, and fits the qualifying data of TPMs that implement SHA-512. Longer nonces would
+// be truncated in TEE reports or rejected by the TPM, so they fail with ErrNonceTooLong instead.
+// Hash a longer value, e.g. with SHA-512, to use it as a nonce.
+const MaxNonceSize = 64
+
+// maxNoncePatternSize is the longest repeating pattern ValidateNonce treats as low entropy.
+const maxNoncePatternSize = 4
+
+// ErrWeakNonce is returned by ValidateNonce for nonces that are too short or obviously predictable.
+var ErrWeakNonce = errors.New("weak nonce")
+
+// ErrNonceTooLong is returned for nonces longer than MaxNonceSize.
+var ErrNonceTooLong = errors.New("nonce too long")
+
+// ValidateNonce rejects nonces that are shorter than MinNonceSize or made of a single repeated
+// byte or short repeated pattern (such as all zeros). It cannot detect a nonce that is merely
+// reused, so nonces should still be freshly generated with crypto/rand for every attestation.
+//
+// Attest and VerifyAttestationWithOptions only call ValidateNonce when StrictNonce is set, so
+// deterministic nonces remain usable for tests and fixtures.
+func ValidateNonce(nonce []byte) error {
+	if len(nonce) < MinNonceSize {
+		return fmt.Errorf("%w: got %d bytes, want at least %d", ErrWeakNonce, len(nonce), MinNonceSize)
+	}
+	for size := 1; size <= maxNoncePatternSize; size++ {
+		if isRepeatedPattern(nonce, size) {
+			return fmt.Errorf("%w: nonce repeats a %d-byte pattern", ErrWeakNonce, size)
+		}
+	}
+	return nil
+}
+
+// isRepeatedPattern reports whether b consists of its first size bytes repeated.
+func isRepeatedPattern(b []byte, size int) bool {
+	for i := size; i < len(b); i += size {
+		if !bytes.HasPrefix(b[i:], b[:min(size, len(b)-i)]) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateNonces validates the nonce and, if one is given, the TEE nonce.
+func validateNonces(nonce []byte, teeNonce []byte) error {
+	if err := ValidateNonce(nonce); err != nil {
+		return fmt.Errorf("invalid nonce: %w", err)
+	}
+	if len(teeNonce) != 0 {
+		if err := ValidateNonce(teeNonce); err != nil {
+			return fmt.Errorf("invalid TEE nonce: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkNonceSizes rejects a nonce or TEE nonce longer than MaxNonceSize.
+func checkNonceSizes(nonce []byte, teeNonce []byte) error {
+	if len(nonce) > MaxNonceSize {
+		return fmt.Errorf("invalid nonce: %w: got %d bytes, want at most %d", ErrNonceTooLong, len(nonce), MaxNonceSize)
+	}
+	if len(teeNonce) > MaxNonceSize {
+		return fmt.Errorf("invalid TEE nonce: %w: got %d bytes, want at most %d", ErrNonceTooLong, len(teeNonce), MaxNonceSize)
+	}
+	return nil
+}
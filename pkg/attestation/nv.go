@@ -0,0 +1,266 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/google/go-tpm-tools/client"
+	legacy "github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// NVReadingField is the field number that carries NVReadings, each encoded as JSON, in the
+// attestation envelope of a binary report.
+const NVReadingField protowire.Number = 3
+
+// NVReading is the content of a TPM NV index at attestation time
+type NVReading struct {
+	// Index is the NV index handle
+	Index uint32 `json:"index"`
+	// Data is the content of the index. Without CertifyInfo it is only the attester's word.
+	Data []byte `json:"data"`
+	// NVPublic is the TPMS_NV_PUBLIC of the index, whose digest is the index name
+	NVPublic []byte `json:"nvPublic,omitempty"`
+	// CertifyInfo is the TPMS_ATTEST produced by TPM2_NV_Certify over Data and the attestation
+	// nonce. It is empty if the AK could not certify the index.
+	CertifyInfo []byte `json:"certifyInfo,omitempty"`
+	// Signature is the TPMT_SIGNATURE of CertifyInfo by the AK
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// readNVIndices reads indices, certifying each with ak over nonce where the TPM allows it.
+func readNVIndices(rw io.ReadWriter, ak *client.Key, indices []uint32, nonce []byte) ([]*NVReading, error) {
+	tpm := transport.FromReadWriter(rw)
+	akPublic, err := tpm2.ReadPublic{ObjectHandle: tpm2.TPMHandle(ak.Handle())}.Execute(tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read AK name: %v", err)
+	}
+
+	readings := make([]*NVReading, 0, len(indices))
+	for _, index := range indices {
+		handle := tpm2.TPMHandle(index)
+		nvPublic, err := tpm2.NVReadPublic{NVIndex: handle}.Execute(tpm)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public area of NV index %#x: %v", index, err)
+		}
+		public, err := nvPublic.NVPublic.Contents()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public area of NV index %#x: %v", index, err)
+		}
+		reading := &NVReading{Index: index, NVPublic: nvPublic.NVPublic.Bytes()}
+
+		certified, err := tpm2.NVCertify{
+			SignHandle:     tpm2.AuthHandle{Handle: tpm2.TPMHandle(ak.Handle()), Name: akPublic.Name, Auth: tpm2.PasswordAuth(nil)},
+			AuthHandle:     tpm2.AuthHandle{Handle: handle, Name: nvPublic.NVName, Auth: tpm2.PasswordAuth(nil)},
+			NVIndex:        tpm2.NamedHandle{Handle: handle, Name: nvPublic.NVName},
+			QualifyingData: tpm2.TPM2BData{Buffer: nonce},
+			InScheme:       tpm2.TPMTSigScheme{Scheme: tpm2.TPMAlgNull},
+			Size:           public.DataSize,
+		}.Execute(tpm)
+		if err == nil {
+			reading.CertifyInfo = certified.CertifyInfo.Bytes()
+			reading.Signature = tpm2.Marshal(certified.Signature)
+			reading.Data, err = certifiedNVData(reading.CertifyInfo)
+			if err != nil {
+				return nil, fmt.Errorf("NV index %#x: %v", index, err)
+			}
+		} else {
+			// Indices larger than the TPM's NV buffer, or not readable with their own empty
+			// authorization, cannot be certified, but may still be read.
+			reading.Data, err = legacy.NVReadEx(rw, tpmutil.Handle(index), tpmutil.Handle(index), "", 0)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read NV index %#x: %v", index, err)
+			}
+		}
+		readings = append(readings, reading)
+	}
+	return readings, nil
+}
+
+// certifiedNVData returns the NV contents certified by a TPMS_ATTEST.
+func certifiedNVData(certifyInfo []byte) ([]byte, error) {
+	attest, err := tpm2.Unmarshal[tpm2.TPMSAttest](certifyInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse NV certification: %v", err)
+	}
+	nv, err := attest.Attested.NV()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse NV certification: %v", err)
+	}
+	return nv.NVContents.Buffer, nil
+}
+
+// attachNVReadings appends readings as NVReadingField envelope fields to additions.
+func attachNVReadings(additions []byte, readings []*NVReading) ([]byte, error) {
+	for _, reading := range readings {
+		encoded, err := json.Marshal(reading)
+		if err != nil {
+			return nil, err
+		}
+		additions = protowire.AppendTag(additions, NVReadingField, protowire.BytesType)
+		additions = protowire.AppendBytes(additions, encoded)
+	}
+	return additions, nil
+}
+
+// GetNVReadings returns the NV readings attached to a binary attestation report. They are not
+// verified: only the data of readings with a CertifyInfo can be, as VerifyOptions.ExpectedNVIndices
+// does, and the data of the others is unauthenticated.
+func GetNVReadings(attestationBytes []byte) ([]*NVReading, error) {
+	values, err := envelopeFieldValues(attestationBytes, NVReadingField, protowire.BytesType)
+	if err != nil {
+		return nil, err
+	}
+	readings := make([]*NVReading, 0, len(values))
+	for _, value := range values {
+		encoded, _ := protowire.ConsumeBytes(value)
+		reading := &NVReading{}
+		if err := json.Unmarshal(encoded, reading); err != nil {
+			return nil, fmt.Errorf("malformed NV reading: %v", err)
+		}
+		readings = append(readings, reading)
+	}
+	return readings, nil
+}
+
+// checkNVIndices checks that a binary attestation report carries a reading of each expected index,
+// certified by akPub over nonce, with the expected content.
+func checkNVIndices(attestationBytes []byte, akPub crypto.PublicKey, nonce []byte, expected map[uint32][]byte) error {
+	readings, err := GetNVReadings(attestationBytes)
+	if err != nil {
+		return err
+	}
+	byIndex := make(map[uint32]*NVReading, len(readings))
+	for _, reading := range readings {
+		byIndex[reading.Index] = reading
+	}
+
+	for index, want := range expected {
+		reading, ok := byIndex[index]
+		if !ok {
+			return fmt.Errorf("attestation does not contain NV index %#x", index)
+		}
+		if len(reading.CertifyInfo) == 0 {
+			return fmt.Errorf("NV index %#x is not certified by the AK", index)
+		}
+		data, err := verifyNVCertification(reading, akPub, nonce)
+		if err != nil {
+			return fmt.Errorf("NV index %#x: %v", index, err)
+		}
+		if !bytes.Equal(data, want) {
+			return fmt.Errorf("NV index %#x is %x, expected %x", index, data, want)
+		}
+	}
+	return nil
+}
+
+// verifyNVCertification checks the AK signature of reading and that it certifies its index over
+// nonce, and returns the certified contents.
+func verifyNVCertification(reading *NVReading, akPub crypto.PublicKey, nonce []byte) ([]byte, error) {
+	if err := verifyTPMSignature(akPub, reading.CertifyInfo, reading.Signature); err != nil {
+		return nil, err
+	}
+	attest, err := tpm2.Unmarshal[tpm2.TPMSAttest](reading.CertifyInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certification: %v", err)
+	}
+	if attest.Type != tpm2.TPMSTAttestNV {
+		return nil, fmt.Errorf("certification is not an NV certification")
+	}
+	if !bytes.Equal(attest.ExtraData.Buffer, nonce) {
+		return nil, fmt.Errorf("certification is not bound to the attestation nonce")
+	}
+	nv, err := attest.Attested.NV()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certification: %v", err)
+	}
+	if nv.Offset != 0 {
+		return nil, fmt.Errorf("certification covers the index from offset %d", nv.Offset)
+	}
+
+	public, err := tpm2.Unmarshal[tpm2.TPMSNVPublic](reading.NVPublic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse NV public area: %v", err)
+	}
+	if uint32(public.NVIndex) != reading.Index {
+		return nil, fmt.Errorf("NV public area describes index %#x", uint32(public.NVIndex))
+	}
+	name, err := tpm2.NVName(public)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(name.Buffer, nv.IndexName.Buffer) {
+		return nil, fmt.Errorf("certification is for a different NV index")
+	}
+	return nv.NVContents.Buffer, nil
+}
+
+// verifyTPMSignature verifies a TPMT_SIGNATURE over data by pub.
+func verifyTPMSignature(pub crypto.PublicKey, data []byte, signature []byte) error {
+	sig, err := tpm2.Unmarshal[tpm2.TPMTSignature](signature)
+	if err != nil {
+		return fmt.Errorf("failed to parse signature: %v", err)
+	}
+	switch sig.SigAlg {
+	case tpm2.TPMAlgRSASSA, tpm2.TPMAlgRSAPSS:
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("RSA signature does not match the AK type %T", pub)
+		}
+		var rsaSig *tpm2.TPMSSignatureRSA
+		if sig.SigAlg == tpm2.TPMAlgRSASSA {
+			rsaSig, err = sig.Signature.RSASSA()
+		} else {
+			rsaSig, err = sig.Signature.RSAPSS()
+		}
+		if err != nil {
+			return err
+		}
+		hash, err := rsaSig.Hash.Hash()
+		if err != nil {
+			return err
+		}
+		h := hash.New()
+		h.Write(data)
+		if sig.SigAlg == tpm2.TPMAlgRSASSA {
+			err = rsa.VerifyPKCS1v15(rsaPub, hash, h.Sum(nil), rsaSig.Sig.Buffer)
+		} else {
+			err = rsa.VerifyPSS(rsaPub, hash, h.Sum(nil), rsaSig.Sig.Buffer, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("signature verification failed: %v", err)
+		}
+	case tpm2.TPMAlgECDSA:
+		eccPub, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("ECDSA signature does not match the AK type %T", pub)
+		}
+		eccSig, err := sig.Signature.ECDSA()
+		if err != nil {
+			return err
+		}
+		hash, err := eccSig.Hash.Hash()
+		if err != nil {
+			return err
+		}
+		h := hash.New()
+		h.Write(data)
+		r := new(big.Int).SetBytes(eccSig.SignatureR.Buffer)
+		s := new(big.Int).SetBytes(eccSig.SignatureS.Buffer)
+		if !ecdsa.Verify(eccPub, h.Sum(nil), r, s) {
+			return fmt.Errorf("signature verification failed")
+		}
+	default:
+		return fmt.Errorf("unsupported signature algorithm %#x", uint16(sig.SigAlg))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package attestation
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// testNVIndex is an NV index defined on the simulated TPM of the tests.
+const testNVIndex = 0x01500000
+
+func TestNVReadings(t *testing.T) {
+	token := []byte("enrollment-token")
+	sim := newTestSimulator(t)
+	defer sim.Close()
+	attributes := tpm2.AttrAuthRead | tpm2.AttrAuthWrite
+	if err := tpm2.NVDefineSpace(sim, tpm2.HandleOwner, testNVIndex, "", "", nil, attributes, uint16(len(token))); err != nil {
+		t.Fatal(err)
+	}
+	if err := tpm2.NVWrite(sim, testNVIndex, testNVIndex, "", token, 0); err != nil {
+		t.Fatal(err)
+	}
+	nonce := []byte("nv-readings-nonce")
+	opts := DefaultAttestOptions()
+	opts.NVIndices = []uint32{testNVIndex}
+	opts.TPM = sim
+	report := attestWithSimulator(t, opts, nonce)
+
+	readings, err := GetNVReadings(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 1 || readings[0].Index != testNVIndex || !bytes.Equal(readings[0].Data, token) || len(readings[0].CertifyInfo) == 0 {
+		t.Fatalf("GetNVReadings() = %+v, want a certified reading of the token", readings)
+	}
+
+	verifyOpts := simulatorVerifyOptions(nonce)
+	verifyOpts.ExpectedNVIndices = map[uint32][]byte{testNVIndex: token}
+	if _, err := VerifyAttestationContext(t.Context(), report, verifyOpts); err != nil {
+		t.Fatalf("VerifyAttestationContext() failed: %v", err)
+	}
+	verifyOpts.ExpectedNVIndices = map[uint32][]byte{testNVIndex: []byte("another-token!!!")}
+	if _, err := VerifyAttestationContext(t.Context(), report, verifyOpts); err == nil {
+		t.Error("VerifyAttestationContext() with other expected contents succeeded, want an error")
+	}
+
+	// An attester can attach any data without a certification, which must not be accepted.
+	plain, _, err := unwrapEnvelope(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := json.Marshal(&NVReading{Index: testNVIndex, Data: []byte("forged-token!!!!"), NVPublic: readings[0].NVPublic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedReport := wrapEnvelope(plain, protowire.AppendBytes(protowire.AppendTag(nil, NVReadingField, protowire.BytesType), forged))
+	verifyOpts.ExpectedNVIndices = map[uint32][]byte{testNVIndex: []byte("forged-token!!!!")}
+	if _, err := VerifyAttestationContext(t.Context(), forgedReport, verifyOpts); err == nil || !strings.Contains(err.Error(), "not certified") {
+		t.Errorf("VerifyAttestationContext() of an uncertified reading = %v, want it rejected as not certified", err)
+	}
+}
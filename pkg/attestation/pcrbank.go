@@ -0,0 +1,125 @@
+package attestation
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// checkPCRBanks checks the PCR banks quoted by attestation before quote verification, so that
+// SHA-1 banks and malformed quotes fail with an actionable error rather than a digest mismatch.
+func checkPCRBanks(attestation *pb.Attestation, allowSHA1 bool, rejectSHA1 bool) error {
+	quotes := attestation.GetQuotes()
+	if len(quotes) == 0 {
+		return fmt.Errorf("attestation does not contain any quotes")
+	}
+
+	onlySHA1 := true
+	for _, quote := range quotes {
+		hash := tpm2.Algorithm(quote.GetPcrs().GetHash())
+		cryptoHash, err := hash.Hash()
+		if err != nil {
+			return fmt.Errorf("quote over unsupported PCR bank %v", hash)
+		}
+		for index, digest := range quote.GetPcrs().GetPcrs() {
+			if len(digest) != cryptoHash.Size() {
+				return fmt.Errorf("PCR %d of the %v bank is %d bytes, expected %d", index, hash, len(digest), cryptoHash.Size())
+			}
+		}
+
+		if hash == tpm2.AlgSHA1 {
+			if rejectSHA1 {
+				return fmt.Errorf("attestation contains a SHA-1 PCR bank, which is rejected by RejectSHA1")
+			}
+		} else {
+			onlySHA1 = false
+		}
+	}
+
+	if onlySHA1 && !allowSHA1 {
+		return fmt.Errorf("attestation only quotes SHA-1 PCR banks, as produced by legacy firmware; set AllowSHA1 to verify it")
+	}
+	return nil
+}
+
+// checkQuoteSchemes checks that every quote of attestation is signed with the signing scheme of
+// the AK, and that the PCR selection it signs is over the bank of the PCR values it carries.
+// Quote verification otherwise takes the hash algorithm from the signature itself. pub must have
+// passed validateAKPublic.
+func checkQuoteSchemes(attestation *pb.Attestation, pub tpm2.Public) error {
+	var scheme *tpm2.SigScheme
+	if pub.Type == tpm2.AlgRSA {
+		scheme = pub.RSAParameters.Sign
+	} else {
+		scheme = pub.ECCParameters.Sign
+	}
+	for i, quote := range attestation.GetQuotes() {
+		sig, err := tpm2.DecodeSignature(bytes.NewBuffer(quote.GetRawSig()))
+		if err != nil {
+			return fmt.Errorf("quote %d: failed to decode signature: %v", i, err)
+		}
+		var sigHash tpm2.Algorithm
+		switch {
+		case sig.RSA != nil:
+			sigHash = sig.RSA.HashAlg
+		case sig.ECC != nil:
+			sigHash = sig.ECC.HashAlg
+		}
+		if sig.Alg != scheme.Alg || sigHash != scheme.Hash {
+			return fmt.Errorf("quote %d is signed with %v over %v, but the AK signs with %v over %v", i, sig.Alg, sigHash, scheme.Alg, scheme.Hash)
+		}
+
+		data, err := tpm2.DecodeAttestationData(quote.GetQuote())
+		if err != nil {
+			return fmt.Errorf("quote %d: failed to decode quote: %v", i, err)
+		}
+		if data.AttestedQuoteInfo == nil {
+			return fmt.Errorf("quote %d does not contain quote info", i)
+		}
+		bank := tpm2.Algorithm(quote.GetPcrs().GetHash())
+		if selected := data.AttestedQuoteInfo.PCRSelection.Hash; selected != bank {
+			return fmt.Errorf("quote %d signs the %v PCR bank, but carries %v PCR values", i, selected, bank)
+		}
+	}
+	return nil
+}
+
+// ParsePCRValue parses a PCR digest written as hex, with or without a 0x prefix and in either case,
+// or as standard or URL-safe base64, with or without padding. Whitespace and colon separators are
+// ignored. The digest must be the size of a SHA-1, SHA-256, SHA-384 or SHA-512 digest.
+func ParsePCRValue(s string) ([]byte, error) {
+	normalized := strings.Map(func(r rune) rune {
+		if r == ':' || r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, s)
+	if len(normalized) > 2 && (normalized[:2] == "0x" || normalized[:2] == "0X") {
+		normalized = normalized[2:]
+	}
+
+	// Hex is tried first, as hex digests are also valid base64.
+	digest, err := hex.DecodeString(normalized)
+	if err != nil {
+		unpadded := strings.TrimRight(normalized, "=")
+		digest, err = base64.RawStdEncoding.DecodeString(unpadded)
+		if err != nil {
+			digest, err = base64.RawURLEncoding.DecodeString(unpadded)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("PCR value %q is neither hex nor base64", s)
+		}
+	}
+
+	switch len(digest) {
+	case 20, 32, 48, 64:
+		return digest, nil
+	default:
+		return nil, fmt.Errorf("PCR value %q is %d bytes, which is not a SHA-1, SHA-256, SHA-384 or SHA-512 digest", s, len(digest))
+	}
+}
@@ -0,0 +1,207 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"github.com/google/go-tpm-tools/proto/tpm"
+	"github.com/google/go-tpm/legacy/tpm2"
+	"google.golang.org/protobuf/proto"
+)
+
+// sha1BankFixture returns a simulated report over nonce that only quotes the SHA-1 PCR bank, as
+// legacy firmware produces, and its SHA-256 quote.
+func sha1BankFixture(t *testing.T, nonce []byte) ([]byte, *tpm.Quote) {
+	t.Helper()
+	attestation := &pb.Attestation{}
+	if err := proto.Unmarshal(attestWithSimulator(t, DefaultAttestOptions(), nonce), attestation); err != nil {
+		t.Fatal(err)
+	}
+	var sha1, sha256 *tpm.Quote
+	for _, quote := range attestation.GetQuotes() {
+		switch quote.GetPcrs().GetHash() {
+		case tpm.HashAlgo_SHA1:
+			sha1 = quote
+		case tpm.HashAlgo_SHA256:
+			sha256 = quote
+		}
+	}
+	if sha1 == nil || sha256 == nil {
+		t.Fatal("simulated report does not quote both the SHA-1 and SHA-256 banks")
+	}
+	attestation.Quotes = []*tpm.Quote{sha1}
+	report, err := proto.Marshal(attestation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report, sha256
+}
+
+func TestSHA1Bank(t *testing.T) {
+	nonce := []byte("sha1-bank-nonce")
+	report, sha256Quote := sha1BankFixture(t, nonce)
+
+	opts := simulatorVerifyOptions(nonce)
+	if _, err := VerifyAttestationContext(t.Context(), report, opts); err == nil || !strings.Contains(err.Error(), "set AllowSHA1") {
+		t.Errorf("VerifyAttestationContext() of a SHA-1 bank = %v, want an error pointing to AllowSHA1", err)
+	}
+
+	opts.AllowSHA1 = true
+	verified, err := VerifyAttestationContext(t.Context(), report, opts)
+	if err != nil {
+		t.Fatalf("VerifyAttestationContext() with AllowSHA1 failed: %v", err)
+	}
+	if verified.MachineState.GetHash() != tpm.HashAlgo_SHA1 {
+		t.Errorf("machine state is over the %v bank, want SHA1", verified.MachineState.GetHash())
+	}
+
+	opts.RejectSHA1 = true
+	if _, err := VerifyAttestationContext(t.Context(), report, opts); err == nil || !strings.Contains(err.Error(), "RejectSHA1") {
+		t.Errorf("VerifyAttestationContext() with RejectSHA1 = %v, want a RejectSHA1 error", err)
+	}
+
+	// The SHA-1 quote carrying the PCR values of the SHA-256 bank signs another bank than it claims.
+	attestation := &pb.Attestation{}
+	if err := proto.Unmarshal(report, attestation); err != nil {
+		t.Fatal(err)
+	}
+	attestation.Quotes[0].Pcrs = sha256Quote.GetPcrs()
+	mismatched, err := proto.Marshal(attestation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.RejectSHA1 = false
+	if _, err := VerifyAttestationContext(t.Context(), mismatched, opts); err == nil || !strings.Contains(err.Error(), "signs the SHA1 PCR bank, but carries SHA256 PCR values") {
+		t.Errorf("VerifyAttestationContext() of a mismatched bank = %v, want a bank mismatch error", err)
+	}
+}
+
+// resignedFixture returns a simulated report over nonce whose AK is replaced by key, with the AK's
+// RSASSA-SHA256 signing scheme, and whose quotes key signs with scheme and hash instead.
+func resignedFixture(t *testing.T, nonce []byte, key *rsa.PrivateKey, scheme, hash tpm2.Algorithm) []byte {
+	t.Helper()
+	attestation := &pb.Attestation{}
+	if err := proto.Unmarshal(attestWithSimulator(t, DefaultAttestOptions(), nonce), attestation); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := tpm2.DecodePublic(attestation.GetAkPub())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub.RSAParameters.ModulusRaw = key.N.Bytes()
+	pub.RSAParameters.Sign = &tpm2.SigScheme{Alg: tpm2.AlgRSASSA, Hash: tpm2.AlgSHA256}
+	if attestation.AkPub, err = pub.Encode(); err != nil {
+		t.Fatal(err)
+	}
+
+	cryptoHash, err := hash.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, quote := range attestation.GetQuotes() {
+		h := cryptoHash.New()
+		h.Write(quote.GetQuote())
+		var sig []byte
+		if scheme == tpm2.AlgRSAPSS {
+			sig, err = rsa.SignPSS(rand.Reader, key, cryptoHash, h.Sum(nil), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, key, cryptoHash, h.Sum(nil))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// TPMT_SIGNATURE: signature scheme, hash algorithm and the TPM2B signature.
+		raw := binary.BigEndian.AppendUint16(nil, uint16(scheme))
+		raw = binary.BigEndian.AppendUint16(raw, uint16(hash))
+		raw = binary.BigEndian.AppendUint16(raw, uint16(len(sig)))
+		quote.RawSig = append(raw, sig...)
+	}
+	report, err := proto.Marshal(attestation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestQuoteSchemeMismatch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := []byte("quote-scheme-nonce")
+	tests := []struct {
+		name   string
+		scheme tpm2.Algorithm
+		hash   tpm2.Algorithm
+	}{
+		{name: "AK scheme", scheme: tpm2.AlgRSASSA, hash: tpm2.AlgSHA256},
+		{name: "other hash", scheme: tpm2.AlgRSASSA, hash: tpm2.AlgSHA384},
+		{name: "other scheme", scheme: tpm2.AlgRSAPSS, hash: tpm2.AlgSHA256},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			report := resignedFixture(t, nonce, key, tc.scheme, tc.hash)
+			_, verifyErr := VerifyAttestationContext(t.Context(), report, simulatorVerifyOptions(nonce))
+			consistencyErr := VerifyInternalConsistency(report, "binarypb")
+			if tc.scheme == tpm2.AlgRSASSA && tc.hash == tpm2.AlgSHA256 {
+				if verifyErr != nil || consistencyErr != nil {
+					t.Errorf("verifying quotes signed with the AK scheme = %v, %v, want success", verifyErr, consistencyErr)
+				}
+				return
+			}
+			// The signatures are valid for the AK, but not with its signing scheme.
+			want := fmt.Sprintf("quote 0 is signed with %v over %v, but the AK signs with %v over %v", tc.scheme, tc.hash, tpm2.AlgRSASSA, tpm2.AlgSHA256)
+			if verifyErr == nil || !strings.Contains(verifyErr.Error(), want) {
+				t.Errorf("VerifyAttestationContext() = %v, want an error containing %q", verifyErr, want)
+			}
+			if consistencyErr == nil || !strings.Contains(consistencyErr.Error(), want) {
+				t.Errorf("VerifyInternalConsistency() = %v, want an error containing %q", consistencyErr, want)
+			}
+		})
+	}
+}
+
+func TestParsePCRValue(t *testing.T) {
+	sha256 := bytes.Repeat([]byte{0xab, 0xcd}, 16)
+	sha1 := bytes.Repeat([]byte{0x01}, 20)
+	lower := strings.Repeat("abcd", 16)
+	tests := []struct {
+		name    string
+		value   string
+		want    []byte
+		wantErr string
+	}{
+		{name: "lower case hex", value: lower, want: sha256},
+		{name: "upper case hex", value: strings.ToUpper(lower), want: sha256},
+		{name: "0x prefix", value: "0x" + lower, want: sha256},
+		{name: "0X prefix", value: "0X" + strings.ToUpper(lower), want: sha256},
+		{name: "colon separators", value: strings.Repeat("ab:cd:", 15) + "ab:cd", want: sha256},
+		{name: "SHA-1 digest", value: strings.Repeat("01", 20), want: sha1},
+		{name: "standard base64", value: base64.StdEncoding.EncodeToString(sha256), want: sha256},
+		{name: "URL-safe base64 without padding", value: base64.RawURLEncoding.EncodeToString(sha1), want: sha1},
+		{name: "odd length hex", value: lower[:63], wantErr: "is 47 bytes"},
+		{name: "wrong digest size", value: strings.Repeat("ab", 31), wantErr: "is 31 bytes"},
+		{name: "neither hex nor base64", value: "not a digest!", wantErr: "neither hex nor base64"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParsePCRValue(tc.value)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("ParsePCRValue(%q) = %x, %v, want an error containing %q", tc.value, got, err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(got, tc.want) {
+				t.Errorf("ParsePCRValue(%q) = %x, %v, want %x", tc.value, got, err, tc.want)
+			}
+		})
+	}
+}
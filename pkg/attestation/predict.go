@@ -0,0 +1,130 @@
+package attestation
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// MeasuredComponent is a single measurement extended into a PCR during boot
+type MeasuredComponent struct {
+	// PCR is the index of the PCR the measurement is extended into
+	PCR uint32
+	// Data is the measured content, hashed with the bank's algorithm. Ignored if Digest is set.
+	Data []byte
+	// Digest is the precomputed event digest. Use it for components that are not measured as a
+	// plain hash of their content, such as PE/COFF images measured by their Authenticode digest.
+	Digest []byte
+}
+
+// Separator returns the EV_SEPARATOR measurement firmware extends into pcr (0-7) before handing
+// control to the boot loader.
+func Separator(pcr uint32) MeasuredComponent {
+	return MeasuredComponent{PCR: pcr, Data: []byte{0, 0, 0, 0}}
+}
+
+// PredictPCRs replays the extends of components, in order, into a PCR bank using hash and
+// returns the final value of every PCR that was extended. The result can be used directly as
+// VerifyOptions.ExpectedPCRs.
+//
+// PCRs start from their power-on value: all zeros, except PCRs 17 to 22 which start as all ones.
+// The prediction only matches a real quote if components lists every event the platform extends
+// into those PCRs, in the same order.
+func PredictPCRs(hash tpm2.Algorithm, components []MeasuredComponent) (map[uint32][]byte, error) {
+	cryptoHash, err := hash.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("unsupported PCR bank %v: %v", hash, err)
+	}
+	if !cryptoHash.Available() {
+		return nil, fmt.Errorf("unsupported PCR bank %v", hash)
+	}
+
+	pcrs := make(map[uint32][]byte)
+	for i, component := range components {
+		digest := component.Digest
+		if digest == nil {
+			h := cryptoHash.New()
+			h.Write(component.Data)
+			digest = h.Sum(nil)
+		}
+		if len(digest) != cryptoHash.Size() {
+			return nil, fmt.Errorf("component %d: digest is %d bytes, want %d for %v", i, len(digest), cryptoHash.Size(), hash)
+		}
+
+		value, ok := pcrs[component.PCR]
+		if !ok {
+			value = initialPCRValue(component.PCR, cryptoHash.Size())
+		}
+		h := cryptoHash.New()
+		h.Write(value)
+		h.Write(digest)
+		pcrs[component.PCR] = h.Sum(nil)
+	}
+	return pcrs, nil
+}
+
+// initialPCRValue returns the power-on value of a PCR.
+func initialPCRValue(pcr uint32, size int) []byte {
+	if pcr >= 17 && pcr <= 22 {
+		return bytes.Repeat([]byte{0xff}, size)
+	}
+	return make([]byte, size)
+}
+
+// callingEFIApplication is the EV_EFI_ACTION firmware extends into PCR 4 before it starts the
+// first boot application.
+const callingEFIApplication = "Calling EFI Application from Boot Option"
+
+// BootImage is the golden boot chain of a VM whose UEFI firmware directly boots a Linux kernel
+// through its EFI stub
+type BootImage struct {
+	// Firmware is the firmware blob the platform measures into PCR 0
+	Firmware []byte
+	// Kernel is the kernel's PE/COFF image, measured into PCR 4 by its Authenticode digest
+	Kernel []byte
+	// Initrd is the initial ramdisk, measured into PCR 9 by the EFI stub. Optional.
+	Initrd []byte
+}
+
+// Components returns the measurements made while booting img, in order, using hash as the PCR
+// bank. Only PCRs 0, 4 and 9 are covered; events the platform extends into other PCRs, or
+// additionally into these, such as a measured kernel command line, must be appended by the caller.
+func (img *BootImage) Components(hash tpm2.Algorithm) ([]MeasuredComponent, error) {
+	cryptoHash, err := hash.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("unsupported PCR bank %v: %v", hash, err)
+	}
+	if len(img.Firmware) == 0 {
+		return nil, fmt.Errorf("boot image has no firmware")
+	}
+	if len(img.Kernel) == 0 {
+		return nil, fmt.Errorf("boot image has no kernel")
+	}
+	kernelDigest, err := authenticodeDigest(cryptoHash, img.Kernel)
+	if err != nil {
+		return nil, fmt.Errorf("measuring kernel: %v", err)
+	}
+
+	components := []MeasuredComponent{
+		{PCR: 0, Data: img.Firmware},
+		Separator(0),
+		{PCR: 4, Data: []byte(callingEFIApplication)},
+		Separator(4),
+		{PCR: 4, Digest: kernelDigest},
+	}
+	if len(img.Initrd) != 0 {
+		components = append(components, MeasuredComponent{PCR: 9, Data: img.Initrd})
+	}
+	return components, nil
+}
+
+// PredictBootImagePCRs predicts the PCRs 0, 4 and 9 of a VM booting img, as PredictPCRs does for
+// the measurements returned by img.Components.
+func PredictBootImagePCRs(hash tpm2.Algorithm, img *BootImage) (map[uint32][]byte, error) {
+	components, err := img.Components(hash)
+	if err != nil {
+		return nil, err
+	}
+	return PredictPCRs(hash, components)
+}
@@ -0,0 +1,69 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"github.com/google/go-tpm-tools/server"
+	"github.com/google/go-tpm/legacy/tpm2"
+	"google.golang.org/protobuf/proto"
+)
+
+// isPSSAK reports whether the AK pub signs with RSA-PSS. pub must have passed validateAKPublic.
+func isPSSAK(pub tpm2.Public) bool {
+	return pub.Type == tpm2.AlgRSA && pub.RSAParameters.Sign.Alg == tpm2.AlgRSAPSS
+}
+
+// resignPSSQuotes returns a copy of attestation whose RSA-PSS quotes are signed instead by an
+// ephemeral ECDSA key, with verifyOpts trusting only that key, as go-tpm-tools only verifies
+// RSASSA and ECDSA quotes. server.VerifyAttestation then checks the nonce and PCR digests of the
+// quotes and replays the event log as for any other AK. The copy has no AK certificate. The RSA-PSS
+// signatures must have passed VerifyQuoteSignature, as they are not checked again.
+func resignPSSQuotes(attestation *pb.Attestation, verifyOpts server.VerifyOpts) (*pb.Attestation, server.VerifyOpts, error) {
+	ephemeral, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, verifyOpts, err
+	}
+	// go-tpm-tools only takes the key of the AK public area, not its attributes or scheme.
+	ephemeralPub, err := server.CreateEKPublicAreaFromKey(&ephemeral.PublicKey)
+	if err != nil {
+		return nil, verifyOpts, err
+	}
+
+	resigned := proto.Clone(attestation).(*pb.Attestation)
+	resigned.AkCert = nil
+	resigned.IntermediateCerts = nil
+	if resigned.AkPub, err = ephemeralPub.Encode(); err != nil {
+		return nil, verifyOpts, err
+	}
+	for i, quote := range resigned.GetQuotes() {
+		sig, err := tpm2.DecodeSignature(bytes.NewBuffer(quote.GetRawSig()))
+		if err != nil {
+			return nil, verifyOpts, fmt.Errorf("quote %d: failed to decode signature: %v", i, err)
+		}
+		hash, err := sig.RSA.HashAlg.Hash()
+		if err != nil {
+			return nil, verifyOpts, fmt.Errorf("quote %d: %v", i, err)
+		}
+		h := hash.New()
+		h.Write(quote.GetQuote())
+		r, s, err := ecdsa.Sign(rand.Reader, ephemeral, h.Sum(nil))
+		if err != nil {
+			return nil, verifyOpts, err
+		}
+		quote.RawSig, err = tpm2.Signature{Alg: tpm2.AlgECDSA, ECC: &tpm2.SignatureECC{HashAlg: sig.RSA.HashAlg, R: r, S: s}}.Encode()
+		if err != nil {
+			return nil, verifyOpts, err
+		}
+	}
+
+	verifyOpts.TrustedAKs = []crypto.PublicKey{&ephemeral.PublicKey}
+	verifyOpts.TrustedRootCerts = nil
+	verifyOpts.IntermediateCerts = nil
+	return resigned, verifyOpts, nil
+}
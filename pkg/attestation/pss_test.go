@@ -0,0 +1,111 @@
+package attestation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-tpm-tools/client"
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"github.com/google/go-tpm-tools/proto/tpm"
+	"github.com/google/go-tpm/legacy/tpm2"
+	"google.golang.org/protobuf/proto"
+)
+
+// pssFixture returns a report over nonce whose AK on a simulated TPM signs with RSASSA-PSS, with
+// quotes of the SHA-1 and SHA-256 banks. go-tpm-tools does not attest with such an AK, so the
+// report is assembled from the quotes as Attest would.
+func pssFixture(t *testing.T, nonce []byte) *pb.Attestation {
+	t.Helper()
+	sim := newTestSimulator(t)
+	defer sim.Close()
+	template := client.AKTemplateRSA()
+	template.RSAParameters.Sign = &tpm2.SigScheme{Alg: tpm2.AlgRSAPSS, Hash: tpm2.AlgSHA256}
+	ak, err := client.NewKey(sim, tpm2.HandleOwner, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ak.Close()
+
+	attestation := &pb.Attestation{}
+	if attestation.AkPub, err = ak.PublicArea().Encode(); err != nil {
+		t.Fatal(err)
+	}
+	// Key.Quote checks its quotes, and only RSASSA and ECDSA signatures at that.
+	for _, hash := range []tpm2.Algorithm{tpm2.AlgSHA1, tpm2.AlgSHA256} {
+		sel := client.FullPcrSel(hash)
+		quoted, sig, err := tpm2.Quote(sim, ak.Handle(), "", "", nonce, sel, tpm2.AlgNull)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rawSig, err := sig.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pcrs, err := client.ReadPCRs(sim, sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		attestation.Quotes = append(attestation.Quotes, &tpm.Quote{Quote: quoted, RawSig: rawSig, Pcrs: pcrs})
+	}
+	return attestation
+}
+
+func TestVerifyPSSAttestation(t *testing.T) {
+	nonce := []byte("rsa-pss-ak-nonce")
+	attestation := pssFixture(t, nonce)
+	marshal := func(attestation *pb.Attestation) []byte {
+		report, err := proto.Marshal(attestation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	report := marshal(attestation)
+	if _, err := VerifyAttestationContext(t.Context(), report, simulatorVerifyOptions(nonce)); err != nil {
+		t.Fatalf("VerifyAttestationContext() of an RSA-PSS AK failed: %v", err)
+	}
+	if err := VerifyInternalConsistency(report, "binarypb"); err != nil {
+		t.Errorf("VerifyInternalConsistency() of an RSA-PSS AK failed: %v", err)
+	}
+
+	// An AK declaring RSASSA does not verify the same key's RSA-PSS quotes.
+	rsassa := proto.Clone(attestation).(*pb.Attestation)
+	pub, err := tpm2.DecodePublic(rsassa.GetAkPub())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub.RSAParameters.Sign.Alg = tpm2.AlgRSASSA
+	if rsassa.AkPub, err = pub.Encode(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nor does an RSA-PSS signature of other data.
+	forged := proto.Clone(attestation).(*pb.Attestation)
+	for _, quote := range forged.GetQuotes() {
+		quote.RawSig[len(quote.RawSig)-1] ^= 0xff
+	}
+
+	tests := []struct {
+		name    string
+		report  []byte
+		opts    VerifyOptions
+		wantErr string
+	}{
+		{
+			name:    "scheme mismatch",
+			report:  marshal(rsassa),
+			opts:    simulatorVerifyOptions(nonce),
+			wantErr: "is signed with RSAPSS over SHA256, but the AK signs with RSASSA over SHA256",
+		},
+		{name: "bad signature", report: marshal(forged), opts: simulatorVerifyOptions(nonce), wantErr: "RSAPSS signature verification failed"},
+		{name: "other nonce", report: report, opts: simulatorVerifyOptions([]byte("another-rsa-pss-nonce")), wantErr: "did not match expected extraData"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := VerifyAttestationContext(t.Context(), tc.report, tc.opts); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("VerifyAttestationContext() = %v, want an error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
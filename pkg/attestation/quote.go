@@ -0,0 +1,103 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// quoteBankPreference orders PCR banks as quote verification prefers them.
+var quoteBankPreference = []tpm2.Algorithm{tpm2.AlgSHA512, tpm2.AlgSHA384, tpm2.AlgSHA256, tpm2.AlgSHA1}
+
+// VerifyQuoteSignature checks that every quote of attestation is a TPM-generated TPMS_ATTEST quote
+// signed by ak (*rsa.PublicKey with RSASSA or RSA-PSS, or *ecdsa.PublicKey with ECDSA), and returns
+// the signed digest of the TPMS_ATTEST of the quote over the most preferred PCR bank (SHA-512,
+// SHA-384, SHA-256, then SHA-1), the one verification normally takes its PCR values from.
+//
+// Only the signatures are checked: the nonce, the PCR values and the signing scheme of the AK are
+// left to VerifyAttestation, which runs this check first. RSA-PSS signatures are accepted with any
+// salt length.
+func VerifyQuoteSignature(attestation *pb.Attestation, ak crypto.PublicKey) (attestedDigest []byte, err error) {
+	quotes := attestation.GetQuotes()
+	if len(quotes) == 0 {
+		return nil, fmt.Errorf("attestation does not contain any quotes")
+	}
+	digests := make(map[tpm2.Algorithm][]byte, len(quotes))
+	for i, quote := range quotes {
+		digest, err := verifyQuoteSignature(quote.GetQuote(), quote.GetRawSig(), ak)
+		if err != nil {
+			return nil, fmt.Errorf("quote %d: %w", i, err)
+		}
+		bank := tpm2.Algorithm(quote.GetPcrs().GetHash())
+		if _, ok := digests[bank]; !ok {
+			digests[bank] = digest
+		}
+	}
+	for _, bank := range quoteBankPreference {
+		if digest, ok := digests[bank]; ok {
+			return digest, nil
+		}
+	}
+	return nil, fmt.Errorf("attestation does not quote a supported PCR bank")
+}
+
+// verifyQuoteSignature checks that rawSig is a signature by ak of the TPMS_ATTEST quoted, and
+// returns the signed digest.
+func verifyQuoteSignature(quoted []byte, rawSig []byte, ak crypto.PublicKey) ([]byte, error) {
+	data, err := tpm2.DecodeAttestationData(quoted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode quote: %v", err)
+	}
+	if data.Type != tpm2.TagAttestQuote {
+		return nil, fmt.Errorf("attestation data is of type %v, expected a quote", data.Type)
+	}
+	sig, err := tpm2.DecodeSignature(bytes.NewBuffer(rawSig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %v", err)
+	}
+
+	var sigHash tpm2.Algorithm
+	switch {
+	case sig.RSA != nil:
+		sigHash = sig.RSA.HashAlg
+	case sig.ECC != nil:
+		sigHash = sig.ECC.HashAlg
+	}
+	hash, err := sigHash.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("unsupported signature hash: %v", err)
+	}
+	h := hash.New()
+	h.Write(quoted)
+	digest := h.Sum(nil)
+
+	switch key := ak.(type) {
+	case *rsa.PublicKey:
+		switch sig.Alg {
+		case tpm2.AlgRSASSA:
+			err = rsa.VerifyPKCS1v15(key, hash, digest, sig.RSA.Signature)
+		case tpm2.AlgRSAPSS:
+			err = rsa.VerifyPSS(key, hash, digest, sig.RSA.Signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+		default:
+			return nil, fmt.Errorf("signature scheme %v does not match an RSA AK", sig.Alg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v signature verification failed: %v", sig.Alg, err)
+		}
+	case *ecdsa.PublicKey:
+		if sig.Alg != tpm2.AlgECDSA {
+			return nil, fmt.Errorf("signature scheme %v does not match an ECC AK", sig.Alg)
+		}
+		if !ecdsa.Verify(key, digest, sig.ECC.R, sig.ECC.S) {
+			return nil, fmt.Errorf("ECDSA signature verification failed")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported AK type %T", ak)
+	}
+	return digest, nil
+}
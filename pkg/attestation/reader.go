@@ -0,0 +1,75 @@
+package attestation
+
+import (
+	"crypto"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// VerifyAttestationFromReader reads an attestation report from r until EOF and verifies it.
+// r does not need to be seekable, so pipes, FIFOs and sockets can be passed directly. Reading stops
+// once the report exceeds opts.MaxAttestationSize.
+func VerifyAttestationFromReader(r io.Reader, opts VerifyOptions) (*pb.MachineState, error) {
+	if opts.MaxAttestationSize > 0 {
+		r = io.LimitReader(r, opts.MaxAttestationSize+1)
+	}
+	attestationBytes, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attestation: %v", err)
+	}
+	return VerifyAttestationWithOptions(attestationBytes, opts)
+}
+
+// VerifyAttestationFile reads an attestation report from the file at path and verifies it.
+// The file is streamed rather than stat-ed, so a named pipe written by an agent also works.
+func VerifyAttestationFile(path string, opts VerifyOptions) (*pb.MachineState, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open attestation file: %v", err)
+	}
+	defer f.Close()
+	return VerifyAttestationFromReader(f, opts)
+}
+
+// VerifyAttestationBase64 decodes a base64-encoded attestation report, as carried in HTTP headers
+// and JSON fields, and verifies it like VerifyAttestation. Both the standard and the URL-safe
+// alphabets are accepted, with or without padding, and whitespace such as line breaks is ignored.
+func VerifyAttestationBase64(encoded string, format string, nonce, teeNonce []byte) (*pb.MachineState, error) {
+	attestationBytes, err := decodeBase64(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 attestation: %v", err)
+	}
+	return VerifyAttestation(attestationBytes, format, nonce, teeNonce)
+}
+
+// decodeBase64 decodes standard or URL-safe base64, ignoring whitespace and padding.
+func decodeBase64(encoded string) ([]byte, error) {
+	encoded = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, encoded)
+	encoded = strings.TrimRight(encoded, "=")
+	if strings.ContainsAny(encoded, "-_") {
+		return base64.RawURLEncoding.DecodeString(encoded)
+	}
+	return base64.RawStdEncoding.DecodeString(encoded)
+}
+
+// VerifyAttestationSigned verifies a detached signature over an attestation report by signerKey,
+// such as one from a provisioning service vouching for the report's origin, and then verifies the
+// report like VerifyAttestation. signerKey is an *rsa.PublicKey or *ecdsa.PublicKey signing the
+// SHA-256 digest of data (PKCS #1 v1.5 or ASN.1 ECDSA), or an ed25519.PublicKey signing data.
+func VerifyAttestationSigned(data, signature []byte, signerKey crypto.PublicKey, format string, nonce, teeNonce []byte) (*pb.MachineState, error) {
+	if err := verifySignature(signerKey, data, signature); err != nil {
+		return nil, fmt.Errorf("verifying detached signature: %v", err)
+	}
+	return VerifyAttestation(data, format, nonce, teeNonce)
+}
@@ -0,0 +1,48 @@
+package attestation
+
+import (
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"google.golang.org/protobuf/proto"
+)
+
+// redactedValue replaces the values of container environment variables in a redacted machine state.
+const redactedValue = "REDACTED"
+
+// RedactMachineState returns a copy of ms that is suitable for logging. The copy keeps the
+// measurements and platform details, but drops:
+//   - the data of event log events, keeping their PCR, type and digest
+//   - the report data of TEE reports, which carries the nonce
+//   - the signatures and certificate chains of TEE reports
+//   - the values of container environment variables, keeping their names
+//
+// ms itself is not modified.
+func RedactMachineState(ms *pb.MachineState) *pb.MachineState {
+	if ms == nil {
+		return nil
+	}
+	redacted := proto.Clone(ms).(*pb.MachineState)
+
+	for _, event := range redacted.GetRawEvents() {
+		event.Data = nil
+	}
+	if snp := redacted.GetSevSnpAttestation(); snp != nil {
+		snp.CertificateChain = nil
+		if report := snp.GetReport(); report != nil {
+			report.ReportData = nil
+			report.Signature = nil
+		}
+	}
+	if tdx := redacted.GetTdxAttestation(); tdx != nil {
+		tdx.SignedData = nil
+		tdx.ExtraBytes = nil
+		if body := tdx.GetTdQuoteBody(); body != nil {
+			body.ReportData = nil
+		}
+	}
+	if container := redacted.GetCos().GetContainer(); container != nil {
+		for name := range container.EnvVars {
+			container.EnvVars[name] = redactedValue
+		}
+	}
+	return redacted
+}
@@ -0,0 +1,336 @@
+package attestation
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// CBOR tags and map keys used by the CoRIM and CoMID data models (draft-ietf-rats-corim).
+const (
+	corimUnsignedTag = 501
+	corimSignedTag   = 18
+	comidTag         = 506
+
+	corimTagsKey           uint64 = 1
+	comidTriplesKey        uint64 = 4
+	triplesReferenceKey    uint64 = 0
+	measurementKeyKey      uint64 = 0
+	measurementValuesKey   uint64 = 1
+	valuesDigestsKey       uint64 = 2
+	valuesIntegrityRegsKey uint64 = 14
+)
+
+// tdxRTMRCount is the number of runtime measurement registers in a TDX quote.
+const tdxRTMRCount = 4
+
+// ReferenceValues describes the measurements a verified platform is allowed to report. Every
+// populated field lists the acceptable digests for that measurement; empty fields are not checked.
+type ReferenceValues struct {
+	// PCRs maps a PCR index to its acceptable digests in the verified PCR bank
+	PCRs map[uint32][][]byte `json:"pcrs,omitempty"`
+	// RTMRs maps a TDX RTMR index (0-3) to its acceptable digests
+	RTMRs map[uint32][][]byte `json:"rtmrs,omitempty"`
+	// MRTD lists the acceptable TDX MRTD values
+	MRTD [][]byte `json:"mrtd,omitempty"`
+	// SevSnpMeasurement lists the acceptable SEV-SNP launch MEASUREMENT values
+	SevSnpMeasurement [][]byte `json:"sevSnpMeasurement,omitempty"`
+}
+
+// referenceValuesFromPCRs turns exact expected PCR values into ReferenceValues.
+func referenceValuesFromPCRs(pcrs map[uint32][]byte) *ReferenceValues {
+	rv := &ReferenceValues{PCRs: make(map[uint32][][]byte, len(pcrs))}
+	for index, digest := range pcrs {
+		rv.PCRs[index] = [][]byte{digest}
+	}
+	return rv
+}
+
+// ParseCoRIM loads ReferenceValues from an unsigned CoRIM (CBOR tag 501).
+//
+// Only the reference-value triples of the embedded CoMIDs are consulted, using this mapping:
+//   - a measurement whose key is an unsigned integer N and that carries digests describes PCR N
+//   - integrity-registers entries with an unsigned integer key N describe PCR N
+//   - a measurement whose key is the text "MRTD", "RTMR0" to "RTMR3" or "MEASUREMENT" describes
+//     the corresponding TDX or SEV-SNP measurement
+//
+// Other measurement and register keys, and PCR indices over 23, are rejected.
+//
+// Signed CoRIMs are loaded with ParseRIM, which checks their signature.
+func ParseCoRIM(data []byte) (*ReferenceValues, error) {
+	rv, _, err := parseCoRIM(data)
+	return rv, err
+}
+
+// parseCoRIM loads ReferenceValues from an unsigned CoRIM, and also returns the CoRIM map.
+func parseCoRIM(data []byte) (*ReferenceValues, map[any]any, error) {
+	var corim cbor.Tag
+	if err := cbor.Unmarshal(data, &corim); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode CoRIM: %v", err)
+	}
+	switch corim.Number {
+	case corimUnsignedTag:
+	case corimSignedTag:
+		return nil, nil, fmt.Errorf("signed CoRIM must be loaded with ParseRIM")
+	default:
+		return nil, nil, fmt.Errorf("unexpected CoRIM tag %d", corim.Number)
+	}
+
+	corimMap, ok := corim.Content.(map[any]any)
+	if !ok {
+		return nil, nil, fmt.Errorf("CoRIM is %T, expected a map", corim.Content)
+	}
+	tags, ok := corimMap[corimTagsKey].([]any)
+	if !ok {
+		return nil, nil, fmt.Errorf("CoRIM does not contain any tags")
+	}
+
+	rv := &ReferenceValues{}
+	for _, t := range tags {
+		tag, ok := t.(cbor.Tag)
+		if !ok || tag.Number != comidTag {
+			// CoSWID and CoTL tags carry no reference values.
+			continue
+		}
+		encoded, ok := tag.Content.([]byte)
+		if !ok {
+			return nil, nil, fmt.Errorf("CoMID tag content is %T, expected bytes", tag.Content)
+		}
+		var comid map[any]any
+		if err := cbor.Unmarshal(encoded, &comid); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode CoMID: %v", err)
+		}
+		if err := rv.addCoMID(comid); err != nil {
+			return nil, nil, err
+		}
+	}
+	return rv, corimMap, nil
+}
+
+func (rv *ReferenceValues) addCoMID(comid map[any]any) error {
+	triples, ok := comid[comidTriplesKey].(map[any]any)
+	if !ok {
+		return fmt.Errorf("CoMID does not contain a triples map")
+	}
+	references, _ := triples[triplesReferenceKey].([]any)
+	for _, r := range references {
+		record, ok := r.([]any)
+		if !ok || len(record) != 2 {
+			return fmt.Errorf("malformed reference triple")
+		}
+		measurements, ok := record[1].([]any)
+		if !ok {
+			return fmt.Errorf("malformed reference triple measurements")
+		}
+		for _, m := range measurements {
+			measurement, ok := m.(map[any]any)
+			if !ok {
+				return fmt.Errorf("measurement is %T, expected a map", m)
+			}
+			if err := rv.addMeasurement(measurement); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (rv *ReferenceValues) addMeasurement(measurement map[any]any) error {
+	values, ok := measurement[measurementValuesKey].(map[any]any)
+	if !ok {
+		return fmt.Errorf("measurement does not contain measurement values")
+	}
+
+	if registers, ok := values[valuesIntegrityRegsKey].(map[any]any); ok {
+		for key, d := range registers {
+			index, ok := key.(uint64)
+			if !ok {
+				return fmt.Errorf("integrity register key %v is %T, expected an unsigned integer", key, key)
+			}
+			digests, err := parseCoRIMDigests(d)
+			if err != nil {
+				return fmt.Errorf("integrity register %d: %w", index, err)
+			}
+			if err := rv.addPCR(index, digests); err != nil {
+				return err
+			}
+		}
+	}
+
+	d := values[valuesDigestsKey]
+	if d == nil {
+		return nil
+	}
+	digests, err := parseCoRIMDigests(d)
+	if err != nil {
+		return err
+	}
+	switch mkey := measurement[measurementKeyKey].(type) {
+	case nil:
+		return nil
+	case uint64:
+		return rv.addPCR(mkey, digests)
+	case string:
+		return rv.addTEEMeasurement(mkey, digests)
+	default:
+		return fmt.Errorf("measurement key %v is %T, expected an unsigned integer or text", mkey, mkey)
+	}
+}
+
+func (rv *ReferenceValues) addPCR(index uint64, digests [][]byte) error {
+	if index > 23 {
+		return fmt.Errorf("invalid PCR index %d", index)
+	}
+	if rv.PCRs == nil {
+		rv.PCRs = make(map[uint32][][]byte)
+	}
+	rv.PCRs[uint32(index)] = append(rv.PCRs[uint32(index)], digests...)
+	return nil
+}
+
+func (rv *ReferenceValues) addTEEMeasurement(name string, digests [][]byte) error {
+	switch {
+	case name == "MRTD":
+		rv.MRTD = append(rv.MRTD, digests...)
+	case name == "MEASUREMENT":
+		rv.SevSnpMeasurement = append(rv.SevSnpMeasurement, digests...)
+	case strings.HasPrefix(name, "RTMR"):
+		index, err := strconv.ParseUint(strings.TrimPrefix(name, "RTMR"), 10, 32)
+		if err != nil || index >= tdxRTMRCount {
+			return fmt.Errorf("unknown TDX measurement %q", name)
+		}
+		if rv.RTMRs == nil {
+			rv.RTMRs = make(map[uint32][][]byte)
+		}
+		rv.RTMRs[uint32(index)] = append(rv.RTMRs[uint32(index)], digests...)
+	default:
+		return fmt.Errorf("unknown TEE measurement %q", name)
+	}
+	return nil
+}
+
+// parseCoRIMDigests decodes a CoRIM digests array, [+ [alg, value]], into its digest values.
+func parseCoRIMDigests(d any) ([][]byte, error) {
+	entries, ok := d.([]any)
+	if !ok {
+		return nil, fmt.Errorf("digests are %T, expected an array", d)
+	}
+	var digests [][]byte
+	for _, e := range entries {
+		entry, ok := e.([]any)
+		if !ok || len(entry) != 2 {
+			return nil, fmt.Errorf("malformed digest entry")
+		}
+		value, ok := entry[1].([]byte)
+		if !ok {
+			return nil, fmt.Errorf("digest value is %T, expected bytes", entry[1])
+		}
+		digests = append(digests, value)
+	}
+	return digests, nil
+}
+
+// referenceMatch is a measurement that matched a reference value.
+type referenceMatch struct {
+	measurement string
+	digest      []byte
+}
+
+// check verifies that the measurements of a verified attestation are all acceptable.
+func (rv *ReferenceValues) check(attestation *pb.Attestation, ms *pb.MachineState) error {
+	_, err := rv.match(attestation, ms)
+	return err
+}
+
+// match verifies that the measurements of a verified attestation are all acceptable, and returns
+// the checked measurements.
+func (rv *ReferenceValues) match(attestation *pb.Attestation, ms *pb.MachineState) ([]referenceMatch, error) {
+	var matches []referenceMatch
+	if len(rv.PCRs) != 0 {
+		pcrs, err := verifiedPCRs(attestation, ms)
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range sortedIndices(rv.PCRs) {
+			got, ok := pcrs[index]
+			if !ok {
+				return nil, fmt.Errorf("PCR %d is not included in the quote", index)
+			}
+			if !containsDigest(rv.PCRs[index], got) {
+				return nil, fmt.Errorf("PCR %d is %x, which is not an acceptable value", index, got)
+			}
+			matches = append(matches, referenceMatch{fmt.Sprintf("PCR %d", index), got})
+		}
+	}
+
+	if len(rv.MRTD) != 0 || len(rv.RTMRs) != 0 {
+		body := ms.GetTdxAttestation().GetTdQuoteBody()
+		if body == nil {
+			return nil, fmt.Errorf("TDX reference values given but the attestation has no TDX quote")
+		}
+		if len(rv.MRTD) != 0 {
+			if !containsDigest(rv.MRTD, body.GetMrTd()) {
+				return nil, fmt.Errorf("MRTD is %x, which is not an acceptable value", body.GetMrTd())
+			}
+			matches = append(matches, referenceMatch{"MRTD", body.GetMrTd()})
+		}
+		for _, index := range sortedIndices(rv.RTMRs) {
+			if int(index) >= len(body.GetRtmrs()) {
+				return nil, fmt.Errorf("RTMR %d is not included in the quote", index)
+			}
+			got := body.GetRtmrs()[index]
+			if !containsDigest(rv.RTMRs[index], got) {
+				return nil, fmt.Errorf("RTMR %d is %x, which is not an acceptable value", index, got)
+			}
+			matches = append(matches, referenceMatch{fmt.Sprintf("RTMR %d", index), got})
+		}
+	}
+
+	if len(rv.SevSnpMeasurement) != 0 {
+		report := ms.GetSevSnpAttestation().GetReport()
+		if report == nil {
+			return nil, fmt.Errorf("SEV-SNP reference values given but the attestation has no SEV-SNP report")
+		}
+		if !containsDigest(rv.SevSnpMeasurement, report.GetMeasurement()) {
+			return nil, fmt.Errorf("MEASUREMENT is %x, which is not an acceptable value", report.GetMeasurement())
+		}
+		matches = append(matches, referenceMatch{"MEASUREMENT", report.GetMeasurement()})
+	}
+	return matches, nil
+}
+
+// sortedIndices returns the keys of registers in ascending order.
+func sortedIndices(registers map[uint32][][]byte) []uint32 {
+	indices := make([]uint32, 0, len(registers))
+	for index := range registers {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	return indices
+}
+
+// verifiedPCRs returns the PCR values from the quote over the bank that verification used.
+// Quotes over other banks are not checked by server.VerifyAttestation and must not be trusted.
+func verifiedPCRs(attestation *pb.Attestation, ms *pb.MachineState) (map[uint32][]byte, error) {
+	for _, quote := range attestation.GetQuotes() {
+		if quote.GetPcrs().GetHash() == ms.GetHash() {
+			return quote.GetPcrs().GetPcrs(), nil
+		}
+	}
+	return nil, fmt.Errorf("no quote over the verified PCR bank (%v)", tpm2.Algorithm(ms.GetHash()))
+}
+
+func containsDigest(acceptable [][]byte, digest []byte) bool {
+	for _, a := range acceptable {
+		if bytes.Equal(a, digest) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package attestation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// testCoRIM encodes an unsigned CoRIM with one CoMID whose single reference triple carries
+// measurements.
+func testCoRIM(t *testing.T, tag uint64, measurements ...map[uint64]any) []byte {
+	t.Helper()
+	triple := []any{map[uint64]any{}, measurements}
+	comid, err := cbor.Marshal(map[uint64]any{comidTriplesKey: map[uint64]any{triplesReferenceKey: []any{triple}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	corim, err := cbor.Marshal(cbor.Tag{Number: tag, Content: map[uint64]any{corimTagsKey: []any{cbor.Tag{Number: comidTag, Content: comid}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return corim
+}
+
+// testDigests encodes digests as a CoRIM digests array of SHA-256 entries.
+func testDigests(digests ...[]byte) []any {
+	var entries []any
+	for _, digest := range digests {
+		entries = append(entries, []any{uint64(1), digest})
+	}
+	return entries
+}
+
+// testMeasurement returns a CoRIM measurement under key carrying digests.
+func testMeasurement(key any, digests ...[]byte) map[uint64]any {
+	return map[uint64]any{
+		measurementKeyKey:    key,
+		measurementValuesKey: map[uint64]any{valuesDigestsKey: testDigests(digests...)},
+	}
+}
+
+// testRegisters returns a CoRIM measurement of integrity registers, keyed by the keys of registers.
+func testRegisters(registers map[any][]byte) map[uint64]any {
+	entries := make(map[any]any, len(registers))
+	for key, digest := range registers {
+		entries[key] = testDigests(digest)
+	}
+	return map[uint64]any{measurementValuesKey: map[uint64]any{valuesIntegrityRegsKey: entries}}
+}
+
+func TestParseCoRIM(t *testing.T) {
+	corim := testCoRIM(t, corimUnsignedTag,
+		testMeasurement(uint64(4), []byte{0x04}, []byte{0x44}),
+		testRegisters(map[any][]byte{uint64(7): {0x07}, uint64(4): {0x40}}),
+		testMeasurement("MRTD", []byte{0xd0}),
+		testMeasurement("RTMR2", []byte{0xa2}),
+		testMeasurement("MEASUREMENT", []byte{0x5e}),
+	)
+	got, err := ParseCoRIM(corim)
+	if err != nil {
+		t.Fatalf("ParseCoRIM() failed: %v", err)
+	}
+	want := &ReferenceValues{
+		PCRs:              map[uint32][][]byte{4: {{0x04}, {0x44}, {0x40}}, 7: {{0x07}}},
+		RTMRs:             map[uint32][][]byte{2: {{0xa2}}},
+		MRTD:              [][]byte{{0xd0}},
+		SevSnpMeasurement: [][]byte{{0x5e}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseCoRIM() = %+v, want %+v", got, want)
+	}
+
+	tests := []struct {
+		name    string
+		corim   []byte
+		wantErr string
+	}{
+		{name: "signed", corim: testCoRIM(t, corimSignedTag), wantErr: "must be loaded with ParseRIM"},
+		{name: "other tag", corim: testCoRIM(t, 502), wantErr: "unexpected CoRIM tag 502"},
+		{name: "unknown TDX measurement", corim: testCoRIM(t, corimUnsignedTag, testMeasurement("MRSEAM", []byte{1})), wantErr: `unknown TEE measurement "MRSEAM"`},
+		{name: "miscased measurement", corim: testCoRIM(t, corimUnsignedTag, testMeasurement("MrTd", []byte{1})), wantErr: `unknown TEE measurement "MrTd"`},
+		{name: "RTMR out of range", corim: testCoRIM(t, corimUnsignedTag, testMeasurement("RTMR4", []byte{1})), wantErr: `unknown TDX measurement "RTMR4"`},
+		{name: "PCR out of range", corim: testCoRIM(t, corimUnsignedTag, testMeasurement(uint64(24), []byte{1})), wantErr: "invalid PCR index 24"},
+		// 1<<32 would truncate to PCR 0.
+		{name: "PCR over 32 bits", corim: testCoRIM(t, corimUnsignedTag, testMeasurement(uint64(1<<32), []byte{1})), wantErr: "invalid PCR index 4294967296"},
+		{name: "negative PCR", corim: testCoRIM(t, corimUnsignedTag, testMeasurement(int64(-1), []byte{1})), wantErr: "expected an unsigned integer or text"},
+		{name: "register out of range", corim: testCoRIM(t, corimUnsignedTag, testRegisters(map[any][]byte{uint64(1<<32 + 7): {1}})), wantErr: "invalid PCR index 4294967303"},
+		{name: "text register key", corim: testCoRIM(t, corimUnsignedTag, testRegisters(map[any][]byte{"pcr7": {1}})), wantErr: "expected an unsigned integer"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ParseCoRIM(tc.corim); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("ParseCoRIM() = %v, want an error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package attestation_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+
+	"lunal-attestation/pkg/attestation"
+	"lunal-attestation/pkg/attestation/attestationtest"
+)
+
+// simulatorVerifyOptions returns the options that verify reports of a simulated TPM, which has no
+// event log, over nonce.
+func simulatorVerifyOptions(nonce []byte) attestation.VerifyOptions {
+	opts := attestation.DefaultVerifyOptions()
+	opts.Nonce = nonce
+	opts.AllowMissingEventLog = true
+	return opts
+}
+
+func TestRejectCraftedAttestations(t *testing.T) {
+	builder, err := attestationtest.NewBuilder(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer builder.Close()
+	nonce := []byte("crafted-attestation-nonce")
+
+	// The report of the builder verifies before it is crafted.
+	report, err := builder.Attest(nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := attestation.VerifyAttestationContext(t.Context(), report, simulatorVerifyOptions(nonce)); err != nil {
+		t.Fatalf("VerifyAttestationContext() of the uncrafted report failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		mutation attestationtest.Mutation
+		policy   func(*attestation.VerifyOptions)
+		wantErr  string
+	}{
+		{name: "corrupt quote signature", mutation: attestationtest.CorruptQuoteSignature, wantErr: "signature verification failed"},
+		{name: "tampered PCR", mutation: attestationtest.TamperPCR(0), wantErr: "PCRs digest not matching"},
+		{name: "no quotes", mutation: attestationtest.DropQuotes, wantErr: "does not contain any quotes"},
+		{name: "mismatched AK", mutation: attestationtest.MismatchedAKPub, wantErr: "signature verification failed"},
+		{name: "mismatched AK certificate", mutation: attestationtest.MismatchedAKCert, wantErr: "AK certificate does not certify the AK"},
+		{
+			name:     "untrusted AK certificate",
+			mutation: attestationtest.UntrustedAKCert,
+			policy:   func(opts *attestation.VerifyOptions) { opts.VerifyGceAKCert = true },
+			wantErr:  "AK certificate",
+		},
+		{
+			name:     "unknown TEE",
+			mutation: attestationtest.UnknownTEE,
+			policy:   func(opts *attestation.VerifyOptions) { opts.RejectUnknownFields = true },
+			wantErr:  attestation.ErrUnknownFields.Error(),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			report, err := builder.Attest(nonce, tc.mutation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := simulatorVerifyOptions(nonce)
+			if tc.policy != nil {
+				tc.policy(&opts)
+			}
+			if _, err := attestation.VerifyAttestationContext(t.Context(), report, opts); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("VerifyAttestationContext() = %v, want an error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+
+	// Without RejectUnknownFields, the unknown TEE is reported rather than rejected.
+	report, err = builder.Attest(nonce, attestationtest.UnknownTEE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, err := attestation.VerifyAttestationContext(t.Context(), report, simulatorVerifyOptions(nonce))
+	if err != nil {
+		t.Fatalf("VerifyAttestationContext() of an unknown TEE failed: %v", err)
+	}
+	if len(verified.UnknownFields) == 0 {
+		t.Error("UnknownFields is empty, want the unknown TEE attestation")
+	}
+}
+
+func TestRejectCraftedFixture(t *testing.T) {
+	encoded, err := os.ReadFile("../../cmd/example/attestation.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := attestation.DefaultVerifyOptions()
+	opts.Nonce = []byte("fixed-deterministic-nonce-for-server")
+
+	tests := []struct {
+		name     string
+		mutation attestationtest.Mutation
+		wantErr  string
+	}{
+		{name: "corrupt event log", mutation: attestationtest.CorruptEventLog, wantErr: "event log"},
+		{name: "corrupt TEE signature", mutation: attestationtest.CorruptTEESignature, wantErr: "using quote's signature"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			report, err := attestationtest.Mutate(fixture, tc.mutation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := attestation.VerifyAttestationContext(t.Context(), report, opts); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("VerifyAttestationContext() = %v, want an error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+
+	if _, err := attestationtest.Mutate(fixture, attestationtest.DropQuotes, attestationtest.CorruptQuoteSignature); err == nil {
+		t.Error("Mutate() corrupting the signatures of no quotes succeeded")
+	}
+	if _, err := attestationtest.Mutate([]byte("not a report")); err == nil {
+		t.Error("Mutate() of a malformed report succeeded")
+	}
+}
@@ -0,0 +1,95 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// ErrReportDataMismatch is returned when the report data of a TEE attestation does not carry
+// VerifyOptions.ExpectedReportDataHash.
+var ErrReportDataMismatch = errors.New("TEE report data does not bind the expected hash")
+
+// ErrUnboundEvidence is returned, with VerifyOptions.RequireBoundEvidence, when the TEE report data
+// does not commit to the nonce signed by the TPM quotes.
+var ErrUnboundEvidence = errors.New("TEE report is not bound to the TPM quotes")
+
+// MaxBoundTeeNonceData is the most data BoundTeeNonce can append to the nonce digest.
+const MaxBoundTeeNonceData = MaxNonceSize - sha256.Size
+
+// PublicKeyReportData returns the SHA-256 digest of the PKIX encoding of pub, for a guest that
+// generated the key to attest with as its TEE nonce, and for the verifier to expect as
+// VerifyOptions.ExpectedReportDataHash.
+func PublicKeyReportData(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %v", err)
+	}
+	digest := sha256.Sum256(der)
+	return digest[:], nil
+}
+
+// teeReportData returns the REPORTDATA of the TDX quote or SEV-SNP report of attestation.
+func teeReportData(attestation *pb.Attestation) ([]byte, error) {
+	switch {
+	case attestation.GetTdxAttestation() != nil:
+		return attestation.GetTdxAttestation().GetTdQuoteBody().GetReportData(), nil
+	case attestation.GetSevSnpAttestation() != nil:
+		return attestation.GetSevSnpAttestation().GetReport().GetReportData(), nil
+	default:
+		return nil, fmt.Errorf("attestation does not contain a TEE attestation")
+	}
+}
+
+// checkReportDataHash checks that the TEE report data of attestation begins with expected.
+func checkReportDataHash(attestation *pb.Attestation, expected []byte) error {
+	reportData, err := teeReportData(attestation)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(reportData, expected) {
+		return fmt.Errorf("%w: report data %x, expected it to begin with %x", ErrReportDataMismatch, reportData, expected)
+	}
+	return nil
+}
+
+// BoundTeeNonce returns a TEE nonce that carries data while remaining bound to nonce: the SHA-256
+// digest of nonce followed by data, at most MaxBoundTeeNonceData bytes. Attesters pass it as
+// AttestOptions.TeeNonce, with nonce as AttestOptions.Nonce, to satisfy
+// VerifyOptions.RequireBoundEvidence.
+func BoundTeeNonce(nonce, data []byte) ([]byte, error) {
+	if len(data) > MaxBoundTeeNonceData {
+		return nil, fmt.Errorf("bound TEE nonce data has %d bytes, want at most %d", len(data), MaxBoundTeeNonceData)
+	}
+	digest := sha256.Sum256(nonce)
+	return append(digest[:], data...), nil
+}
+
+// checkBoundEvidence checks that the TEE report data of attestation commits to the nonce signed by
+// its TPM quotes: that it is the nonce, zero padded, as Attest binds it without a TEE nonce, or
+// begins with its SHA-256 digest, as BoundTeeNonce binds it.
+func checkBoundEvidence(attestation *pb.Attestation) error {
+	nonce, err := quotedNonce(attestation)
+	if err != nil {
+		return err
+	}
+	if len(nonce) == 0 {
+		return fmt.Errorf("%w: the TPM quotes sign an empty nonce", ErrUnboundEvidence)
+	}
+	reportData, err := teeReportData(attestation)
+	if err != nil {
+		return err
+	}
+	padded := make([]byte, len(reportData))
+	copy(padded, nonce)
+	digest := sha256.Sum256(nonce)
+	if len(nonce) <= len(reportData) && bytes.Equal(reportData, padded) || bytes.HasPrefix(reportData, digest[:]) {
+		return nil
+	}
+	return fmt.Errorf("%w: report data %x commits to neither the quoted nonce %x nor its SHA-256 digest", ErrUnboundEvidence, reportData, nonce)
+}
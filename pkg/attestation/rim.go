@@ -0,0 +1,176 @@
+package attestation
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// COSE header labels and algorithms (RFC 9052, RFC 9053) used by signed CoRIMs.
+const (
+	coseHeaderAlg = 1
+
+	coseAlgES256 = -7
+	coseAlgES384 = -35
+	coseAlgES512 = -36
+	coseAlgEdDSA = -8
+	coseAlgPS256 = -37
+	coseAlgPS384 = -38
+	coseAlgPS512 = -39
+)
+
+// corimIDKey is the CoRIM map key of the manifest identifier.
+const corimIDKey uint64 = 0
+
+// RIM is a vendor Reference Integrity Manifest whose signature has been verified
+type RIM struct {
+	// ID is the identifier of the manifest, from its CoRIM
+	ID string `json:"id"`
+	// ReferenceValues are the measurements the manifest allows
+	ReferenceValues *ReferenceValues `json:"referenceValues"`
+}
+
+// RIMMatch records a measurement that matched a reference value of a RIM
+type RIMMatch struct {
+	// RIM is the ID of the manifest
+	RIM string `json:"rim"`
+	// Measurement names the matched measurement, e.g. "PCR 0" or "MRTD"
+	Measurement string `json:"measurement"`
+	// Digest is the matched value
+	Digest HexBytes `json:"digest"`
+}
+
+// ParseRIM verifies the COSE_Sign1 signature of a signed CoRIM (CBOR tag 18) with vendorKey
+// (*ecdsa.PublicKey, *rsa.PublicKey or ed25519.PublicKey), and loads its reference values as
+// ParseCoRIM does.
+func ParseRIM(data []byte, vendorKey crypto.PublicKey) (*RIM, error) {
+	var signed cbor.Tag
+	if err := cbor.Unmarshal(data, &signed); err != nil {
+		return nil, fmt.Errorf("failed to decode RIM: %v", err)
+	}
+	if signed.Number != corimSignedTag {
+		return nil, fmt.Errorf("RIM has CBOR tag %d, expected a signed CoRIM (%d)", signed.Number, corimSignedTag)
+	}
+	sign1, ok := signed.Content.([]any)
+	if !ok || len(sign1) != 4 {
+		return nil, fmt.Errorf("RIM is not a COSE_Sign1 structure")
+	}
+	protected, ok1 := sign1[0].([]byte)
+	payload, ok2 := sign1[2].([]byte)
+	signature, ok3 := sign1[3].([]byte)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("RIM is not a COSE_Sign1 structure with an attached payload")
+	}
+
+	var header map[any]any
+	if err := cbor.Unmarshal(protected, &header); err != nil {
+		return nil, fmt.Errorf("failed to decode RIM protected header: %v", err)
+	}
+	alg, ok := header[uint64(coseHeaderAlg)].(int64)
+	if !ok {
+		return nil, fmt.Errorf("RIM protected header does not contain a signature algorithm")
+	}
+	toBeSigned, err := cbor.Marshal([]any{"Signature1", protected, []byte{}, payload})
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyCOSESignature(vendorKey, alg, toBeSigned, signature); err != nil {
+		return nil, fmt.Errorf("RIM signature: %w", err)
+	}
+
+	rv, corimMap, err := parseCoRIM(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &RIM{ID: corimID(corimMap[corimIDKey]), ReferenceValues: rv}, nil
+}
+
+// corimID formats a CoRIM identifier, which is text or a UUID.
+func corimID(id any) string {
+	switch id := id.(type) {
+	case string:
+		return id
+	case []byte:
+		if len(id) == 16 {
+			return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+		}
+		return fmt.Sprintf("%x", id)
+	}
+	return ""
+}
+
+// verifyCOSESignature verifies a COSE signature over toBeSigned by key with the COSE algorithm alg.
+func verifyCOSESignature(key crypto.PublicKey, alg int64, toBeSigned []byte, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case coseAlgES256, coseAlgPS256:
+		hash = crypto.SHA256
+	case coseAlgES384, coseAlgPS384:
+		hash = crypto.SHA384
+	case coseAlgES512, coseAlgPS512:
+		hash = crypto.SHA512
+	case coseAlgEdDSA:
+	default:
+		return fmt.Errorf("unsupported COSE algorithm %d", alg)
+	}
+
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if alg != coseAlgES256 && alg != coseAlgES384 && alg != coseAlgES512 {
+			return fmt.Errorf("COSE algorithm %d does not match an ECDSA key", alg)
+		}
+		// COSE encodes ECDSA signatures as r and s, each the size of the curve order.
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("ECDSA signature has %d bytes, expected %d", len(signature), 2*size)
+		}
+		h := hash.New()
+		h.Write(toBeSigned)
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, h.Sum(nil), r, s) {
+			return fmt.Errorf("signature verification failed")
+		}
+	case *rsa.PublicKey:
+		if alg != coseAlgPS256 && alg != coseAlgPS384 && alg != coseAlgPS512 {
+			return fmt.Errorf("COSE algorithm %d does not match an RSA key", alg)
+		}
+		h := hash.New()
+		h.Write(toBeSigned)
+		if err := rsa.VerifyPSS(key, hash, h.Sum(nil), signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}); err != nil {
+			return fmt.Errorf("signature verification failed: %v", err)
+		}
+	case ed25519.PublicKey:
+		if alg != coseAlgEdDSA {
+			return fmt.Errorf("COSE algorithm %d does not match an Ed25519 key", alg)
+		}
+		if !ed25519.Verify(key, toBeSigned, signature) {
+			return fmt.Errorf("signature verification failed")
+		}
+	default:
+		return fmt.Errorf("unsupported vendor key type %T", key)
+	}
+	return nil
+}
+
+// checkRIMs checks the measurements of a verified attestation against each RIM and returns the
+// reference values they matched.
+func checkRIMs(rims []*RIM, attestation *pb.Attestation, ms *pb.MachineState) ([]RIMMatch, error) {
+	var matches []RIMMatch
+	for _, rim := range rims {
+		matched, err := rim.ReferenceValues.match(attestation, ms)
+		if err != nil {
+			return nil, fmt.Errorf("RIM %q: %w", rim.ID, err)
+		}
+		for _, m := range matched {
+			matches = append(matches, RIMMatch{RIM: rim.ID, Measurement: m.measurement, Digest: m.digest})
+		}
+	}
+	return matches, nil
+}
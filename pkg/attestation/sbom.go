@@ -0,0 +1,88 @@
+package attestation
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kinds of MeasuredComponents
+const (
+	// ComponentKindFirmware is platform firmware code or its version, measured into PCR 0
+	ComponentKindFirmware = "firmware"
+	// ComponentKindDriver is a UEFI driver, such as an option ROM
+	ComponentKindDriver = "driver"
+	// ComponentKindBootApplication is a UEFI boot application, such as a shim, boot loader or
+	// kernel EFI stub
+	ComponentKindBootApplication = "boot-application"
+	// ComponentKindFile is a file the boot loader loaded, such as a kernel, initrd or configuration
+	ComponentKindFile = "file"
+)
+
+// Component is a binary measured during boot, as listed by MeasuredComponents
+type Component struct {
+	// Kind is the kind of component, e.g. ComponentKindBootApplication
+	Kind string `json:"kind"`
+	// Name is the file path or description recorded with the measurement. It is not measured, so it
+	// is only a hint, except for the firmware version.
+	Name string `json:"name,omitempty"`
+	// PCR is the PCR the component was measured into
+	PCR uint32 `json:"pcr"`
+	// Digest is the digest extended into PCR, from the verified PCR bank. For UEFI images it is
+	// their Authenticode digest.
+	Digest HexBytes `json:"digest"`
+	// Index is the position of the component's event in the event log
+	Index int `json:"index"`
+}
+
+// MeasuredComponents returns the binaries measured while booting a verified report, in load
+// order, as an inventory to compare against an approved software bill of materials: firmware,
+// UEFI drivers and boot applications, and the files the boot loader loaded into PCR 9. It only
+// reads the replayed TCG event log, so it is empty without one; IMA logs of what the kernel loads
+// later are not parsed by this package.
+func MeasuredComponents(report *VerificationReport) []Component {
+	if report == nil {
+		return nil
+	}
+	var components []Component
+	for i, event := range EventLogEntries(report.MachineState) {
+		component := Component{PCR: event.PCR, Digest: event.Digest, Index: i}
+		switch event.Type {
+		case evSCRTMVersion:
+			component.Kind = ComponentKindFirmware
+			component.Name = decodeUTF16(event.Data)
+		case evPostCode, evSCRTMContents, evEFIPlatformFirmwareBlob, evEFIPlatformFirmwareBlob2:
+			component.Kind = ComponentKindFirmware
+			component.Name = printableEventData(event.Data)
+		case evEFIBootServicesDriver, evEFIRuntimeServicesDriver:
+			component.Kind = ComponentKindDriver
+			component.Name = imageLoadEventPath(event.Data)
+		case evEFIBootServicesApplication:
+			component.Kind = ComponentKindBootApplication
+			component.Name = imageLoadEventPath(event.Data)
+		case evIPL:
+			// GRUB measures the files it loads into PCR 9, and commands into PCR 8.
+			if event.PCR != 9 {
+				continue
+			}
+			component.Kind = ComponentKindFile
+			component.Name = printableEventData(event.Data)
+		default:
+			continue
+		}
+		components = append(components, component)
+	}
+	return components
+}
+
+// printableEventData returns event data that is a NUL-terminated string as a string, and an empty
+// string for binary data such as a firmware blob's address and length.
+func printableEventData(data []byte) string {
+	text := strings.TrimRight(string(data), "\x00")
+	for _, r := range text {
+		if r == utf8.RuneError || !unicode.IsPrint(r) {
+			return ""
+		}
+	}
+	return text
+}
@@ -0,0 +1,114 @@
+package attestation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ErrUnknownFields is returned with VerifyOptions.RejectUnknownFields for reports carrying fields
+// this package does not know, as produced against a newer attest.proto.
+var ErrUnknownFields = errors.New("attestation has fields unknown to this verifier")
+
+// unknownAttestationFields lists the fields of attestation, at any depth, that its schema does not
+// define, e.g. "17" for a top-level field 17 or "quotes[0].9" for a field of a quote. Protobuf
+// keeps them as unknown fields, which verification otherwise ignores.
+func unknownAttestationFields(attestation *pb.Attestation) ([]string, error) {
+	var paths []string
+	err := walkUnknownFields(attestation.ProtoReflect(), "", func(path string, num protowire.Number) {
+		paths = append(paths, joinFieldPath(path, fmt.Sprint(num)))
+	})
+	return paths, err
+}
+
+// unknownReportFields lists the unknown fields of attestation, as unknownAttestationFields, and
+// those of the attestation envelope of the report data it was unmarshaled from.
+func unknownReportFields(data []byte, attestation *pb.Attestation) ([]string, error) {
+	paths, err := unknownAttestationFields(attestation)
+	if err != nil {
+		return nil, err
+	}
+	envelopePaths, err := unknownEnvelopeFields(data)
+	if err != nil {
+		return nil, err
+	}
+	return append(paths, envelopePaths...), nil
+}
+
+// walkUnknownFields calls visit with the path and number of every unknown field of m and of the
+// messages it contains.
+func walkUnknownFields(m protoreflect.Message, path string, visit func(path string, num protowire.Number)) error {
+	for unknown := m.GetUnknown(); len(unknown) > 0; {
+		num, typ, n := protowire.ConsumeTag(unknown)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		unknown = unknown[n:]
+		n = protowire.ConsumeFieldValue(num, typ, unknown)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		unknown = unknown[n:]
+		visit(path, num)
+	}
+
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		name := joinFieldPath(path, string(fd.Name()))
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() == nil {
+				return true
+			}
+			value.Map().Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+				err = walkUnknownFields(value.Message(), fmt.Sprintf("%s[%v]", name, key.Interface()), visit)
+				return err == nil
+			})
+		case fd.IsList():
+			if fd.Message() == nil {
+				return true
+			}
+			list := value.List()
+			for i := 0; i < list.Len() && err == nil; i++ {
+				err = walkUnknownFields(list.Get(i).Message(), fmt.Sprintf("%s[%d]", name, i), visit)
+			}
+		case fd.Message() != nil:
+			err = walkUnknownFields(value.Message(), name, visit)
+		}
+		return err == nil
+	})
+	return err
+}
+
+// joinFieldPath appends name to the field path path.
+func joinFieldPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// checkAttestationSchema fails with ErrUnknownFields if the report carries fields this package
+// does not know. Unknown fields of textproto reports are found by parsing data again without
+// discarding them.
+func checkAttestationSchema(data []byte, format string, attestation *pb.Attestation) error {
+	if format == "textproto" {
+		if err := prototext.Unmarshal(data, &pb.Attestation{}); err != nil {
+			return fmt.Errorf("%w: %v", ErrUnknownFields, err)
+		}
+		return nil
+	}
+	paths, err := unknownReportFields(data, attestation)
+	if err != nil {
+		return err
+	}
+	if len(paths) != 0 {
+		return fmt.Errorf("%w: %s; it was likely produced against a newer attest.proto", ErrUnknownFields, strings.Join(paths, ", "))
+	}
+	return nil
+}
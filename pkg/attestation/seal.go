@@ -0,0 +1,130 @@
+package attestation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Files written by VerifyAndArchive into an evidence package.
+const (
+	// EvidenceManifestFile is the EvidenceManifest of the package
+	EvidenceManifestFile = "manifest.json"
+	// EvidenceSummaryFile is the Summary of the verification
+	EvidenceSummaryFile = "report.json"
+	// EvidenceMachineStateFile is the verified machine state, in protojson
+	EvidenceMachineStateFile = "machine-state.json"
+	// EvidenceBundleFile is the EvidenceBundle of the report and the collateral used to verify it
+	EvidenceBundleFile = "evidence.json"
+)
+
+// EvidenceManifest describes an evidence package written by VerifyAndArchive
+type EvidenceManifest struct {
+	// Fingerprint is the AttestationFingerprint of the verified report
+	Fingerprint HexBytes `json:"fingerprint"`
+	// Format is the format of the report, binarypb or textproto
+	Format string `json:"format"`
+	// Technology is the verified TEE technology (sev-snp, tdx, or empty)
+	Technology string `json:"technology"`
+	// VerifiedAt is when the report was verified
+	VerifiedAt time.Time `json:"verifiedAt"`
+	// Files maps the name of each other file in the package to its SHA-256 digest
+	Files map[string]HexBytes `json:"files"`
+}
+
+// VerifyAndArchive verifies an attestation report with the default options and seals the result
+// into dir as an evidence package: the verification summary, the machine state, an EvidenceBundle
+// of the report and the collateral used, and a manifest with the report fingerprint and the digest
+// of each file. dir is created if needed. Nothing is written if verification fails, and an existing
+// package in dir is never overwritten. The package can be verified again offline by passing its
+// EvidenceBundle to VerifyEvidenceBundle.
+func VerifyAndArchive(attestationBytes []byte, format string, nonce, teeNonce []byte, dir string) (*VerificationReport, error) {
+	opts := DefaultVerifyOptions()
+	opts.Format = format
+	opts.Nonce = nonce
+	opts.TeeNonce = teeNonce
+
+	recorder := &recordingCollateral{source: newCollateralCache(nil, 0, nil, nil)}
+	capturedAt := time.Now()
+	report, err := verifyAttestation(context.Background(), attestationBytes, opts, recorder)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := AttestationFingerprint(report.Attestation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fingerprint attestation: %v", err)
+	}
+	summary, err := json.MarshalIndent(Summarize(report, nil), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	machineState, err := MachineStateJSON(report.MachineState, false)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := json.MarshalIndent(&EvidenceBundle{
+		Attestation: attestationBytes,
+		Format:      format,
+		Collateral:  recorder.documents,
+		CapturedAt:  capturedAt,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{
+		EvidenceSummaryFile:      summary,
+		EvidenceMachineStateFile: machineState,
+		EvidenceBundleFile:       bundle,
+	}
+	manifest := EvidenceManifest{
+		Fingerprint: fingerprint,
+		Format:      format,
+		Technology:  report.Technology,
+		VerifiedAt:  report.VerifiedAt,
+		Files:       make(map[string]HexBytes, len(files)),
+	}
+	for name, data := range files {
+		digest := sha256.Sum256(data)
+		manifest.Files[name] = digest[:]
+	}
+	encodedManifest, err := json.MarshalIndent(&manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create evidence directory: %v", err)
+	}
+	// The manifest is written last, so that a package with a manifest is complete.
+	for _, name := range []string{EvidenceSummaryFile, EvidenceMachineStateFile, EvidenceBundleFile} {
+		if err := writeNewFile(filepath.Join(dir, name), files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeNewFile(filepath.Join(dir, EvidenceManifestFile), encodedManifest); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// writeNewFile writes data to a file at path, failing if it already exists.
+func writeNewFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
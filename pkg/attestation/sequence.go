@@ -0,0 +1,62 @@
+package attestation
+
+import (
+	"bytes"
+	"fmt"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// ExpectedEvent is a measurement of VerifyOptions.ExpectedEventSequence
+type ExpectedEvent struct {
+	// PCR is the PCR the measurement must be extended into
+	PCR uint32 `json:"pcr"`
+	// Digest is the digest extended into PCR, in the verified PCR bank
+	Digest HexBytes `json:"digest"`
+}
+
+// EventSequenceError is returned for an event log that does not contain the measurements of
+// VerifyOptions.ExpectedEventSequence in order
+type EventSequenceError struct {
+	// Step is the index in the expected sequence of the first measurement not found in order
+	Step int
+	// Event is that measurement
+	Event ExpectedEvent
+	// OutOfOrder reports that the measurement is in the event log, but only before the
+	// measurement expected ahead of it
+	OutOfOrder bool
+}
+
+func (e *EventSequenceError) Error() string {
+	if e.OutOfOrder {
+		return fmt.Sprintf("measurement %d of the expected sequence (PCR %d, digest %s) is extended out of order", e.Step, e.Event.PCR, e.Event.Digest)
+	}
+	return fmt.Sprintf("measurement %d of the expected sequence (PCR %d, digest %s) is not in the event log", e.Step, e.Event.PCR, e.Event.Digest)
+}
+
+// checkEventSequence checks that the events of a verified machine state contain the measurements
+// of expected in order. Other events may come between them, but an expected measurement only
+// counts once the one before it was extended.
+func checkEventSequence(ms *pb.MachineState, expected []ExpectedEvent) error {
+	step := 0
+	for _, event := range EventLogEntries(ms) {
+		if step == len(expected) {
+			return nil
+		}
+		if event.PCR == expected[step].PCR && bytes.Equal(event.Digest, expected[step].Digest) {
+			step++
+		}
+	}
+	if step == len(expected) {
+		return nil
+	}
+
+	err := &EventSequenceError{Step: step, Event: expected[step]}
+	for _, event := range EventLogEntries(ms) {
+		if event.PCR == err.Event.PCR && bytes.Equal(event.Digest, err.Event.Digest) {
+			err.OutOfOrder = true
+			break
+		}
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package attestation
+
+import (
+	"bytes"
+	"fmt"
+
+	sabi "github.com/google/go-sev-guest/abi"
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+	"github.com/google/go-sev-guest/validate"
+)
+
+// SevSnpPolicy holds the SEV-SNP-specific requirements of a verification
+type SevSnpPolicy struct {
+	// ExpectedReportID is the required 32-byte REPORT_ID of the guest. Not checked if empty.
+	ExpectedReportID []byte `json:"expectedReportID,omitempty"`
+	// ExpectedReportIDMA is the required 32-byte REPORT_ID_MA, the report ID of the guest's
+	// migration agent. Not checked if empty.
+	ExpectedReportIDMA []byte `json:"expectedReportIDMA,omitempty"`
+	// TrustedIDKeyHashes are the SHA-384 digests of the ID keys, in SEV-SNP API format, trusted to
+	// sign the ID block that authorized the guest's launch. Setting it or TrustedAuthorKeyHashes
+	// requires the guest to have been launched with an ID block signed by a trusted key.
+	TrustedIDKeyHashes [][]byte `json:"trustedIDKeyHashes,omitempty"`
+	// TrustedAuthorKeyHashes are the SHA-384 digests of the author keys, in SEV-SNP API format,
+	// trusted to sign ID keys. An ID key signed by a trusted author key is trusted.
+	TrustedAuthorKeyHashes [][]byte `json:"trustedAuthorKeyHashes,omitempty"`
+	// RequireAuthorKey requires the ID key to be signed by one of TrustedAuthorKeyHashes
+	RequireAuthorKey bool `json:"requireAuthorKey,omitempty"`
+	// RequireEmbeddedCerts verifies the report offline, with the VCEK or VLEK, ASK and ARK
+	// certificates embedded in the attestation as by an extended report, and fails if any is
+	// missing. Otherwise missing certificates are fetched from AMD KDS.
+	RequireEmbeddedCerts bool `json:"requireEmbeddedCerts,omitempty"`
+	// RequireSMTDisabled requires the PLATFORM_INFO of the report to show simultaneous
+	// multithreading disabled on the host, so that no sibling thread of another tenant shares a
+	// core with the guest
+	RequireSMTDisabled bool `json:"requireSMTDisabled,omitempty"`
+	// RequireTSMEEnabled requires the PLATFORM_INFO of the report to show transparent secure memory
+	// encryption enabled on the host
+	RequireTSMEEnabled bool `json:"requireTSMEEnabled,omitempty"`
+}
+
+// PLATFORM_INFO bits of a SEV-SNP report
+const (
+	sevSnpPlatformInfoSMTEnabled  = 1 << 0
+	sevSnpPlatformInfoTSMEEnabled = 1 << 1
+)
+
+// apply adds the requirements of p to the validation options v.
+func (p *SevSnpPolicy) apply(v *validate.Options) {
+	if p == nil {
+		return
+	}
+	if len(p.ExpectedReportID) != 0 {
+		v.ReportID = p.ExpectedReportID
+	}
+	if len(p.ExpectedReportIDMA) != 0 {
+		v.ReportIDMA = p.ExpectedReportIDMA
+	}
+	if len(p.TrustedIDKeyHashes) != 0 || len(p.TrustedAuthorKeyHashes) != 0 || p.RequireAuthorKey {
+		// The firmware checked the ID block signatures at launch, so trusting the key digests in
+		// the report is enough.
+		v.RequireIDBlock = true
+		v.RequireAuthorKey = p.RequireAuthorKey
+		v.TrustedIDKeyHashes = p.TrustedIDKeyHashes
+		v.TrustedAuthorKeyHashes = p.TrustedAuthorKeyHashes
+	}
+}
+
+// checkPlatformInfo checks the PLATFORM_INFO of report against p.
+func (p *SevSnpPolicy) checkPlatformInfo(report *spb.Report) error {
+	if p == nil {
+		return nil
+	}
+	info := report.GetPlatformInfo()
+	if p.RequireSMTDisabled && info&sevSnpPlatformInfoSMTEnabled != 0 {
+		return fmt.Errorf("SEV-SNP PLATFORM_INFO %#x shows SMT enabled, but the policy requires it disabled", info)
+	}
+	if p.RequireTSMEEnabled && info&sevSnpPlatformInfoTSMEEnabled == 0 {
+		return fmt.Errorf("SEV-SNP PLATFORM_INFO %#x shows TSME disabled, but the policy requires it enabled", info)
+	}
+	return nil
+}
+
+// SevSnpReport describes the guest of a verified SEV-SNP attestation
+type SevSnpReport struct {
+	// ReportID is the REPORT_ID of the guest
+	ReportID []byte
+	// ReportIDMA is the REPORT_ID_MA of the guest, identifying its migration agent. It is all 0xff
+	// bytes when the guest has no migration agent.
+	ReportIDMA []byte
+	// LaunchAuthorization describes the ID block the guest was launched with, if any
+	LaunchAuthorization *SevSnpLaunchAuthorization
+	// EmbeddedCerts reports that the attestation embedded its full certificate chain, so that
+	// none was fetched from AMD KDS
+	EmbeddedCerts bool
+	// PlatformInfo is the PLATFORM_INFO of the report, describing the host's configuration
+	PlatformInfo uint64
+	// SMTEnabled reports that the host has simultaneous multithreading enabled, from PLATFORM_INFO
+	SMTEnabled bool
+	// TSMEEnabled reports that the host has transparent secure memory encryption enabled, from
+	// PLATFORM_INFO
+	TSMEEnabled bool
+}
+
+// SevSnpLaunchAuthorization describes the ID block that authorized the launch of a SEV-SNP guest.
+// The firmware only launches the guest if the ID block is signed by the ID key and its launch
+// digest equals the guest's MEASUREMENT.
+type SevSnpLaunchAuthorization struct {
+	// IDKeyDigest is the SHA-384 digest of the ID key that signed the ID block
+	IDKeyDigest HexBytes
+	// AuthorKeyDigest is the SHA-384 digest of the author key that signed the ID key, if any
+	AuthorKeyDigest HexBytes
+	// FamilyID is the FAMILY_ID of the ID block
+	FamilyID HexBytes
+	// ImageID is the IMAGE_ID of the ID block
+	ImageID HexBytes
+	// GuestSVN is the guest SVN of the ID block
+	GuestSVN uint32
+}
+
+func newSevSnpReport(report *spb.Report) *SevSnpReport {
+	return &SevSnpReport{
+		ReportID:            report.GetReportId(),
+		ReportIDMA:          report.GetReportIdMa(),
+		LaunchAuthorization: newSevSnpLaunchAuthorization(report),
+		PlatformInfo:        report.GetPlatformInfo(),
+		SMTEnabled:          report.GetPlatformInfo()&sevSnpPlatformInfoSMTEnabled != 0,
+		TSMEEnabled:         report.GetPlatformInfo()&sevSnpPlatformInfoTSMEEnabled != 0,
+	}
+}
+
+// newSevSnpLaunchAuthorization returns the ID block details of report, or nil if the guest was
+// launched without an ID block.
+func newSevSnpLaunchAuthorization(report *spb.Report) *SevSnpLaunchAuthorization {
+	idKeyDigest := report.GetIdKeyDigest()
+	if len(idKeyDigest) == 0 || bytes.Equal(idKeyDigest, make([]byte, len(idKeyDigest))) {
+		return nil
+	}
+	authorization := &SevSnpLaunchAuthorization{
+		IDKeyDigest: idKeyDigest,
+		FamilyID:    report.GetFamilyId(),
+		ImageID:     report.GetImageId(),
+		GuestSVN:    report.GetGuestSvn(),
+	}
+	if info, err := sabi.ParseSignerInfo(report.GetSignerInfo()); err == nil && info.AuthorKeyEn {
+		authorization.AuthorKeyDigest = report.GetAuthorKeyDigest()
+	}
+	return authorization
+}
@@ -0,0 +1,444 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	_ "embed"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/google/go-tdx-guest/pcs"
+	"github.com/google/go-tdx-guest/verify/trust"
+)
+
+// Intel's SGX root CA certificate (DER encoded), as published at
+// https://certificates.trustedservices.intel.com/Intel_SGX_Provisioning_Certification_RootCA.cer
+//
+//go:embed ca-certs/intel_sgx_root_ca.cer
+var intelSGXRootCA []byte
+
+// IntelSGXRootCerts are the bundled Intel roots of PCK certificate chains, trusted by SgxPolicy
+// unless it sets TrustedRoots
+var IntelSGXRootCerts []*x509.Certificate
+
+func init() {
+	var err error
+	IntelSGXRootCerts, err = parseCertificates(intelSGXRootCA)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse bundled Intel SGX root certificate: %v", err))
+	}
+}
+
+// Intel PCS endpoints of the SGX collateral, as opposed to the TDX collateral of pcs.TcbInfoURL
+// and pcs.QeIdentityURL.
+const (
+	sgxTcbInfoURL    = "https://api.trustedservices.intel.com/sgx/certification/v4/tcb?fmspc="
+	sgxQeIdentityURL = "https://api.trustedservices.intel.com/sgx/certification/v4/qe/identity"
+)
+
+// Layout of an SGX DCAP quote, version 3, with an ECDSA P-256 attestation key.
+const (
+	sgxQuoteVersion        = 3
+	sgxAttestationKeyType  = 2
+	sgxTeeType             = 0
+	sgxQuoteHeaderSize     = 48
+	sgxReportBodySize      = 384
+	sgxSignatureSize       = 64
+	sgxAttestationKeySize  = 64
+	sgxReportDataSize      = 64
+	sgxPCKCertChainType    = 5
+	sgxDebugAttribute      = 0x02
+	sgxQuoteSignedDataSize = sgxQuoteHeaderSize + sgxReportBodySize
+)
+
+// SgxPolicy holds the requirements of an SGX enclave quote
+type SgxPolicy struct {
+	// ExpectedMRENCLAVEs lists the accepted enclave measurements. Empty accepts any enclave.
+	ExpectedMRENCLAVEs []HexBytes `json:"expectedMRENCLAVEs,omitempty"`
+	// ExpectedMRSIGNERs lists the accepted enclave signer measurements. Empty accepts any signer.
+	ExpectedMRSIGNERs []HexBytes `json:"expectedMRSIGNERs,omitempty"`
+	// ISVProdID is the required product ID of the enclave, if set
+	ISVProdID *uint16 `json:"isvProdId,omitempty"`
+	// MinISVSVN is the minimum security version of the enclave
+	MinISVSVN uint16 `json:"minIsvSvn,omitempty"`
+	// AllowDebug accepts enclaves launched in debug mode, whose memory is not protected
+	AllowDebug bool `json:"allowDebug,omitempty"`
+	// ExpectedFMSPCs lists the accepted platform FMSPCs, as hex strings. Empty accepts any FMSPC.
+	ExpectedFMSPCs []string `json:"expectedFMSPCs,omitempty"`
+	// RequireTCBStatus lists the accepted TCB statuses of the platform (e.g. "UpToDate"), as
+	// resolved from Intel's SGX TCB info and QE identity. Empty skips TCB status evaluation.
+	RequireTCBStatus []string `json:"requireTCBStatus,omitempty"`
+	// TrustedRoots are the accepted roots of the PCK certificate chain. Defaults to
+	// IntelSGXRootCerts. Not encoded as JSON.
+	TrustedRoots []*x509.Certificate `json:"-"`
+	// VerificationTime is the time at which certificates are checked. Defaults to the current time.
+	VerificationTime time.Time `json:"verificationTime,omitempty"`
+}
+
+// SgxReport describes the enclave and platform of a verified SGX quote
+type SgxReport struct {
+	// MRENCLAVE is the measurement of the enclave
+	MRENCLAVE HexBytes
+	// MRSIGNER is the measurement of the enclave signer
+	MRSIGNER HexBytes
+	// ISVProdID is the product ID of the enclave
+	ISVProdID uint16
+	// ISVSVN is the security version of the enclave
+	ISVSVN uint16
+	// Debug reports that the enclave was launched in debug mode
+	Debug bool
+	// FMSPC identifies the platform family, as a hex string
+	FMSPC string
+	// TCBStatus is the resolved TCB status of the platform. It is only set when the SgxPolicy
+	// requires a TCB status.
+	TCBStatus string
+}
+
+// sgxQuote is a parsed SGX DCAP quote.
+type sgxQuote struct {
+	signedData     []byte
+	body           []byte
+	signature      []byte
+	attestationKey []byte
+	qeReport       []byte
+	qeSignature    []byte
+	qeAuthData     []byte
+	pckChain       []*x509.Certificate
+}
+
+// VerifySgxQuote verifies an SGX DCAP quote of an enclave: its PCK certificate chain, quoting
+// enclave and signature, that its report data holds nonce, and that the enclave and platform
+// satisfy policy. A nil policy only checks the quote and nonce, and rejects debug enclaves.
+func VerifySgxQuote(quote []byte, nonce []byte, policy *SgxPolicy) error {
+	_, err := verifySgxQuote(quote, nonce, policy, trust.DefaultHTTPSGetter())
+	return err
+}
+
+// VerifySgxQuote verifies an SGX DCAP quote like the package-level VerifySgxQuote, fetching Intel
+// collateral through the verifier's collateral cache, and describes the verified enclave.
+func (v *Verifier) VerifySgxQuote(ctx context.Context, quote []byte, nonce []byte, policy *SgxPolicy) (*SgxReport, error) {
+	return verifySgxQuote(quote, nonce, policy, &tdxCollateralGetter{ctx: ctx, source: v.collateral})
+}
+
+// verifySgxQuote verifies an SGX DCAP quote, fetching Intel collateral through getter.
+func verifySgxQuote(rawQuote []byte, nonce []byte, policy *SgxPolicy, getter trust.HTTPSGetter) (*SgxReport, error) {
+	if policy == nil {
+		policy = &SgxPolicy{}
+	}
+	if len(nonce) == 0 {
+		return nil, fmt.Errorf("a nonce is required to verify an SGX quote")
+	}
+	if len(nonce) > sgxReportDataSize {
+		return nil, fmt.Errorf("invalid nonce: %w: got %d bytes, at most %d fit in the SGX report data", ErrNonceTooLong, len(nonce), sgxReportDataSize)
+	}
+	quote, err := parseSgxQuote(rawQuote)
+	if err != nil {
+		return nil, err
+	}
+
+	now := policy.VerificationTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+	root, err := verifySgxPCKChain(quote.pckChain, policy.TrustedRoots, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySgxQuoteSignatures(quote); err != nil {
+		return nil, err
+	}
+
+	body := quote.body
+	reportData := make([]byte, sgxReportDataSize)
+	copy(reportData, nonce)
+	if !bytes.Equal(body[320:384], reportData) {
+		return nil, fmt.Errorf("SGX report data %x does not match the nonce", body[320:384])
+	}
+
+	exts, err := pcs.PckCertificateExtensions(quote.pckChain[0])
+	if err != nil {
+		return nil, fmt.Errorf("could not get PCK certificate extensions: %v", err)
+	}
+	report := &SgxReport{
+		MRENCLAVE: append(HexBytes(nil), body[64:96]...),
+		MRSIGNER:  append(HexBytes(nil), body[128:160]...),
+		ISVProdID: binary.LittleEndian.Uint16(body[256:258]),
+		ISVSVN:    binary.LittleEndian.Uint16(body[258:260]),
+		Debug:     body[48]&sgxDebugAttribute != 0,
+		FMSPC:     strings.ToLower(exts.FMSPC),
+	}
+	if err := checkSgxPolicy(report, policy); err != nil {
+		return nil, err
+	}
+
+	if len(policy.RequireTCBStatus) != 0 {
+		status, err := sgxTCBStatus(quote, exts, root, getter)
+		if err != nil {
+			return nil, err
+		}
+		report.TCBStatus = string(status)
+		if !containsFold(policy.RequireTCBStatus, report.TCBStatus) {
+			return nil, fmt.Errorf("TCB status %s is not an accepted status", report.TCBStatus)
+		}
+	}
+	return report, nil
+}
+
+// parseSgxQuote parses a version 3 SGX DCAP quote signed with an ECDSA P-256 attestation key and
+// certified by a PCK certificate chain.
+func parseSgxQuote(raw []byte) (*sgxQuote, error) {
+	if len(raw) < sgxQuoteSignedDataSize+4 {
+		return nil, fmt.Errorf("SGX quote is %d bytes, too short for its header and report body", len(raw))
+	}
+	if version := binary.LittleEndian.Uint16(raw[0:2]); version != sgxQuoteVersion {
+		return nil, fmt.Errorf("unsupported SGX quote version %d", version)
+	}
+	if keyType := binary.LittleEndian.Uint16(raw[2:4]); keyType != sgxAttestationKeyType {
+		return nil, fmt.Errorf("unsupported SGX attestation key type %d", keyType)
+	}
+	if teeType := binary.LittleEndian.Uint32(raw[4:8]); teeType != sgxTeeType {
+		return nil, fmt.Errorf("quote has TEE type %#x, not SGX", teeType)
+	}
+
+	quote := &sgxQuote{
+		signedData: raw[:sgxQuoteSignedDataSize],
+		body:       raw[sgxQuoteHeaderSize:sgxQuoteSignedDataSize],
+	}
+	signatureDataSize := binary.LittleEndian.Uint32(raw[sgxQuoteSignedDataSize : sgxQuoteSignedDataSize+4])
+	data := raw[sgxQuoteSignedDataSize+4:]
+	if uint64(len(data)) != uint64(signatureDataSize) {
+		return nil, fmt.Errorf("SGX quote has %d bytes of signature data, expected %d", len(data), signatureDataSize)
+	}
+
+	next := func(n int) ([]byte, error) {
+		if len(data) < n {
+			return nil, fmt.Errorf("SGX quote signature data is truncated")
+		}
+		field := data[:n]
+		data = data[n:]
+		return field, nil
+	}
+	var err error
+	if quote.signature, err = next(sgxSignatureSize); err != nil {
+		return nil, err
+	}
+	if quote.attestationKey, err = next(sgxAttestationKeySize); err != nil {
+		return nil, err
+	}
+	if quote.qeReport, err = next(sgxReportBodySize); err != nil {
+		return nil, err
+	}
+	if quote.qeSignature, err = next(sgxSignatureSize); err != nil {
+		return nil, err
+	}
+	size, err := next(2)
+	if err != nil {
+		return nil, err
+	}
+	if quote.qeAuthData, err = next(int(binary.LittleEndian.Uint16(size))); err != nil {
+		return nil, err
+	}
+	header, err := next(6)
+	if err != nil {
+		return nil, err
+	}
+	if certType := binary.LittleEndian.Uint16(header[0:2]); certType != sgxPCKCertChainType {
+		return nil, fmt.Errorf("unsupported SGX certification data type %d", certType)
+	}
+	certData, err := next(int(binary.LittleEndian.Uint32(header[2:6])))
+	if err != nil {
+		return nil, err
+	}
+
+	for rest := certData; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PCK certificate chain: %v", err)
+		}
+		quote.pckChain = append(quote.pckChain, cert)
+	}
+	if len(quote.pckChain) == 0 {
+		return nil, fmt.Errorf("quote does not contain a PCK certificate chain")
+	}
+	return quote, nil
+}
+
+// verifySgxPCKChain verifies a PCK certificate chain, leaf first, against roots (IntelSGXRootCerts
+// if empty) and returns the trusted root it chains to.
+func verifySgxPCKChain(chain []*x509.Certificate, roots []*x509.Certificate, now time.Time) (*x509.Certificate, error) {
+	if len(roots) == 0 {
+		roots = IntelSGXRootCerts
+	}
+	rootPool := x509.NewCertPool()
+	for _, root := range roots {
+		rootPool.AddCert(root)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         rootPool,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify PCK certificate chain: %v", err)
+	}
+	verified := chains[0]
+	return verified[len(verified)-1], nil
+}
+
+// verifySgxQuoteSignatures checks that the quoting enclave report is signed by the PCK key and
+// binds the attestation key, and that the attestation key signed the quote.
+func verifySgxQuoteSignatures(quote *sgxQuote) error {
+	pckKey, ok := quote.pckChain[0].PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("PCK certificate has a %T key, expected ECDSA", quote.pckChain[0].PublicKey)
+	}
+	if !verifyRawECDSA(pckKey, quote.qeReport, quote.qeSignature) {
+		return fmt.Errorf("quoting enclave report signature verification failed")
+	}
+
+	binding := sha256.Sum256(append(append([]byte(nil), quote.attestationKey...), quote.qeAuthData...))
+	qeReportData := quote.qeReport[320:384]
+	if !bytes.Equal(qeReportData[:sha256.Size], binding[:]) || !bytes.Equal(qeReportData[sha256.Size:], make([]byte, sgxReportDataSize-sha256.Size)) {
+		return fmt.Errorf("quoting enclave report does not certify the attestation key")
+	}
+
+	attestationKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(quote.attestationKey[:32]),
+		Y:     new(big.Int).SetBytes(quote.attestationKey[32:]),
+	}
+	if !attestationKey.Curve.IsOnCurve(attestationKey.X, attestationKey.Y) {
+		return fmt.Errorf("invalid attestation key")
+	}
+	if !verifyRawECDSA(attestationKey, quote.signedData, quote.signature) {
+		return fmt.Errorf("SGX quote signature verification failed")
+	}
+	return nil
+}
+
+// verifyRawECDSA verifies an ECDSA signature over the SHA-256 digest of message, encoded as the
+// concatenated r and s of 32 bytes each.
+func verifyRawECDSA(key *ecdsa.PublicKey, message []byte, signature []byte) bool {
+	digest := sha256.Sum256(message)
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	return ecdsa.Verify(key, digest[:], r, s)
+}
+
+// checkSgxPolicy checks the enclave identity and platform of a verified quote against policy.
+func checkSgxPolicy(report *SgxReport, policy *SgxPolicy) error {
+	if report.Debug && !policy.AllowDebug {
+		return fmt.Errorf("enclave is launched in debug mode")
+	}
+	if len(policy.ExpectedMRENCLAVEs) != 0 && !containsHexBytes(policy.ExpectedMRENCLAVEs, report.MRENCLAVE) {
+		return fmt.Errorf("MRENCLAVE %s is not an expected value", report.MRENCLAVE)
+	}
+	if len(policy.ExpectedMRSIGNERs) != 0 && !containsHexBytes(policy.ExpectedMRSIGNERs, report.MRSIGNER) {
+		return fmt.Errorf("MRSIGNER %s is not an expected value", report.MRSIGNER)
+	}
+	if policy.ISVProdID != nil && report.ISVProdID != *policy.ISVProdID {
+		return fmt.Errorf("ISVPRODID %d is not the expected %d", report.ISVProdID, *policy.ISVProdID)
+	}
+	if report.ISVSVN < policy.MinISVSVN {
+		return fmt.Errorf("ISVSVN %d is below the minimum %d", report.ISVSVN, policy.MinISVSVN)
+	}
+	if len(policy.ExpectedFMSPCs) != 0 && !containsFold(policy.ExpectedFMSPCs, report.FMSPC) {
+		return fmt.Errorf("FMSPC %s is not an expected value", report.FMSPC)
+	}
+	return nil
+}
+
+// sgxTCBStatus resolves the TCB status of the platform of a quote from Intel's SGX TCB info and
+// the identity of its quoting enclave. A quoting enclave status other than UpToDate takes
+// precedence over the platform's.
+func sgxTCBStatus(quote *sgxQuote, exts *pcs.PckExtensions, root *x509.Certificate, getter trust.HTTPSGetter) (pcs.TcbComponentStatus, error) {
+	fmspc := strings.ToLower(exts.FMSPC)
+	tcbInfo, err := fetchTcbInfo(sgxTcbInfoURL+fmspc, fmspc, root, getter)
+	if err != nil {
+		return "", err
+	}
+	var platform *pcs.TcbLevel
+	for i, level := range tcbInfo.TcbLevels {
+		if svnsAtLeast(exts.TCB.CPUSvnComponents, level.Tcb.SgxTcbcomponents, 0) && exts.TCB.PCESvn >= level.Tcb.Pcesvn {
+			platform = &tcbInfo.TcbLevels[i]
+			break
+		}
+	}
+	if platform == nil {
+		return "", fmt.Errorf("no TCB level matches the platform")
+	}
+
+	identity, err := fetchQeIdentity(sgxQeIdentityURL, root, getter)
+	if err != nil {
+		return "", err
+	}
+	qeReport := quote.qeReport
+	if !bytes.Equal(qeReport[128:160], identity.Mrsigner.Bytes) {
+		return "", fmt.Errorf("quoting enclave MRSIGNER %x does not match its identity %x", qeReport[128:160], identity.Mrsigner.Bytes)
+	}
+	if prodID := binary.LittleEndian.Uint16(qeReport[256:258]); prodID != identity.IsvProdID {
+		return "", fmt.Errorf("quoting enclave ISVPRODID %d does not match its identity %d", prodID, identity.IsvProdID)
+	}
+	// MISCSELECT is little endian in reports and big endian in identities.
+	if len(identity.Miscselect.Bytes) != 4 || len(identity.MiscselectMask.Bytes) != 4 {
+		return "", fmt.Errorf("quoting enclave identity has an invalid MISCSELECT")
+	}
+	miscSelect, mask := binary.LittleEndian.Uint32(qeReport[16:20]), binary.BigEndian.Uint32(identity.MiscselectMask.Bytes)
+	if expected := binary.BigEndian.Uint32(identity.Miscselect.Bytes); miscSelect&mask != expected&mask {
+		return "", fmt.Errorf("quoting enclave MISCSELECT %08x does not match its identity %08x", miscSelect, expected)
+	}
+	if !maskedEqual(qeReport[48:64], identity.Attributes.Bytes, identity.AttributesMask.Bytes) {
+		return "", fmt.Errorf("quoting enclave attributes %x do not match its identity %x", qeReport[48:64], identity.Attributes.Bytes)
+	}
+	isvSvn := binary.LittleEndian.Uint16(qeReport[258:260])
+	for _, level := range identity.TcbLevels {
+		if uint32(isvSvn) >= level.Tcb.Isvsvn {
+			if level.TcbStatus != pcs.TcbComponentStatusUpToDate {
+				return level.TcbStatus, nil
+			}
+			return platform.TcbStatus, nil
+		}
+	}
+	return "", fmt.Errorf("no TCB level matches the quoting enclave ISVSVN %d", isvSvn)
+}
+
+// maskedEqual reports whether value and expected are equal under mask.
+func maskedEqual(value []byte, expected []byte, mask []byte) bool {
+	if len(value) != len(mask) || len(expected) != len(mask) {
+		return false
+	}
+	for i := range mask {
+		if value[i]&mask[i] != expected[i]&mask[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// containsHexBytes reports whether values contains value.
+func containsHexBytes(values []HexBytes, value []byte) bool {
+	for _, v := range values {
+		if bytes.Equal(v, value) {
+			return true
+		}
+	}
+	return false
+}
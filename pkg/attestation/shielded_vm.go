@@ -0,0 +1,70 @@
+package attestation
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// ShieldedVMState is the Shielded VM configuration of a GCE VM as evidenced by its attestation.
+// GCE does not attest the settings themselves, so each is derived from what the report proves.
+type ShieldedVMState struct {
+	// VTPM reports that the quotes were signed by a vTPM AK endorsed by a Google gceAK
+	// certificate, which requires VerifyOptions.VerifyGceAKCert
+	VTPM bool `json:"vtpm"`
+	// SecureBoot reports that Secure Boot was enabled, as recorded in the event log
+	SecureBoot bool `json:"secureBoot"`
+	// IntegrityMonitoring reports that the attestation carries a measured boot event log that
+	// replayed against the quoted PCRs, which is what GCE integrity monitoring validates
+	IntegrityMonitoring bool `json:"integrityMonitoring"`
+	// ConfidentialComputing is the verified TEE technology (sev-snp, tdx, or empty)
+	ConfidentialComputing string `json:"confidentialComputing"`
+}
+
+// ShieldedVMPolicy lists the Shielded VM settings a GCE VM must have been launched with. Settings
+// left false are not checked.
+type ShieldedVMPolicy struct {
+	// VTPM requires the AK to be a vTPM key endorsed by a gceAK certificate
+	VTPM bool `json:"vtpm,omitempty"`
+	// SecureBoot requires Secure Boot to be enabled
+	SecureBoot bool `json:"secureBoot,omitempty"`
+	// IntegrityMonitoring requires a measured boot event log
+	IntegrityMonitoring bool `json:"integrityMonitoring,omitempty"`
+	// ConfidentialComputing requires a verified SEV-SNP or TDX attestation
+	ConfidentialComputing bool `json:"confidentialComputing,omitempty"`
+}
+
+// shieldedVMState derives the Shielded VM configuration of a verified attestation. akCert is the
+// verified gceAK certificate, if any.
+func shieldedVMState(attestation *pb.Attestation, ms *pb.MachineState, akCert *x509.Certificate) *ShieldedVMState {
+	hasEventLog := len(attestation.GetEventLog()) != 0
+	return &ShieldedVMState{
+		VTPM:                  akCert != nil,
+		SecureBoot:            hasEventLog && ms.GetSecureBoot().GetEnabled(),
+		IntegrityMonitoring:   hasEventLog,
+		ConfidentialComputing: teeTechnology(attestation),
+	}
+}
+
+// check lists every setting p requires that state lacks.
+func (p *ShieldedVMPolicy) check(state *ShieldedVMState) error {
+	var missing []string
+	if p.VTPM && !state.VTPM {
+		missing = append(missing, "the AK is not endorsed by a gceAK certificate (vTPM)")
+	}
+	if p.SecureBoot && !state.SecureBoot {
+		missing = append(missing, "secure boot is not enabled")
+	}
+	if p.IntegrityMonitoring && !state.IntegrityMonitoring {
+		missing = append(missing, "there is no measured boot event log (integrity monitoring)")
+	}
+	if p.ConfidentialComputing && state.ConfidentialComputing == "" {
+		missing = append(missing, "there is no SEV-SNP or TDX attestation (confidential computing)")
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("VM is not shielded as required: %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
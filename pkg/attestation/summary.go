@@ -0,0 +1,117 @@
+package attestation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Summary holds the commonly used fields of a verification. Every field is always encoded, so
+// that callers across the FFI boundary can rely on its shape.
+type Summary struct {
+	// Verified reports whether verification succeeded
+	Verified bool `json:"verified"`
+	// Error is the verification error, if verification failed
+	Error string `json:"error"`
+	// Technology is the verified TEE technology (sev-snp, tdx, or empty)
+	Technology string `json:"technology"`
+	// SecureBoot reports whether Secure Boot was enabled, as recorded in the event log
+	SecureBoot bool `json:"secureBoot"`
+	// Nonce is the nonce signed by the TPM quotes
+	Nonce HexBytes `json:"nonce"`
+	// PCRBank is the verified PCR bank, e.g. SHA256
+	PCRBank string `json:"pcrBank"`
+	// HostID identifies the host by its AK, as BaselineHostID does
+	HostID string `json:"hostId"`
+	// TEEMeasurement is the launch measurement of the TEE: MRTD for TDX, MEASUREMENT for SEV-SNP
+	TEEMeasurement HexBytes `json:"teeMeasurement"`
+	// TCBStatus is the resolved TDX TCB status, when the policy requires one
+	TCBStatus string `json:"tcbStatus"`
+	// VerifiedAt is when verification completed
+	VerifiedAt time.Time `json:"verifiedAt"`
+}
+
+// Summarize extracts a Summary from the outcome of a verification, the report if it succeeded or
+// err if it failed.
+func Summarize(report *VerificationReport, err error) Summary {
+	if err != nil {
+		return Summary{Error: err.Error()}
+	}
+	if report == nil {
+		return Summary{}
+	}
+
+	ms := report.MachineState
+	summary := Summary{
+		Verified:   true,
+		Technology: report.Technology,
+		SecureBoot: ms.GetSecureBoot().GetEnabled(),
+		PCRBank:    ms.GetHash().String(),
+		HostID:     BaselineHostID(report),
+		VerifiedAt: report.VerifiedAt,
+	}
+	// The quotes verified, so the nonce can be decoded.
+	summary.Nonce, _ = quotedNonce(report.Attestation)
+	if tdx := ms.GetTdxAttestation(); tdx != nil {
+		summary.TEEMeasurement = tdx.GetTdQuoteBody().GetMrTd()
+	} else if snp := ms.GetSevSnpAttestation(); snp != nil {
+		summary.TEEMeasurement = snp.GetReport().GetMeasurement()
+	}
+	if report.Tdx != nil {
+		summary.TCBStatus = report.Tdx.TCBStatus
+	}
+	return summary
+}
+
+// SummaryString formats the key fields of a verified report on one line, e.g.
+// "verified: TDX, secure-boot=on, TCB=UpToDate, instance=foo in us-central1-a". Fields that were
+// not verified, such as a TCB status the policy did not resolve, are left out.
+func SummaryString(report *VerificationReport) string {
+	if report == nil {
+		return "not verified"
+	}
+	ms := report.MachineState
+	var parts []string
+	switch report.Technology {
+	case Tdx:
+		parts = append(parts, "TDX")
+	case SevSnp:
+		parts = append(parts, "SEV-SNP")
+	default:
+		parts = append(parts, "no TEE")
+	}
+	if ms.GetSecureBoot().GetEnabled() {
+		parts = append(parts, "secure-boot=on")
+	} else {
+		parts = append(parts, "secure-boot=off")
+	}
+	if report.Tdx != nil && report.Tdx.TCBStatus != "" {
+		parts = append(parts, "TCB="+report.Tdx.TCBStatus)
+	}
+	if info := ms.GetPlatform().GetInstanceInfo(); info.GetInstanceName() != "" {
+		parts = append(parts, fmt.Sprintf("instance=%s in %s", info.GetInstanceName(), info.GetZone()))
+	}
+	if report.TrustConfig != "" {
+		parts = append(parts, "trust-config="+report.TrustConfig)
+	}
+	return "verified: " + strings.Join(parts, ", ")
+}
+
+// MachineStateJSON encodes a machine state as indented protojson, or on a single line when
+// compact is set, e.g. for log collectors that expect one record per line. Redact it first with
+// RedactMachineState before logging it.
+func MachineStateJSON(ms *pb.MachineState, compact bool) ([]byte, error) {
+	opts := protojson.MarshalOptions{}
+	if !compact {
+		opts.Multiline = true
+		opts.Indent = "  "
+	}
+	out, err := opts.Marshal(ms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal machine state: %v", err)
+	}
+	return out, nil
+}
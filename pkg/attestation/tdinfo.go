@@ -0,0 +1,65 @@
+package attestation
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// HexBytes is a byte string that prints and encodes as text in lowercase hex
+type HexBytes []byte
+
+// String returns b in lowercase hex.
+func (b HexBytes) String() string {
+	return hex.EncodeToString(b)
+}
+
+// MarshalText encodes b in lowercase hex.
+func (b HexBytes) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// UnmarshalText decodes hex text into b.
+func (b *HexBytes) UnmarshalText(text []byte) error {
+	decoded, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// TDInfo holds the measurements of a TDX trust domain, from the TD_INFO of its quote
+type TDInfo struct {
+	// MRTD is the measurement of the initial contents of the TD
+	MRTD HexBytes `json:"mrtd"`
+	// MRConfigID is the software-defined ID of the TD configuration
+	MRConfigID HexBytes `json:"mrConfigId"`
+	// MROwner is the software-defined ID of the TD owner
+	MROwner HexBytes `json:"mrOwner"`
+	// MROwnerConfig is the software-defined ID of the owner-defined configuration
+	MROwnerConfig HexBytes `json:"mrOwnerConfig"`
+	// RTMRs are the runtime measurement registers 0 to 3
+	RTMRs []HexBytes `json:"rtmrs"`
+}
+
+// TdxTDInfo returns the TD_INFO measurements of the TDX quote in a verified machine state.
+func TdxTDInfo(ms *pb.MachineState) (*TDInfo, error) {
+	quote := ms.GetTdxAttestation()
+	if quote == nil {
+		return nil, fmt.Errorf("machine state does not contain a TDX attestation")
+	}
+	body := quote.GetTdQuoteBody()
+	info := &TDInfo{
+		MRTD:          body.GetMrTd(),
+		MRConfigID:    body.GetMrConfigId(),
+		MROwner:       body.GetMrOwner(),
+		MROwnerConfig: body.GetMrOwnerConfig(),
+		RTMRs:         make([]HexBytes, len(body.GetRtmrs())),
+	}
+	for i, rtmr := range body.GetRtmrs() {
+		info.RTMRs[i] = rtmr
+	}
+	return info, nil
+}
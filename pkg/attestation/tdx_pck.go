@@ -0,0 +1,147 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	neturl "net/url"
+	"strings"
+
+	"github.com/google/go-tdx-guest/pcs"
+	"github.com/google/go-tdx-guest/proto/tdx"
+	"github.com/google/go-tdx-guest/verify/trust"
+)
+
+// Retrieval modes of the PCK certificate a TDX quote is verified with, for TdxPolicy.PCKRetrieval
+const (
+	// PCKFromQuote verifies the quote with the PCK certificate chain it carries
+	PCKFromQuote = "quote"
+	// PCKFromPPID fetches the PCK certificate of the platform by the encrypted PPID the quote
+	// carries instead of a certificate chain, from the PCCS at TdxPolicy.PCKServiceURL
+	PCKFromPPID = "ppid"
+)
+
+// Certification data types of the PCK certificate chain data of a TDX quote
+const (
+	encryptedPPIDCertificationDataType = 3
+	pckCertChainCertificationDataType  = 5
+)
+
+// pckIssuerChainHeader is the response header carrying the issuer chain of a PCK certificate.
+const pckIssuerChainHeader = "Sgx-Pck-Certificate-Issuer-Chain"
+
+// Sizes of the fields of encrypted PPID certification data: the PPID encrypted with RSA-3072,
+// followed by the raw CPUSVN, PCESVN and PCEID of the platform.
+const (
+	encryptedPPIDSize     = 384
+	rawCPUSVNSize         = 16
+	encryptedPPIDDataSize = encryptedPPIDSize + rawCPUSVNSize + 2 + 2
+)
+
+// pckRetrieval returns the PCK retrieval mode of the policy.
+func (p *TdxPolicy) pckRetrieval() (string, error) {
+	if p == nil || p.PCKRetrieval == "" {
+		return PCKFromQuote, nil
+	}
+	switch p.PCKRetrieval {
+	case PCKFromQuote:
+		return p.PCKRetrieval, nil
+	case PCKFromPPID:
+		// Intel's PCS only serves PCK certificates with a subscription key, so they come from a PCCS.
+		if p.PCKServiceURL == "" {
+			return "", fmt.Errorf("PCK retrieval %q requires a PCKServiceURL", PCKFromPPID)
+		}
+		return p.PCKRetrieval, nil
+	default:
+		return "", fmt.Errorf("unknown PCK retrieval mode %q", p.PCKRetrieval)
+	}
+}
+
+// retrievePCKCertificate prepares quote for verification under the PCK retrieval mode of policy.
+// A quote that carries encrypted PPID certification data has it replaced with the PCK certificate
+// chain fetched for its platform through getter, once the certificate matches the PCEID and does
+// not exceed the PCESVN of the quote. The signature of the QE report and the chain itself are
+// verified with the quote.
+func retrievePCKCertificate(quote *tdx.QuoteV4, policy *TdxPolicy, getter trust.HTTPSGetter) error {
+	mode, err := policy.pckRetrieval()
+	if err != nil {
+		return err
+	}
+	chainData := quote.GetSignedData().GetCertificationData().GetQeReportCertificationData().GetPckCertificateChainData()
+	if chainData.GetCertificateDataType() != encryptedPPIDCertificationDataType {
+		// Quotes carrying a certificate chain are verified with it in either mode.
+		return nil
+	}
+	if mode != PCKFromPPID {
+		return fmt.Errorf("quote carries an encrypted PPID instead of a PCK certificate chain, which requires PCK retrieval %q", PCKFromPPID)
+	}
+
+	data := chainData.GetPckCertChain()
+	if len(data) != encryptedPPIDDataSize {
+		return fmt.Errorf("encrypted PPID certification data is %d bytes, expected %d", len(data), encryptedPPIDDataSize)
+	}
+	encryptedPPID := data[:encryptedPPIDSize]
+	cpuSvn := data[encryptedPPIDSize : encryptedPPIDSize+rawCPUSVNSize]
+	pceSvn := data[encryptedPPIDSize+rawCPUSVNSize : encryptedPPIDSize+rawCPUSVNSize+2]
+	pceID := data[encryptedPPIDSize+rawCPUSVNSize+2:]
+
+	serviceURL := strings.TrimSuffix(policy.PCKServiceURL, "/")
+	url := fmt.Sprintf("%s/pckcert?encrypted_ppid=%x&cpusvn=%x&pcesvn=%x&pceid=%x", serviceURL, encryptedPPID, cpuSvn, pceSvn, pceID)
+	chain, err := fetchPCKCertificate(url, getter)
+	if err != nil {
+		return err
+	}
+
+	block, _ := pem.Decode(chain)
+	if block == nil {
+		return fmt.Errorf("invalid PCK certificate: no PEM data")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid PCK certificate: %v", err)
+	}
+	exts, err := pcs.PckCertificateExtensions(cert)
+	if err != nil {
+		return fmt.Errorf("invalid PCK certificate: %v", err)
+	}
+	if !strings.EqualFold(exts.PCEID, hex.EncodeToString(pceID)) {
+		return fmt.Errorf("PCK certificate is for PCEID %s, but the quote is from PCEID %x", exts.PCEID, pceID)
+	}
+	if quotePceSvn := binary.LittleEndian.Uint16(pceSvn); exts.TCB.PCESvn > quotePceSvn {
+		return fmt.Errorf("PCK certificate is for PCESVN %d, above the PCESVN %d of the quote", exts.TCB.PCESvn, quotePceSvn)
+	}
+
+	// Sizes enclosing the certification data grow or shrink with it.
+	delta := uint32(len(chain)) - uint32(len(data))
+	chainData.CertificateDataType = pckCertChainCertificationDataType
+	chainData.PckCertChain = chain
+	chainData.Size = uint32(len(chain))
+	quote.GetSignedData().GetCertificationData().Size += delta
+	quote.SignedDataSize += delta
+	return nil
+}
+
+// fetchPCKCertificate fetches the PCK certificate at url and returns it with its issuer chain, as
+// PEM.
+func fetchPCKCertificate(url string, getter trust.HTTPSGetter) ([]byte, error) {
+	header, body, err := getter.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch PCK certificate: %v", err)
+	}
+	issuerChain := header[pckIssuerChainHeader]
+	if len(issuerChain) != 1 {
+		return nil, fmt.Errorf("PCK certificate response has no %s header", pckIssuerChainHeader)
+	}
+	chainPEM, err := neturl.QueryUnescape(issuerChain[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid PCK certificate issuer chain: %v", err)
+	}
+	if block, _ := pem.Decode(body); block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("PCK certificate response does not contain a certificate")
+	}
+	chain := append(append([]byte{}, bytes.TrimSpace(body)...), '\n')
+	return append(chain, chainPEM...), nil
+}
@@ -0,0 +1,473 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"sort"
+	"strings"
+	"time"
+
+	tabi "github.com/google/go-tdx-guest/abi"
+	"github.com/google/go-tdx-guest/pcs"
+	"github.com/google/go-tdx-guest/proto/tdx"
+	"github.com/google/go-tdx-guest/verify/trust"
+)
+
+// Response headers carrying the issuer chains of Intel PCS documents.
+const (
+	tcbInfoIssuerChainHeader    = "Tcb-Info-Issuer-Chain"
+	qeIdentityIssuerChainHeader = "Sgx-Enclave-Identity-Issuer-Chain"
+)
+
+// ErrStaleCollateral is returned when TDX collateral is outside its validity window or older than
+// the accepted maximum age.
+var ErrStaleCollateral = errors.New("stale TDX collateral")
+
+// ErrInconsistentCollateral is returned when TDX collateral predates the PCK certificate of the
+// quote or the TCB level it rates the platform at, as when old collateral is mixed with a newer
+// quote.
+var ErrInconsistentCollateral = errors.New("inconsistent TDX collateral")
+
+// TdxPolicy holds the TDX-specific requirements of a verification
+type TdxPolicy struct {
+	// ExpectedFMSPCs lists the accepted platform FMSPCs, as hex strings. Empty accepts any FMSPC.
+	ExpectedFMSPCs []string `json:"expectedFMSPCs,omitempty"`
+	// RequireTCBStatus lists the accepted TCB statuses of the platform (e.g. "UpToDate"), as
+	// resolved from Intel's TCB info for its FMSPC. Empty skips TCB status evaluation.
+	RequireTCBStatus []string `json:"requireTCBStatus,omitempty"`
+	// RequireFreshCollateral requires Intel's TCB info and QE identity for the platform to be
+	// within their validity window.
+	RequireFreshCollateral bool `json:"requireFreshCollateral,omitempty"`
+	// MaxCollateralAge additionally bounds the age of fresh collateral since it was issued.
+	// Zero means no bound.
+	MaxCollateralAge time.Duration `json:"maxCollateralAge,omitempty"`
+	// MinTEETCBSVN maps components of the TEE_TCB_SVN of the TD quote, by their index (0 to 15) as
+	// in Intel's TCB info, to the minimum SVN they must have. It requires patched components
+	// individually, unlike RequireTCBStatus.
+	MinTEETCBSVN map[uint32]uint8 `json:"minTeeTcbSvn,omitempty"`
+	// ExpectedMRSEAMs lists the accepted MRSEAM values, the measurements of the TDX module as
+	// published by Intel with each module release. Empty accepts any TDX module. The SEAMSVN of the
+	// module is component 0 of MinTEETCBSVN.
+	ExpectedMRSEAMs []HexBytes `json:"expectedMRSEAMs,omitempty"`
+	// VerifySEAMIdentity requires the MRSIGNERSEAM and SEAM_ATTRIBUTES of the TDX module to match
+	// its identity in Intel's TCB info for the platform.
+	VerifySEAMIdentity bool `json:"verifySEAMIdentity,omitempty"`
+	// RequireConsistentCollateral requires Intel's TCB info to be issued no earlier than the PCK
+	// certificate of the quote and the TCB date of the platform's TCB level.
+	RequireConsistentCollateral bool `json:"requireConsistentCollateral,omitempty"`
+	// PCKRetrieval is how the PCK certificate the quote is verified with is obtained: PCKFromQuote
+	// (the default) takes the certificate chain the quote carries, and PCKFromPPID fetches it by
+	// the encrypted PPID of quotes that carry one instead.
+	PCKRetrieval string `json:"pckRetrieval,omitempty"`
+	// PCKServiceURL is the base URL of the PCCS that PCKFromPPID fetches PCK certificates from,
+	// e.g. https://pccs.example.com/sgx/certification/v4. It is required by PCKFromPPID, as Intel's
+	// PCS requires a subscription key for PCK certificates.
+	PCKServiceURL string `json:"pckServiceURL,omitempty"`
+}
+
+// TdxReport describes the TDX platform of a verified attestation
+type TdxReport struct {
+	// FMSPC identifies the platform family, as a hex string
+	FMSPC string
+	// TCBStatus is the resolved TCB status of the platform. It is only set when the TdxPolicy
+	// requires a TCB status.
+	TCBStatus string
+	// CollateralNextUpdate is when Intel next updates the platform's TCB info or QE identity. It is
+	// only set when the TdxPolicy requires fresh collateral.
+	CollateralNextUpdate time.Time
+	// TCBDate is the TCB date of the TCB level of the platform in Intel's TCB info. It is only set
+	// when the TdxPolicy requires TCB info.
+	TCBDate time.Time
+	// CollateralIssueDate is when Intel issued the TCB info the platform was evaluated against. It
+	// is only set when the TdxPolicy requires TCB info.
+	CollateralIssueDate time.Time
+	// PCKIssueDate is when the PCK certificate of the quote was issued
+	PCKIssueDate time.Time
+	// TEETCBSVN is the TEE_TCB_SVN of the TD quote, one SVN per component
+	TEETCBSVN HexBytes
+	// MRSEAM is the measurement of the TDX module
+	MRSEAM HexBytes
+	// MRSIGNERSEAM is the measurement of the TDX module signer, all zeros for Intel's modules
+	MRSIGNERSEAM HexBytes
+	// SEAMSVN is the security version of the TDX module, component 0 of TEETCBSVN
+	SEAMSVN uint8
+}
+
+// checkTdxPolicy enforces policy on a quote whose signature and certificates have already been
+// verified, fetching TCB info and QE identity through getter.
+func checkTdxPolicy(quote *tdx.QuoteV4, policy *TdxPolicy, getter trust.HTTPSGetter, now time.Time) (*TdxReport, error) {
+	chain, err := pckCertificateChain(quote)
+	if err != nil {
+		return nil, err
+	}
+	exts, err := pcs.PckCertificateExtensions(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("could not get PCK certificate extensions: %v", err)
+	}
+	body := quote.GetTdQuoteBody()
+	report := &TdxReport{
+		FMSPC:        strings.ToLower(exts.FMSPC),
+		TEETCBSVN:    body.GetTeeTcbSvn(),
+		MRSEAM:       body.GetMrSeam(),
+		MRSIGNERSEAM: body.GetMrSignerSeam(),
+		PCKIssueDate: chain[0].NotBefore,
+	}
+	if len(report.TEETCBSVN) != 0 {
+		report.SEAMSVN = report.TEETCBSVN[0]
+	}
+	if policy == nil {
+		return report, nil
+	}
+
+	if len(policy.ExpectedFMSPCs) != 0 && !containsFold(policy.ExpectedFMSPCs, report.FMSPC) {
+		return nil, fmt.Errorf("FMSPC %s is not an expected value", report.FMSPC)
+	}
+	if len(policy.MinTEETCBSVN) != 0 {
+		if err := checkTEETCBSVN(report.TEETCBSVN, policy.MinTEETCBSVN); err != nil {
+			return nil, err
+		}
+	}
+	if len(policy.ExpectedMRSEAMs) != 0 {
+		expected := false
+		for _, mrSeam := range policy.ExpectedMRSEAMs {
+			expected = expected || bytes.Equal(mrSeam, report.MRSEAM)
+		}
+		if !expected {
+			return nil, fmt.Errorf("MRSEAM %s is not an expected TDX module measurement", report.MRSEAM)
+		}
+	}
+
+	root := chain[len(chain)-1]
+	if len(policy.RequireTCBStatus) != 0 || policy.RequireFreshCollateral || policy.VerifySEAMIdentity || policy.RequireConsistentCollateral {
+		tcbInfo, err := fetchTcbInfo(pcs.TcbInfoURL(report.FMSPC), report.FMSPC, root, getter)
+		if err != nil {
+			return nil, err
+		}
+		report.CollateralIssueDate = tcbInfo.IssueDate
+		if level, err := tdxPlatformTcbLevel(tcbInfo, body.GetTeeTcbSvn(), exts); err == nil {
+			report.TCBDate, _ = time.Parse(time.RFC3339, level.TcbDate)
+		}
+
+		if policy.RequireConsistentCollateral {
+			if err := checkCollateralConsistency(report); err != nil {
+				return nil, err
+			}
+		}
+
+		if policy.VerifySEAMIdentity {
+			if err := checkSEAMIdentity(tcbInfo, body); err != nil {
+				return nil, err
+			}
+		}
+
+		if policy.RequireFreshCollateral {
+			qeIdentity, err := fetchQeIdentity(pcs.QeIdentityURL(), root, getter)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkFreshness("TCB info", tcbInfo.IssueDate, tcbInfo.NextUpdate, policy.MaxCollateralAge, now); err != nil {
+				return nil, err
+			}
+			if err := checkFreshness("QE identity", qeIdentity.IssueDate, qeIdentity.NextUpdate, policy.MaxCollateralAge, now); err != nil {
+				return nil, err
+			}
+			report.CollateralNextUpdate = tcbInfo.NextUpdate
+			if qeIdentity.NextUpdate.Before(report.CollateralNextUpdate) {
+				report.CollateralNextUpdate = qeIdentity.NextUpdate
+			}
+		}
+
+		if len(policy.RequireTCBStatus) != 0 {
+			status, err := tdxTCBStatus(tcbInfo, quote.GetTdQuoteBody(), exts)
+			if err != nil {
+				return nil, err
+			}
+			report.TCBStatus = string(status)
+			if !containsFold(policy.RequireTCBStatus, report.TCBStatus) {
+				return nil, fmt.Errorf("TCB status %s is not an accepted status", report.TCBStatus)
+			}
+		}
+	}
+	return report, nil
+}
+
+// checkSEAMIdentity checks the MRSIGNERSEAM and SEAM_ATTRIBUTES of a quote against the identity of
+// its TDX module in tcbInfo: the module identity of its version, or the tdxModule of TCB info for
+// quotes without a module version.
+func checkSEAMIdentity(tcbInfo *pcs.TcbInfo, body *tdx.TDQuoteBody) error {
+	teeTcbSvn := body.GetTeeTcbSvn()
+	if len(teeTcbSvn) < 2 {
+		return fmt.Errorf("quote has an invalid TEE_TCB_SVN")
+	}
+	mrSigner, attributes, mask := tcbInfo.TdxModule.Mrsigner.Bytes, tcbInfo.TdxModule.Attributes.Bytes, tcbInfo.TdxModule.AttributesMask.Bytes
+	if teeTcbSvn[1] > 0 {
+		id := "TDX_" + hex.EncodeToString(teeTcbSvn[1:2])
+		found := false
+		for _, identity := range tcbInfo.TdxModuleIdentities {
+			if strings.EqualFold(identity.ID, id) {
+				mrSigner, attributes, mask = identity.Mrsigner.Bytes, identity.Attributes.Bytes, identity.AttributesMask.Bytes
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("TCB info has no identity for TDX module %s", id)
+		}
+	}
+
+	if !bytes.Equal(body.GetMrSignerSeam(), mrSigner) {
+		return fmt.Errorf("MRSIGNERSEAM %x does not match the TDX module signer %x in TCB info", body.GetMrSignerSeam(), mrSigner)
+	}
+	seamAttributes := body.GetSeamAttributes()
+	if len(seamAttributes) != len(mask) || len(attributes) != len(mask) {
+		return fmt.Errorf("SEAM_ATTRIBUTES %x cannot be compared with the TDX module attributes %x in TCB info", seamAttributes, attributes)
+	}
+	for i := range mask {
+		if seamAttributes[i]&mask[i] != attributes[i]&mask[i] {
+			return fmt.Errorf("SEAM_ATTRIBUTES %x do not match the TDX module attributes %x under mask %x in TCB info", seamAttributes, attributes, mask)
+		}
+	}
+	return nil
+}
+
+// checkTEETCBSVN checks each component of svn against its minimum, listing every component below
+// its minimum.
+func checkTEETCBSVN(svn []byte, minimums map[uint32]uint8) error {
+	indices := make([]uint32, 0, len(minimums))
+	for index := range minimums {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+
+	var below []string
+	for _, index := range indices {
+		if int(index) >= len(svn) {
+			return fmt.Errorf("TEE_TCB_SVN has no component %d", index)
+		}
+		if got, min := svn[index], minimums[index]; got < min {
+			below = append(below, fmt.Sprintf("component %d is %d, minimum is %d", index, got, min))
+		}
+	}
+	if len(below) != 0 {
+		return fmt.Errorf("TEE_TCB_SVN %x is below the minimum: %s", svn, strings.Join(below, "; "))
+	}
+	return nil
+}
+
+// checkFreshness fails with ErrStaleCollateral unless now is within the validity window of a
+// collateral document and, when maxAge is positive, the document is at most maxAge old.
+func checkFreshness(name string, issueDate time.Time, nextUpdate time.Time, maxAge time.Duration, now time.Time) error {
+	if now.Before(issueDate) {
+		return fmt.Errorf("%w: %s is issued in the future (%v)", ErrStaleCollateral, name, issueDate)
+	}
+	if now.After(nextUpdate) {
+		return fmt.Errorf("%w: %s expired at %v", ErrStaleCollateral, name, nextUpdate)
+	}
+	if maxAge > 0 && now.Sub(issueDate) > maxAge {
+		return fmt.Errorf("%w: %s was issued at %v, more than %v ago", ErrStaleCollateral, name, issueDate, maxAge)
+	}
+	return nil
+}
+
+// pckCertificateChain returns the PCK certificate chain of quote, leaf first.
+func pckCertificateChain(quote *tdx.QuoteV4) ([]*x509.Certificate, error) {
+	rest := quote.GetSignedData().GetCertificationData().GetQeReportCertificationData().GetPckCertificateChainData().GetPckCertChain()
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PCK certificate chain: %v", err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("quote does not contain a PCK certificate chain")
+	}
+	return chain, nil
+}
+
+// fetchTcbInfo fetches the TCB info for fmspc from url, signed by a certificate issued by root.
+func fetchTcbInfo(url string, fmspc string, root *x509.Certificate, getter trust.HTTPSGetter) (*pcs.TcbInfo, error) {
+	raw, err := fetchSignedCollateral(url, tcbInfoIssuerChainHeader, "tcbInfo", root, getter)
+	if err != nil {
+		return nil, fmt.Errorf("TCB info: %v", err)
+	}
+	var tcbInfo pcs.TcbInfo
+	if err := json.Unmarshal(raw, &tcbInfo); err != nil {
+		return nil, fmt.Errorf("could not parse TCB info: %v", err)
+	}
+	if !strings.EqualFold(tcbInfo.Fmspc, fmspc) {
+		return nil, fmt.Errorf("TCB info is for FMSPC %s, expected %s", tcbInfo.Fmspc, fmspc)
+	}
+	return &tcbInfo, nil
+}
+
+// fetchQeIdentity fetches a quoting enclave identity from url, signed by a certificate issued by
+// root.
+func fetchQeIdentity(url string, root *x509.Certificate, getter trust.HTTPSGetter) (*pcs.EnclaveIdentity, error) {
+	raw, err := fetchSignedCollateral(url, qeIdentityIssuerChainHeader, "enclaveIdentity", root, getter)
+	if err != nil {
+		return nil, fmt.Errorf("QE identity: %v", err)
+	}
+	var identity pcs.EnclaveIdentity
+	if err := json.Unmarshal(raw, &identity); err != nil {
+		return nil, fmt.Errorf("could not parse QE identity: %v", err)
+	}
+	return &identity, nil
+}
+
+// fetchSignedCollateral fetches a signed Intel PCS document and returns its field member once
+// its signature is verified. The signing certificate is taken from the issuerHeader response
+// header and must be issued by root, the already verified root of the PCK certificate chain.
+func fetchSignedCollateral(url string, issuerHeader string, field string, root *x509.Certificate, getter trust.HTTPSGetter) (json.RawMessage, error) {
+	header, body, err := getter.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch %s: %v", url, err)
+	}
+
+	issuerChain := header[issuerHeader]
+	if len(issuerChain) != 1 {
+		return nil, fmt.Errorf("response has no %s header", issuerHeader)
+	}
+	chainPEM, err := neturl.QueryUnescape(issuerChain[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid issuer chain: %v", err)
+	}
+	block, rest := pem.Decode([]byte(chainPEM))
+	if block == nil {
+		return nil, fmt.Errorf("issuer chain does not contain a signing certificate")
+	}
+	signer, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing certificate: %v", err)
+	}
+	if block, _ = pem.Decode(rest); block == nil || !bytes.Equal(block.Bytes, root.Raw) {
+		return nil, fmt.Errorf("not issued by the root of the PCK certificate chain")
+	}
+	if err := signer.CheckSignatureFrom(root); err != nil {
+		return nil, fmt.Errorf("invalid signing certificate: %v", err)
+	}
+
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("could not parse response: %v", err)
+	}
+	var encodedSignature string
+	if err := json.Unmarshal(response["signature"], &encodedSignature); err != nil {
+		return nil, fmt.Errorf("invalid signature: %v", err)
+	}
+	signature, err := hex.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %v", err)
+	}
+	derSignature, err := tabi.SignatureToDER(signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %v", err)
+	}
+	if err := signer.CheckSignature(x509.ECDSAWithSHA256, response[field], derSignature); err != nil {
+		return nil, fmt.Errorf("signature verification failed: %v", err)
+	}
+	return response[field], nil
+}
+
+// tdxTCBStatus resolves the TCB status of a platform from its TCB info, following Intel's TCB
+// level matching. A TDX module status other than UpToDate takes precedence over the platform's.
+func tdxTCBStatus(tcbInfo *pcs.TcbInfo, body *tdx.TDQuoteBody, exts *pcs.PckExtensions) (pcs.TcbComponentStatus, error) {
+	teeTcbSvn := body.GetTeeTcbSvn()
+	platform, err := tdxPlatformTcbLevel(tcbInfo, teeTcbSvn, exts)
+	if err != nil {
+		return "", err
+	}
+
+	if teeTcbSvn[1] > 0 {
+		id := "TDX_" + hex.EncodeToString(teeTcbSvn[1:2])
+		for _, identity := range tcbInfo.TdxModuleIdentities {
+			if !strings.EqualFold(identity.ID, id) {
+				continue
+			}
+			for _, level := range identity.TcbLevels {
+				if uint32(teeTcbSvn[0]) >= level.Tcb.Isvsvn {
+					if level.TcbStatus != pcs.TcbComponentStatusUpToDate {
+						return level.TcbStatus, nil
+					}
+					return platform.TcbStatus, nil
+				}
+			}
+			return "", fmt.Errorf("no TCB level matches TDX module %s", id)
+		}
+		return "", fmt.Errorf("TCB info has no identity for TDX module %s", id)
+	}
+	return platform.TcbStatus, nil
+}
+
+// tdxPlatformTcbLevel returns the first TCB level of tcbInfo that the SVNs of the platform meet.
+func tdxPlatformTcbLevel(tcbInfo *pcs.TcbInfo, teeTcbSvn []byte, exts *pcs.PckExtensions) (*pcs.TcbLevel, error) {
+	if len(teeTcbSvn) < 2 {
+		return nil, fmt.Errorf("quote has an invalid TEE_TCB_SVN")
+	}
+	for i, level := range tcbInfo.TcbLevels {
+		if svnsAtLeast(exts.TCB.CPUSvnComponents, level.Tcb.SgxTcbcomponents, 0) &&
+			exts.TCB.PCESvn >= level.Tcb.Pcesvn &&
+			svnsAtLeast(teeTcbSvn, level.Tcb.TdxTcbcomponents, tdxTcbSvnStart(teeTcbSvn)) {
+			return &tcbInfo.TcbLevels[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no TCB level matches the platform")
+}
+
+// checkCollateralConsistency fails with ErrInconsistentCollateral if the TCB info of report was
+// issued before its PCK certificate or its TCB date: Intel only issues PCK certificates for TCBs
+// its current TCB info rates, so such collateral is older than the quote it is verified against.
+func checkCollateralConsistency(report *TdxReport) error {
+	if report.TCBDate.IsZero() {
+		return fmt.Errorf("%w: TCB info has no TCB level with a date for the platform", ErrInconsistentCollateral)
+	}
+	if report.CollateralIssueDate.Before(report.PCKIssueDate) {
+		return fmt.Errorf("%w: TCB info was issued at %v, before the PCK certificate at %v", ErrInconsistentCollateral, report.CollateralIssueDate, report.PCKIssueDate)
+	}
+	if report.CollateralIssueDate.Before(report.TCBDate) {
+		return fmt.Errorf("%w: TCB info was issued at %v, before the TCB date %v of the platform", ErrInconsistentCollateral, report.CollateralIssueDate, report.TCBDate)
+	}
+	return nil
+}
+
+// tdxTcbSvnStart returns the first TEE_TCB_SVN component compared against TCB levels. The TDX
+// module SVNs are evaluated against the module identities instead when a module version is set.
+func tdxTcbSvnStart(teeTcbSvn []byte) int {
+	if teeTcbSvn[1] > 0 {
+		return 2
+	}
+	return 0
+}
+
+func svnsAtLeast(svns []byte, components []pcs.TcbComponent, start int) bool {
+	if len(svns) != len(components) {
+		return false
+	}
+	for i := start; i < len(svns); i++ {
+		if svns[i] < components[i].Svn {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
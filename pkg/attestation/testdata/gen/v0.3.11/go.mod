@@ -0,0 +1,19 @@
+module fixturegen
+
+go 1.22
+
+require (
+	github.com/google/go-tpm v0.3.3
+	github.com/google/go-tpm-tools v0.3.11
+	google.golang.org/protobuf v1.28.0
+)
+
+require (
+	github.com/google/go-sev-guest v0.5.2 // indirect
+	github.com/google/logger v1.1.1 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/pborman/uuid v1.2.0 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e // indirect
+	golang.org/x/sys v0.0.0-20220624220833-87e55d714810 // indirect
+)
@@ -0,0 +1,20 @@
+module fixturegen
+
+go 1.22
+
+require (
+	github.com/google/go-tpm v0.9.0
+	github.com/google/go-tpm-tools v0.4.1
+	google.golang.org/protobuf v1.31.0
+)
+
+require (
+	github.com/google/go-sev-guest v0.7.0 // indirect
+	github.com/google/go-tdx-guest v0.2.1-0.20230907045450-944015509c84 // indirect
+	github.com/google/logger v1.1.1 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/pborman/uuid v1.2.0 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/crypto v0.11.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
+)
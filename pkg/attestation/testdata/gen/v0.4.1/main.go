@@ -0,0 +1,52 @@
+// Command fixturegen writes the go-tpm-tools-v0.4.1.binarypb fixture: an attestation produced the
+// way go-tpm-tools v0.4.1 attests, on the simulated TPM with seed 1, without the event log that the
+// simulator does not have.
+//
+// Usage: fixturegen <output> <nonce>
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/google/go-tpm-tools/client"
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/legacy/tpm2"
+	"google.golang.org/protobuf/proto"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: fixturegen <output> <nonce>")
+		os.Exit(2)
+	}
+	sim, err := simulator.GetWithFixedSeedInsecure(1)
+	if err != nil {
+		panic(err)
+	}
+	defer sim.Close()
+	ak, err := client.AttestationKeyRSA(sim)
+	if err != nil {
+		panic(err)
+	}
+	defer ak.Close()
+	attestation := &pb.Attestation{}
+	if attestation.AkPub, err = ak.PublicArea().Encode(); err != nil {
+		panic(err)
+	}
+	for _, hash := range []tpm2.Algorithm{tpm2.AlgSHA1, tpm2.AlgSHA256} {
+		quote, err := ak.Quote(client.FullPcrSel(hash), []byte(os.Args[2]))
+		if err != nil {
+			panic(err)
+		}
+		attestation.Quotes = append(attestation.Quotes, quote)
+	}
+	out, err := proto.Marshal(attestation)
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(os.Args[1], out, 0o644); err != nil {
+		panic(err)
+	}
+}
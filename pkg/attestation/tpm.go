@@ -0,0 +1,69 @@
+package attestation
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"time"
+
+	"github.com/google/go-tpm-tools/client"
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// DefaultBusyRetryDelay is the delay before the first retry of a busy TPM when none is configured.
+const DefaultBusyRetryDelay = 100 * time.Millisecond
+
+// ErrTPMBusy is returned when the TPM is held by another process. Retry later, or open the TPM
+// through the kernel resource manager (/dev/tpmrm0) so that processes can share it.
+var ErrTPMBusy = errors.New("TPM is busy, retry later or use the TPM resource manager")
+
+// nopCloser is a TPM that is not closed by Attest because the caller owns it.
+type nopCloser struct {
+	io.ReadWriter
+}
+
+func (nopCloser) Close() error { return nil }
+
+// attestationTPM returns opts.TPM if it is set, and otherwise opens the TPM device.
+func attestationTPM(opts AttestOptions) (io.ReadWriteCloser, error) {
+	if opts.TPM != nil {
+		return nopCloser{opts.TPM}, nil
+	}
+	return openTPM(opts.BusyRetries, opts.BusyRetryDelay)
+}
+
+// readEventLog reads the TCG event log of the TPM device, or of opts.TPM if it is set.
+func readEventLog(opts AttestOptions, rwc io.ReadWriter) ([]byte, error) {
+	if opts.TPM == nil {
+		return client.GetEventLog(rwc)
+	}
+	// The host's event log does not describe a caller's TPM.
+	if getter, ok := opts.TPM.(client.EventLogGetter); ok {
+		return getter.EventLog()
+	}
+	// An empty, non-nil log stops client.AttestOpts from reading the host's log.
+	return []byte{}, nil
+}
+
+// openTPM opens the TPM, retrying up to retries times while it is busy. The delay between
+// attempts starts at delay and doubles after each attempt.
+func openTPM(retries int, delay time.Duration) (io.ReadWriteCloser, error) {
+	if delay <= 0 {
+		delay = DefaultBusyRetryDelay
+	}
+	for attempt := 0; ; attempt++ {
+		rwc, err := tpm2.OpenTPM()
+		if err == nil {
+			return rwc, nil
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			return nil, fmt.Errorf("failed to open TPM: %v", err)
+		}
+		if attempt >= retries {
+			return nil, fmt.Errorf("failed to open TPM: %w: %v", ErrTPMBusy, err)
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,59 @@
+package attestation
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the instrumentation scope of the spans created by this package.
+const tracerName = "lunal-attestation/pkg/attestation"
+
+// Span attribute keys set on verification spans.
+const (
+	technologyAttribute = attribute.Key("attestation.tee.technology")
+	tcbAttribute        = attribute.Key("attestation.tee.tcb")
+	outcomeAttribute    = attribute.Key("attestation.outcome")
+)
+
+// startSpan starts a child of the span carried by ctx. When ctx carries no span, no tracer is
+// looked up and a no-op span is returned, so verification without tracing pays nothing.
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.SpanContext().IsValid() {
+		return ctx, noop.Span{}
+	}
+	return parent.TracerProvider().Tracer(tracerName).Start(ctx, name)
+}
+
+// endSpan records the outcome of a verification step and ends its span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(outcomeAttribute.String("failed"))
+	} else {
+		span.SetAttributes(outcomeAttribute.String("verified"))
+	}
+	span.End()
+}
+
+// setTEEAttributes describes the TEE attestation being verified on span.
+func setTEEAttributes(span trace.Span, attestation *pb.Attestation) {
+	if !span.IsRecording() {
+		return
+	}
+	span.SetAttributes(technologyAttribute.String(teeTechnology(attestation)))
+	switch tee := attestation.GetTeeAttestation().(type) {
+	case *pb.Attestation_SevSnpAttestation:
+		span.SetAttributes(tcbAttribute.String(fmt.Sprintf("%#016x", tee.SevSnpAttestation.GetReport().GetReportedTcb())))
+	case *pb.Attestation_TdxAttestation:
+		span.SetAttributes(tcbAttribute.String(hex.EncodeToString(tee.TdxAttestation.GetTdQuoteBody().GetTeeTcbSvn())))
+	}
+}
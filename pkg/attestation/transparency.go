@@ -0,0 +1,136 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotInTransparencyLog is returned when no signed reference entry for the measurements of a
+// report is found in the transparency log of VerifyOptions.TransparencyLog.
+var ErrNotInTransparencyLog = errors.New("measurements are not in the transparency log")
+
+// ReferenceStatement lists the reference measurements of a platform, as a publisher signs them and
+// records them in a transparency log
+type ReferenceStatement struct {
+	// Hash is the PCR bank of PCRs, e.g. SHA256
+	Hash string `json:"hash"`
+	// PCRs are the reference PCR values
+	PCRs map[uint32]HexBytes `json:"pcrs"`
+	// TEE holds the reference TEE measurements: mrtd and rtmr0 to rtmr2 for TDX, measurement for
+	// SEV-SNP
+	TEE map[string]HexBytes `json:"tee,omitempty"`
+}
+
+// NewReferenceStatement takes a statement of the given PCRs and the TEE measurements of a verified
+// report, for a publisher to sign and log.
+func NewReferenceStatement(report *VerificationReport, pcrs []uint32) (*ReferenceStatement, error) {
+	quoted, err := verifiedPCRs(report.Attestation, report.MachineState)
+	if err != nil {
+		return nil, err
+	}
+	statement := &ReferenceStatement{
+		Hash: report.MachineState.GetHash().String(),
+		PCRs: make(map[uint32]HexBytes, len(pcrs)),
+		TEE:  teeMeasurements(report.MachineState),
+	}
+	for _, index := range pcrs {
+		value, ok := quoted[index]
+		if !ok {
+			return nil, fmt.Errorf("attestation does not quote PCR %d", index)
+		}
+		statement.PCRs[index] = value
+	}
+	return statement, nil
+}
+
+// Marshal encodes s as JSON, the form publishers sign. The encoding is deterministic.
+func (s *ReferenceStatement) Marshal() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// Digest returns the SHA-256 digest of the encoding of s, under which transparency logs index it.
+func (s *ReferenceStatement) Digest() ([]byte, error) {
+	data, err := s.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(data)
+	return digest[:], nil
+}
+
+// LoggedReference is a signed ReferenceStatement recorded in a transparency log
+type LoggedReference struct {
+	// Statement is the encoded ReferenceStatement
+	Statement []byte
+	// Signature is the publisher's signature over Statement: RSA PKCS #1 v1.5 or ECDSA over its
+	// SHA-256 digest, or Ed25519 over Statement itself
+	Signature []byte
+	// LogIndex is the index of the entry in the log
+	LogIndex int64
+	// IntegratedTime is when the log recorded the entry
+	IntegratedTime time.Time
+}
+
+// TransparencyLog looks up signed reference statements in a transparency log, such as Rekor.
+// Implementations verify that the entries they return are included in the log, e.g. with an
+// inclusion proof or a signed entry timestamp.
+type TransparencyLog interface {
+	// FindReferences returns the entries logged for the ReferenceStatement digest, or none if
+	// there are none
+	FindReferences(ctx context.Context, digest []byte) ([]LoggedReference, error)
+}
+
+// TransparencyLogPolicy requires the measurements of a report to be published in a transparency
+// log by a trusted publisher
+type TransparencyLogPolicy struct {
+	// Log is the transparency log to look up the measurements in. Not encoded as JSON, so a
+	// decoded policy fails verification until it is set.
+	Log TransparencyLog
+	// PublisherKeys are the keys trusted to sign reference statements (*rsa.PublicKey,
+	// *ecdsa.PublicKey or ed25519.PublicKey)
+	PublisherKeys []crypto.PublicKey
+	// PCRs are the PCRs covered by the reference statements. Defaults to DefaultBaselinePCRs.
+	PCRs []uint32
+}
+
+// checkTransparencyLog looks up the measurements of a verified report in the log of policy and
+// returns the first entry signed by a publisher key whose statement matches them.
+func checkTransparencyLog(ctx context.Context, policy *TransparencyLogPolicy, report *VerificationReport) (*LoggedReference, error) {
+	if policy.Log == nil {
+		return nil, fmt.Errorf("no transparency log")
+	}
+	pcrs := policy.PCRs
+	if len(pcrs) == 0 {
+		pcrs = DefaultBaselinePCRs
+	}
+	statement, err := NewReferenceStatement(report, pcrs)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := statement.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(expected)
+	entries, err := policy.Log.FindReferences(ctx, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the transparency log: %v", err)
+	}
+	for i := range entries {
+		if !bytes.Equal(entries[i].Statement, expected) {
+			continue
+		}
+		for _, key := range policy.PublisherKeys {
+			if verifySignature(key, entries[i].Statement, entries[i].Signature) == nil {
+				return &entries[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: no entry for reference statement %x is signed by a trusted publisher (%d entries found)", ErrNotInTransparencyLog, digest, len(entries))
+}
@@ -0,0 +1,302 @@
+package attestation
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	pb "github.com/google/go-tpm-tools/proto/attest"
+)
+
+// VerificationReport is the outcome of a successful verification
+type VerificationReport struct {
+	// Attestation is the attestation report as unmarshaled for verification
+	Attestation *pb.Attestation
+	// MachineState is the verified machine state
+	MachineState *pb.MachineState
+	// Technology is the TEE technology that was verified (sev-snp, tdx, or empty)
+	Technology string
+	// VerifiedAt is when verification completed
+	VerifiedAt time.Time
+	// Audit chains the verification to VerifyOptions.PreviousAuditDigest
+	Audit *AuditRecord
+	// Tdx describes the TDX platform, for TDX attestations
+	Tdx *TdxReport
+	// SevSnp describes the SEV-SNP guest, for SEV-SNP attestations
+	SevSnp *SevSnpReport
+	// CPU describes the CPU of the host as its TEE attestation reports it, for TEE attestations
+	CPU *CPUInfo
+	// ShieldedVM is the Shielded VM configuration the attestation evidences
+	ShieldedVM *ShieldedVMState
+	// QuoteClock holds the TPM clock and reset counters of the quote over the verified PCR bank
+	QuoteClock *QuoteClockInfo
+	// AKName is the TPM name of the AK, to record when enrolling a host for
+	// VerifyOptions.ExpectedAKName
+	AKName HexBytes
+	// GceAKEndorsement describes the gceAK certificate that endorsed the AK, when
+	// VerifyOptions.VerifyGceAKCert is set
+	GceAKEndorsement *GceAKEndorsement
+	// WorkloadClaim is the verified workload claim, when VerifyOptions.WorkloadClaimKey is set
+	WorkloadClaim *WorkloadClaim
+	// RIMMatches lists the measurements that matched VerifyOptions.RIMs
+	RIMMatches []RIMMatch
+	// Integrity holds the result of each category of VerifyOptions.IntegrityBaseline
+	Integrity []IntegrityResult
+	// Components holds the result of each component of VerifyOptions.Components, by name
+	Components []ComponentResult
+	// RTMRComponents holds the result of each component of VerifyOptions.RTMRComponents, by name
+	RTMRComponents []ComponentResult
+	// TransparencyLogEntry is the logged reference statement that matched the measurements, when
+	// VerifyOptions.TransparencyLog is set
+	TransparencyLogEntry *LoggedReference
+	// EventLogTruncated reports that the attester omitted its event log, e.g. for exceeding
+	// AttestOptions.MaxEventLogSize, as the quoted boot PCRs hold measurements that no log replays.
+	// MachineState then holds no events.
+	EventLogTruncated bool
+	// EventLogError is why the event log failed to parse or replay, when
+	// VerifyOptions.TolerateEventLogParseErrors let verification complete without it
+	EventLogError string
+	// EventLogMissing reports that the verified attestation has no event log, so MachineState
+	// holds no boot events
+	EventLogMissing bool
+	// SkippedChecks lists the checks that were not performed for lack of an event log, as
+	// VerifyOptions.AllowMissingEventLog allows
+	SkippedChecks []string
+	// UnknownFields lists the fields of a binary report that this package does not know, which
+	// were not verified, as with VerifyOptions.RejectUnknownFields
+	UnknownFields []string
+	// EKCertificate is the verified EK certificate, when VerifyOptions.TrustedEKRoots is set
+	EKCertificate *x509.Certificate
+	// EKIdentity identifies the TPM by its EK, when the attestation carries an EK public area or
+	// certificate
+	EKIdentity *EKIdentity
+	// Instance is the allowed instance the gceAK certificate named, when
+	// VerifyOptions.AllowedInstances is set
+	Instance *InstanceIdentity
+	// TrustConfig is the name of the trust configuration that verified the attestation, when the
+	// Verifier has TrustConfigs
+	TrustConfig string
+	// Baseline is the baseline of the host the report matched, when the Verifier has Baselines
+	Baseline *Baseline
+	// BaselineEstablished reports that the host had no baseline and this report became it, as
+	// VerifierConfig.TrustFirstBaseline allows
+	BaselineEstablished bool
+}
+
+// VerifierConfig holds the configuration a Verifier shares across all of its verifications.
+// It can be encoded as JSON, except for HTTPClient, CollateralCache and Baselines.
+type VerifierConfig struct {
+	// Options is the default verification policy. Its Format, Nonce, TeeNonce, EventLog and
+	// PreviousAuditDigest are ignored, as they are supplied by each VerifyRequest.
+	Options VerifyOptions
+	// HTTPClient is used to fetch TEE collateral. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// CollateralTTL is how long fetched TEE collateral is reused. Defaults to DefaultCollateralTTL.
+	CollateralTTL time.Duration
+	// CollateralCache stores fetched TEE collateral, e.g. in a cache shared by several verifiers.
+	// Defaults to a MemoryCollateralCache. Not encoded as JSON.
+	CollateralCache CollateralCache
+	// CollateralRateLimit caps collateral fetches from AMD KDS and Intel PCS to this many per
+	// second, across all verifications. Fetches over the limit wait, bounded by the verification
+	// context. 0 means no limit.
+	CollateralRateLimit float64
+	// CollateralBurst is how many fetches may exceed CollateralRateLimit at once. Defaults to 1.
+	CollateralBurst int
+	// CollateralFetchTimeout bounds each collateral fetch, including its wait for
+	// CollateralRateLimit, however long the verifications waiting for it are willing to wait.
+	// Defaults to DefaultCollateralFetchTimeout.
+	CollateralFetchTimeout time.Duration
+	// TrustConfigs are named trust configurations, tried in order against each attestation. When
+	// set, they replace Options.
+	TrustConfigs []TrustConfig
+	// ChallengeTTL is how long a challenge from NewChallenge can be answered. Defaults to
+	// DefaultChallengeTTL.
+	ChallengeTTL time.Duration
+	// Baselines enables trust-on-first-use verification: each verified report must also match
+	// the baseline measurements recorded for its host. Not encoded as JSON.
+	Baselines BaselineStore
+	// TrustFirstBaseline records the first report of a host without a baseline as its baseline.
+	// Otherwise such hosts fail with ErrNoBaseline until Verifier.TrustBaseline is called.
+	TrustFirstBaseline bool
+	// BaselinePCRs are the PCRs recorded in baselines. Defaults to DefaultBaselinePCRs.
+	BaselinePCRs []uint32
+	// BaselineResetCount also records the TPM reset count in baselines, so that a report from a
+	// host that rebooted or had its TPM reset since its baseline drifts. Baselines recorded without
+	// a reset count are not checked against it.
+	BaselineResetCount bool
+	// AsyncWorkers is the number of verifications VerifyAsync runs concurrently. Defaults to
+	// GOMAXPROCS.
+	AsyncWorkers int
+}
+
+// TrustConfig is a named verification policy for attestations from one kind of platform, such as
+// a cloud provider with its own trust anchors
+type TrustConfig struct {
+	// Name identifies the configuration in verification reports
+	Name string `json:"name"`
+	// Technologies restricts the configuration to attestations with these TEE technologies
+	// (sev-snp, tdx, or empty for attestations without a TEE attestation). Empty matches all.
+	Technologies []string `json:"technologies,omitempty"`
+	// Options is the verification policy of the configuration. Its Format, Nonce, TeeNonce, EventLog
+	// and PreviousAuditDigest are ignored, as they are supplied by each VerifyRequest.
+	Options VerifyOptions `json:"options"`
+}
+
+// matches reports whether c applies to attestations with the given TEE technology.
+func (c *TrustConfig) matches(technology string) bool {
+	if len(c.Technologies) == 0 {
+		return true
+	}
+	for _, t := range c.Technologies {
+		if t == technology {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyRequest describes a single verification performed by a Verifier
+type VerifyRequest struct {
+	// Attestation is the serialized attestation report
+	Attestation []byte
+	// Format specifies the report format (binarypb or textproto). Defaults to binarypb.
+	Format string
+	// Nonce is the nonce that was passed to Attest
+	Nonce []byte
+	// TeeNonce is the TEE nonce that was passed to Attest, if any
+	TeeNonce []byte
+	// EventLog is the event log of an attestation stored without it, as by SplitAttestation
+	EventLog []byte
+	// PreviousAuditDigest is the audit digest of the previous verification of an audit chain
+	PreviousAuditDigest []byte
+	// ExpectedPCRs replaces the verifier's expected PCRs for this call when non-nil
+	ExpectedPCRs map[uint32][]byte
+	// ReferenceValues replaces the verifier's reference values for this call when non-nil
+	ReferenceValues *ReferenceValues
+	// RequireTEE replaces the verifier's RequireTEE setting for this call when non-nil
+	RequireTEE *bool
+	// ExpectedReportDataHash replaces the verifier's expected report data hash for this call when
+	// non-nil, e.g. with the PublicKeyReportData of the key being provisioned
+	ExpectedReportDataHash []byte
+}
+
+// Verifier verifies attestation reports against a shared policy. Fetched TEE collateral is cached
+// and reused across calls. A Verifier is safe for concurrent use.
+type Verifier struct {
+	config     VerifierConfig
+	collateral *collateralCache
+	challenges *challengeStore
+	// workers holds a token for each running VerifyAsync verification
+	workers chan struct{}
+}
+
+// NewVerifier creates a Verifier from config
+func NewVerifier(config VerifierConfig) *Verifier {
+	collateral := newCollateralCache(config.HTTPClient, config.CollateralTTL, newRateLimiter(config.CollateralRateLimit, config.CollateralBurst), config.CollateralCache)
+	collateral.maxDocumentSize = config.Options.MaxCollateralSize
+	if config.CollateralFetchTimeout > 0 {
+		collateral.fetchTimeout = config.CollateralFetchTimeout
+	}
+	return &Verifier{
+		config:     config,
+		collateral: collateral,
+		challenges: newChallengeStore(config.ChallengeTTL),
+		workers:    make(chan struct{}, asyncWorkers(config)),
+	}
+}
+
+// InvalidateCache makes v fetch all TEE collateral again on its next use, rather than once the
+// cached documents expire, e.g. after a key rotation or revocation. Documents in a shared
+// VerifierConfig.CollateralCache are only ignored by v, not removed for other verifiers.
+func (v *Verifier) InvalidateCache() {
+	v.collateral.invalidate(nil)
+}
+
+// InvalidateKey makes v fetch the TEE collateral documents that descriptor selects again on their
+// next use, as InvalidateCache does for all of them.
+func (v *Verifier) InvalidateKey(descriptor CollateralDescriptor) {
+	v.collateral.invalidate(&descriptor)
+}
+
+// Config returns the configuration v was created with. Its JSON encoding records the policy v
+// enforces, and a Verifier with the same policy can be created from the decoded configuration.
+func (v *Verifier) Config() VerifierConfig {
+	return v.config
+}
+
+// Verify verifies req.Attestation using the verifier's policy, overridden by the policy fields
+// set in req. If the verifier has trust configurations, those matching the TEE technology of the
+// attestation are tried in order, and the first that verifies it is named in the report. If the
+// verifier has Baselines, the report must then also match the baseline of its host.
+func (v *Verifier) Verify(ctx context.Context, req VerifyRequest) (*VerificationReport, error) {
+	report, err := v.verify(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if v.config.Baselines != nil {
+		if err := v.checkBaseline(ctx, report); err != nil {
+			return nil, fmt.Errorf("verifying baseline: %w", err)
+		}
+	}
+	return report, nil
+}
+
+// verify verifies req against the verifier's policy or trust configurations.
+func (v *Verifier) verify(ctx context.Context, req VerifyRequest) (*VerificationReport, error) {
+	if len(v.config.TrustConfigs) == 0 {
+		return verifyAttestation(ctx, req.Attestation, v.options(v.config.Options, req), v.collateral)
+	}
+
+	opts := v.options(VerifyOptions{}, req)
+	_, technology, err := HasTEEAttestation(req.Attestation, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for i := range v.config.TrustConfigs {
+		config := &v.config.TrustConfigs[i]
+		if !config.matches(technology) {
+			continue
+		}
+		report, err := verifyAttestation(ctx, req.Attestation, v.options(config.Options, req), v.collateral)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("trust config %q: %w", config.Name, err))
+			continue
+		}
+		report.TrustConfig = config.Name
+		return report, nil
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no trust config accepts attestations with TEE technology %q", technology)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// options merges the policy base with the overrides of req.
+func (v *Verifier) options(base VerifyOptions, req VerifyRequest) VerifyOptions {
+	opts := base
+	opts.Format = req.Format
+	if opts.Format == "" {
+		opts.Format = "binarypb"
+	}
+	opts.Nonce = req.Nonce
+	opts.TeeNonce = req.TeeNonce
+	opts.EventLog = req.EventLog
+	opts.PreviousAuditDigest = req.PreviousAuditDigest
+	if req.ExpectedPCRs != nil {
+		opts.ExpectedPCRs = req.ExpectedPCRs
+	}
+	if req.ReferenceValues != nil {
+		opts.ReferenceValues = req.ReferenceValues
+	}
+	if req.RequireTEE != nil {
+		opts.RequireTEE = *req.RequireTEE
+	}
+	if req.ExpectedReportDataHash != nil {
+		opts.ExpectedReportDataHash = req.ExpectedReportDataHash
+	}
+	return opts
+}
@@ -1,13 +1,18 @@
 package attestation
 
 import (
+	"bytes"
+	"context"
 	"crypto"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/google/go-sev-guest/proto/sevsnp"
-	sv "github.com/google/go-sev-guest/verify"
 	"github.com/google/go-tdx-guest/proto/tdx"
-	tv "github.com/google/go-tdx-guest/verify"
 	pb "github.com/google/go-tpm-tools/proto/attest"
 	"github.com/google/go-tpm-tools/server"
 	"github.com/google/go-tpm/legacy/tpm2"
@@ -19,52 +24,774 @@ var (
 	unmarshalOptions = prototext.UnmarshalOptions{DiscardUnknown: true}
 )
 
+// ErrVerificationBudgetExceeded is returned when a verification takes longer than
+// VerifyOptions.MaxVerifyDuration, typically while fetching TEE collateral from a slow upstream.
+// The report may verify when retried.
+var ErrVerificationBudgetExceeded = errors.New("verification budget exceeded")
+
+// VerifyOptions contains all the options for verifying an attestation report
+type VerifyOptions struct {
+	// Format specifies the input format (binarypb or textproto)
+	Format string `json:"format,omitempty"`
+	// Nonce is the nonce that was passed to Attest
+	Nonce []byte `json:"nonce,omitempty"`
+	// TeeNonce is the TEE nonce that was passed to Attest, if any
+	TeeNonce []byte `json:"teeNonce,omitempty"`
+	// AllowUnboundTeeReportData leaves the report data of a TEE attestation unchecked when neither
+	// TeeNonce nor ExpectedReportDataHash is set, instead of checking it against Nonce. It is for
+	// callers that require a TeeNonce distinct from Nonce and always pass one.
+	AllowUnboundTeeReportData bool `json:"allowUnboundTeeReportData,omitempty"`
+	// EventLog is the TCG event log of an attestation that was stored without it, as by
+	// SplitAttestation. It is attached before verification.
+	EventLog []byte `json:"-"`
+	// ExpectedPCRs maps PCR indices to the exact digest each must hold in the verified PCR bank
+	ExpectedPCRs map[uint32][]byte `json:"expectedPCRs,omitempty"`
+	// CurrentPCRs are PCR values of the verified bank read from the host through a trusted channel
+	// at verification time. Quoted PCRs that differ fail with ErrStaleQuote, as a replayed quote of
+	// an earlier state would.
+	CurrentPCRs map[uint32][]byte `json:"currentPCRs,omitempty"`
+	// ReferenceValues lists acceptable PCR and TEE measurements, e.g. as loaded from a CoRIM
+	ReferenceValues *ReferenceValues `json:"referenceValues,omitempty"`
+	// DeniedMeasurements lists PCR and TEE measurements of known-bad images. An attestation with
+	// any of them fails with ErrDeniedMeasurement before the allowlists are checked.
+	DeniedMeasurements *ReferenceValues `json:"deniedMeasurements,omitempty"`
+	// ExpectedReportDataHash requires the report data of the TDX quote or SEV-SNP report to begin
+	// with this digest, e.g. the PublicKeyReportData of a key the guest generated, so that the
+	// attested key is confirmed. Without TeeNonce, the report data is expected to be exactly this
+	// digest, zero padded. Requires a TEE attestation.
+	ExpectedReportDataHash HexBytes `json:"expectedReportDataHash,omitempty"`
+	// RequireBoundEvidence requires the TEE report data to commit to the nonce signed by the TPM
+	// quotes, either as the nonce itself or as a BoundTeeNonce, so that a quote and a TEE report
+	// cannot be spliced from different attestations. Requires a TEE attestation.
+	RequireBoundEvidence bool `json:"requireBoundEvidence,omitempty"`
+	// RequireTEE rejects attestations that do not carry a SEV-SNP or TDX attestation
+	RequireTEE bool `json:"requireTEE,omitempty"`
+	// StrictNonce rejects weak nonces using ValidateNonce
+	StrictNonce bool `json:"strictNonce,omitempty"`
+	// AllowedBootEntries lists the digests of the EFI boot applications allowed in the event log
+	AllowedBootEntries [][]byte `json:"allowedBootEntries,omitempty"`
+	// ExpectedEventSequence requires the event log to extend these measurements in this order, with
+	// any other events between them. Final PCR values do not reveal the order of their extends.
+	ExpectedEventSequence []ExpectedEvent `json:"expectedEventSequence,omitempty"`
+	// RequireSecureBoot requires Secure Boot, as recorded in the event log, to be enabled (true) or
+	// disabled (false). Not checked if nil.
+	RequireSecureBoot *bool `json:"requireSecureBoot,omitempty"`
+	// ShieldedVM requires a GCE VM to have been launched with these Shielded VM settings
+	ShieldedVM *ShieldedVMPolicy `json:"shieldedVM,omitempty"`
+	// ExpectedAKName requires the AK to have this TPM name, the name algorithm followed by the
+	// digest of the public area, with or without the TPM2B_NAME size prefix
+	ExpectedAKName []byte `json:"expectedAKName,omitempty"`
+	// VerifyGceAKCert requires the AK to be endorsed by a Google-issued gceAK certificate that
+	// chains to GceRootCerts, instead of trusting the AK embedded in the attestation
+	VerifyGceAKCert bool `json:"verifyGceAKCert,omitempty"`
+	// AllowAKCertMismatch skips the check that an AK certificate carried by the attestation
+	// certifies its AK, when VerifyGceAKCert is not set. A certificate for another key is a sign of
+	// a spliced report, so this is only meant for attesters known to attach stale certificates.
+	AllowAKCertMismatch bool `json:"allowAKCertMismatch,omitempty"`
+	// RequireInstanceInfo rejects attestations whose gceAK certificate does not name the GCE
+	// instance, so that every accepted report can be attributed to one. It requires
+	// VerifyGceAKCert.
+	RequireInstanceInfo bool `json:"requireInstanceInfo,omitempty"`
+	// AllowedInstances rejects attestations whose gceAK certificate does not name one of these
+	// instances. Like RequireInstanceInfo, it requires VerifyGceAKCert.
+	AllowedInstances []InstanceIdentity `json:"allowedInstances,omitempty"`
+	// GceRootCerts are the trusted roots for gceAK certificates. Defaults to GceAKRootCerts.
+	GceRootCerts []*x509.Certificate `json:"gceRootCerts,omitempty"`
+	// GceIntermediateCerts are intermediates for gceAK certificates, in addition to those in the
+	// attestation. Defaults to GceAKIntermediateCerts.
+	GceIntermediateCerts []*x509.Certificate `json:"gceIntermediateCerts,omitempty"`
+	// TrustedEKRoots requires the attestation to carry an EK certificate that chains to one of
+	// these TPM manufacturer roots. Binding the AK to that EK takes a Verifier EK challenge.
+	TrustedEKRoots []*x509.Certificate `json:"trustedEKRoots,omitempty"`
+	// Tdx holds additional requirements for TDX attestations
+	Tdx *TdxPolicy `json:"tdx,omitempty"`
+	// SevSnp holds additional requirements for SEV-SNP attestations
+	SevSnp *SevSnpPolicy `json:"sevSnp,omitempty"`
+	// AllowSHA1 allows verifying attestations through SHA-1 PCR banks when they quote no stronger
+	// bank, as is the case with legacy firmware. SHA-1 is vulnerable to collisions.
+	AllowSHA1 bool `json:"allowSHA1,omitempty"`
+	// RejectSHA1 rejects attestations that quote a SHA-1 PCR bank at all
+	RejectSHA1 bool `json:"rejectSHA1,omitempty"`
+	// ExpectedNVIndices maps TPM NV indices to their required contents, which must be certified
+	// by the AK
+	ExpectedNVIndices map[uint32][]byte `json:"expectedNVIndices,omitempty"`
+	// RIMs are vendor Reference Integrity Manifests, loaded with ParseRIM, that the measurements
+	// must all satisfy
+	RIMs []*RIM `json:"rims,omitempty"`
+	// IntegrityBaseline requires the PCRs of each boot category to match a GCE-style integrity
+	// monitoring baseline
+	IntegrityBaseline *IntegrityBaseline `json:"integrityBaseline,omitempty"`
+	// Components requires the PCRs of named boot components, resolved through the policy's PCR
+	// layout, to hold acceptable measurements
+	Components *ComponentPolicy `json:"components,omitempty"`
+	// RTMRComponents requires the TDX RTMRs of named boot components, resolved through the
+	// policy's RTMR layout, to hold acceptable measurements. Requires a TDX attestation.
+	RTMRComponents *RTMRComponentPolicy `json:"rtmrComponents,omitempty"`
+	// TransparencyLog requires the measurements of the report to be published by a trusted
+	// publisher in a transparency log
+	TransparencyLog *TransparencyLogPolicy `json:"transparencyLog,omitempty"`
+	// CheckRevocations checks the certificates of TEE attestations against the CRLs of AMD KDS or
+	// Intel PCS, fetched with the rest of the collateral
+	CheckRevocations bool `json:"checkRevocations,omitempty"`
+	// VerificationTime is the time at which TEE certificates and collateral must be valid.
+	// Defaults to the current time.
+	VerificationTime time.Time `json:"verificationTime,omitzero"`
+	// WorkloadClaimKey requires a workload claim bound to Nonce and signed by this host key
+	// (*rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey)
+	WorkloadClaimKey crypto.PublicKey `json:"workloadClaimKey,omitempty"`
+	// TolerateEventLogParseErrors completes verification without the event log when it fails to
+	// parse or replay, rather than failing. The quotes, nonce and PCR values are still verified,
+	// the event log is removed from the verified attestation so that checks needing it fail, and
+	// the reason is recorded in VerificationReport.EventLogError.
+	TolerateEventLogParseErrors bool `json:"tolerateEventLogParseErrors,omitempty"`
+	// AllowMissingEventLog skips the checks that need an event log (AllowedBootEntries,
+	// ExpectedEventSequence and RequireSecureBoot) for attestations without one, as from
+	// AttestOptions.OmitEventLog, rather than failing. Skipped checks are listed in
+	// VerificationReport.SkippedChecks.
+	AllowMissingEventLog bool `json:"allowMissingEventLog,omitempty"`
+	// RejectUnknownFields rejects reports with fields this package does not know, at any depth,
+	// with ErrUnknownFields. Protobuf otherwise drops them silently, so a report produced against
+	// a newer attest.proto could pass without its new fields being verified.
+	RejectUnknownFields bool `json:"rejectUnknownFields,omitempty"`
+	// PreviousAuditDigest is the AuditRecord digest of the previous verification of an audit chain.
+	// Empty starts a new chain.
+	PreviousAuditDigest HexBytes `json:"previousAuditDigest,omitempty"`
+	// CollectAllErrors runs every policy check that does not depend on an earlier one, instead of
+	// stopping at the first failure, and returns all failures combined with errors.Join
+	CollectAllErrors bool `json:"collectAllErrors,omitempty"`
+	// MaxAttestationSize bounds the size in bytes of the attestation report, with EventLog, and
+	// VerifyAttestationFromReader stops reading beyond it. 0 means no limit.
+	MaxAttestationSize int64 `json:"maxAttestationSize,omitempty"`
+	// MaxEventLogEntries bounds the number of events of the TCG event log, which are counted
+	// before the log is parsed and replayed. 0 means no limit.
+	MaxEventLogEntries int `json:"maxEventLogEntries,omitempty"`
+	// MaxCollateralSize bounds the size in bytes of each TEE collateral document fetched or taken
+	// from a cache. A Verifier stops reading fetched documents beyond the MaxCollateralSize of its
+	// default Options. 0 means no limit.
+	MaxCollateralSize int64 `json:"maxCollateralSize,omitempty"`
+	// MaxVerifyDuration is the time budget of a verification, including TEE collateral fetches.
+	// Verification that takes longer fails with ErrVerificationBudgetExceeded. 0 means no budget.
+	MaxVerifyDuration time.Duration `json:"maxVerifyDuration,omitempty"`
+}
+
+// DefaultVerifyOptions returns the default options for verification
+func DefaultVerifyOptions() VerifyOptions {
+	return VerifyOptions{
+		Format:                      "binarypb",
+		Nonce:                       nil,
+		TeeNonce:                    nil,
+		AllowUnboundTeeReportData:   false,
+		EventLog:                    nil,
+		ExpectedPCRs:                nil,
+		CurrentPCRs:                 nil,
+		ReferenceValues:             nil,
+		DeniedMeasurements:          nil,
+		ExpectedReportDataHash:      nil,
+		RequireBoundEvidence:        false,
+		RequireTEE:                  false,
+		StrictNonce:                 false,
+		AllowedBootEntries:          nil,
+		ExpectedEventSequence:       nil,
+		RequireSecureBoot:           nil,
+		ShieldedVM:                  nil,
+		ExpectedAKName:              nil,
+		VerifyGceAKCert:             false,
+		AllowAKCertMismatch:         false,
+		RequireInstanceInfo:         false,
+		AllowedInstances:            nil,
+		GceRootCerts:                nil,
+		GceIntermediateCerts:        nil,
+		TrustedEKRoots:              nil,
+		Tdx:                         nil,
+		SevSnp:                      nil,
+		AllowSHA1:                   false,
+		RejectSHA1:                  false,
+		ExpectedNVIndices:           nil,
+		RIMs:                        nil,
+		IntegrityBaseline:           nil,
+		Components:                  nil,
+		RTMRComponents:              nil,
+		TransparencyLog:             nil,
+		CheckRevocations:            false,
+		VerificationTime:            time.Time{},
+		WorkloadClaimKey:            nil,
+		TolerateEventLogParseErrors: false,
+		AllowMissingEventLog:        false,
+		RejectUnknownFields:         false,
+		PreviousAuditDigest:         nil,
+		CollectAllErrors:            false,
+		MaxAttestationSize:          DefaultMaxAttestationSize,
+		MaxEventLogEntries:          DefaultMaxEventLogEntries,
+		MaxCollateralSize:           DefaultMaxCollateralSize,
+		MaxVerifyDuration:           0,
+	}
+}
+
 // VerifyAttestation verifies a remote attestation report.
 // It takes the attestation bytes, format (binarypb or textproto), nonce and teeNonce.
 // Returns the verified machine state or an error if verification fails.
 func VerifyAttestation(attestationBytes []byte, format string, nonce []byte, teeNonce []byte) (*pb.MachineState, error) {
-	attestation := &pb.Attestation{}
+	opts := DefaultVerifyOptions()
+	opts.Format = format
+	opts.Nonce = nonce
+	opts.TeeNonce = teeNonce
+	return VerifyAttestationWithOptions(attestationBytes, opts)
+}
 
-	if format == "binarypb" {
-		err := proto.Unmarshal(attestationBytes, attestation)
-		if err != nil {
-			return nil, fmt.Errorf("fail to unmarshal attestation report: %v", err)
+// VerifyAttestationProto verifies a remote attestation report like VerifyAttestation, and also
+// returns the attestation proto it unmarshaled, so callers needing both avoid parsing it twice.
+func VerifyAttestationProto(attestationBytes []byte, format string, nonce []byte, teeNonce []byte) (*pb.Attestation, *pb.MachineState, error) {
+	opts := DefaultVerifyOptions()
+	opts.Format = format
+	opts.Nonce = nonce
+	opts.TeeNonce = teeNonce
+	report, err := VerifyAttestationContext(context.Background(), attestationBytes, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return report.Attestation, report.MachineState, nil
+}
+
+// VerifyAttestationWithOptions verifies a remote attestation report according to opts.
+// On top of the checks done by VerifyAttestation, it enforces the expected measurements in opts.
+func VerifyAttestationWithOptions(attestationBytes []byte, opts VerifyOptions) (*pb.MachineState, error) {
+	report, err := VerifyAttestationContext(context.Background(), attestationBytes, opts)
+	if err != nil {
+		return nil, err
+	}
+	return report.MachineState, nil
+}
+
+// VerifyAttestationContext verifies a remote attestation report according to opts and returns the
+// full verification report. If ctx carries an OpenTelemetry span, the TPM and TEE verification
+// steps are recorded as child spans.
+func VerifyAttestationContext(ctx context.Context, attestationBytes []byte, opts VerifyOptions) (*VerificationReport, error) {
+	return verifyAttestation(ctx, attestationBytes, opts, nil)
+}
+
+// VerifyAttestationAnyNonce verifies a remote attestation report according to opts, accepting any
+// of the candidate nonces in place of opts.Nonce, and returns the nonce that matched. The report is
+// unmarshaled and verified once, with the candidate its quotes carry. Accepting several nonces widens the set of
+// reports that verify: a report answering any outstanding candidate is fresh only relative to the
+// oldest of them, so keep the candidates few and short-lived.
+func VerifyAttestationAnyNonce(ctx context.Context, attestationBytes []byte, candidates [][]byte, opts VerifyOptions) (*VerificationReport, []byte, error) {
+	if err := checkAttestationSize(attestationBytes, opts); err != nil {
+		return nil, nil, err
+	}
+	attestation, err := unmarshalAttestation(attestationBytes, opts.Format)
+	if err != nil {
+		return nil, nil, err
+	}
+	quoted, err := quotedNonce(attestation)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, candidate := range candidates {
+		if bytes.Equal(candidate, quoted) {
+			opts.Nonce = candidate
+			report, err := verifyParsedAttestation(ctx, attestationBytes, attestation, opts, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			return report, candidate, nil
 		}
-	} else if format == "textproto" {
-		err := unmarshalOptions.Unmarshal(attestationBytes, attestation)
-		if err != nil {
-			return nil, fmt.Errorf("fail to unmarshal attestation report: %v", err)
+	}
+	return nil, nil, fmt.Errorf("attestation nonce does not match any of the %d candidate nonces", len(candidates))
+}
+
+// verifyAttestation holds the verification logic shared by VerifyAttestationWithOptions and Verifier.
+// TEE collateral is fetched through collateral when it is non-nil, and directly otherwise.
+func verifyAttestation(ctx context.Context, attestationBytes []byte, opts VerifyOptions, collateral collateralSource) (*VerificationReport, error) {
+	return verifyParsedAttestation(ctx, attestationBytes, nil, opts, collateral)
+}
+
+// verifyParsedAttestation is verifyAttestation for an attestation that the caller may already have
+// unmarshaled from attestationBytes, so that it is not unmarshaled again. A nil attestation is
+// unmarshaled from attestationBytes.
+func verifyParsedAttestation(ctx context.Context, attestationBytes []byte, attestation *pb.Attestation, opts VerifyOptions, collateral collateralSource) (*VerificationReport, error) {
+	if collateral == nil && opts.MaxVerifyDuration > 0 {
+		// The default collateral getters of go-sev-guest and go-tdx-guest take no context, so they
+		// could not be aborted at the deadline.
+		collateral = &httpCollateral{client: http.DefaultClient, maxDocumentSize: opts.MaxCollateralSize}
+	}
+	if opts.MaxCollateralSize <= 0 {
+		return verifyAttestationWithin(ctx, attestationBytes, attestation, opts, collateral)
+	}
+
+	// Without a source, the default collateral getters are bounded instead.
+	limited := &limitedCollateral{source: collateral, max: opts.MaxCollateralSize}
+	report, err := verifyAttestationWithin(ctx, attestationBytes, attestation, opts, limited)
+	if exceeded := limited.exceededLimit(); err != nil && exceeded != nil {
+		return nil, fmt.Errorf("verifying TEE attestation: %w", exceeded)
+	}
+	return report, err
+}
+
+// verifyAttestationWithin runs verifyAttestationChecks within opts.MaxVerifyDuration.
+func verifyAttestationWithin(ctx context.Context, attestationBytes []byte, attestation *pb.Attestation, opts VerifyOptions, collateral collateralSource) (*VerificationReport, error) {
+	if opts.MaxVerifyDuration <= 0 {
+		return verifyAttestationChecks(ctx, attestationBytes, attestation, opts, collateral)
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, opts.MaxVerifyDuration, ErrVerificationBudgetExceeded)
+	defer cancel()
+	report, err := verifyAttestationChecks(ctx, attestationBytes, attestation, opts, collateral)
+	if errors.Is(context.Cause(ctx), ErrVerificationBudgetExceeded) {
+		return nil, fmt.Errorf("%w: verification took longer than %v", ErrVerificationBudgetExceeded, opts.MaxVerifyDuration)
+	}
+	return report, err
+}
+
+// verifyAttestationChecks runs the checks of verifyAttestation.
+func verifyAttestationChecks(ctx context.Context, attestationBytes []byte, attestation *pb.Attestation, opts VerifyOptions, collateral collateralSource) (*VerificationReport, error) {
+	nonce, teeNonce := opts.Nonce, opts.TeeNonce
+
+	// Checks that later checks do not depend on record their failure with failed, which reports
+	// whether verification stops there.
+	var failures []error
+	failed := func(err error) bool {
+		failures = append(failures, err)
+		return !opts.CollectAllErrors
+	}
+
+	if err := checkNonceSizes(nonce, teeNonce); err != nil && failed(err) {
+		return nil, failures[0]
+	}
+	if opts.StrictNonce {
+		if err := validateNonces(nonce, teeNonce); err != nil && failed(err) {
+			return nil, failures[0]
 		}
-	} else {
-		return nil, fmt.Errorf("format should be either binarypb or textproto")
+	}
+
+	if err := checkAttestationSize(attestationBytes, opts); err != nil {
+		return nil, joinFailures(append(failures, err))
+	}
+	if attestation == nil {
+		var err error
+		if attestation, err = unmarshalAttestation(attestationBytes, opts.Format); err != nil {
+			return nil, joinFailures(append(failures, err))
+		}
+	}
+	if len(opts.EventLog) != 0 {
+		if err := attachEventLog(attestation, opts.EventLog); err != nil {
+			return nil, joinFailures(append(failures, err))
+		}
+	}
+	if err := checkEventLogEntries(attestation.GetEventLog(), opts.MaxEventLogEntries); err != nil {
+		return nil, joinFailures(append(failures, err))
+	}
+
+	if opts.RejectUnknownFields {
+		if err := checkAttestationSchema(attestationBytes, opts.Format, attestation); err != nil && failed(fmt.Errorf("verifying attestation schema: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+
+	if opts.RequireTEE && attestation.GetTeeAttestation() == nil && failed(fmt.Errorf("attestation does not contain a TEE attestation")) {
+		return nil, failures[0]
 	}
 
 	pub, err := tpm2.DecodePublic(attestation.GetAkPub())
 	if err != nil {
-		return nil, err
+		return nil, joinFailures(append(failures, err))
+	}
+	if err := validateAKPublic(pub); err != nil {
+		return nil, joinFailures(append(failures, err))
 	}
 	cryptoPub, err := pub.Key()
 	if err != nil {
-		return nil, err
+		return nil, joinFailures(append(failures, err))
 	}
-
-	ms, err := server.VerifyAttestation(attestation, server.VerifyOpts{Nonce: nonce, TrustedAKs: []crypto.PublicKey{cryptoPub}})
+	name, err := akName(pub)
 	if err != nil {
-		return nil, fmt.Errorf("verifying TPM attestation: %w", err)
+		return nil, joinFailures(append(failures, err))
+	}
+	if len(opts.ExpectedAKName) != 0 {
+		if err := checkAKName(name, opts.ExpectedAKName); err != nil && failed(fmt.Errorf("verifying AK name: %w", err)) {
+			return nil, failures[0]
+		}
 	}
 
-	err = verifyGceTechnology(attestation, nonce, teeNonce)
+	// With VerifyGceAKCert, the certificate is checked below and must certify the AK.
+	if !opts.VerifyGceAKCert && !opts.AllowAKCertMismatch && len(attestation.GetAkCert()) != 0 {
+		if _, err := checkGceAKCert(attestation, cryptoPub); err != nil && failed(fmt.Errorf("verifying AK certificate: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+
+	if err := checkPCRBanks(attestation, opts.AllowSHA1, opts.RejectSHA1); err != nil {
+		return nil, joinFailures(append(failures, err))
+	}
+	if err := checkQuoteSchemes(attestation, pub); err != nil {
+		return nil, joinFailures(append(failures, fmt.Errorf("verifying TPM attestation: %w", err)))
+	}
+	if _, err := VerifyQuoteSignature(attestation, cryptoPub); err != nil {
+		return nil, joinFailures(append(failures, fmt.Errorf("verifying TPM attestation: %w", err)))
+	}
+
+	verifyOpts := server.VerifyOpts{Nonce: nonce, TrustedAKs: []crypto.PublicKey{cryptoPub}, AllowSHA1: opts.AllowSHA1}
+	var akCert *x509.Certificate
+	if opts.VerifyGceAKCert {
+		akCert, err = checkGceAKCert(attestation, cryptoPub)
+		if err != nil {
+			return nil, joinFailures(append(failures, fmt.Errorf("verifying gceAK certificate: %w", err)))
+		}
+		verifyOpts.TrustedAKs = nil
+		verifyOpts.TrustedRootCerts = opts.GceRootCerts
+		if len(verifyOpts.TrustedRootCerts) == 0 {
+			verifyOpts.TrustedRootCerts = GceAKRootCerts
+		}
+		verifyOpts.IntermediateCerts = opts.GceIntermediateCerts
+		if len(verifyOpts.IntermediateCerts) == 0 {
+			verifyOpts.IntermediateCerts = GceAKIntermediateCerts
+		}
+	}
+
+	var ekCert *x509.Certificate
+	if len(opts.TrustedEKRoots) != 0 {
+		ekCert, err = checkEKCert(attestationBytes, attestation, opts.TrustedEKRoots)
+		if err != nil && failed(fmt.Errorf("verifying EK certificate: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+	ekID, err := ekIdentity(attestationBytes, ekCert)
+	if err != nil && failed(fmt.Errorf("verifying EK identity: %w", err)) {
+		return nil, failures[0]
+	}
+
+	_, tpmSpan := startSpan(ctx, "attestation.VerifyTPM")
+	tpmAttestation := attestation
+	if isPSSAK(pub) {
+		if opts.VerifyGceAKCert {
+			err = fmt.Errorf("gceAK certificates are only verified for RSASSA and ECDSA AKs, not RSA-PSS")
+		} else {
+			tpmAttestation, verifyOpts, err = resignPSSQuotes(attestation, verifyOpts)
+		}
+		if err != nil {
+			endSpan(tpmSpan, err)
+			return nil, joinFailures(append(failures, fmt.Errorf("verifying TPM attestation: %w", err)))
+		}
+	}
+	ms, err := server.VerifyAttestation(tpmAttestation, verifyOpts)
+	var eventLogErr error
+	if err != nil && opts.TolerateEventLogParseErrors && len(attestation.GetEventLog()) != 0 {
+		ms, eventLogErr = verifyWithoutEventLog(tpmAttestation, verifyOpts, err)
+		if ms != nil {
+			err = nil
+			attestation.EventLog = nil
+		}
+	}
+	endSpan(tpmSpan, err)
 	if err != nil {
-		return nil, fmt.Errorf("verifying TEE attestation: %w", err)
+		return nil, joinFailures(append(failures, fmt.Errorf("verifying TPM attestation: %w", err)))
+	}
+
+	if opts.RequireInstanceInfo {
+		if err := checkInstanceInfo(ms, opts.VerifyGceAKCert); err != nil && failed(fmt.Errorf("verifying instance info: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+
+	var instance *InstanceIdentity
+	if len(opts.AllowedInstances) != 0 {
+		instance, err = checkAllowedInstances(ms, opts.AllowedInstances, opts.VerifyGceAKCert)
+		if err != nil && failed(fmt.Errorf("verifying allowed instances: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+
+	report := &VerificationReport{
+		Attestation:   attestation,
+		MachineState:  ms,
+		Technology:    teeTechnology(attestation),
+		AKName:        name,
+		EKCertificate: ekCert,
+		EKIdentity:    ekID,
+		Instance:      instance,
+	}
+	// The quotes verified, so failing to decode the quote over the verified bank means they are
+	// inconsistent with the machine state.
+	report.QuoteClock, err = quoteClockInfo(attestation, ms)
+	if err != nil && failed(fmt.Errorf("reading the quote clock: %w", err)) {
+		return nil, failures[0]
+	}
+	if eventLogErr != nil {
+		report.EventLogError = eventLogErr.Error()
+	}
+	report.UnknownFields, _ = unknownReportFields(attestationBytes, attestation)
+	report.EventLogMissing = len(attestation.GetEventLog()) == 0
+	report.EventLogTruncated = eventLogErr == nil && eventLogOmitted(attestation, ms)
+
+	// missingEventLog fails a check that needs the event log, or skips it with
+	// AllowMissingEventLog. It reports whether verification stops.
+	missingEventLog := func(check string) bool {
+		if opts.AllowMissingEventLog {
+			report.SkippedChecks = append(report.SkippedChecks, check)
+			return false
+		}
+		return failed(fmt.Errorf("verifying %s: attestation has no event log", check))
 	}
 
+	if len(opts.ExpectedReportDataHash) != 0 {
+		if err := checkReportDataHash(attestation, opts.ExpectedReportDataHash); err != nil && failed(fmt.Errorf("verifying report data: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+	if opts.RequireBoundEvidence {
+		if err := checkBoundEvidence(attestation); err != nil && failed(fmt.Errorf("verifying bound evidence: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+
+	teeCtx, teeSpan := startSpan(ctx, "attestation.VerifyTEE")
+	setTEEAttributes(teeSpan, attestation)
+	err = verifyGceTechnology(teeCtx, attestation, opts, collateral, report)
+	endSpan(teeSpan, err)
+	if err != nil && failed(fmt.Errorf("verifying TEE attestation: %w", err)) {
+		return nil, failures[0]
+	}
+	report.CPU = cpuInfo(attestation)
+
 	teeMS, err := parseTEEAttestation(attestation, ms.GetPlatform().Technology)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse machineState from TEE attestation: %w", err)
+		return nil, joinFailures(append(failures, fmt.Errorf("failed to parse machineState from TEE attestation: %w", err)))
 	}
 	ms.TeeAttestation = teeMS.TeeAttestation
 
-	return ms, nil
+	if opts.DeniedMeasurements != nil {
+		// A denied measurement fails verification at once, whatever the allowlists accept.
+		if err := checkDeniedMeasurements(opts.DeniedMeasurements, attestation, ms); err != nil {
+			return nil, joinFailures(append(failures, fmt.Errorf("verifying denied measurements: %w", err)))
+		}
+	}
+	if len(opts.ExpectedPCRs) != 0 {
+		if err := referenceValuesFromPCRs(opts.ExpectedPCRs).check(attestation, ms); err != nil && failed(fmt.Errorf("verifying expected PCRs: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+	if len(opts.CurrentPCRs) != 0 {
+		if err := checkCurrentPCRs(opts.CurrentPCRs, attestation, ms); err != nil && failed(fmt.Errorf("verifying current PCRs: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+	if opts.ReferenceValues != nil {
+		if err := opts.ReferenceValues.check(attestation, ms); err != nil && failed(fmt.Errorf("verifying reference values: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+
+	if len(opts.RIMs) != 0 {
+		report.RIMMatches, err = checkRIMs(opts.RIMs, attestation, ms)
+		if err != nil && failed(fmt.Errorf("verifying RIMs: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+
+	if opts.IntegrityBaseline != nil {
+		report.Integrity, err = checkIntegrityBaseline(opts.IntegrityBaseline, attestation, ms)
+		if err != nil && failed(fmt.Errorf("verifying integrity baseline: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+
+	if opts.Components != nil {
+		report.Components, err = checkComponentPolicy(opts.Components, attestation, ms)
+		if err != nil && failed(fmt.Errorf("verifying components: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+	if opts.RTMRComponents != nil {
+		report.RTMRComponents, err = checkRTMRComponentPolicy(opts.RTMRComponents, ms)
+		if err != nil && failed(fmt.Errorf("verifying RTMR components: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+
+	if opts.TransparencyLog != nil {
+		report.TransparencyLogEntry, err = checkTransparencyLog(ctx, opts.TransparencyLog, report)
+		if err != nil && failed(fmt.Errorf("verifying transparency log: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+
+	if len(opts.AllowedBootEntries) != 0 {
+		// Without an event log there are no boot entries, which must not pass as all allowed.
+		if report.EventLogMissing {
+			if missingEventLog("boot entries") {
+				return nil, failures[0]
+			}
+		} else if err := checkBootEntries(ms, opts.AllowedBootEntries); err != nil && failed(fmt.Errorf("verifying boot entries: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+
+	if len(opts.ExpectedEventSequence) != 0 {
+		if report.EventLogMissing {
+			if missingEventLog("event sequence") {
+				return nil, failures[0]
+			}
+		} else if err := checkEventSequence(ms, opts.ExpectedEventSequence); err != nil && failed(fmt.Errorf("verifying event sequence: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+
+	if opts.RequireSecureBoot != nil {
+		if report.EventLogMissing {
+			if missingEventLog("secure boot") {
+				return nil, failures[0]
+			}
+		} else if err := checkSecureBoot(ms, *opts.RequireSecureBoot); err != nil && failed(fmt.Errorf("verifying secure boot: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+
+	report.ShieldedVM = shieldedVMState(attestation, ms, akCert)
+	if opts.ShieldedVM != nil {
+		if err := opts.ShieldedVM.check(report.ShieldedVM); err != nil && failed(fmt.Errorf("verifying shielded VM: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+
+	if len(opts.ExpectedNVIndices) != 0 {
+		if err := checkNVIndices(attestationBytes, cryptoPub, nonce, opts.ExpectedNVIndices); err != nil && failed(fmt.Errorf("verifying NV indices: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+
+	if opts.WorkloadClaimKey != nil {
+		report.WorkloadClaim, err = verifyWorkloadClaim(attestationBytes, opts.WorkloadClaimKey, nonce)
+		if err != nil && failed(fmt.Errorf("verifying workload claim: %w", err)) {
+			return nil, failures[0]
+		}
+	}
+
+	if len(failures) != 0 {
+		return nil, joinFailures(failures)
+	}
+	report.VerifiedAt = time.Now()
+	report.Audit, err = newAuditRecord(opts.PreviousAuditDigest, attestation, report.VerifiedAt)
+	if err != nil {
+		return nil, fmt.Errorf("computing audit digest: %w", err)
+	}
+	if akCert != nil {
+		report.GceAKEndorsement = newGceAKEndorsement(akCert, ms)
+	}
+	return report, nil
+}
+
+// verifyWithoutEventLog verifies attestation, whose verification with its event log failed with
+// err, again without the event log. If that succeeds, the event log is removed from attestation
+// and the returned error is err, as the reason the event log was dropped. Otherwise err stands.
+func verifyWithoutEventLog(attestation *pb.Attestation, verifyOpts server.VerifyOpts, err error) (*pb.MachineState, error) {
+	withoutEventLog := proto.Clone(attestation).(*pb.Attestation)
+	withoutEventLog.EventLog = nil
+	ms, retryErr := server.VerifyAttestation(withoutEventLog, verifyOpts)
+	if retryErr != nil {
+		return nil, err
+	}
+	attestation.EventLog = nil
+	return ms, err
+}
+
+// joinFailures combines the failed checks of a verification with errors.Join, leaving a single
+// failure as it is.
+func joinFailures(failures []error) error {
+	if len(failures) == 1 {
+		return failures[0]
+	}
+	return errors.Join(failures...)
+}
+
+// unmarshalAttestation parses an attestation report in the given format.
+func unmarshalAttestation(attestationBytes []byte, format string) (*pb.Attestation, error) {
+	attestation := &pb.Attestation{}
+	if format == "binarypb" {
+		var err error
+		if attestationBytes, _, err = unwrapEnvelope(attestationBytes); err != nil {
+			return nil, fmt.Errorf("fail to unmarshal attestation report: %w", err)
+		}
+		if err := checkAttestationLayout(attestationBytes); err != nil {
+			return nil, fmt.Errorf("fail to unmarshal attestation report: %w", err)
+		}
+		err = proto.Unmarshal(attestationBytes, attestation)
+		if err != nil {
+			return nil, fmt.Errorf("fail to unmarshal attestation report: %v", err)
+		}
+	} else if format == "textproto" {
+		err := unmarshalOptions.Unmarshal(attestationBytes, attestation)
+		if err != nil {
+			return nil, fmt.Errorf("fail to unmarshal attestation report: %v", err)
+		}
+	} else {
+		return nil, fmt.Errorf("format should be either binarypb or textproto")
+	}
+	return attestation, nil
+}
+
+// teeTechnology returns the TEE technology constant matching the attestation's TEE attestation,
+// or an empty string if it has none.
+func teeTechnology(attestation *pb.Attestation) string {
+	switch attestation.GetTeeAttestation().(type) {
+	case *pb.Attestation_SevSnpAttestation:
+		return SevSnp
+	case *pb.Attestation_TdxAttestation:
+		return Tdx
+	default:
+		return ""
+	}
+}
+
+// akName returns the TPM name of the AK: its name algorithm followed by the digest of its public
+// area.
+func akName(pub tpm2.Public) (HexBytes, error) {
+	name, err := pub.Name()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute AK name: %v", err)
+	}
+	return name.Digest.Encode()
+}
+
+// checkAKName checks that name equals expected, which may carry a TPM2B_NAME size prefix.
+func checkAKName(name []byte, expected []byte) error {
+	if len(expected) == len(name)+2 && int(binary.BigEndian.Uint16(expected)) == len(name) {
+		expected = expected[2:]
+	}
+	if !bytes.Equal(name, expected) {
+		return fmt.Errorf("AK name is %x, expected %x", name, expected)
+	}
+	return nil
+}
+
+// validateAKPublic checks that the AK uses a key type and signing scheme the quote verification
+// supports: RSASSA with RSA keys or ECDSA with NIST P-256, P-384 or P-521 keys, over SHA256,
+// SHA384 or SHA512.
+func validateAKPublic(pub tpm2.Public) error {
+	var scheme *tpm2.SigScheme
+	switch pub.Type {
+	case tpm2.AlgRSA:
+		scheme = pub.RSAParameters.Sign
+		if scheme == nil || (scheme.Alg != tpm2.AlgRSASSA && scheme.Alg != tpm2.AlgRSAPSS) {
+			return fmt.Errorf("unsupported AK signing scheme for RSA key: %v", scheme)
+		}
+	case tpm2.AlgECC:
+		scheme = pub.ECCParameters.Sign
+		if scheme == nil || scheme.Alg != tpm2.AlgECDSA {
+			return fmt.Errorf("unsupported AK signing scheme for ECC key: %v", scheme)
+		}
+		switch pub.ECCParameters.CurveID {
+		case tpm2.CurveNISTP256, tpm2.CurveNISTP384, tpm2.CurveNISTP521:
+		default:
+			return fmt.Errorf("unsupported AK curve: %v", pub.ECCParameters.CurveID)
+		}
+	default:
+		return fmt.Errorf("unsupported AK type: %v", pub.Type)
+	}
+	switch scheme.Hash {
+	case tpm2.AlgSHA256, tpm2.AlgSHA384, tpm2.AlgSHA512:
+		return nil
+	default:
+		return fmt.Errorf("unsupported AK signing hash: %v", scheme.Hash)
+	}
 }
 
 // parseTEEAttestation parses a machineState from TeeAttestation.
@@ -95,49 +822,51 @@ func parseTEEAttestation(attestation *pb.Attestation, tech pb.GCEConfidentialTec
 	}
 }
 
-func verifyGceTechnology(attestation *pb.Attestation, nonce []byte, teeNonce []byte) error {
+// verifyGceTechnology verifies the TEE attestation of attestation, if any, enforcing the TEE
+// policies of opts. The verified TEE platform is described in report.
+func verifyGceTechnology(ctx context.Context, attestation *pb.Attestation, opts VerifyOptions, collateral collateralSource, report *VerificationReport) error {
 	if attestation.GetTeeAttestation() == nil {
 		return nil
 	}
 
-	switch attestation.GetTeeAttestation().(type) {
-	case *pb.Attestation_TdxAttestation:
-		var tdxOpts *verifyTdxOpts
-		if len(teeNonce) != 0 {
-			tdxOpts = &verifyTdxOpts{
-				Validation:   tdxDefaultValidateOpts(teeNonce),
-				Verification: tv.DefaultOptions(),
-			}
-		} else {
-			tdxOpts = &verifyTdxOpts{
-				Validation:   tdxDefaultValidateOpts(nonce),
-				Verification: tv.DefaultOptions(),
-			}
+	// The TEE nonce, when given, is bound to the TEE attestation, and otherwise the expected report
+	// data hash or, unless AllowUnboundTeeReportData, the TPM nonce is. Nil report data is not
+	// checked.
+	var reportData []byte
+	if len(opts.TeeNonce) != 0 {
+		reportData = opts.TeeNonce
+	} else if len(opts.ExpectedReportDataHash) != 0 {
+		reportData = opts.ExpectedReportDataHash
+	} else if !opts.AllowUnboundTeeReportData {
+		reportData = opts.Nonce
+		if reportData == nil {
+			reportData = []byte{}
 		}
-		tee, ok := attestation.TeeAttestation.(*pb.Attestation_TdxAttestation)
-		if !ok {
-			return fmt.Errorf("TEE attestation is %T, expected a TdxAttestation", attestation.GetTeeAttestation())
+	}
+
+	var err error
+	switch tee := attestation.GetTeeAttestation().(type) {
+	case *pb.Attestation_TdxAttestation:
+		tdxOpts := &verifyTdxOpts{
+			Validation:   tdxDefaultValidateOpts(reportData),
+			Verification: tdxVerifyOptions(ctx, collateral, opts.VerificationTime),
+			Policy:       opts.Tdx,
 		}
-		return verifyTdxAttestation(tee.TdxAttestation, tdxOpts)
+		// Revocations are checked against the Intel collateral, which is then verified too.
+		tdxOpts.Verification.GetCollateral = opts.CheckRevocations
+		tdxOpts.Verification.CheckRevocations = opts.CheckRevocations
+		report.Tdx, err = verifyTdxAttestation(tee.TdxAttestation, tdxOpts)
+		return err
 
 	case *pb.Attestation_SevSnpAttestation:
-		var snpOpts *verifySnpOpts
-		if len(teeNonce) != 0 {
-			snpOpts = &verifySnpOpts{
-				Validation:   sevSnpDefaultValidateOpts(teeNonce),
-				Verification: &sv.Options{},
-			}
-		} else {
-			snpOpts = &verifySnpOpts{
-				Validation:   sevSnpDefaultValidateOpts(nonce),
-				Verification: &sv.Options{},
-			}
-		}
-		tee, ok := attestation.TeeAttestation.(*pb.Attestation_SevSnpAttestation)
-		if !ok {
-			return fmt.Errorf("TEE attestation is %T, expected a SevSnpAttestation", attestation.GetTeeAttestation())
+		snpOpts := &verifySnpOpts{
+			Validation:   sevSnpDefaultValidateOpts(reportData),
+			Verification: sevSnpVerifyOptions(ctx, collateral, opts.VerificationTime),
+			Policy:       opts.SevSnp,
 		}
-		return verifySevSnpAttestation(tee.SevSnpAttestation, snpOpts)
+		snpOpts.Verification.CheckRevocations = opts.CheckRevocations
+		report.SevSnp, err = verifySevSnpAttestation(tee.SevSnpAttestation, snpOpts)
+		return err
 
 	default:
 		return fmt.Errorf("unknown attestation type: %T", attestation.GetTeeAttestation())
@@ -1,10 +1,19 @@
 package attestation
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"fmt"
+	"time"
+
 	sabi "github.com/google/go-sev-guest/abi"
 	spb "github.com/google/go-sev-guest/proto/sevsnp"
 	"github.com/google/go-sev-guest/validate"
 	sv "github.com/google/go-sev-guest/verify"
+	"github.com/google/go-sev-guest/verify/trust"
 )
 
 // The policy on GCE is to allow SMT, and eventually MigrateMA, but no debug bit.
@@ -17,24 +26,170 @@ var defaultSevSnpGuestPolicy = sabi.SnpPolicy{
 type verifySnpOpts struct {
 	Validation   *validate.Options
 	Verification *sv.Options
+	// Policy holds additional requirements on the SEV-SNP guest, if any
+	Policy *SevSnpPolicy
 }
 
 // sevSnpDefaultValidateOpts returns a default validation policy for SEV-SNP attestation reports on GCE.
+// The report data is not checked if tpmNonce is nil.
 func sevSnpDefaultValidateOpts(tpmNonce []byte) *validate.Options {
 	policy := &validate.Options{GuestPolicy: defaultSevSnpGuestPolicy}
-	policy.ReportData = make([]byte, sabi.ReportDataSize)
-	copy(policy.ReportData, tpmNonce)
+	if tpmNonce != nil {
+		policy.ReportData = make([]byte, sabi.ReportDataSize)
+		copy(policy.ReportData, tpmNonce)
+	}
 	return policy
 }
 
+// sevSnpVerifyOptions returns the SEV-SNP verification options, fetching certificates through
+// collateral when it is non-nil.
+func sevSnpVerifyOptions(ctx context.Context, collateral collateralSource, now time.Time) *sv.Options {
+	return &sv.Options{Now: now, Getter: sevSnpGetter(ctx, collateral)}
+}
+
+// sevSnpGetter returns the go-sev-guest getter that fetches collateral through collateral, or nil
+// for the library's default getter.
+func sevSnpGetter(ctx context.Context, collateral collateralSource) trust.HTTPSGetter {
+	limited, ok := collateral.(*limitedCollateral)
+	switch {
+	case collateral == nil:
+		return nil
+	case !ok || limited.source != nil:
+		return &sevSnpCollateralGetter{ctx: ctx, source: collateral}
+	}
+
+	getter := trust.DefaultHTTPSGetter()
+	if retry, ok := getter.(*trust.RetryHTTPSGetter); ok {
+		retry.Getter = sevSnpGetterFunc(func(url string) ([]byte, error) {
+			_, body, err := limited.fetchTruncated(url)
+			return body, err
+		})
+	}
+	return sevSnpGetterFunc(func(url string) ([]byte, error) {
+		body, err := getter.Get(url)
+		_, body, err = limited.check(url, nil, body, err)
+		return body, err
+	})
+}
+
+// sevSnpGetterFunc adapts a function to go-sev-guest's trust.HTTPSGetter.
+type sevSnpGetterFunc func(url string) ([]byte, error)
+
+// Get returns the body of url.
+func (f sevSnpGetterFunc) Get(url string) ([]byte, error) {
+	return f(url)
+}
+
 // verifySevSnpAttestation checks that the SEV-SNP attestation report matches expectations for the
 // product.
-func verifySevSnpAttestation(attestation *spb.Attestation, opts *verifySnpOpts) error {
-	// Check that the report is signed by a valid AMD key. Do not check revocations. This must be
-	// done before validation to ensure the certificates are filled in by the verify library.
+func verifySevSnpAttestation(attestation *spb.Attestation, opts *verifySnpOpts) (*SevSnpReport, error) {
+	if err := checkSevSnpSignatureAlgo(attestation.GetReport()); err != nil {
+		return nil, err
+	}
+	embedded, err := sevSnpCertsEmbedded(attestation)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Policy != nil && opts.Policy.RequireEmbeddedCerts {
+		if !embedded {
+			return nil, fmt.Errorf("SEV-SNP attestation does not embed its full certificate chain")
+		}
+		opts.Verification.DisableCertFetching = true
+	}
+	if err := checkSevSnpEmbeddedARK(attestation.GetCertificateChain().GetArkCert()); err != nil {
+		return nil, err
+	}
+	// Check that the report is signed by a valid AMD key, not revoked if the options check
+	// revocations. This must be done before validation to ensure the certificates are filled in by
+	// the verify library.
 	if err := sv.SnpAttestation(attestation, opts.Verification); err != nil {
-		return err
+		return nil, err
+	}
+	if err := checkSevSnpSigningKey(attestation); err != nil {
+		return nil, err
 	}
 	// Check that the fields of the report are acceptable.
-	return validate.SnpAttestation(attestation, opts.Validation)
+	opts.Policy.apply(opts.Validation)
+	if err := validate.SnpAttestation(attestation, opts.Validation); err != nil {
+		return nil, err
+	}
+	if err := opts.Policy.checkPlatformInfo(attestation.GetReport()); err != nil {
+		return nil, err
+	}
+	report := newSevSnpReport(attestation.GetReport())
+	report.EmbeddedCerts = embedded
+	return report, nil
+}
+
+// sevSnpCertsEmbedded reports whether attestation embeds the VCEK or VLEK that signed its report,
+// the ASK or ASVK and the ARK, as the certificate table of an extended report does.
+func sevSnpCertsEmbedded(attestation *spb.Attestation) (bool, error) {
+	info, err := sabi.ParseSignerInfo(attestation.GetReport().GetSignerInfo())
+	if err != nil {
+		return false, err
+	}
+	chain := attestation.GetCertificateChain()
+	signer := chain.GetVcekCert()
+	if info.SigningKey == sabi.VlekReportSigner {
+		signer = chain.GetVlekCert()
+	}
+	return len(signer) != 0 && len(chain.GetAskCert()) != 0 && len(chain.GetArkCert()) != 0, nil
+}
+
+// checkSevSnpEmbeddedARK checks that an embedded ARK certificate, if any, is the root of one of
+// AMD's product lines. Verification then checks that it is the root of the report's product line.
+func checkSevSnpEmbeddedARK(der []byte) error {
+	if len(der) == 0 {
+		return nil
+	}
+	ark, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("failed to parse embedded ARK certificate: %v", err)
+	}
+	for _, root := range trust.DefaultRootCerts {
+		if root.ProductCerts != nil && root.ProductCerts.Ark != nil && bytes.Equal(root.ProductCerts.Ark.RawSubjectPublicKeyInfo, ark.RawSubjectPublicKeyInfo) {
+			return nil
+		}
+	}
+	return fmt.Errorf("embedded ARK certificate %q is not an AMD root", ark.Subject)
+}
+
+// checkSevSnpSignatureAlgo checks that report claims ECDSA P-384 with SHA-384, the only signature
+// algorithm of the SEV-SNP ABI, and that its signature has no bytes past the r and s components.
+func checkSevSnpSignatureAlgo(report *spb.Report) error {
+	if algo := report.GetSignatureAlgo(); algo != sabi.SignEcdsaP384Sha384 {
+		return fmt.Errorf("SEV-SNP report signature algorithm is %d, expected ECDSA P-384 with SHA-384 (%d)", algo, sabi.SignEcdsaP384Sha384)
+	}
+	signature := report.GetSignature()
+	if len(signature) < sabi.EcdsaP384Sha384SignatureSize {
+		return fmt.Errorf("SEV-SNP report signature has %d bytes, expected at least %d", len(signature), sabi.EcdsaP384Sha384SignatureSize)
+	}
+	for _, b := range signature[sabi.EcdsaP384Sha384SignatureSize:] {
+		if b != 0 {
+			return fmt.Errorf("SEV-SNP report signature has nonzero bytes after its ECDSA P-384 signature")
+		}
+	}
+	return nil
+}
+
+// checkSevSnpSigningKey checks that the VCEK or VLEK that signed a verified report is an ECDSA
+// P-384 key.
+func checkSevSnpSigningKey(attestation *spb.Attestation) error {
+	info, err := sabi.ParseSignerInfo(attestation.GetReport().GetSignerInfo())
+	if err != nil {
+		return err
+	}
+	der := attestation.GetCertificateChain().GetVcekCert()
+	if info.SigningKey == sabi.VlekReportSigner {
+		der = attestation.GetCertificateChain().GetVlekCert()
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("failed to parse %v certificate: %v", info.SigningKey, err)
+	}
+	key, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || key.Curve != elliptic.P384() {
+		return fmt.Errorf("%v is not an ECDSA P-384 key", info.SigningKey)
+	}
+	return nil
 }
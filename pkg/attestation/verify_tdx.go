@@ -1,35 +1,101 @@
 package attestation
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	tabi "github.com/google/go-tdx-guest/abi"
+	"github.com/google/go-tdx-guest/proto/tdx"
 	"github.com/google/go-tdx-guest/validate"
 	tv "github.com/google/go-tdx-guest/verify"
+	"github.com/google/go-tdx-guest/verify/trust"
 )
 
 // verifyTdxOpts allows for customizing the functionality of VerifyAttestation's TDX verification.
 type verifyTdxOpts struct {
 	Validation   *validate.Options
 	Verification *tv.Options
+	// Policy holds additional requirements on the TDX platform, if any
+	Policy *TdxPolicy
 }
 
 // tdxDefaultValidateOpts returns a default validation policy for TDX attestation quote on GCE.
+// The report data is not checked if tdxNonce is nil.
 func tdxDefaultValidateOpts(tdxNonce []byte) *validate.Options {
 	policy := &validate.Options{HeaderOptions: validate.HeaderOptions{},
 		TdQuoteBodyOptions: validate.TdQuoteBodyOptions{}}
-	policy.TdQuoteBodyOptions.ReportData = make([]byte, tabi.ReportDataSize)
-	copy(policy.TdQuoteBodyOptions.ReportData, tdxNonce)
+	if tdxNonce != nil {
+		policy.TdQuoteBodyOptions.ReportData = make([]byte, tabi.ReportDataSize)
+		copy(policy.TdQuoteBodyOptions.ReportData, tdxNonce)
+	}
 	return policy
 }
 
+// tdxVerifyOptions returns the TDX verification options at time now (the current time if zero),
+// fetching collateral through collateral when it is non-nil.
+func tdxVerifyOptions(ctx context.Context, collateral collateralSource, now time.Time) *tv.Options {
+	opts := tv.DefaultOptions()
+	if getter := tdxGetter(ctx, collateral); getter != nil {
+		opts.Getter = getter
+	}
+	if !now.IsZero() {
+		opts.Now = now
+	}
+	return opts
+}
+
+// tdxGetter returns the go-tdx-guest getter that fetches collateral through collateral, or nil for
+// the library's default getter.
+func tdxGetter(ctx context.Context, collateral collateralSource) trust.HTTPSGetter {
+	limited, ok := collateral.(*limitedCollateral)
+	switch {
+	case collateral == nil:
+		return nil
+	case !ok || limited.source != nil:
+		return &tdxCollateralGetter{ctx: ctx, source: collateral}
+	}
+
+	getter := trust.DefaultHTTPSGetter()
+	if retry, ok := getter.(*trust.RetryHTTPSGetter); ok {
+		retry.Getter = tdxGetterFunc(limited.fetchTruncated)
+	}
+	return tdxGetterFunc(func(url string) (map[string][]string, []byte, error) {
+		header, body, err := getter.Get(url)
+		return limited.check(url, header, body, err)
+	})
+}
+
+// tdxGetterFunc adapts a function to go-tdx-guest's trust.HTTPSGetter.
+type tdxGetterFunc func(url string) (map[string][]string, []byte, error)
+
+// Get returns the headers and body of url.
+func (f tdxGetterFunc) Get(url string) (map[string][]string, []byte, error) {
+	return f(url)
+}
+
 // verifyTdxAttestation checks that the TDX attestation quote is valid. The TEE-specific attestation
 // quote is extracted from the Attestation protobuf. At a granular level, this quote is fetched via
 // go-tdx-guest's GetQuote client API.
 // Supported quote formats - QuoteV4.
-func verifyTdxAttestation(tdxAttestationQuote any, opts *verifyTdxOpts) error {
-	// Check that the quote contains valid signature and certificates. Do not check revocations.
-	if err := tv.TdxQuote(tdxAttestationQuote, opts.Verification); err != nil {
-		return err
+func verifyTdxAttestation(tdxAttestationQuote any, opts *verifyTdxOpts) (*TdxReport, error) {
+	quote, ok := tdxAttestationQuote.(*tdx.QuoteV4)
+	if !ok {
+		return nil, fmt.Errorf("unsupported TDX quote type: %T", tdxAttestationQuote)
+	}
+	// Fetch the PCK certificate of quotes that carry an encrypted PPID instead
+	if err := retrievePCKCertificate(quote, opts.Policy, opts.Verification.Getter); err != nil {
+		return nil, err
+	}
+	// Check that the quote contains valid signature and certificates, not revoked if the options
+	// check revocations.
+	if err := tv.TdxQuote(quote, opts.Verification); err != nil {
+		return nil, err
 	}
 	// Check that the fields of the quote are acceptable
-	return validate.TdxQuote(tdxAttestationQuote, opts.Validation)
+	if err := validate.TdxQuote(quote, opts.Validation); err != nil {
+		return nil, err
+	}
+	// Check the platform against the policy
+	return checkTdxPolicy(quote, opts.Policy, opts.Verification.Getter, opts.Verification.Now)
 }
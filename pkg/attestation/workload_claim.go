@@ -0,0 +1,128 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// WorkloadClaimField is the field number that carries a SignedWorkloadClaim, encoded as JSON, in
+// the attestation envelope of a binary report. Textproto reports have no envelope.
+const WorkloadClaimField protowire.Number = 2
+
+// WorkloadClaim is a statement by the host about the workload it runs
+type WorkloadClaim struct {
+	// ImageDigest identifies the workload image, e.g. sha256:...
+	ImageDigest string `json:"imageDigest"`
+	// ConfigHash identifies the workload configuration
+	ConfigHash string `json:"configHash,omitempty"`
+	// Nonce binds the claim to an attestation. Attest sets it to the attestation nonce.
+	Nonce []byte `json:"nonce"`
+}
+
+// SignedWorkloadClaim is a WorkloadClaim with the host's signature
+type SignedWorkloadClaim struct {
+	// Claim is the JSON encoding of the WorkloadClaim
+	Claim []byte `json:"claim"`
+	// Signature is the signature of Claim. RSA and ECDSA keys sign its SHA256 digest (PKCS #1 v1.5
+	// and ASN.1 respectively), Ed25519 keys sign Claim itself.
+	Signature []byte `json:"signature"`
+}
+
+// signWorkloadClaim signs claim with signer.
+func signWorkloadClaim(claim *WorkloadClaim, signer crypto.Signer) (*SignedWorkloadClaim, error) {
+	encoded, err := json.Marshal(claim)
+	if err != nil {
+		return nil, err
+	}
+	var signature []byte
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		signature, err = signer.Sign(rand.Reader, encoded, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(encoded)
+		signature, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign workload claim: %v", err)
+	}
+	return &SignedWorkloadClaim{Claim: encoded, Signature: signature}, nil
+}
+
+// attachWorkloadClaim appends signed as the WorkloadClaimField envelope field to additions.
+func attachWorkloadClaim(additions []byte, signed *SignedWorkloadClaim) ([]byte, error) {
+	encoded, err := json.Marshal(signed)
+	if err != nil {
+		return nil, err
+	}
+	additions = protowire.AppendTag(additions, WorkloadClaimField, protowire.BytesType)
+	return protowire.AppendBytes(additions, encoded), nil
+}
+
+// GetWorkloadClaim returns the signed workload claim attached to a binary attestation report, or
+// nil if it has none. The claim is not verified.
+func GetWorkloadClaim(attestationBytes []byte) (*SignedWorkloadClaim, error) {
+	value, ok, err := envelopeField(attestationBytes, WorkloadClaimField, protowire.BytesType)
+	if err != nil || !ok {
+		return nil, err
+	}
+	claim, _ := protowire.ConsumeBytes(value)
+	signed := &SignedWorkloadClaim{}
+	if err := json.Unmarshal(claim, signed); err != nil {
+		return nil, fmt.Errorf("malformed workload claim: %v", err)
+	}
+	return signed, nil
+}
+
+// verifyWorkloadClaim checks that a binary attestation report carries a workload claim signed by
+// key and bound to nonce, and returns the claim.
+func verifyWorkloadClaim(attestationBytes []byte, key crypto.PublicKey, nonce []byte) (*WorkloadClaim, error) {
+	signed, err := GetWorkloadClaim(attestationBytes)
+	if err != nil {
+		return nil, err
+	}
+	if signed == nil {
+		return nil, fmt.Errorf("attestation does not contain a workload claim")
+	}
+
+	if err := verifySignature(key, signed.Claim, signed.Signature); err != nil {
+		return nil, fmt.Errorf("workload claim signature verification failed: %v", err)
+	}
+
+	claim := &WorkloadClaim{}
+	if err := json.Unmarshal(signed.Claim, claim); err != nil {
+		return nil, fmt.Errorf("malformed workload claim: %v", err)
+	}
+	if !bytes.Equal(claim.Nonce, nonce) {
+		return nil, fmt.Errorf("workload claim is not bound to the attestation nonce")
+	}
+	return claim, nil
+}
+
+// verifySignature verifies a signature over message by key: RSA PKCS #1 v1.5 or ASN.1 ECDSA over
+// its SHA-256 digest, or Ed25519 over the message itself.
+func verifySignature(key crypto.PublicKey, message []byte, signature []byte) error {
+	digest := sha256.Sum256(message)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest[:], signature) {
+			return fmt.Errorf("invalid ECDSA signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, message, signature) {
+			return fmt.Errorf("invalid Ed25519 signature")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	return nil
+}